@@ -21,13 +21,23 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/bbva/qed/api/auth"
 	"github.com/bbva/qed/log"
 	"github.com/bbva/qed/protocol"
 	"github.com/bbva/qed/raftwal"
+	"github.com/bbva/qed/sign"
+	"github.com/bbva/qed/storage"
 )
 
+// PayloadsNotStoredHeader lists, separated by commas, the versions of the
+// events just added whose payload is too large to be stored, so they are
+// logged only by their digest.
+const PayloadsNotStoredHeader = "Payloads-Not-Stored"
+
 // HealthCheckResponse contains the response from HealthCheckHandler.
 type HealthCheckResponse struct {
 	Version int    `json:"version"`
@@ -66,8 +76,31 @@ func HealthCheckHandler(w http.ResponseWriter, r *http.Request) {
 //     "HyperDigest": "mHzXvSE/j7eFmNObvC7PdtQTmd4W0q/FPHmiYEjL0eM=",
 //     "HistoryDigest": "Kpbn+7P4XrZi2hKpdhA7freUicZdUsU6GqmUk0vDJ8A=",
 //     "Version": 1,
+//     "Timestamp": 1550566416000000000,
 //     "Event": "VGhpcyBpcyBteSBmaXJzdCBldmVudA=="
 //   }
+//
+// Retries of the same request can be made safe sending a unique
+// Idempotency-Key header: every request with the same key gets back the
+// snapshot of the first one, and the event is only added once. Sending
+// the same key along with another event returns a 409 status. Keys are
+// forgotten 24 hours after the first request, and can then be used again.
+//
+// If the body sets "IfAbsent" to true, the event is only added if it is not
+// already in the log. The body of the response then contains the snapshot
+// and, if the event already existed, the HTTP status is 200 and the
+// response also contains a membership proof that verifies against it:
+//   {
+//     "Snapshot": { ... },
+//     "Proof": { ... }
+//   }
+// As retries of these requests are already safe, they cannot send an
+// Idempotency-Key header, and doing so returns a 400 status.
+//
+// If the server stores the payloads of the events, events larger than
+// the configured limit are logged only by their digest, and the version
+// of the event is listed in the Payloads-Not-Stored header of the
+// response.
 func Add(balloon raftwal.RaftBalloonApi) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
@@ -90,10 +123,26 @@ func Add(balloon raftwal.RaftBalloonApi) http.HandlerFunc {
 			return
 		}
 
+		key := r.Header.Get("Idempotency-Key")
+		if event.IfAbsent && key != "" {
+			http.Error(w, "IfAbsent cannot be combined with an Idempotency-Key", http.StatusBadRequest)
+			return
+		}
+
+		if event.IfAbsent {
+			addIfAbsent(balloon, event.Event, w)
+			return
+		}
+
+		if key != "" {
+			addIdempotent(balloon, event.Event, key, w)
+			return
+		}
+
 		// Wait for the response
 		response, err := balloon.Add(event.Event)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			http.Error(w, err.Error(), addErrorStatus(err))
 			return
 		}
 
@@ -105,6 +154,7 @@ func Add(balloon raftwal.RaftBalloonApi) http.HandlerFunc {
 			return
 		}
 
+		setPayloadsNotStored(w, balloon, [][]byte{event.Event}, []uint64{response.Version})
 		w.WriteHeader(http.StatusCreated)
 		_, _ = w.Write(out)
 
@@ -112,6 +162,80 @@ func Add(balloon raftwal.RaftBalloonApi) http.HandlerFunc {
 	}
 }
 
+func addIdempotent(balloon raftwal.RaftBalloonApi, event []byte, key string, w http.ResponseWriter) {
+
+	// Wait for the response
+	response, err := balloon.AddIdempotent(event, key)
+	if err != nil {
+		http.Error(w, err.Error(), addErrorStatus(err))
+		return
+	}
+
+	snapshot := protocol.Snapshot(*response)
+
+	out, err := json.Marshal(&snapshot)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	setPayloadsNotStored(w, balloon, [][]byte{event}, []uint64{response.Version})
+	w.WriteHeader(http.StatusCreated)
+	_, _ = w.Write(out)
+}
+
+func addIfAbsent(balloon raftwal.RaftBalloonApi, event []byte, w http.ResponseWriter) {
+
+	// Wait for the response
+	response, proof, err := balloon.AddIfAbsent(event)
+	if err != nil {
+		http.Error(w, err.Error(), addErrorStatus(err))
+		return
+	}
+
+	out, err := json.Marshal(protocol.ToAddResult(event, response, proof))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if proof != nil {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		setPayloadsNotStored(w, balloon, [][]byte{event}, []uint64{response.Version})
+		w.WriteHeader(http.StatusCreated)
+	}
+	_, _ = w.Write(out)
+}
+
+// addErrorStatus returns the HTTP status of an error adding events.
+func addErrorStatus(err error) int {
+	switch err {
+	case raftwal.ErrIdempotencyKeyReused:
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// setPayloadsNotStored lists in the Payloads-Not-Stored header of the
+// response the versions of the new events whose payload is too large to
+// be stored.
+func setPayloadsNotStored(w http.ResponseWriter, balloon raftwal.RaftBalloonApi, events [][]byte, versions []uint64) {
+	var notStored []string
+	listed := make(map[uint64]bool)
+	for i, event := range events {
+		if listed[versions[i]] || !balloon.PayloadTooLarge(event) {
+			continue
+		}
+		listed[versions[i]] = true
+		notStored = append(notStored, strconv.FormatUint(versions[i], 10))
+	}
+	if len(notStored) > 0 {
+		w.Header().Set(PayloadsNotStoredHeader, strings.Join(notStored, ","))
+	}
+}
+
 // AddBulk posts a bulk of events into the system:
 // The http post url is:
 //   POST /events/bulk
@@ -123,16 +247,25 @@ func Add(balloon raftwal.RaftBalloonApi) http.HandlerFunc {
 //			"HyperDigest": "mHzXvSE/j7eFmNObvC7PdtQTmd4W0q/FPHmiYEjL0eM=",
 //			"HistoryDigest": "Kpbn+7P4XrZi2hKpdhA7freUicZdUsU6GqmUk0vDJ8A=",
 //			"Version": 1,
+//			"Timestamp": 1550566416000000000,
 //			"Event": "VGhpcyBpcyBteSBmaXJzdCBldmVudA=="
 //		},
 //		{
 //			"HyperDigest": "mHzXvSE/j7eFmNObvC7PdtQTmd4W0q/FPHmiYEjL0eM=",
 //			"HistoryDigest": "reUicZdUsU6GqmUk0vDJ8A=Kpbn+7P4XrZi2hKpdhA7f",
 //			"Version": 2,
+//			"Timestamp": 1550566416000000000,
 //			"Event": "pcyBteSBmaXJzdCBldmVudAVGhpcyB=="
 //		},
 //		...
 //	]
+//
+// If the body sets "IfAbsent" to true, only the events not already in the
+// log are added, and the body of the response contains a list of results
+// like the ones returned by Add.
+//
+// As in Add, the versions of the events logged only by their digest are
+// listed in the Payloads-Not-Stored header of the response.
 func AddBulk(balloon raftwal.RaftBalloonApi) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
@@ -155,9 +288,181 @@ func AddBulk(balloon raftwal.RaftBalloonApi) http.HandlerFunc {
 			return
 		}
 
+		if eventBulk.IfAbsent {
+			addBulkIfAbsent(balloon, eventBulk.Events, w)
+			return
+		}
+
 		// Wait for the response
 		snapshotBulk, err := balloon.AddBulk(eventBulk.Events)
 
+		if err != nil {
+			http.Error(w, err.Error(), addErrorStatus(err))
+			return
+		}
+
+		out, err := json.Marshal(snapshotBulk)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		versions := make([]uint64, len(snapshotBulk))
+		for i, snapshot := range snapshotBulk {
+			versions[i] = snapshot.Version
+		}
+		setPayloadsNotStored(w, balloon, eventBulk.Events, versions)
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write(out)
+
+		return
+	}
+}
+
+func addBulkIfAbsent(balloon raftwal.RaftBalloonApi, events [][]byte, w http.ResponseWriter) {
+
+	// Wait for the response
+	snapshotBulk, proofs, err := balloon.AddBulkIfAbsent(events)
+	if err != nil {
+		http.Error(w, err.Error(), addErrorStatus(err))
+		return
+	}
+
+	results := make([]*protocol.AddResult, len(events))
+	var added [][]byte
+	var versions []uint64
+	for i, event := range events {
+		results[i] = protocol.ToAddResult(event, snapshotBulk[i], proofs[i])
+		if proofs[i] == nil {
+			added = append(added, event)
+			versions = append(versions, snapshotBulk[i].Version)
+		}
+	}
+
+	out, err := json.Marshal(results)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	setPayloadsNotStored(w, balloon, added, versions)
+	w.WriteHeader(http.StatusCreated)
+	_, _ = w.Write(out)
+}
+
+// AddDigest posts an event already hashed by the producer into the system,
+// so the event itself never leaves it. The digest must be computed with the
+// hasher of the log:
+// The http post url is:
+//   POST /events/digest
+//
+// The body contains:
+//   {
+//     "Digest": "mHzXvSE/j7eFmNObvC7PdtQTmd4W0q/FPHmiYEjL0eM="
+//   }
+//
+// The following statuses are expected:
+// If everything is alright, the HTTP status is 201 and the body contains
+// the same snapshot that Add returns. If the digest does not have the
+// length of the log hasher, the HTTP status is 400.
+func AddDigest(balloon raftwal.RaftBalloonApi) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		// Make sure we can only be called with an HTTP POST request.
+		if r.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		if r.Body == nil {
+			http.Error(w, "Please send a request body", http.StatusBadRequest)
+			return
+		}
+
+		var digest protocol.EventDigest
+		err := json.NewDecoder(r.Body).Decode(&digest)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Wait for the response
+		response, err := balloon.AddDigest(digest.Digest)
+		if err == raftwal.ErrInvalidDigestLength {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		snapshot := protocol.Snapshot(*response)
+
+		out, err := json.Marshal(&snapshot)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write(out)
+
+		return
+	}
+}
+
+// AddDigestBulk posts a bulk of events already hashed by the producer into
+// the system:
+// The http post url is:
+//   POST /events/digest/bulk
+//
+// The body contains:
+//   {
+//     "Digests": [
+//       "mHzXvSE/j7eFmNObvC7PdtQTmd4W0q/FPHmiYEjL0eM=",
+//       ...
+//     ]
+//   }
+//
+// The following statuses are expected:
+// If everything is alright, the HTTP status is 201 and the body contains
+// the same list of snapshots that AddBulk returns. If any of the digests
+// does not have the length of the log hasher, nothing is added and the
+// HTTP status is 400.
+func AddDigestBulk(balloon raftwal.RaftBalloonApi) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		// Make sure we can only be called with an HTTP POST request.
+		if r.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		if r.Body == nil {
+			http.Error(w, "Please send a request body", http.StatusBadRequest)
+			return
+		}
+
+		var digestBulk protocol.EventDigestsBulk
+		err := json.NewDecoder(r.Body).Decode(&digestBulk)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(digestBulk.Digests) == 0 {
+			http.Error(w, "Please send at least one digest", http.StatusBadRequest)
+			return
+		}
+
+		// Wait for the response
+		snapshotBulk, err := balloon.AddDigestBulk(digestBulk.Digests)
+		if err == raftwal.ErrInvalidDigestLength {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -281,6 +586,81 @@ func DigestMembership(balloon raftwal.RaftBalloonApi) http.HandlerFunc {
 	}
 }
 
+// GetEvent returns an event of a log that stores their payloads, along
+// with its digest and a membership proof:
+// The http get url is:
+//   GET /events/{version}
+//
+// The following statuses are expected:
+// If everything is alright, the HTTP status is 200 and the body contains:
+//   {
+//     "Version": 1,
+//     "Event": "VGhpcyBpcyBteSBmaXJzdCBldmVudA==",
+//     "EventDigest": "mHzXvSE/j7eFmNObvC7PdtQTmd4W0q/FPHmiYEjL0eM=",
+//     "Timestamp": 1550566416000000000,
+//     "Proof": { ... }
+//   }
+// The proof verifies against the snapshot of the version of the event. If
+// the version is not in the log or its payload has not been stored, the
+// HTTP status is 404.
+func GetEvent(balloon raftwal.RaftBalloonApi) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		// Make sure we can only be called with an HTTP GET request.
+		if r.Method != "GET" {
+			w.Header().Set("Allow", "GET")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		version, err := strconv.ParseUint(strings.TrimPrefix(r.URL.Path, "/events/"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid event version", http.StatusBadRequest)
+			return
+		}
+		if version >= balloon.Version() {
+			http.Error(w, "The version is not in the log", http.StatusNotFound)
+			return
+		}
+
+		event, err := balloon.QueryPayload(version)
+		if err == storage.ErrKeyNotFound {
+			http.Error(w, "The payload of the event is not stored", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		proof, err := balloon.QueryMembership(event, version)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		timestamp, err := balloon.QueryTimestamp(version)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		out, err := json.Marshal(&protocol.EventPayload{
+			Version:     version,
+			Event:       event,
+			EventDigest: proof.KeyDigest,
+			Timestamp:   timestamp,
+			Proof:       protocol.ToMembershipResult(nil, proof),
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(out)
+	}
+}
+
 // Incremental returns an incrementalProof from the system
 // The http post url is:
 //   POST /proofs/incremental
@@ -328,41 +708,82 @@ func Incremental(balloon raftwal.RaftBalloonApi) http.HandlerFunc {
 	}
 }
 
-// AuthHandlerMiddleware function is an HTTP handler wrapper that performs
-// simple authorization tasks. Currently only checks that Api-Key it's present.
-//
-// If not present will raise a `http.StatusUnauthorized` errror.
-func AuthHandlerMiddleware(handler http.HandlerFunc) http.HandlerFunc {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-
-		// Check if Api-Key header is empty
-		if r.Header.Get("Api-Key") == "" {
-			http.Error(w, "Missing Api-Key header", http.StatusUnauthorized)
-			return
-		}
-
-		handler.ServeHTTP(w, r)
-	})
-}
-
 // NewApiHttp returns a new *http.ServeMux containing the current API handlers.
 //	/health-check -> HealthCheckHandler
 //	/events -> Add
+//	/events/{version} -> GetEvent
+//	/events/by-version/{version} -> EventByVersion
+//	/events/by-version -> EventsByVersion
+//	/events/digest -> AddDigest
+//	/events/export -> Export
 //	/proofs/membership -> Membership
-func NewApiHttp(balloon raftwal.RaftBalloonApi) *http.ServeMux {
+//	/proofs/receipt -> Receipt
+//	/info -> InfoHandler
+//	/info/keys -> InfoKeys
+//	/ct/v1/ -> NewCTApiHttp
+//
+// Adding events needs an API key with the writer role, and every other
+// handler one with the reader role, but /info and /info/keys, which are
+// public. If the forwarder is not nil, the followers forward the events
+// they receive to the leader. Proofs are answered with the read
+// consistency requested in the query string. Receipts, exports and the CT
+// tree heads are signed with the given signer.
+func NewApiHttp(balloon raftwal.RaftBalloonApi, keys *auth.KeyStore, signer sign.Signer, info protocol.ServerInfo, forwarder *Forwarder) *http.ServeMux {
 
 	api := http.NewServeMux()
-	api.HandleFunc("/healthcheck", AuthHandlerMiddleware(HealthCheckHandler))
-	api.HandleFunc("/events", AuthHandlerMiddleware(Add(balloon)))
-	api.HandleFunc("/events/bulk", AuthHandlerMiddleware(AddBulk(balloon)))
-	api.HandleFunc("/proofs/membership", AuthHandlerMiddleware(Membership(balloon)))
-	api.HandleFunc("/proofs/digest-membership", AuthHandlerMiddleware(DigestMembership(balloon)))
-	api.HandleFunc("/proofs/incremental", AuthHandlerMiddleware(Incremental(balloon)))
-	api.HandleFunc("/info/shards", AuthHandlerMiddleware(InfoShardsHandler(balloon)))
+	api.HandleFunc("/healthcheck", keys.Handler(auth.Reader, HealthCheckHandler))
+	api.HandleFunc("/events", keys.Handler(auth.Writer, forwarder.Handler(Add(balloon))))
+	api.HandleFunc("/events/bulk", keys.Handler(auth.Writer, forwarder.Handler(AddBulk(balloon))))
+	api.HandleFunc("/events/digest", keys.Handler(auth.Writer, forwarder.Handler(AddDigest(balloon))))
+	api.HandleFunc("/events/digest/bulk", keys.Handler(auth.Writer, forwarder.Handler(AddDigestBulk(balloon))))
+	api.HandleFunc("/events/", keys.Handler(auth.Reader, ReadConsistencyHandler(balloon, GetEvent(balloon))))
+	api.HandleFunc("/events/by-version", keys.Handler(auth.Reader, ReadConsistencyHandler(balloon, EventsByVersion(balloon))))
+	api.HandleFunc("/events/by-version/", keys.Handler(auth.Reader, ReadConsistencyHandler(balloon, EventByVersion(balloon))))
+	api.HandleFunc("/proofs/membership", keys.Handler(auth.Reader, ReadConsistencyHandler(balloon, Membership(balloon))))
+	api.HandleFunc("/proofs/digest-membership", keys.Handler(auth.Reader, ReadConsistencyHandler(balloon, DigestMembership(balloon))))
+	api.HandleFunc("/proofs/incremental", keys.Handler(auth.Reader, ReadConsistencyHandler(balloon, Incremental(balloon))))
+	api.HandleFunc("/info/shards", keys.Handler(auth.Reader, InfoShardsHandler(balloon)))
+	api.HandleFunc("/info", InfoHandler(info))
+	api.HandleFunc("/info/keys", InfoKeys(balloon, signer))
+	api.Handle("/ct/v1/", NewCTApiHttp(balloon, signer, keys))
+	api.HandleFunc("/proofs/receipt", keys.Handler(auth.Reader, ReadConsistencyHandler(balloon, Receipt(balloon, signer))))
+	api.HandleFunc("/events/export", keys.Handler(auth.Reader, ReadConsistencyHandler(balloon, Export(balloon, signer))))
 
 	return api
 }
 
+// ReadConsistencyHandler answers the query with the given handler only if
+// the node meets the read consistency requested in the query string:
+//   POST /proofs/membership?consistency=stale
+//   POST /proofs/membership?consistency=bounded&max_lag=5s&max_lag_versions=100
+//   POST /proofs/membership?consistency=linearizable
+//
+// Queries are stale by default. If the node cannot meet the requested
+// consistency, because it is not the leader or lags behind it, the HTTP
+// status is 503 and the query can be sent to another node.
+func ReadConsistencyHandler(balloon raftwal.RaftBalloonApi, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		consistency, err := protocol.ParseReadConsistency(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		err = balloon.CheckConsistency(consistency)
+		if err == raftwal.ErrNotLeader || err == raftwal.ErrStaleRead {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		h(w, r)
+	}
+}
+
 type statusWriter struct {
 	http.ResponseWriter
 	status int
@@ -382,6 +803,13 @@ func (w *statusWriter) Write(b []byte) (int, error) {
 	return w.ResponseWriter.Write(b)
 }
 
+// Flush lets streaming handlers flush through the wrapped writer.
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
 // LogHandler Logs the Http Status for a request into fileHandler and returns a
 // httphandler function which is a wrapper to log the requests.
 func LogHandler(handle http.Handler) http.HandlerFunc {
@@ -401,6 +829,31 @@ func LogHandler(handle http.Handler) http.HandlerFunc {
 	}
 }
 
+// InfoHandler returns the information of the server that the clients
+// need to verify its proofs:
+//   GET /info
+//
+// It is served without authentication, so it never includes the
+// configuration of the server.
+func InfoHandler(info protocol.ServerInfo) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			w.Header().Set("Allow", "GET")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		out, err := json.Marshal(info)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(out)
+	}
+}
+
 func InfoShardsHandler(balloon raftwal.RaftBalloonApi) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != "GET" {
@@ -20,18 +20,22 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"testing"
 	"time"
 
+	"github.com/bbva/qed/api/auth"
 	"github.com/bbva/qed/balloon"
 	"github.com/bbva/qed/balloon/history"
 	"github.com/bbva/qed/balloon/hyper"
 	"github.com/bbva/qed/hashing"
 	"github.com/bbva/qed/protocol"
 	"github.com/bbva/qed/raftwal"
+	"github.com/bbva/qed/sign"
+	"github.com/bbva/qed/storage"
 	"github.com/bbva/qed/testutils/rand"
 	storage_utils "github.com/bbva/qed/testutils/storage"
 	assert "github.com/stretchr/testify/require"
@@ -69,15 +73,97 @@ func (b fakeRaftBalloon) AddBulk(bulk [][]byte) ([]*balloon.Snapshot, error) {
 	}, nil
 }
 
+func (b fakeRaftBalloon) AddIfAbsent(event []byte) (*balloon.Snapshot, *balloon.MembershipProof, error) {
+	snapshot, _ := b.Add(event)
+	if string(event) == "existing event" {
+		proof, _ := b.QueryMembership(event, 0)
+		return snapshot, proof, nil
+	}
+	return snapshot, nil, nil
+}
+
+func (b fakeRaftBalloon) AddBulkIfAbsent(bulk [][]byte) ([]*balloon.Snapshot, []*balloon.MembershipProof, error) {
+	snapshotBulk, _ := b.AddBulk(bulk)
+	proofs := make([]*balloon.MembershipProof, len(bulk))
+	for i, event := range bulk {
+		if string(event) == "existing event" {
+			proofs[i], _ = b.QueryMembership(event, 0)
+		}
+	}
+	return snapshotBulk, proofs, nil
+}
+
+func (b fakeRaftBalloon) AddIdempotent(event []byte, key string) (*balloon.Snapshot, error) {
+	if key == "used key" {
+		return nil, raftwal.ErrIdempotencyKeyReused
+	}
+	return b.Add(event)
+}
+
+func (b fakeRaftBalloon) AddDigest(eventDigest hashing.Digest) (*balloon.Snapshot, error) {
+	if len(eventDigest) != 32 {
+		return nil, raftwal.ErrInvalidDigestLength
+	}
+	return &balloon.Snapshot{
+		EventDigest:   eventDigest,
+		HistoryDigest: hashing.Digest{0x00},
+		HyperDigest:   hashing.Digest{0x01},
+		Version:       0}, nil
+}
+
+func (b fakeRaftBalloon) AddDigestBulk(eventBulkDigest []hashing.Digest) ([]*balloon.Snapshot, error) {
+	var snapshotBulk []*balloon.Snapshot
+	for i, eventDigest := range eventBulkDigest {
+		snapshot, err := b.AddDigest(eventDigest)
+		if err != nil {
+			return nil, err
+		}
+		snapshot.Version = uint64(i)
+		snapshotBulk = append(snapshotBulk, snapshot)
+	}
+	return snapshotBulk, nil
+}
+
 func (b fakeRaftBalloon) Join(nodeID, addr string, metadata map[string]string) error {
 	return nil
 }
 
+func (b fakeRaftBalloon) Remove(id string) error {
+	return nil
+}
+
+func (b fakeRaftBalloon) Status() (*protocol.NodeStatus, error) {
+	return &protocol.NodeStatus{}, nil
+}
+
+func (b fakeRaftBalloon) ClusterNodes() ([]protocol.ClusterNode, error) {
+	return []protocol.ClusterNode{}, nil
+}
+
+func (b fakeRaftBalloon) SetMetadata(nodeInvolved string, md map[string]string) error {
+	return nil
+}
+
+func (b fakeRaftBalloon) TransferLeadership(id string) error {
+	return nil
+}
+
+func (b fakeRaftBalloon) Snapshot() error {
+	return nil
+}
+
+func (b fakeRaftBalloon) CheckConsistency(c protocol.ReadConsistency) error {
+	if c.Level == protocol.Linearizable {
+		return raftwal.ErrNotLeader
+	}
+	return nil
+}
+
 func (b fakeRaftBalloon) QueryDigestMembership(keyDigest hashing.Digest, version uint64) (*balloon.MembershipProof, error) {
 	return &balloon.MembershipProof{
 		Exists:         true,
-		HyperProof:     hyper.NewQueryProof([]byte{0x0}, []byte{0x0}, hyper.AuditPath{}, nil),
-		HistoryProof:   history.NewMembershipProof(0, 0, history.AuditPath{}, nil),
+		HyperProof:     hyper.NewQueryProof([]byte{0x0}, []byte{0x0}, hyper.AuditPath{}, nil, hashing.FormatV0),
+		HistoryProof:   history.NewMembershipProof(0, 0, history.AuditPath{}, nil, hashing.FormatV0),
 		CurrentVersion: 1,
 		QueryVersion:   1,
 		ActualVersion:  2,
@@ -90,8 +176,8 @@ func (b fakeRaftBalloon) QueryMembership(event []byte, version uint64) (*balloon
 	hasher := hashing.NewFakeXorHasher()
 	return &balloon.MembershipProof{
 		Exists:         true,
-		HyperProof:     hyper.NewQueryProof([]byte{0x0}, []byte{0x0}, hyper.AuditPath{}, nil),
-		HistoryProof:   history.NewMembershipProof(0, 0, history.AuditPath{}, nil),
+		HyperProof:     hyper.NewQueryProof([]byte{0x0}, []byte{0x0}, hyper.AuditPath{}, nil, hashing.FormatV0),
+		HistoryProof:   history.NewMembershipProof(0, 0, history.AuditPath{}, nil, hashing.FormatV0),
 		CurrentVersion: 1,
 		QueryVersion:   1,
 		ActualVersion:  2,
@@ -111,6 +197,87 @@ func (b fakeRaftBalloon) QueryConsistency(start, end uint64) (*balloon.Increment
 	return &ip, nil
 }
 
+func (b fakeRaftBalloon) QueryHistoryDigest(version uint64) (hashing.Digest, error) {
+	return hashing.Digest{0x00}, nil
+}
+
+func (b fakeRaftBalloon) QueryHyperDigest(version uint64) (hashing.Digest, error) {
+	return hashing.Digest{0x01}, nil
+}
+
+func (b fakeRaftBalloon) QueryLeafHashes(start, end uint64) ([]hashing.Digest, error) {
+	hashes := make([]hashing.Digest, 0)
+	for i := start; i <= end; i++ {
+		hashes = append(hashes, hashing.Digest{byte(i)})
+	}
+	return hashes, nil
+}
+
+// QueryEventDigests has no digest for version 0, as if it was logged
+// before the index was kept.
+func (b fakeRaftBalloon) QueryEventDigests(start, end uint64) ([]hashing.Digest, error) {
+	digests := make([]hashing.Digest, 0)
+	for i := start; i <= end; i++ {
+		if i == 0 {
+			digests = append(digests, nil)
+			continue
+		}
+		digests = append(digests, hashing.Digest{byte(i)})
+	}
+	return digests, nil
+}
+
+func (b fakeRaftBalloon) QueryHistoryMembership(index, version uint64) (*history.MembershipProof, error) {
+	return history.NewMembershipProof(index, version, history.AuditPath{}, hashing.NewFakeXorHasher(), hashing.FormatV0), nil
+}
+
+func (b fakeRaftBalloon) QueryTimestamp(version uint64) (int64, error) {
+	return 1550566416000000000, nil
+}
+
+func (b fakeRaftBalloon) PayloadTooLarge(event []byte) bool {
+	return string(event) == "too large event"
+}
+
+func (b fakeRaftBalloon) QueryPayload(version uint64) ([]byte, error) {
+	if version == 0 {
+		return nil, storage.ErrKeyNotFound
+	}
+	return []byte("this is a sample event"), nil
+}
+
+func (b fakeRaftBalloon) Version() uint64 {
+	return 9
+}
+
+func (b fakeRaftBalloon) Hasher() string {
+	return hashing.Sha256
+}
+
+func (b fakeRaftBalloon) Format() hashing.FormatVersion {
+	return hashing.CurrentFormat
+}
+
+func (b fakeRaftBalloon) Keys() []protocol.KeyInfo {
+	return nil
+}
+
+func (b fakeRaftBalloon) ActiveKeyID() string {
+	return ""
+}
+
+func (b fakeRaftBalloon) ActivateKey(key, previous sign.Signer) error {
+	return nil
+}
+
+func (b fakeRaftBalloon) Backup(w io.Writer, signer sign.Signer) error {
+	return nil
+}
+
+func (b fakeRaftBalloon) BackupVersions(w io.Writer, from, until uint64, signer sign.Signer) error {
+	return nil
+}
+
 func (b fakeRaftBalloon) Info() map[string]interface{} {
 	return make(map[string]interface{})
 }
@@ -147,7 +314,7 @@ func TestHealthCheckHandler(t *testing.T) {
 func TestAdd(t *testing.T) {
 	// Create a request to pass to our handler. We pass a message as a data.
 	// If it's nil it will fail.
-	data, _ := json.Marshal(&protocol.Event{[]byte("this is a sample event")})
+	data, _ := json.Marshal(&protocol.Event{Event: []byte("this is a sample event")})
 
 	req, err := http.NewRequest("POST", "/events", bytes.NewBuffer(data))
 	if len(data) == 0 {
@@ -234,6 +401,217 @@ func TestAddBulk(t *testing.T) {
 	}
 }
 
+func TestAddIfAbsent(t *testing.T) {
+	cases := []struct {
+		event          string
+		expectedStatus int
+		expectedProof  bool
+	}{
+		{"new event", http.StatusCreated, false},
+		{"existing event", http.StatusOK, true},
+	}
+
+	for i, c := range cases {
+		data, _ := json.Marshal(&protocol.Event{Event: []byte(c.event), IfAbsent: true})
+		req, err := http.NewRequest("POST", "/events", bytes.NewBuffer(data))
+		assert.NoError(t, err)
+
+		rr := httptest.NewRecorder()
+		Add(fakeRaftBalloon{}).ServeHTTP(rr, req)
+		assert.Equalf(t, c.expectedStatus, rr.Code, "Wrong status code in test case %d", i)
+
+		var result protocol.AddResult
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &result))
+		assert.Equalf(t, uint64(0), result.Snapshot.Version, "Wrong snapshot in test case %d", i)
+		assert.Equalf(t, c.expectedProof, result.Proof != nil, "Wrong proof in test case %d", i)
+	}
+}
+
+func TestAddBulkIfAbsent(t *testing.T) {
+	data, _ := json.Marshal(protocol.EventsBulk{
+		Events:   [][]byte{[]byte("existing event"), []byte("new event")},
+		IfAbsent: true,
+	})
+	req, err := http.NewRequest("POST", "/events/bulk", bytes.NewBuffer(data))
+	assert.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+	AddBulk(fakeRaftBalloon{}).ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusCreated, rr.Code, "Wrong status code")
+
+	var results []*protocol.AddResult
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &results))
+	assert.Len(t, results, 2, "Wrong number of results")
+	assert.NotNil(t, results[0].Proof, "The existing event should come with a proof")
+	assert.Nil(t, results[1].Proof, "The new event should not come with a proof")
+	assert.Equal(t, uint64(1), results[1].Snapshot.Version, "Wrong snapshot")
+}
+
+func TestAddIdempotent(t *testing.T) {
+	cases := []struct {
+		key            string
+		expectedStatus int
+	}{
+		{"new key", http.StatusCreated},
+		{"used key", http.StatusConflict},
+	}
+
+	for i, c := range cases {
+		data, _ := json.Marshal(&protocol.Event{Event: []byte("this is a sample event")})
+		req, err := http.NewRequest("POST", "/events", bytes.NewBuffer(data))
+		assert.NoError(t, err)
+		req.Header.Set("Idempotency-Key", c.key)
+
+		rr := httptest.NewRecorder()
+		Add(fakeRaftBalloon{}).ServeHTTP(rr, req)
+		assert.Equalf(t, c.expectedStatus, rr.Code, "Wrong status code in test case %d", i)
+	}
+}
+
+func TestAddIfAbsentIdempotent(t *testing.T) {
+	data, _ := json.Marshal(&protocol.Event{Event: []byte("this is a sample event"), IfAbsent: true})
+	req, err := http.NewRequest("POST", "/events", bytes.NewBuffer(data))
+	assert.NoError(t, err)
+	req.Header.Set("Idempotency-Key", "new key")
+
+	rr := httptest.NewRecorder()
+	Add(fakeRaftBalloon{}).ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code, "IfAbsent and Idempotency-Key should not be combined")
+}
+
+func TestAddPayloadTooLarge(t *testing.T) {
+	data, _ := json.Marshal(&protocol.Event{Event: []byte("too large event")})
+	req, err := http.NewRequest("POST", "/events", bytes.NewBuffer(data))
+	assert.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+	Add(fakeRaftBalloon{}).ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusCreated, rr.Code, "Events too large to store should be logged anyway")
+	assert.Equal(t, "0", rr.Header().Get(PayloadsNotStoredHeader), "The event should be reported as not stored")
+
+	data, _ = json.Marshal(&protocol.Event{Event: []byte("this is a sample event")})
+	req, err = http.NewRequest("POST", "/events", bytes.NewBuffer(data))
+	assert.NoError(t, err)
+
+	rr = httptest.NewRecorder()
+	Add(fakeRaftBalloon{}).ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	assert.Empty(t, rr.Header().Get(PayloadsNotStoredHeader), "Stored events should not be reported")
+}
+
+func TestAddBulkPayloadTooLarge(t *testing.T) {
+	cases := []struct {
+		events            [][]byte
+		ifAbsent          bool
+		expectedNotStored string
+	}{
+		{[][]byte{[]byte("too large event"), []byte("new event")}, false, "0"},
+		{[][]byte{[]byte("new event"), []byte("too large event")}, false, "1"},
+		{[][]byte{[]byte("existing event"), []byte("too large event")}, true, "1"},
+		{[][]byte{[]byte("existing event"), []byte("new event")}, true, ""},
+	}
+
+	for i, c := range cases {
+		data, _ := json.Marshal(protocol.EventsBulk{Events: c.events, IfAbsent: c.ifAbsent})
+		req, err := http.NewRequest("POST", "/events/bulk", bytes.NewBuffer(data))
+		assert.NoError(t, err)
+
+		rr := httptest.NewRecorder()
+		AddBulk(fakeRaftBalloon{}).ServeHTTP(rr, req)
+		assert.Equalf(t, http.StatusCreated, rr.Code, "Wrong status code in test case %d", i)
+		assert.Equalf(t, c.expectedNotStored, rr.Header().Get(PayloadsNotStoredHeader), "Wrong versions not stored in test case %d", i)
+	}
+}
+
+func TestGetEvent(t *testing.T) {
+	cases := []struct {
+		method, path   string
+		expectedStatus int
+	}{
+		{"GET", "/events/1", http.StatusOK},
+		{"GET", "/events/0", http.StatusNotFound}, // payload not stored
+		{"GET", "/events/9", http.StatusNotFound}, // version not in the log
+		{"GET", "/events/first", http.StatusBadRequest},
+		{"POST", "/events/1", http.StatusMethodNotAllowed},
+	}
+
+	for i, c := range cases {
+		req, err := http.NewRequest(c.method, c.path, nil)
+		assert.NoError(t, err)
+
+		rr := httptest.NewRecorder()
+		GetEvent(fakeRaftBalloon{}).ServeHTTP(rr, req)
+		assert.Equalf(t, c.expectedStatus, rr.Code, "Wrong status code in test case %d", i)
+	}
+
+	req, err := http.NewRequest("GET", "/events/1", nil)
+	assert.NoError(t, err)
+	rr := httptest.NewRecorder()
+	GetEvent(fakeRaftBalloon{}).ServeHTTP(rr, req)
+
+	var result protocol.EventPayload
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &result))
+	assert.Equal(t, uint64(1), result.Version)
+	assert.Equal(t, []byte("this is a sample event"), result.Event)
+	assert.Equal(t, hashing.NewFakeXorHasher().Do(result.Event), result.EventDigest)
+	assert.Equal(t, int64(1550566416000000000), result.Timestamp)
+	assert.True(t, result.Proof.Exists)
+}
+
+func TestAddDigest(t *testing.T) {
+	cases := []struct {
+		digest         hashing.Digest
+		expectedStatus int
+	}{
+		{hashing.NewSha256Hasher().Do([]byte("this is a sample event")), http.StatusCreated},
+		{hashing.Digest{0x01, 0x02}, http.StatusBadRequest},
+	}
+
+	for i, c := range cases {
+		data, _ := json.Marshal(&protocol.EventDigest{Digest: c.digest})
+		req, err := http.NewRequest("POST", "/events/digest", bytes.NewBuffer(data))
+		assert.NoError(t, err)
+
+		rr := httptest.NewRecorder()
+		AddDigest(fakeRaftBalloon{}).ServeHTTP(rr, req)
+		assert.Equalf(t, c.expectedStatus, rr.Code, "Wrong status code in test case %d", i)
+
+		if c.expectedStatus == http.StatusCreated {
+			var snapshot protocol.Snapshot
+			assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &snapshot))
+			assert.Equalf(t, c.digest, snapshot.EventDigest, "The digest should be added as is in test case %d", i)
+		}
+	}
+}
+
+func TestAddDigestBulk(t *testing.T) {
+	hasher := hashing.NewSha256Hasher()
+	cases := []struct {
+		digests        []hashing.Digest
+		expectedStatus int
+	}{
+		{[]hashing.Digest{hasher.Do([]byte("event 1")), hasher.Do([]byte("event 2"))}, http.StatusCreated},
+		{[]hashing.Digest{hasher.Do([]byte("event 1")), {0x01}}, http.StatusBadRequest},
+		{[]hashing.Digest{}, http.StatusBadRequest},
+	}
+
+	for i, c := range cases {
+		data, _ := json.Marshal(&protocol.EventDigestsBulk{Digests: c.digests})
+		req, err := http.NewRequest("POST", "/events/digest/bulk", bytes.NewBuffer(data))
+		assert.NoError(t, err)
+
+		rr := httptest.NewRecorder()
+		AddDigestBulk(fakeRaftBalloon{}).ServeHTTP(rr, req)
+		assert.Equalf(t, c.expectedStatus, rr.Code, "Wrong status code in test case %d", i)
+
+		if c.expectedStatus == http.StatusCreated {
+			var snapshots []*protocol.Snapshot
+			assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &snapshots))
+			assert.Lenf(t, snapshots, len(c.digests), "Wrong number of snapshots in test case %d", i)
+		}
+	}
+}
+
 func TestMembership(t *testing.T) {
 	var version uint64 = 1
 	key := []byte("this is a sample event")
@@ -279,6 +657,35 @@ func TestMembership(t *testing.T) {
 
 }
 
+func TestReadConsistencyHandler(t *testing.T) {
+	query, _ := json.Marshal(protocol.MembershipQuery{
+		Key:     []byte("this is a sample event"),
+		Version: 1,
+	})
+
+	cases := []struct {
+		consistency    string
+		expectedStatus int
+	}{
+		{"", http.StatusOK},
+		{"?consistency=stale", http.StatusOK},
+		{"?consistency=bounded&max_lag=5s", http.StatusOK},
+		{"?consistency=bounded", http.StatusBadRequest},
+		{"?consistency=bounded&max_lag_versions=100", http.StatusBadRequest},
+		{"?consistency=eventual", http.StatusBadRequest},
+		{"?consistency=linearizable", http.StatusServiceUnavailable},
+	}
+
+	for i, c := range cases {
+		req, err := http.NewRequest("POST", "/proofs/membership"+c.consistency, bytes.NewBuffer(query))
+		assert.NoError(t, err)
+
+		rr := httptest.NewRecorder()
+		ReadConsistencyHandler(fakeRaftBalloon{}, Membership(fakeRaftBalloon{})).ServeHTTP(rr, req)
+		assert.Equalf(t, c.expectedStatus, rr.Code, "Wrong status code in test case %d", i)
+	}
+}
+
 func TestDigestMembership(t *testing.T) {
 
 	version := uint64(1)
@@ -362,6 +769,61 @@ func TestIncremental(t *testing.T) {
 	assert.Equal(t, expectedResult, actualResult, "Incorrect proof")
 }
 
+func newKeyStore(t testing.TB) *auth.KeyStore {
+	keys, err := auth.NewKeyStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys.AddKey("reader", "this-is-my-reader-key", auth.Reader)
+	keys.AddKey("writer", "this-is-my-api-key", auth.Writer)
+	return keys
+}
+
+func TestInfoShardsAfterRestore(t *testing.T) {
+
+	dbPath := "/var/tmp/raft-test/node0/db"
+	raftPath := "/var/tmp/raft-test/node0/raft"
+	defer os.RemoveAll("/var/tmp/raft-test/node0")
+
+	// Closing the balloon closes its store, so the directories are only
+	// removed at the end of the test.
+	openNode := func() *raftwal.RaftBalloon {
+		assert.NoError(t, os.MkdirAll(dbPath, os.FileMode(0755)))
+		assert.NoError(t, os.MkdirAll(raftPath, os.FileMode(0755)))
+		store, _ := storage_utils.OpenRocksDBStore(t, dbPath)
+		r, err := raftwal.NewRaftBalloon(raftPath, "127.0.0.1:18900", "0", hashing.Sha256, store, make(chan *protocol.Snapshot, 100))
+		assert.NoError(t, err)
+		return r
+	}
+
+	r := openNode()
+	assert.NoError(t, r.Open(true, map[string]string{"HTTPAddr": "127.0.0.1:8800"}))
+	_, err := r.WaitForLeader(10 * time.Second)
+	assert.NoError(t, err)
+	_, err = r.Add([]byte("All's right with the world"))
+	assert.NoError(t, err)
+	assert.NoError(t, r.Snapshot())
+	assert.NoError(t, r.Close(true))
+
+	// The metadata was applied before the snapshot, so the restarted node
+	// can only learn it by restoring the snapshot.
+	r = openNode()
+	defer r.Close(true)
+	assert.NoError(t, r.Open(false, map[string]string{}))
+	_, err = r.WaitForLeader(10 * time.Second)
+	assert.NoError(t, err)
+
+	req, err := http.NewRequest("GET", "/info/shards", nil)
+	assert.NoError(t, err)
+	rr := httptest.NewRecorder()
+	InfoShardsHandler(r).ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var shards protocol.Shards
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &shards))
+	assert.Equal(t, "127.0.0.1:8800", shards.Shards["0"].HTTPAddr, "The node metadata should survive a snapshot restore")
+}
+
 func TestAuthHandlerMiddleware(t *testing.T) {
 
 	req, err := http.NewRequest("HEAD", "/healthcheck", nil)
@@ -374,7 +836,7 @@ func TestAuthHandlerMiddleware(t *testing.T) {
 
 	// We create a ResponseRecorder (which satisfies http.ResponseWriter) to record the response.
 	rr := httptest.NewRecorder()
-	handler := AuthHandlerMiddleware(HealthCheckHandler)
+	handler := newKeyStore(t).Handler(auth.Reader, HealthCheckHandler)
 
 	// Our handlers satisfy http.Handler, so we can call their ServeHTTP method
 	// directly and pass in our Request and ResponseRecorder.
@@ -387,6 +849,43 @@ func TestAuthHandlerMiddleware(t *testing.T) {
 	}
 }
 
+func TestApiHttpRoles(t *testing.T) {
+
+	event, _ := json.Marshal(protocol.Event{Event: []byte("this is a sample event")})
+
+	cases := []struct {
+		method, path, key string
+		expectedStatus    int
+	}{
+		{"HEAD", "/healthcheck", "", http.StatusUnauthorized},
+		{"HEAD", "/healthcheck", "not-a-key", http.StatusUnauthorized},
+		{"HEAD", "/healthcheck", "this-is-my-reader-key", http.StatusNoContent},
+		{"POST", "/events", "this-is-my-reader-key", http.StatusForbidden},
+		{"POST", "/events", "this-is-my-api-key", http.StatusCreated},
+		{"POST", "/proofs/membership", "", http.StatusUnauthorized},
+		{"GET", "/events/1", "this-is-my-reader-key", http.StatusOK},
+		{"GET", "/info", "", http.StatusOK},
+		{"GET", "/info/keys", "", http.StatusOK},
+		{"POST", "/proofs/receipt", "", http.StatusUnauthorized},
+		{"GET", "/events/export", "", http.StatusUnauthorized},
+		{"GET", "/ct/v1/get-sth", "", http.StatusUnauthorized},
+	}
+
+	api := NewApiHttp(fakeRaftBalloon{}, newKeyStore(t), sign.NewEd25519Signer(), protocol.ServerInfo{}, nil)
+
+	for i, c := range cases {
+		req, err := http.NewRequest(c.method, c.path, bytes.NewBuffer(event))
+		assert.NoError(t, err)
+		if c.key != "" {
+			req.Header.Set("Api-Key", c.key)
+		}
+
+		rr := httptest.NewRecorder()
+		api.ServeHTTP(rr, req)
+		assert.Equalf(t, c.expectedStatus, rr.Code, "Wrong status code in test case %d", i)
+	}
+}
+
 func BenchmarkNoAuth(b *testing.B) {
 
 	req, err := http.NewRequest("GET", "/health-check", nil)
@@ -422,7 +921,7 @@ func BenchmarkAuth(b *testing.B) {
 
 	// We create a ResponseRecorder (which satisfies http.ResponseWriter) to record the response.
 	rr := httptest.NewRecorder()
-	handler := AuthHandlerMiddleware(HealthCheckHandler)
+	handler := newKeyStore(b).Handler(auth.Reader, HealthCheckHandler)
 
 	// Our handlers satisfy http.Handler, so we can call their ServeHTTP method
 	// directly and pass in our Request and ResponseRecorder.
@@ -444,7 +943,7 @@ func newNodeBench(b *testing.B, id int) (*raftwal.RaftBalloon, func()) {
 
 	raftPath := fmt.Sprintf("/var/tmp/raft-test/node%d/raft", id)
 	os.MkdirAll(raftPath, os.FileMode(0755))
-	r, err := raftwal.NewRaftBalloon(raftPath, ":8301", fmt.Sprintf("%d", id), rocks, make(chan *protocol.Snapshot))
+	r, err := raftwal.NewRaftBalloon(raftPath, ":8301", fmt.Sprintf("%d", id), hashing.Sha256, rocks, make(chan *protocol.Snapshot))
 	assert.NoError(b, err)
 
 	return r, closeF
@@ -464,7 +963,7 @@ func BenchmarkApiAdd(b *testing.B) {
 	b.ResetTimer()
 	b.N = 10000
 	for i := 0; i < b.N; i++ {
-		data, _ := json.Marshal(&protocol.Event{rand.Bytes(128)})
+		data, _ := json.Marshal(&protocol.Event{Event: rand.Bytes(128)})
 		req, _ := http.NewRequest("POST", "/events", bytes.NewBuffer(data))
 		rr := httptest.NewRecorder()
 		handler.ServeHTTP(rr, req)
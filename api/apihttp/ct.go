@@ -0,0 +1,337 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package apihttp
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bbva/qed/api/auth"
+	"github.com/bbva/qed/protocol"
+	"github.com/bbva/qed/raftwal"
+	"github.com/bbva/qed/sign"
+)
+
+// CTMaxEntries is the maximum number of entries returned by a single
+// get-entries request.
+const CTMaxEntries = 1000
+
+// The handlers below are a QED-specific API that exposes the history tree
+// with the shape of the Certificate Transparency read API (RFC 6962,
+// section 4). Tree sizes are counted in events, so a tree of size N is the
+// QED version N-1.
+//
+// It is not an RFC 6962 log: QED salts every node hash with its position
+// in the tree, so the root hashes, the consistency proofs and the audit
+// paths can only be verified with the QED hashing rules, and CT libraries
+// will reject them. Entries carry TLS encoded MerkleTreeLeaf structs that
+// CT libraries can parse, but their leaf hashes are the QED ones, not
+// SHA-256(0x00 || leaf_input). Tree heads are signed TreeHeadSignature
+// structs, which CT libraries only verify for ECDSA P-256 keys; heads
+// signed with other keys must be verified with the QED signers.
+
+// GetSTH returns the latest signed tree head of the log:
+//   GET /ct/v1/get-sth
+//
+// If everything is alright, the HTTP status is 200 and the body contains:
+//   {
+//     "tree_size": 8,
+//     "timestamp": 1550566416000,
+//     "sha256_root_hash": "Kpbn+7P4XrZi2hKpdhA7freUicZdUsU6GqmUk0vDJ8A=",
+//     "tree_head_signature": "<truncated for clarity in docs>"
+//   }
+//
+// The timestamp is the one of the last event, so the status is 404 until
+// an event is logged with a timestamp.
+func GetSTH(balloon raftwal.RaftBalloonApi, signer sign.Signer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		// Make sure we can only be called with an HTTP GET request.
+		if r.Method != "GET" {
+			w.Header().Set("Allow", "GET")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		treeSize := balloon.Version()
+		if treeSize == 0 {
+			http.Error(w, "The log is empty", http.StatusNotFound)
+			return
+		}
+
+		digest, err := balloon.QueryHistoryDigest(treeSize - 1)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		snapshot := &protocol.Snapshot{
+			HistoryDigest: digest,
+			Version:       treeSize - 1,
+		}
+
+		// the head is as recent as its last event, but events logged
+		// before timestamps were recorded have none
+		timestamp, err := balloon.QueryTimestamp(treeSize - 1)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if timestamp == 0 {
+			http.Error(w, "The last event has no timestamp", http.StatusNotFound)
+			return
+		}
+		head := protocol.NewTreeHead(snapshot, uint64(timestamp/int64(time.Millisecond)))
+
+		// a single key signs the head, so the algorithm is known
+		key := sign.ActiveKey(signer)
+		signature, err := key.Sign(head.Encode())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		sth, err := protocol.ToSignedTreeHead(head, key.Algorithm(), signature)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, sth)
+	}
+}
+
+// GetSTHConsistency returns a consistency proof between two tree sizes:
+//   GET /ct/v1/get-sth-consistency?first=2&second=8
+//
+// If everything is alright, the HTTP status is 200 and the body contains:
+//   {
+//     "consistency": ["<truncated for clarity in docs>"]
+//   }
+func GetSTHConsistency(balloon raftwal.RaftBalloonApi) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		// Make sure we can only be called with an HTTP GET request.
+		if r.Method != "GET" {
+			w.Header().Set("Allow", "GET")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		first, err := treeSizeParam(r, "first")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		second, err := treeSizeParam(r, "second")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		proof, err := balloon.QueryConsistency(first-1, second-1)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		writeJSON(w, &protocol.ConsistencyResponse{
+			Consistency: protocol.ToCTAuditPath(proof.AuditPath),
+		})
+	}
+}
+
+// GetProofByHash returns the index and the audit path of an event,
+// looked up by its digest, in a tree of the given size:
+//   GET /ct/v1/get-proof-by-hash?hash=<base64 event digest>&tree_size=8
+//
+// Unlike RFC 6962, the hash parameter is the QED digest of the event, not
+// the hash of its leaf, and the audit path follows the QED hashing rules.
+//
+// If everything is alright, the HTTP status is 200 and the body contains:
+//   {
+//     "leaf_index": 3,
+//     "audit_path": ["<truncated for clarity in docs>"]
+//   }
+func GetProofByHash(balloon raftwal.RaftBalloonApi) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		// Make sure we can only be called with an HTTP GET request.
+		if r.Method != "GET" {
+			w.Header().Set("Allow", "GET")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		digest, err := base64.StdEncoding.DecodeString(r.URL.Query().Get("hash"))
+		if err != nil || len(digest) == 0 {
+			http.Error(w, "Invalid hash parameter", http.StatusBadRequest)
+			return
+		}
+		treeSize, err := treeSizeParam(r, "tree_size")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if treeSize > balloon.Version() {
+			http.Error(w, "The tree size is greater than the size of the log", http.StatusBadRequest)
+			return
+		}
+
+		// the hyper tree maps every event digest to its index
+		proof, err := balloon.QueryDigestMembership(digest, treeSize-1)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !proof.Exists || proof.HistoryProof == nil {
+			http.Error(w, "The hash is not in the tree", http.StatusNotFound)
+			return
+		}
+
+		writeJSON(w, &protocol.ProofByHashResponse{
+			LeafIndex: proof.ActualVersion,
+			AuditPath: protocol.ToCTAuditPath(proof.HistoryProof.AuditPath),
+		})
+	}
+}
+
+// GetEntries returns the leaves of the tree between two indexes, both
+// included. At most CTMaxEntries are returned per request:
+//   GET /ct/v1/get-entries?start=0&end=7
+//
+// If everything is alright, the HTTP status is 200 and the body contains:
+//   {
+//     "entries": [
+//       {
+//         "leaf_input": "<truncated for clarity in docs>",
+//         "extra_data": "",
+//         "leaf_index": 0,
+//         "history_leaf_hash": "mHzXvSE/j7eFmNObvC7PdtQTmd4W0q/FPHmiYEjL0eM="
+//       },
+//       ...
+//     ]
+//   }
+//
+// Events logged before timestamps were recorded have a zero timestamp in
+// their leaf input.
+func GetEntries(balloon raftwal.RaftBalloonApi) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		// Make sure we can only be called with an HTTP GET request.
+		if r.Method != "GET" {
+			w.Header().Set("Allow", "GET")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		start, err := strconv.ParseUint(r.URL.Query().Get("start"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid start parameter", http.StatusBadRequest)
+			return
+		}
+		end, err := strconv.ParseUint(r.URL.Query().Get("end"), 10, 64)
+		if err != nil || end < start {
+			http.Error(w, "Invalid end parameter", http.StatusBadRequest)
+			return
+		}
+
+		// clients must ask again for the remaining entries
+		if end-start >= CTMaxEntries {
+			end = start + CTMaxEntries - 1
+		}
+		if last := balloon.Version(); last > 0 && end >= last {
+			end = last - 1
+		}
+
+		hashes, err := balloon.QueryLeafHashes(start, end)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		digests, err := balloon.QueryEventDigests(start, end)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		entries := make([]protocol.Entry, 0, len(hashes))
+		for i, hash := range hashes {
+			index := start + uint64(i)
+			timestamp, err := balloon.QueryTimestamp(index)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			leaf, err := protocol.EncodeMerkleTreeLeaf(digests[i], uint64(timestamp/int64(time.Millisecond)))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			entries = append(entries, protocol.Entry{
+				LeafInput:       leaf,
+				ExtraData:       []byte{},
+				LeafIndex:       index,
+				HistoryLeafHash: hash,
+			})
+		}
+
+		writeJSON(w, &protocol.EntriesResponse{Entries: entries})
+	}
+}
+
+// NewCTApiHttp returns a new *http.ServeMux containing the QED handlers
+// shaped after the Certificate Transparency read API. They are not
+// verifiable with CT libraries. It is meant to be mounted under /ct/v1/.
+//	/ct/v1/get-sth -> GetSTH
+//	/ct/v1/get-sth-consistency -> GetSTHConsistency
+//	/ct/v1/get-proof-by-hash -> GetProofByHash
+//	/ct/v1/get-entries -> GetEntries
+// All of them need an API key with the reader role.
+func NewCTApiHttp(balloon raftwal.RaftBalloonApi, signer sign.Signer, keys *auth.KeyStore) *http.ServeMux {
+
+	api := http.NewServeMux()
+	api.HandleFunc("/ct/v1/get-sth", keys.Handler(auth.Reader, GetSTH(balloon, signer)))
+	api.HandleFunc("/ct/v1/get-sth-consistency", keys.Handler(auth.Reader, GetSTHConsistency(balloon)))
+	api.HandleFunc("/ct/v1/get-proof-by-hash", keys.Handler(auth.Reader, GetProofByHash(balloon)))
+	api.HandleFunc("/ct/v1/get-entries", keys.Handler(auth.Reader, GetEntries(balloon)))
+
+	return api
+}
+
+func treeSizeParam(r *http.Request, name string) (uint64, error) {
+	size, err := strconv.ParseUint(r.URL.Query().Get(name), 10, 64)
+	if err != nil || size == 0 {
+		return 0, fmt.Errorf("Invalid %s parameter", name)
+	}
+	return size, nil
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	out, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(out)
+}
@@ -0,0 +1,202 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package apihttp
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bbva/qed/hashing"
+	"github.com/bbva/qed/protocol"
+	"github.com/bbva/qed/sign"
+	ct "github.com/google/certificate-transparency-go"
+	cttls "github.com/google/certificate-transparency-go/tls"
+	assert "github.com/stretchr/testify/require"
+)
+
+// ctFakeBalloon has SHA-256 sized root hashes, as CT clients expect, and
+// can miss the timestamp of its last event.
+type ctFakeBalloon struct {
+	fakeRaftBalloon
+	noTimestamps bool
+}
+
+func (b ctFakeBalloon) QueryHistoryDigest(version uint64) (hashing.Digest, error) {
+	return hashing.NewSha256Hasher().Do([]byte{byte(version)}), nil
+}
+
+func (b ctFakeBalloon) QueryTimestamp(version uint64) (int64, error) {
+	if b.noTimestamps {
+		return 0, nil
+	}
+	return b.fakeRaftBalloon.QueryTimestamp(version)
+}
+
+func TestGetSTH(t *testing.T) {
+	req, err := http.NewRequest("GET", "/ct/v1/get-sth", nil)
+	assert.NoError(t, err)
+
+	signer := sign.NewEd25519Signer()
+	rr := httptest.NewRecorder()
+	GetSTH(fakeRaftBalloon{}, signer).ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code, "Wrong status code")
+
+	var sth protocol.SignedTreeHead
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &sth))
+	assert.Equal(t, uint64(9), sth.TreeSize, "Wrong tree size")
+	assert.Equal(t, uint64(1550566416000), sth.Timestamp, "The timestamp should be the one of the last event")
+	assert.Equal(t, hashing.Digest{0x00}, sth.RootHash, "Wrong root hash")
+
+	signature, err := sth.Signature()
+	assert.NoError(t, err)
+	ok, err := signer.Verify(sth.TreeHead().Encode(), signature)
+	assert.NoError(t, err)
+	assert.True(t, ok, "The tree head signature should verify")
+}
+
+func TestGetSTHWithCTVerifier(t *testing.T) {
+	req, err := http.NewRequest("GET", "/ct/v1/get-sth", nil)
+	assert.NoError(t, err)
+
+	signer := sign.NewECDSASigner()
+	rr := httptest.NewRecorder()
+	GetSTH(ctFakeBalloon{}, signer).ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code, "Wrong status code")
+
+	var response ct.GetSTHResponse
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	sth, err := response.ToSignedTreeHead()
+	assert.NoError(t, err)
+
+	publicKey, err := x509.ParsePKIXPublicKey(signer.PublicKey())
+	assert.NoError(t, err)
+	verifier, err := ct.NewSignatureVerifier(publicKey)
+	assert.NoError(t, err)
+	assert.NoError(t, verifier.VerifySTHSignature(*sth), "CT clients should verify the tree head")
+}
+
+func TestGetSTHWithoutTimestamp(t *testing.T) {
+	req, err := http.NewRequest("GET", "/ct/v1/get-sth", nil)
+	assert.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+	GetSTH(ctFakeBalloon{noTimestamps: true}, sign.NewEd25519Signer()).ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusNotFound, rr.Code, "Heads must not be signed without the time of their last event")
+}
+
+func TestGetSTHConsistency(t *testing.T) {
+	cases := []struct {
+		query          string
+		expectedStatus int
+	}{
+		{"first=3&second=9", http.StatusOK},
+		{"first=0&second=9", http.StatusBadRequest},
+		{"first=3", http.StatusBadRequest},
+	}
+
+	for i, c := range cases {
+		req, err := http.NewRequest("GET", "/ct/v1/get-sth-consistency?"+c.query, nil)
+		assert.NoError(t, err)
+
+		rr := httptest.NewRecorder()
+		GetSTHConsistency(fakeRaftBalloon{}).ServeHTTP(rr, req)
+		assert.Equalf(t, c.expectedStatus, rr.Code, "Wrong status code in test case %d", i)
+
+		if c.expectedStatus == http.StatusOK {
+			var response protocol.ConsistencyResponse
+			assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+			assert.Equal(t, []hashing.Digest{{0x00}}, response.Consistency, "Wrong consistency proof")
+		}
+	}
+}
+
+func TestGetProofByHash(t *testing.T) {
+	hash := base64.StdEncoding.EncodeToString([]byte{0x01})
+
+	cases := []struct {
+		query          string
+		expectedStatus int
+	}{
+		{"hash=" + hash + "&tree_size=9", http.StatusOK},
+		{"hash=" + hash + "&tree_size=10", http.StatusBadRequest},
+		{"hash=&tree_size=9", http.StatusBadRequest},
+		{"hash=" + hash, http.StatusBadRequest},
+	}
+
+	for i, c := range cases {
+		req, err := http.NewRequest("GET", "/ct/v1/get-proof-by-hash?"+c.query, nil)
+		assert.NoError(t, err)
+
+		rr := httptest.NewRecorder()
+		GetProofByHash(fakeRaftBalloon{}).ServeHTTP(rr, req)
+		assert.Equalf(t, c.expectedStatus, rr.Code, "Wrong status code in test case %d", i)
+
+		if c.expectedStatus == http.StatusOK {
+			var response protocol.ProofByHashResponse
+			assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+			assert.Equal(t, uint64(2), response.LeafIndex, "Wrong leaf index")
+			assert.Empty(t, response.AuditPath, "Wrong audit path")
+		}
+	}
+}
+
+func TestGetEntries(t *testing.T) {
+	cases := []struct {
+		query           string
+		expectedStatus  int
+		expectedEntries int
+	}{
+		{"start=2&end=4", http.StatusOK, 3},
+		{"start=5&end=100", http.StatusOK, 4},
+		{"start=4&end=2", http.StatusBadRequest, 0},
+		{"end=2", http.StatusBadRequest, 0},
+	}
+
+	for i, c := range cases {
+		req, err := http.NewRequest("GET", "/ct/v1/get-entries?"+c.query, nil)
+		assert.NoError(t, err)
+
+		rr := httptest.NewRecorder()
+		GetEntries(fakeRaftBalloon{}).ServeHTTP(rr, req)
+		assert.Equalf(t, c.expectedStatus, rr.Code, "Wrong status code in test case %d", i)
+
+		if c.expectedStatus == http.StatusOK {
+			var response protocol.EntriesResponse
+			assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+			assert.Lenf(t, response.Entries, c.expectedEntries, "Wrong number of entries in test case %d", i)
+			for _, e := range response.Entries {
+				assert.Equal(t, hashing.Digest{byte(e.LeafIndex)}, e.HistoryLeafHash, "Wrong leaf hash")
+
+				var leaf ct.MerkleTreeLeaf
+				rest, err := cttls.Unmarshal(e.LeafInput, &leaf)
+				assert.NoError(t, err)
+				assert.Empty(t, rest, "The leaf input should be a single MerkleTreeLeaf")
+				assert.Equal(t, ct.TimestampedEntryLeafType, leaf.LeafType, "Wrong leaf type")
+				assert.Equal(t, uint64(1550566416000), leaf.TimestampedEntry.Timestamp, "Wrong leaf timestamp")
+				assert.Equal(t, ct.XJSONLogEntryType, leaf.TimestampedEntry.EntryType, "Wrong entry type")
+
+				var data protocol.EntryData
+				assert.NoError(t, json.Unmarshal(leaf.TimestampedEntry.JSONEntry.Data, &data))
+				assert.Equal(t, hashing.Digest{byte(e.LeafIndex)}, data.EventDigest, "Wrong event digest")
+			}
+		}
+	}
+}
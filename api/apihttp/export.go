@@ -0,0 +1,168 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package apihttp
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/bbva/qed/log"
+	"github.com/bbva/qed/protocol"
+	"github.com/bbva/qed/raftwal"
+	"github.com/bbva/qed/sign"
+)
+
+// exportBatch is the number of versions read at once, and flushed to the
+// client, while streaming an export.
+const exportBatch = 1000
+
+// Export streams the entries of the log between two versions, both
+// included, as JSON Lines:
+// The http get url is:
+//   GET /events/export?from=0&to=7&limit=1000
+//
+// The first line is a protocol.ExportHeader with the snapshot of the to
+// version signed by the node, followed by a protocol.ExportEntry for each
+// version. The to version defaults to the last version of the log, which
+// pins the export: every version up to it is immutable, so the entries
+// match the signed snapshot while the log keeps growing. A limit caps the
+// number of entries of the response, and the export is resumed from the
+// end version of the header, or from the last entry received.
+//
+// The following statuses are expected:
+// If everything is alright, the HTTP status is 200 and the body contains:
+//   {"From":0,"End":7,"To":7,"Snapshot":{...}}
+//   {"Version":0,"EventDigest":"...","HistoryDigest":"...","Timestamp":1550566416000000000}
+//   ...
+// If the from version is not in the log, the HTTP status is 404. Errors
+// found once the stream has started close it before its end version.
+func Export(balloon raftwal.RaftBalloonApi, signer sign.Signer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		// Make sure we can only be called with an HTTP GET request.
+		if r.Method != "GET" {
+			w.Header().Set("Allow", "GET")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		query := r.URL.Query()
+		from, err := uintParam(query.Get("from"), 0)
+		if err != nil {
+			http.Error(w, "Invalid from parameter", http.StatusBadRequest)
+			return
+		}
+		last := balloon.Version()
+		if from >= last {
+			http.Error(w, "The from version is not in the log", http.StatusNotFound)
+			return
+		}
+		to, err := uintParam(query.Get("to"), last-1)
+		if err != nil || to < from || to >= last {
+			http.Error(w, "Invalid to parameter", http.StatusBadRequest)
+			return
+		}
+		limit, err := uintParam(query.Get("limit"), 0)
+		if err != nil {
+			http.Error(w, "Invalid limit parameter", http.StatusBadRequest)
+			return
+		}
+		end := to
+		if limit > 0 && to-from >= limit {
+			end = from + limit - 1
+		}
+
+		// the key ID must be the one of the key that signs, even if the
+		// keys are rotated meanwhile
+		snapshot, err := signedSnapshot(balloon, sign.ActiveKey(signer), to, nil)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.WriteHeader(http.StatusOK)
+
+		err = json.NewEncoder(w).Encode(&protocol.ExportHeader{
+			From:     from,
+			End:      end,
+			To:       to,
+			Snapshot: snapshot,
+		})
+		if err == nil {
+			err = exportEntries(balloon, from, end, w)
+		}
+		if err != nil {
+			log.Infof("Export of versions %d to %d aborted: %v", from, end, err)
+		}
+	}
+}
+
+// exportEntries writes a line for each version between from and end, both
+// included, flushing the writer after every batch.
+func exportEntries(balloon raftwal.RaftBalloonApi, from, end uint64, w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	flusher, _ := w.(http.Flusher)
+	for start := from; ; start += exportBatch {
+		batchEnd := start + exportBatch - 1
+		if batchEnd > end || batchEnd < start {
+			batchEnd = end
+		}
+
+		digests, err := balloon.QueryEventDigests(start, batchEnd)
+		if err != nil {
+			return err
+		}
+		for i, digest := range digests {
+			version := start + uint64(i)
+			historyDigest, err := balloon.QueryHistoryDigest(version)
+			if err != nil {
+				return err
+			}
+			timestamp, err := balloon.QueryTimestamp(version)
+			if err != nil {
+				return err
+			}
+			err = encoder.Encode(&protocol.ExportEntry{
+				Version:       version,
+				EventDigest:   digest,
+				HistoryDigest: historyDigest,
+				Timestamp:     timestamp,
+			})
+			if err != nil {
+				return err
+			}
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+
+		if batchEnd == end {
+			return nil
+		}
+	}
+}
+
+// uintParam parses an optional unsigned query string parameter.
+func uintParam(param string, def uint64) (uint64, error) {
+	if param == "" {
+		return def, nil
+	}
+	return strconv.ParseUint(param, 10, 64)
+}
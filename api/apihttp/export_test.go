@@ -0,0 +1,98 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package apihttp
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bbva/qed/hashing"
+	"github.com/bbva/qed/protocol"
+	"github.com/bbva/qed/sign"
+	assert "github.com/stretchr/testify/require"
+)
+
+func TestExport(t *testing.T) {
+	cases := []struct {
+		path             string
+		expectedStatus   int
+		expectedHeader   protocol.ExportHeader
+		expectedVersions []uint64
+	}{
+		{"/events/export", http.StatusOK, protocol.ExportHeader{From: 0, End: 8, To: 8}, []uint64{0, 1, 2, 3, 4, 5, 6, 7, 8}},
+		{"/events/export?from=2&to=4", http.StatusOK, protocol.ExportHeader{From: 2, End: 4, To: 4}, []uint64{2, 3, 4}},
+		{"/events/export?from=2&to=6&limit=2", http.StatusOK, protocol.ExportHeader{From: 2, End: 3, To: 6}, []uint64{2, 3}},
+		{"/events/export?from=6&limit=5", http.StatusOK, protocol.ExportHeader{From: 6, End: 8, To: 8}, []uint64{6, 7, 8}},
+		{"/events/export?from=9", http.StatusNotFound, protocol.ExportHeader{}, nil},
+		{"/events/export?from=4&to=2", http.StatusBadRequest, protocol.ExportHeader{}, nil},
+		{"/events/export?to=9", http.StatusBadRequest, protocol.ExportHeader{}, nil},
+		{"/events/export?from=first", http.StatusBadRequest, protocol.ExportHeader{}, nil},
+		{"/events/export?limit=-1", http.StatusBadRequest, protocol.ExportHeader{}, nil},
+	}
+
+	// the snapshot is signed by the active key of the keyring
+	active := sign.NewEd25519Signer()
+	signer := sign.NewKeyring(func() string { return sign.KeyID(active.PublicKey()) }, sign.NewEd25519Signer(), active)
+
+	for i, c := range cases {
+		req, err := http.NewRequest("GET", c.path, nil)
+		assert.NoError(t, err)
+
+		rr := httptest.NewRecorder()
+		Export(fakeRaftBalloon{}, signer).ServeHTTP(rr, req)
+		assert.Equalf(t, c.expectedStatus, rr.Code, "Wrong status code in test case %d", i)
+
+		if c.expectedStatus != http.StatusOK {
+			continue
+		}
+
+		scanner := bufio.NewScanner(rr.Body)
+		assert.Truef(t, scanner.Scan(), "The header is missing in test case %d", i)
+		var header protocol.ExportHeader
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &header))
+		assert.Equalf(t, c.expectedHeader.From, header.From, "Wrong from version in test case %d", i)
+		assert.Equalf(t, c.expectedHeader.End, header.End, "Wrong end version in test case %d", i)
+		assert.Equalf(t, c.expectedHeader.To, header.To, "Wrong to version in test case %d", i)
+		assert.Equalf(t, c.expectedHeader.To, header.Snapshot.Snapshot.Version, "The snapshot should be the one of the to version in test case %d", i)
+		assert.Equalf(t, hashing.Digest{byte(c.expectedHeader.To)}, header.Snapshot.Snapshot.EventDigest, "Wrong event digest of the snapshot in test case %d", i)
+		assert.Equalf(t, hashing.Digest{0x01}, header.Snapshot.Snapshot.HyperDigest, "Wrong hyper digest of the snapshot in test case %d", i)
+		assert.Equalf(t, sign.KeyID(active.PublicKey()), header.Snapshot.KeyID, "The snapshot should be signed by the active key in test case %d", i)
+
+		payload, err := header.Snapshot.SigningPayload()
+		assert.NoError(t, err)
+		ok, err := active.Verify(payload, header.Snapshot.Signature)
+		assert.NoError(t, err)
+		assert.Truef(t, ok, "The snapshot signature should verify in test case %d", i)
+
+		var versions []uint64
+		for scanner.Scan() {
+			var entry protocol.ExportEntry
+			assert.NoError(t, json.Unmarshal(scanner.Bytes(), &entry))
+			assert.Equalf(t, hashing.Digest{0x00}, entry.HistoryDigest, "Wrong history digest in test case %d", i)
+			if entry.Version == 0 {
+				assert.Nilf(t, entry.EventDigest, "Version 0 is not indexed in test case %d", i)
+			} else {
+				assert.Equalf(t, hashing.Digest{byte(entry.Version)}, entry.EventDigest, "Wrong event digest in test case %d", i)
+			}
+			versions = append(versions, entry.Version)
+		}
+		assert.Equalf(t, c.expectedVersions, versions, "Wrong versions in test case %d", i)
+	}
+}
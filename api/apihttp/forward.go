@@ -0,0 +1,135 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package apihttp
+
+import (
+	"bytes"
+	"crypto/tls"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/bbva/qed/log"
+	"github.com/bbva/qed/raftwal"
+)
+
+const (
+	// ServedByHeader names the node that served a write.
+	ServedByHeader = "Served-By"
+
+	// forwardedByHeader names the follower that forwarded a write, so
+	// that forwarded writes are not forwarded again.
+	forwardedByHeader = "Forwarded-By"
+)
+
+// forwardedHeaders are the request headers sent along with the writes
+// forwarded to the leader.
+var forwardedHeaders = []string{"Api-Key", "Idempotency-Key", "Content-Type"}
+
+// Forwarder sends the writes received by a follower to the leader of the
+// cluster and returns the response of the leader, so that clients can
+// write to any node.
+type Forwarder struct {
+	balloon raftwal.RaftBalloonApi
+	scheme  string
+	client  *http.Client
+}
+
+// NewForwarder returns a forwarder that reaches the public API of the
+// leader at the address it registered when joining the cluster. If the
+// TLS configuration is not nil, it is used to talk to the leader.
+func NewForwarder(balloon raftwal.RaftBalloonApi, tlsConfig *tls.Config, timeout time.Duration) *Forwarder {
+	scheme := "http"
+	if tlsConfig != nil {
+		scheme = "https"
+	}
+	return &Forwarder{
+		balloon: balloon,
+		scheme:  scheme,
+		client: &http.Client{
+			Timeout:   timeout,
+			Transport: &http.Transport{TLSClientConfig: tlsConfig},
+		},
+	}
+}
+
+// Handler serves the writes with the given handler when this node is the
+// leader, and forwards them to the leader otherwise. Either way, the
+// response names the node that served the write in the Served-By header.
+// A nil forwarder returns the handler as is.
+func (f *Forwarder) Handler(h http.HandlerFunc) http.HandlerFunc {
+	if f == nil {
+		return h
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		info := f.balloon.Info()
+		nodeID, _ := info["nodeID"].(string)
+		leaderID, _ := info["leaderID"].(string)
+
+		// writes already forwarded are served here even if the leadership
+		// changed in the meantime, to avoid forwarding loops
+		if leaderID == nodeID || r.Header.Get(forwardedByHeader) != "" {
+			w.Header().Set(ServedByHeader, nodeID)
+			h(w, r)
+			return
+		}
+
+		meta, _ := info["meta"].(map[string]map[string]string)
+		leaderAddr := meta[leaderID]["HTTPAddr"]
+		if leaderID == "" || leaderAddr == "" {
+			http.Error(w, "Unable to find the leader of the cluster", http.StatusServiceUnavailable)
+			return
+		}
+
+		f.forward(w, r, nodeID, f.scheme+"://"+leaderAddr)
+	}
+}
+
+func (f *Forwarder) forward(w http.ResponseWriter, r *http.Request, nodeID, leader string) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	req, err := http.NewRequest(r.Method, leader+r.URL.RequestURI(), bytes.NewReader(body))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for _, h := range forwardedHeaders {
+		if v := r.Header.Get(h); v != "" {
+			req.Header.Set(h, v)
+		}
+	}
+	req.Header.Set(forwardedByHeader, nodeID)
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		log.Infof("Unable to forward the write to the leader %s: %v", leader, err)
+		http.Error(w, "Unable to forward the write to the leader", http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	for k, v := range resp.Header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(resp.StatusCode)
+	_, _ = io.Copy(w, resp.Body)
+}
@@ -0,0 +1,104 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package apihttp
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bbva/qed/protocol"
+	"github.com/bbva/qed/sign"
+	assert "github.com/stretchr/testify/require"
+)
+
+// clusterRaftBalloon is a fake balloon that belongs to a cluster led by
+// the node with the given HTTP address.
+type clusterRaftBalloon struct {
+	fakeRaftBalloon
+	nodeID, leaderID, leaderAddr string
+}
+
+func (b clusterRaftBalloon) Info() map[string]interface{} {
+	meta := map[string]map[string]string{b.nodeID: {"HTTPAddr": "127.0.0.1:0"}}
+	if b.leaderAddr != "" {
+		meta[b.leaderID] = map[string]string{"HTTPAddr": b.leaderAddr}
+	}
+	return map[string]interface{}{
+		"nodeID":   b.nodeID,
+		"leaderID": b.leaderID,
+		"meta":     meta,
+	}
+}
+
+func TestForwarder(t *testing.T) {
+	leaderBalloon := clusterRaftBalloon{nodeID: "0", leaderID: "0"}
+	leader := httptest.NewServer(NewApiHttp(leaderBalloon, newKeyStore(t), sign.NewEd25519Signer(), protocol.ServerInfo{}, NewForwarder(leaderBalloon, nil, time.Second)))
+	defer leader.Close()
+
+	followerBalloon := clusterRaftBalloon{nodeID: "1", leaderID: "0", leaderAddr: strings.TrimPrefix(leader.URL, "http://")}
+	follower := NewApiHttp(followerBalloon, newKeyStore(t), sign.NewEd25519Signer(), protocol.ServerInfo{}, NewForwarder(followerBalloon, nil, time.Second))
+
+	body, _ := json.Marshal(&protocol.Event{Event: []byte("this is a sample event")})
+
+	cases := []struct {
+		headers          map[string]string
+		expectedStatus   int
+		expectedServedBy string
+	}{
+		{map[string]string{"Api-Key": "this-is-my-api-key"}, http.StatusCreated, "0"},
+		{map[string]string{"Api-Key": "this-is-my-reader-key"}, http.StatusForbidden, ""},
+		{map[string]string{"Api-Key": "this-is-my-api-key", "Forwarded-By": "2"}, http.StatusCreated, "1"},
+	}
+
+	for i, c := range cases {
+		req, err := http.NewRequest("POST", "/events", bytes.NewReader(body))
+		assert.NoError(t, err)
+		for k, v := range c.headers {
+			req.Header.Set(k, v)
+		}
+
+		rr := httptest.NewRecorder()
+		follower.ServeHTTP(rr, req)
+		assert.Equalf(t, c.expectedStatus, rr.Code, "Wrong status code in test case %d", i)
+		assert.Equalf(t, c.expectedServedBy, rr.Header().Get(ServedByHeader), "Wrong serving node in test case %d", i)
+
+		if c.expectedStatus == http.StatusCreated {
+			var snapshot protocol.Snapshot
+			assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &snapshot))
+			assert.Equalf(t, uint64(0), snapshot.Version, "Wrong version in test case %d", i)
+		}
+	}
+}
+
+func TestForwarderWithoutLeader(t *testing.T) {
+	balloon := clusterRaftBalloon{nodeID: "1"}
+	api := NewApiHttp(balloon, newKeyStore(t), sign.NewEd25519Signer(), protocol.ServerInfo{}, NewForwarder(balloon, nil, time.Second))
+
+	body, _ := json.Marshal(&protocol.Event{Event: []byte("this is a sample event")})
+	req, err := http.NewRequest("POST", "/events", bytes.NewReader(body))
+	assert.NoError(t, err)
+	req.Header.Set("Api-Key", "this-is-my-api-key")
+
+	rr := httptest.NewRecorder()
+	api.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code, "Wrong status code")
+}
@@ -0,0 +1,74 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package apihttp
+
+import (
+	"net/http"
+
+	"github.com/bbva/qed/protocol"
+	"github.com/bbva/qed/raftwal"
+	"github.com/bbva/qed/sign"
+)
+
+// InfoKeys returns the public keys that sign, or signed, the snapshots
+// of the log, so that verifiers can check snapshots signed before and
+// after a key rotation:
+//   GET /info/keys
+//
+// If everything is alright, the HTTP status is 200 and the body contains:
+//   {
+//     "Keys": [
+//       {
+//         "KeyID": "9a0364b9e99bb480",
+//         "Algorithm": "ed25519",
+//         "PublicKey": "l4svbWjYfmr5hAMs355Ql8WB84Et8BjwoudgGPKkMk8=",
+//         "ValidFrom": 0,
+//         "ValidUntil": 1550566416000000000
+//       },
+//       {
+//         "KeyID": "1c4f5a0c2b7e9d33",
+//         "Algorithm": "ecdsa-p256-sha256",
+//         "PublicKey": "<truncated for clarity in docs>",
+//         "ValidFrom": 1550566416000000000
+//       }
+//     ]
+//   }
+//
+// A log that has never been rotated only lists the key of the server.
+func InfoKeys(balloon raftwal.RaftBalloonApi, signer sign.Signer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		// Make sure we can only be called with an HTTP GET request.
+		if r.Method != "GET" {
+			w.Header().Set("Allow", "GET")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		keys := balloon.Keys()
+		if len(keys) == 0 {
+			key := sign.ActiveKey(signer)
+			keys = []protocol.KeyInfo{{
+				KeyID:     sign.KeyID(key.PublicKey()),
+				Algorithm: key.Algorithm(),
+				PublicKey: key.PublicKey(),
+			}}
+		}
+
+		writeJSON(w, &protocol.KeysResponse{Keys: keys})
+	}
+}
@@ -0,0 +1,44 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package apihttp
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bbva/qed/protocol"
+	"github.com/bbva/qed/sign"
+	assert "github.com/stretchr/testify/require"
+)
+
+func TestInfoKeys(t *testing.T) {
+	req, err := http.NewRequest("GET", "/info/keys", nil)
+	assert.NoError(t, err)
+
+	signer := sign.NewEd25519Signer()
+	rr := httptest.NewRecorder()
+	InfoKeys(fakeRaftBalloon{}, sign.NewKeyring(nil, signer)).ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code, "Wrong status code")
+
+	var response protocol.KeysResponse
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	assert.Len(t, response.Keys, 1, "A log never rotated should list the key of the server")
+	assert.Equal(t, sign.KeyID(signer.PublicKey()), response.Keys[0].KeyID, "Wrong key ID")
+	assert.Equal(t, signer.PublicKey(), response.Keys[0].PublicKey, "Wrong public key")
+}
@@ -0,0 +1,192 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package apihttp
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/bbva/qed/hashing"
+	"github.com/bbva/qed/protocol"
+	"github.com/bbva/qed/raftwal"
+	"github.com/bbva/qed/sign"
+)
+
+// Receipt returns a receipt of an event, looked up by its digest, that can
+// be verified offline with the public key of the server:
+// The http post url is:
+//   POST /proofs/receipt
+//
+// The body contains the event digest and, optionally, a later version to
+// prove the consistency of the log up to it:
+//   {
+//     "KeyDigest": "mHzXvSE/j7eFmNObvC7PdtQTmd4W0q/FPHmiYEjL0eM=",
+//     "LaterVersion": 8
+//   }
+//
+// The following statuses are expected:
+// If everything is alright, the HTTP status is 200 and the body contains:
+//   {
+//     "Version": 1,
+//     "Hasher": "sha256",
+//     "Format": 1,
+//     "EventDigest": "mHzXvSE/j7eFmNObvC7PdtQTmd4W0q/FPHmiYEjL0eM=",
+//     "KeyID": "5c3e8b8a1f4d2e6a",
+//     "Snapshot": { "Snapshot": { ... }, "Signature": "..." },
+//     "Membership": { ... },
+//     "Consistency": { ... },
+//     "LaterSnapshot": { "Snapshot": { ... }, "Signature": "..." }
+//   }
+// If the event is not in the log, the HTTP status is 404.
+//
+// Both snapshots are complete, so each of them verifies on its own.
+func Receipt(balloon raftwal.RaftBalloonApi, signer sign.Signer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		// Make sure we can only be called with an HTTP POST request.
+		if r.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		var query protocol.ReceiptQuery
+		err := json.NewDecoder(r.Body).Decode(&query)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		last := balloon.Version()
+		if last == 0 {
+			http.Error(w, "The event is not in the log", http.StatusNotFound)
+			return
+		}
+		if query.LaterVersion >= last {
+			http.Error(w, "The later version is greater than the last version of the log", http.StatusBadRequest)
+			return
+		}
+
+		// the proof must verify against the snapshot of the event itself
+		proof, err := balloon.QueryDigestMembership(query.KeyDigest, last-1)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !proof.Exists {
+			http.Error(w, "The event is not in the log", http.StatusNotFound)
+			return
+		}
+		if proof.ActualVersion < proof.QueryVersion {
+			proof, err = balloon.QueryDigestMembership(query.KeyDigest, proof.ActualVersion)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+
+		// both snapshots must be signed with the same key
+		key := sign.ActiveKey(signer)
+		snapshot, err := signedSnapshot(balloon, key, proof.ActualVersion, query.KeyDigest)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		receipt := &protocol.Receipt{
+			Version:     protocol.ReceiptVersion,
+			Hasher:      balloon.Hasher(),
+			Format:      balloon.Format(),
+			EventDigest: query.KeyDigest,
+			KeyID:       snapshot.KeyID,
+			Snapshot:    snapshot,
+			Membership:  protocol.ToMembershipResult(nil, proof),
+		}
+
+		if query.LaterVersion != 0 {
+			if query.LaterVersion < proof.ActualVersion {
+				http.Error(w, "The later version is lower than the version of the event", http.StatusBadRequest)
+				return
+			}
+			incremental, err := balloon.QueryConsistency(proof.ActualVersion, query.LaterVersion)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			later, err := signedSnapshot(balloon, key, query.LaterVersion, nil)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			receipt.Consistency = protocol.ToIncrementalResponse(incremental)
+			receipt.LaterSnapshot = later
+		}
+
+		writeJSON(w, receipt)
+	}
+}
+
+// signedSnapshot rebuilds the snapshot of the given version of the log and
+// signs it. The event digest is looked up in the index unless it is given,
+// and it is nil for events logged before the index was kept.
+func signedSnapshot(balloon raftwal.RaftBalloonApi, signer sign.Signer, version uint64, eventDigest hashing.Digest) (*protocol.SignedSnapshot, error) {
+
+	if eventDigest == nil {
+		digests, err := balloon.QueryEventDigests(version, version)
+		if err != nil {
+			return nil, err
+		}
+		eventDigest = digests[0]
+	}
+	historyDigest, err := balloon.QueryHistoryDigest(version)
+	if err != nil {
+		return nil, err
+	}
+	hyperDigest, err := balloon.QueryHyperDigest(version)
+	if err != nil {
+		return nil, err
+	}
+	timestamp, err := balloon.QueryTimestamp(version)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := &protocol.Snapshot{
+		EventDigest:   eventDigest,
+		HistoryDigest: historyDigest,
+		HyperDigest:   hyperDigest,
+		Version:       version,
+		Timestamp:     timestamp,
+	}
+
+	signed := &protocol.SignedSnapshot{
+		Snapshot:  snapshot,
+		Hasher:    balloon.Hasher(),
+		KeyID:     sign.KeyID(signer.PublicKey()),
+		Algorithm: signer.Algorithm(),
+	}
+	payload, err := signed.SigningPayload()
+	if err != nil {
+		return nil, err
+	}
+	signed.Signature, err = signer.Sign(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	return signed, nil
+}
@@ -0,0 +1,85 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package apihttp
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bbva/qed/hashing"
+	"github.com/bbva/qed/protocol"
+	"github.com/bbva/qed/sign"
+	assert "github.com/stretchr/testify/require"
+)
+
+func TestReceipt(t *testing.T) {
+	cases := []struct {
+		laterVersion       uint64
+		expectedStatus     int
+		expectsConsistency bool
+	}{
+		{0, http.StatusOK, false},
+		{5, http.StatusOK, true},
+		{1, http.StatusBadRequest, false},
+		{9, http.StatusBadRequest, false},
+	}
+
+	signer := sign.NewEd25519Signer()
+
+	for i, c := range cases {
+		data, _ := json.Marshal(&protocol.ReceiptQuery{KeyDigest: hashing.Digest{0x01}, LaterVersion: c.laterVersion})
+		req, err := http.NewRequest("POST", "/proofs/receipt", bytes.NewBuffer(data))
+		assert.NoError(t, err)
+
+		rr := httptest.NewRecorder()
+		Receipt(fakeRaftBalloon{}, signer).ServeHTTP(rr, req)
+		assert.Equalf(t, c.expectedStatus, rr.Code, "Wrong status code in test case %d", i)
+
+		if c.expectedStatus != http.StatusOK {
+			continue
+		}
+
+		var receipt protocol.Receipt
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &receipt))
+		assert.Equalf(t, sign.KeyID(signer.PublicKey()), receipt.KeyID, "Wrong key ID in test case %d", i)
+		assert.Equalf(t, hashing.Sha256, receipt.Hasher, "Wrong hasher in test case %d", i)
+		assert.Equalf(t, uint64(2), receipt.Snapshot.Snapshot.Version, "The snapshot should be the one of the event in test case %d", i)
+		assert.Equalf(t, int64(1550566416000000000), receipt.Snapshot.Snapshot.Timestamp, "Wrong timestamp in test case %d", i)
+
+		payload, err := receipt.Snapshot.SigningPayload()
+		assert.NoError(t, err)
+		ok, err := signer.Verify(payload, receipt.Snapshot.Signature)
+		assert.NoError(t, err)
+		assert.Truef(t, ok, "The snapshot signature should verify in test case %d", i)
+
+		assert.Equalf(t, c.expectsConsistency, receipt.Consistency != nil, "Wrong consistency proof in test case %d", i)
+		if c.expectsConsistency {
+			assert.Equalf(t, c.laterVersion, receipt.LaterSnapshot.Snapshot.Version, "Wrong later snapshot in test case %d", i)
+			assert.Equalf(t, hashing.Digest{byte(c.laterVersion)}, receipt.LaterSnapshot.Snapshot.EventDigest, "The later snapshot should carry its event digest in test case %d", i)
+			assert.Equalf(t, hashing.Digest{0x01}, receipt.LaterSnapshot.Snapshot.HyperDigest, "The later snapshot should carry its hyper digest in test case %d", i)
+
+			payload, err := receipt.LaterSnapshot.SigningPayload()
+			assert.NoError(t, err)
+			ok, err := signer.Verify(payload, receipt.LaterSnapshot.Signature)
+			assert.NoError(t, err)
+			assert.Truef(t, ok, "The later snapshot signature should verify in test case %d", i)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package apihttp
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/bbva/qed/hashing"
+	"github.com/bbva/qed/protocol"
+	"github.com/bbva/qed/raftwal"
+)
+
+// MaxVersionEntries is the maximum number of entries returned by a single
+// EventsByVersion request.
+const MaxVersionEntries = 1000
+
+// EventByVersion returns the digest of the event logged at a version
+// along with a proof of its membership in the history tree:
+// The http get url is:
+//   GET /events/by-version/{version}?version=8
+//
+// The proof verifies against the history digest of the version given in
+// the query string, which defaults to the last version of the log.
+//
+// The following statuses are expected:
+// If everything is alright, the HTTP status is 200 and the body contains:
+//   {
+//     "Version": 1,
+//     "EventDigest": "mHzXvSE/j7eFmNObvC7PdtQTmd4W0q/FPHmiYEjL0eM=",
+//     "Timestamp": 1550566416000000000,
+//     "QueryVersion": 8,
+//     "AuditPath": { ... }
+//   }
+// If the version is not in the log, or the event was logged before the
+// digests were indexed, the HTTP status is 404.
+func EventByVersion(balloon raftwal.RaftBalloonApi) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		// Make sure we can only be called with an HTTP GET request.
+		if r.Method != "GET" {
+			w.Header().Set("Allow", "GET")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		version, err := strconv.ParseUint(strings.TrimPrefix(r.URL.Path, "/events/by-version/"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid event version", http.StatusBadRequest)
+			return
+		}
+		last := balloon.Version()
+		if version >= last {
+			http.Error(w, "The version is not in the log", http.StatusNotFound)
+			return
+		}
+		queryVersion, err := queryVersionParam(r, version, last)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		digests, err := balloon.QueryEventDigests(version, version)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if digests[0] == nil {
+			http.Error(w, "The digest of the event is not indexed", http.StatusNotFound)
+			return
+		}
+
+		entry, err := versionEntry(balloon, version, queryVersion, digests[0])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, entry)
+	}
+}
+
+// EventsByVersion returns the digests of the events logged between two
+// versions, both included, along with proofs of their membership in the
+// history tree. At most MaxVersionEntries are returned per request:
+// The http get url is:
+//   GET /events/by-version?start=0&end=7&version=8
+//
+// The end defaults to the last version of the log, and the proofs verify
+// against the history digest of the version given in the query string,
+// which defaults to the last version of the log too. Events logged before
+// the digests were indexed are left out, so clients paging through a
+// range should resume after the end version of the response.
+//
+// The following statuses are expected:
+// If everything is alright, the HTTP status is 200 and the body contains:
+//   {
+//     "Start": 0,
+//     "End": 7,
+//     "QueryVersion": 8,
+//     "Entries": [
+//       { "Version": 0, "EventDigest": "...", "QueryVersion": 8, ... },
+//       ...
+//     ]
+//   }
+func EventsByVersion(balloon raftwal.RaftBalloonApi) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		// Make sure we can only be called with an HTTP GET request.
+		if r.Method != "GET" {
+			w.Header().Set("Allow", "GET")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		query := r.URL.Query()
+		start, err := strconv.ParseUint(query.Get("start"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid start parameter", http.StatusBadRequest)
+			return
+		}
+		last := balloon.Version()
+		if start >= last {
+			http.Error(w, "The start version is not in the log", http.StatusNotFound)
+			return
+		}
+		end := last - 1
+		if query.Get("end") != "" {
+			end, err = strconv.ParseUint(query.Get("end"), 10, 64)
+			if err != nil || end < start {
+				http.Error(w, "Invalid end parameter", http.StatusBadRequest)
+				return
+			}
+		}
+		if end-start >= MaxVersionEntries {
+			end = start + MaxVersionEntries - 1
+		}
+		if end >= last {
+			end = last - 1
+		}
+		queryVersion, err := queryVersionParam(r, end, last)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		digests, err := balloon.QueryEventDigests(start, end)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		entries := make([]*protocol.VersionEntry, 0, len(digests))
+		for i, digest := range digests {
+			if digest == nil {
+				continue
+			}
+			entry, err := versionEntry(balloon, start+uint64(i), queryVersion, digest)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			entries = append(entries, entry)
+		}
+
+		writeJSON(w, &protocol.VersionEntries{
+			Start:        start,
+			End:          end,
+			QueryVersion: queryVersion,
+			Entries:      entries,
+		})
+	}
+}
+
+// queryVersionParam returns the version the proofs must verify against,
+// which defaults to the last version of the log. It cannot be lower than
+// the version of the events proved.
+func queryVersionParam(r *http.Request, min, last uint64) (uint64, error) {
+	param := r.URL.Query().Get("version")
+	if param == "" {
+		return last - 1, nil
+	}
+	version, err := strconv.ParseUint(param, 10, 64)
+	if err != nil || version < min || version >= last {
+		return 0, errors.New("Invalid version parameter")
+	}
+	return version, nil
+}
+
+// versionEntry builds the entry of the event logged at the given version,
+// with a proof that verifies against the history digest of queryVersion.
+func versionEntry(balloon raftwal.RaftBalloonApi, version, queryVersion uint64, digest hashing.Digest) (*protocol.VersionEntry, error) {
+
+	proof, err := balloon.QueryHistoryMembership(version, queryVersion)
+	if err != nil {
+		return nil, err
+	}
+	timestamp, err := balloon.QueryTimestamp(version)
+	if err != nil {
+		return nil, err
+	}
+
+	return &protocol.VersionEntry{
+		Version:      version,
+		EventDigest:  digest,
+		Timestamp:    timestamp,
+		QueryVersion: queryVersion,
+		AuditPath:    proof.AuditPath.Serialize(),
+	}, nil
+}
@@ -0,0 +1,107 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package apihttp
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bbva/qed/hashing"
+	"github.com/bbva/qed/protocol"
+	assert "github.com/stretchr/testify/require"
+)
+
+func TestEventByVersion(t *testing.T) {
+	cases := []struct {
+		path                 string
+		expectedStatus       int
+		expectedQueryVersion uint64
+	}{
+		{"/events/by-version/3", http.StatusOK, 8},
+		{"/events/by-version/3?version=5", http.StatusOK, 5},
+		{"/events/by-version/3?version=2", http.StatusBadRequest, 0},
+		{"/events/by-version/3?version=9", http.StatusBadRequest, 0},
+		{"/events/by-version/0", http.StatusNotFound, 0}, // not indexed
+		{"/events/by-version/9", http.StatusNotFound, 0},
+		{"/events/by-version/last", http.StatusBadRequest, 0},
+	}
+
+	for i, c := range cases {
+		req, err := http.NewRequest("GET", c.path, nil)
+		assert.NoError(t, err)
+
+		rr := httptest.NewRecorder()
+		EventByVersion(fakeRaftBalloon{}).ServeHTTP(rr, req)
+		assert.Equalf(t, c.expectedStatus, rr.Code, "Wrong status code in test case %d", i)
+
+		if c.expectedStatus != http.StatusOK {
+			continue
+		}
+
+		var entry protocol.VersionEntry
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &entry))
+		assert.Equalf(t, uint64(3), entry.Version, "Wrong version in test case %d", i)
+		assert.Equalf(t, hashing.Digest{0x03}, entry.EventDigest, "Wrong digest in test case %d", i)
+		assert.Equalf(t, c.expectedQueryVersion, entry.QueryVersion, "Wrong query version in test case %d", i)
+		assert.Equalf(t, int64(1550566416000000000), entry.Timestamp, "Wrong timestamp in test case %d", i)
+	}
+}
+
+func TestEventsByVersion(t *testing.T) {
+	cases := []struct {
+		path             string
+		expectedStatus   int
+		expectedEnd      uint64
+		expectedVersions []uint64
+	}{
+		{"/events/by-version?start=2&end=4", http.StatusOK, 4, []uint64{2, 3, 4}},
+		{"/events/by-version?start=6", http.StatusOK, 8, []uint64{6, 7, 8}},
+		{"/events/by-version?start=6&end=20", http.StatusOK, 8, []uint64{6, 7, 8}},
+		{"/events/by-version?start=0&end=2", http.StatusOK, 2, []uint64{1, 2}}, // 0 is not indexed
+		{"/events/by-version?start=0&end=0", http.StatusOK, 0, nil},
+		{"/events/by-version?start=2&end=4&version=3", http.StatusBadRequest, 0, nil},
+		{"/events/by-version?start=4&end=2", http.StatusBadRequest, 0, nil},
+		{"/events/by-version?end=2", http.StatusBadRequest, 0, nil},
+		{"/events/by-version?start=9", http.StatusNotFound, 0, nil},
+	}
+
+	for i, c := range cases {
+		req, err := http.NewRequest("GET", c.path, nil)
+		assert.NoError(t, err)
+
+		rr := httptest.NewRecorder()
+		EventsByVersion(fakeRaftBalloon{}).ServeHTTP(rr, req)
+		assert.Equalf(t, c.expectedStatus, rr.Code, "Wrong status code in test case %d", i)
+
+		if c.expectedStatus != http.StatusOK {
+			continue
+		}
+
+		var response protocol.VersionEntries
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+		assert.Equalf(t, c.expectedEnd, response.End, "Wrong end version in test case %d", i)
+		assert.Equalf(t, uint64(8), response.QueryVersion, "Wrong query version in test case %d", i)
+		var versions []uint64
+		for _, entry := range response.Entries {
+			assert.Equalf(t, uint64(8), entry.QueryVersion, "Wrong query version in test case %d", i)
+			versions = append(versions, entry.Version)
+		}
+		assert.Equalf(t, c.expectedVersions, versions, "Wrong versions in test case %d", i)
+	}
+}
@@ -0,0 +1,314 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package auth authenticates the API keys of the requests and authorizes
+// them by the role of each key.
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/bbva/qed/log"
+	"github.com/bbva/qed/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Role is the set of permissions of an API key. Roles are ordered, so
+// every role is granted the permissions of the lower ones: admins can
+// also write and read, and writers can also read.
+type Role int
+
+const (
+	Reader Role = iota + 1
+	Writer
+	Admin
+)
+
+var roleNames = map[Role]string{
+	Reader: "reader",
+	Writer: "writer",
+	Admin:  "admin",
+}
+
+func (r Role) String() string {
+	if name, ok := roleNames[r]; ok {
+		return name
+	}
+	return fmt.Sprintf("Role(%d)", int(r))
+}
+
+// Allows tells whether the role is granted the permissions of the
+// required one.
+func (r Role) Allows(required Role) bool {
+	return r >= required
+}
+
+// ParseRole returns the role with the given name.
+func ParseRole(name string) (Role, error) {
+	for role, n := range roleNames {
+		if n == name {
+			return role, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown role %q", name)
+}
+
+var (
+	// ErrMissingKey is returned when a request carries no API key.
+	ErrMissingKey = errors.New("missing api key")
+	// ErrUnknownKey is returned when an API key is not in the key store.
+	ErrUnknownKey = errors.New("unknown api key")
+	// ErrForbidden is returned when the role of an API key does not
+	// allow the request.
+	ErrForbidden = errors.New("forbidden")
+)
+
+// Key is an entry of the keys file. Keys are stored as the hex encoded
+// SHA-256 digest of the API key, so the file does not reveal them:
+//   echo -n "$API_KEY" | sha256sum
+type Key struct {
+	Name string
+	Hash string
+	Role string
+}
+
+// keysFile is the JSON document of the keys file:
+//   {
+//     "Keys": [
+//       { "Name": "ingestion", "Hash": "2c26b46b...", "Role": "writer" },
+//       { "Name": "auditors", "Hash": "fcde2b2e...", "Role": "reader" }
+//     ]
+//   }
+type keysFile struct {
+	Keys []Key
+}
+
+type entry struct {
+	name string
+	role Role
+}
+
+// HashKey returns the hash under which an API key is stored.
+func HashKey(key string) string {
+	digest := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(digest[:])
+}
+
+// KeyStore holds the API keys allowed to use the service. Keys are loaded
+// from a file, which is reloaded when it changes, and from static keys
+// added in the configuration.
+//
+// A key store without keys rejects every request.
+type KeyStore struct {
+	path string
+
+	mu      sync.RWMutex
+	keys    map[string]entry
+	static  map[string]entry
+	modTime time.Time
+	size    int64
+
+	metrics *keyStoreMetrics
+	quitCh  chan struct{}
+}
+
+// NewKeyStore returns a key store loaded from the given keys file. An
+// empty path returns a key store with static keys only.
+func NewKeyStore(path string) (*KeyStore, error) {
+	s := &KeyStore{
+		path:    path,
+		keys:    make(map[string]entry),
+		static:  make(map[string]entry),
+		metrics: newKeyStoreMetrics(),
+		quitCh:  make(chan struct{}),
+	}
+	if path != "" {
+		if err := s.Reload(); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// AddKey adds a static API key, which is kept across reloads.
+func (s *KeyStore) AddKey(name, key string, role Role) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.static[HashKey(key)] = entry{name, role}
+}
+
+// Reload loads the keys file again if it has changed since the last
+// load. The keys are left untouched if the file is not valid.
+func (s *KeyStore) Reload() error {
+
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return err
+	}
+
+	s.mu.RLock()
+	unchanged := info.ModTime().Equal(s.modTime) && info.Size() == s.size
+	s.mu.RUnlock()
+	if unchanged {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+	var file keysFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("invalid keys file %s: %v", s.path, err)
+	}
+
+	keys := make(map[string]entry, len(file.Keys))
+	for _, k := range file.Keys {
+		role, err := ParseRole(k.Role)
+		if err != nil {
+			return fmt.Errorf("invalid key %s: %v", k.Name, err)
+		}
+		if _, err := hex.DecodeString(k.Hash); err != nil || len(k.Hash) != 2*sha256.Size {
+			return fmt.Errorf("invalid key %s: the hash is not a hex encoded sha256 digest", k.Name)
+		}
+		keys[k.Hash] = entry{k.Name, role}
+	}
+
+	s.mu.Lock()
+	s.keys = keys
+	s.modTime = info.ModTime()
+	s.size = info.Size()
+	s.mu.Unlock()
+
+	log.Infof("Loaded %d api keys from %s", len(keys), s.path)
+	return nil
+}
+
+// Start reloads the keys file, if any, every interval until Stop is
+// called.
+func (s *KeyStore) Start(interval time.Duration) {
+	if s.path == "" {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := s.Reload(); err != nil {
+					log.Errorf("Unable to reload the api keys: %v", err)
+				}
+			case <-s.quitCh:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops reloading the keys file.
+func (s *KeyStore) Stop() {
+	close(s.quitCh)
+}
+
+// Authorize checks that the API key is in the store and that its role
+// allows the required one. Rejected keys are counted in the metrics.
+func (s *KeyStore) Authorize(key string, required Role) error {
+
+	if key == "" {
+		s.metrics.Rejected.WithLabelValues("missing").Inc()
+		return ErrMissingKey
+	}
+
+	hash := HashKey(key)
+	s.mu.RLock()
+	e, ok := s.static[hash]
+	if !ok {
+		e, ok = s.keys[hash]
+	}
+	s.mu.RUnlock()
+
+	if !ok {
+		s.metrics.Rejected.WithLabelValues("unknown").Inc()
+		return ErrUnknownKey
+	}
+	if !e.role.Allows(required) {
+		s.metrics.Rejected.WithLabelValues("forbidden").Inc()
+		log.Debugf("Key %s with role %s cannot act as %s", e.name, e.role, required)
+		return ErrForbidden
+	}
+	return nil
+}
+
+// Handler wraps the given handler so that it only serves the requests
+// whose Api-Key header holds a key allowed the required role. Other
+// requests get a 401 status, or a 403 status if the key is known but its
+// role is not enough.
+func (s *KeyStore) Handler(required Role, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := s.Authorize(r.Header.Get("Api-Key"), required)
+		switch err {
+		case nil:
+			handler.ServeHTTP(w, r)
+		case ErrForbidden:
+			http.Error(w, "The Api-Key needs the "+required.String()+" role", http.StatusForbidden)
+		case ErrMissingKey:
+			http.Error(w, "Missing Api-Key header", http.StatusUnauthorized)
+		default:
+			http.Error(w, "Invalid Api-Key header", http.StatusUnauthorized)
+		}
+	}
+}
+
+// RegisterMetrics registers the metrics of the key store.
+func (s *KeyStore) RegisterMetrics(registry metrics.Registry) {
+	if registry != nil {
+		registry.MustRegister(s.metrics.collectors()...)
+	}
+}
+
+type keyStoreMetrics struct {
+	Rejected *prometheus.CounterVec
+}
+
+func newKeyStoreMetrics() *keyStoreMetrics {
+	return &keyStoreMetrics{
+		Rejected: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "qed",
+				Subsystem: "api",
+				Name:      "auth_rejected_total",
+				Help:      "Number of requests rejected by their api key, by reason: missing, unknown or forbidden",
+			},
+			[]string{"reason"},
+		),
+	}
+}
+
+func (m *keyStoreMetrics) collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		m.Rejected,
+	}
+}
@@ -0,0 +1,127 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package auth
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	assert "github.com/stretchr/testify/require"
+)
+
+func writeKeysFile(t *testing.T, path string, keys ...Key) {
+	data, err := json.Marshal(keysFile{Keys: keys})
+	assert.NoError(t, err)
+	assert.NoError(t, ioutil.WriteFile(path, data, 0600))
+}
+
+func TestRoles(t *testing.T) {
+
+	for _, role := range []Role{Reader, Writer, Admin} {
+		parsed, err := ParseRole(role.String())
+		assert.NoError(t, err)
+		assert.Equal(t, role, parsed, "Wrong role")
+	}
+	_, err := ParseRole("root")
+	assert.Error(t, err, "Unknown roles must fail")
+
+	assert.True(t, Admin.Allows(Writer), "Admins should write")
+	assert.True(t, Writer.Allows(Reader), "Writers should read")
+	assert.False(t, Reader.Allows(Writer), "Readers should not write")
+	assert.False(t, Writer.Allows(Admin), "Writers should not administer")
+}
+
+func TestKeyStoreReload(t *testing.T) {
+
+	file, err := ioutil.TempFile("", "qed-api-keys")
+	assert.NoError(t, err)
+	file.Close()
+	defer os.Remove(file.Name())
+
+	writeKeysFile(t, file.Name(), Key{"ingestion", HashKey("writer-key"), "writer"})
+
+	keys, err := NewKeyStore(file.Name())
+	assert.NoError(t, err)
+	keys.AddKey("config", "admin-key", Admin)
+
+	assert.NoError(t, keys.Authorize("writer-key", Writer))
+	assert.NoError(t, keys.Authorize("admin-key", Admin))
+	assert.Equal(t, ErrUnknownKey, keys.Authorize("reader-key", Reader))
+
+	// the keys file is replaced
+	writeKeysFile(t, file.Name(), Key{"auditors", HashKey("reader-key"), "reader"})
+	later := time.Now().Add(time.Minute)
+	assert.NoError(t, os.Chtimes(file.Name(), later, later))
+	assert.NoError(t, keys.Reload())
+
+	assert.Equal(t, ErrUnknownKey, keys.Authorize("writer-key", Writer), "Removed keys should be rejected")
+	assert.NoError(t, keys.Authorize("reader-key", Reader))
+	assert.Equal(t, ErrForbidden, keys.Authorize("reader-key", Writer))
+	assert.NoError(t, keys.Authorize("admin-key", Admin), "Static keys should survive reloads")
+
+	// invalid files keep the previous keys
+	writeKeysFile(t, file.Name(), Key{"ingestion", "not-a-hash", "writer"})
+	later = later.Add(time.Minute)
+	assert.NoError(t, os.Chtimes(file.Name(), later, later))
+	assert.Error(t, keys.Reload())
+	assert.NoError(t, keys.Authorize("reader-key", Reader))
+
+	_, err = NewKeyStore(file.Name())
+	assert.Error(t, err, "Invalid files should not load")
+}
+
+func TestHandler(t *testing.T) {
+
+	keys, err := NewKeyStore("")
+	assert.NoError(t, err)
+	keys.AddKey("reader", "reader-key", Reader)
+
+	cases := []struct {
+		key            string
+		required       Role
+		expectedStatus int
+		expectedReason string
+	}{
+		{"reader-key", Reader, http.StatusOK, ""},
+		{"", Reader, http.StatusUnauthorized, "missing"},
+		{"other-key", Reader, http.StatusUnauthorized, "unknown"},
+		{"reader-key", Writer, http.StatusForbidden, "forbidden"},
+	}
+
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
+
+	for i, c := range cases {
+		req, err := http.NewRequest("GET", "/", nil)
+		assert.NoError(t, err)
+		req.Header.Set("Api-Key", c.key)
+
+		rr := httptest.NewRecorder()
+		keys.Handler(c.required, ok).ServeHTTP(rr, req)
+		assert.Equalf(t, c.expectedStatus, rr.Code, "Wrong status code in test case %d", i)
+
+		if c.expectedReason != "" {
+			rejected := testutil.ToFloat64(keys.metrics.Rejected.WithLabelValues(c.expectedReason))
+			assert.Equalf(t, float64(1), rejected, "The rejection should be counted in test case %d", i)
+		}
+	}
+}
@@ -18,20 +18,249 @@ package mgmthttp
 
 import (
 	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
+	"strconv"
 
+	"github.com/bbva/qed/api/auth"
+	"github.com/bbva/qed/log"
+	"github.com/bbva/qed/protocol"
 	"github.com/bbva/qed/raftwal"
+	"github.com/bbva/qed/sign"
+	"github.com/hashicorp/raft"
 )
 
 // NewMgmtHttp will return a mux server with the endpoint required to
 // tamper the server. it's a internal debug implementation. Running a server
-// with this enabled will run useless the qed server.
-func NewMgmtHttp(raftBalloon raftwal.RaftBalloonApi) *http.ServeMux {
+// with this enabled will run useless the qed server. Every endpoint needs
+// an API key with the admin role.
+//	/join -> joinHandle
+//	/cluster/status -> ClusterStatusHandle
+//	/cluster/nodes -> ClusterNodesHandle
+//	/cluster/remove -> RemoveNodeHandle
+//	/cluster/metadata -> SetMetadataHandle
+//	/cluster/snapshot -> SnapshotHandle
+//	/cluster/transfer-leader -> TransferLeaderHandle
+//	/keys/activate -> ActivateKeyHandle
+//	/backup -> BackupHandle
+//	/gossip/keys -> GossipKeysHandle
+//
+// The keyring signs the backups and holds the keys that can be activated,
+// and the gossip keyring holds the keys that encrypt the gossip traffic.
+func NewMgmtHttp(raftBalloon raftwal.RaftBalloonApi, keys *auth.KeyStore, keyring *sign.Keyring, gossipKeys GossipKeyring) *http.ServeMux {
 	mux := http.NewServeMux()
-	mux.HandleFunc("/join", joinHandle(raftBalloon))
+	mux.HandleFunc("/join", keys.Handler(auth.Admin, joinHandle(raftBalloon)))
+	mux.HandleFunc("/cluster/status", keys.Handler(auth.Admin, ClusterStatusHandle(raftBalloon)))
+	mux.HandleFunc("/cluster/nodes", keys.Handler(auth.Admin, ClusterNodesHandle(raftBalloon)))
+	mux.HandleFunc("/cluster/remove", keys.Handler(auth.Admin, RemoveNodeHandle(raftBalloon)))
+	mux.HandleFunc("/cluster/metadata", keys.Handler(auth.Admin, SetMetadataHandle(raftBalloon)))
+	mux.HandleFunc("/cluster/snapshot", keys.Handler(auth.Admin, SnapshotHandle(raftBalloon)))
+	mux.HandleFunc("/cluster/transfer-leader", keys.Handler(auth.Admin, TransferLeaderHandle(raftBalloon)))
+	mux.HandleFunc("/keys/activate", keys.Handler(auth.Admin, ActivateKeyHandle(raftBalloon, keyring)))
+	mux.HandleFunc("/backup", keys.Handler(auth.Admin, BackupHandle(raftBalloon, keyring)))
+	mux.HandleFunc("/gossip/keys", keys.Handler(auth.Admin, GossipKeysHandle(gossipKeys)))
 	return mux
 }
 
+// ClusterStatusHandle returns the raft and FSM state of the node:
+//   GET /cluster/status
+//
+// If everything is alright, the HTTP status is 200 and the body contains:
+//   {
+//     "ID": "server0",
+//     "Addr": "127.0.0.1:8500",
+//     "LeaderID": "server0",
+//     "State": "Leader",
+//     "Term": 2,
+//     "LastLogIndex": 1033,
+//     "CommitIndex": 1033,
+//     "AppliedIndex": 1033,
+//     "LastSnapshotIndex": 1024,
+//     "FSM": { "Version": 1000, "Hasher": "sha256", "Format": 1 }
+//   }
+func ClusterStatusHandle(raftBalloon raftwal.RaftBalloonApi) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		// Make sure we can only be called with an HTTP GET request.
+		if r.Method != "GET" {
+			w.Header().Set("Allow", "GET")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		status, err := raftBalloon.Status()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, status)
+	}
+}
+
+// ClusterNodesHandle returns the members of the cluster with the metadata
+// they registered when joining:
+//   GET /cluster/nodes
+//
+// If everything is alright, the HTTP status is 200 and the body contains:
+//   {
+//     "Nodes": [
+//       {
+//         "ID": "server0",
+//         "Addr": "127.0.0.1:8500",
+//         "Voter": true,
+//         "Leader": true,
+//         "Metadata": { "HTTPAddr": "127.0.0.1:8800", "MgmtAddr": "127.0.0.1:8700" }
+//       }
+//     ]
+//   }
+func ClusterNodesHandle(raftBalloon raftwal.RaftBalloonApi) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		// Make sure we can only be called with an HTTP GET request.
+		if r.Method != "GET" {
+			w.Header().Set("Allow", "GET")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		nodes, err := raftBalloon.ClusterNodes()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, &protocol.NodesResponse{Nodes: nodes})
+	}
+}
+
+// RemoveNodeHandle removes a node from the cluster. It must be sent to
+// the leader:
+//   POST /cluster/remove
+//   { "ID": "server2" }
+//
+// A node leaves the cluster by asking the leader to remove it.
+func RemoveNodeHandle(raftBalloon raftwal.RaftBalloonApi) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		// Make sure we can only be called with an HTTP POST request.
+		if r.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		var body struct {
+			ID string
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.ID == "" {
+			http.Error(w, "Invalid node ID", http.StatusBadRequest)
+			return
+		}
+
+		if err := raftBalloon.Remove(body.ID); err != nil {
+			http.Error(w, err.Error(), errorStatus(err))
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// SetMetadataHandle adds metadata to the one of a node. It must be sent
+// to the leader:
+//   POST /cluster/metadata
+//   { "ID": "server2", "Metadata": { "zone": "eu-west-1a" } }
+func SetMetadataHandle(raftBalloon raftwal.RaftBalloonApi) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		// Make sure we can only be called with an HTTP POST request.
+		if r.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		var body struct {
+			ID       string
+			Metadata map[string]string
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.ID == "" || len(body.Metadata) == 0 {
+			http.Error(w, "Invalid node metadata", http.StatusBadRequest)
+			return
+		}
+
+		if err := raftBalloon.SetMetadata(body.ID, body.Metadata); err != nil {
+			http.Error(w, err.Error(), errorStatus(err))
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// SnapshotHandle makes the node take a raft snapshot, so that it can
+// compact its log:
+//   POST /cluster/snapshot
+func SnapshotHandle(raftBalloon raftwal.RaftBalloonApi) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		// Make sure we can only be called with an HTTP POST request.
+		if r.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		err := raftBalloon.Snapshot()
+		if err == raft.ErrNothingNewToSnapshot {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// TransferLeaderHandle hands the leadership over to another node. It must
+// be sent to the leader:
+//   POST /cluster/transfer-leader
+//   { "ID": "server2" }
+//
+// Without a body, or without an ID, the most up to date follower becomes
+// the leader.
+func TransferLeaderHandle(raftBalloon raftwal.RaftBalloonApi) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		// Make sure we can only be called with an HTTP POST request.
+		if r.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		var body struct {
+			ID string
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil && err != io.EOF {
+			http.Error(w, "Invalid node ID", http.StatusBadRequest)
+			return
+		}
+
+		if err := raftBalloon.TransferLeadership(body.ID); err != nil {
+			http.Error(w, err.Error(), errorStatus(err))
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
 func joinHandle(raftBalloon raftwal.RaftBalloonApi) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		body := make(map[string]interface{})
@@ -70,10 +299,203 @@ func joinHandle(raftBalloon raftwal.RaftBalloonApi) http.HandlerFunc {
 		}
 
 		if err := raftBalloon.Join(nodeID, remoteAddr, metadata); err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
 		w.WriteHeader(http.StatusOK)
 	}
 }
+
+// ActivateKeyHandle replicates the activation of one of the keys of the
+// keyring, so that every node of the cluster signs the snapshots with it.
+// The body names the key to activate:
+//   POST /keys/activate
+//   { "KeyID": "1c4f5a0c2b7e9d33" }
+//
+// The leader must hold the key, and every other node should too, as the
+// nodes that do not hold it keep signing with their default key.
+func ActivateKeyHandle(raftBalloon raftwal.RaftBalloonApi, keyring *sign.Keyring) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		// Make sure we can only be called with an HTTP POST request.
+		if r.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		var body struct {
+			KeyID string
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		key, err := keyring.Get(body.KeyID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// the first rotation also records the key that signed until then
+		var previous sign.Signer
+		if len(raftBalloon.Keys()) == 0 {
+			previous = keyring.Default()
+		}
+
+		err = raftBalloon.ActivateKey(key, previous)
+		if err == raftwal.ErrKeyAlreadyActivated {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// BackupHandle streams a consistent backup of the log of the node, whose
+// last snapshot is signed with the active key of the keyring:
+//   GET /backup
+//   GET /backup?until=<version> -> the log as it was at that version
+//   GET /backup?from=<version>&until=<version> -> an incremental backup
+//
+// Backups with a range of versions are signed with the snapshot of until,
+// which defaults to the last version. The backup is written while it is
+// taken, so a failure halfway aborts the response instead of answering
+// with a truncated backup.
+func BackupHandle(raftBalloon raftwal.RaftBalloonApi, keyring *sign.Keyring) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		// Make sure we can only be called with an HTTP GET request.
+		if r.Method != "GET" {
+			w.Header().Set("Allow", "GET")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		query := r.URL.Query()
+		if query.Get("from") == "" && query.Get("until") == "" {
+			w.Header().Set("Content-Type", "application/octet-stream")
+			if err := raftBalloon.Backup(w, keyring); err != nil {
+				log.Infof("Unable to back up the log: %v", err)
+				panic(http.ErrAbortHandler)
+			}
+			return
+		}
+
+		version := raftBalloon.Version()
+		if version == 0 {
+			http.Error(w, "The log is empty", http.StatusBadRequest)
+			return
+		}
+		from, until := uint64(0), version-1
+		var err error
+		if v := query.Get("from"); v != "" {
+			if from, err = strconv.ParseUint(v, 10, 64); err != nil {
+				http.Error(w, "Invalid from version", http.StatusBadRequest)
+				return
+			}
+		}
+		if v := query.Get("until"); v != "" {
+			if until, err = strconv.ParseUint(v, 10, 64); err != nil {
+				http.Error(w, "Invalid until version", http.StatusBadRequest)
+				return
+			}
+		}
+		if until >= version || from > until {
+			http.Error(w, fmt.Sprintf("Invalid versions %d to %d of a log with %d versions", from, until, version), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/octet-stream")
+		if err := raftBalloon.BackupVersions(w, from, until, keyring); err != nil {
+			log.Infof("Unable to back up versions %d to %d of the log: %v", from, until, err)
+			panic(http.ErrAbortHandler)
+		}
+	}
+}
+
+// GossipKeyring manages the keys that encrypt the gossip traffic.
+type GossipKeyring interface {
+	EncryptionKeys() ([]string, error)
+	InstallKey(key string) error
+	UseKey(key string) error
+	RemoveKey(key string) error
+}
+
+// GossipKeysHandle manages the keys of the gossip keyring of the node.
+// Keys are base64 encoded, and a key is rotated by installing it in every
+// node, then using it as the primary key, and finally removing the old one:
+//   GET /gossip/keys -> lists the installed keys, the primary key first
+//   POST /gossip/keys { "Key": "<base64 key>" } -> installs the key
+//   PUT /gossip/keys { "Key": "<base64 key>" } -> uses the key as primary
+//   DELETE /gossip/keys { "Key": "<base64 key>" } -> removes the key
+func GossipKeysHandle(keyring GossipKeyring) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		if r.Method == "GET" {
+			keys, err := keyring.EncryptionKeys()
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			writeJSON(w, keys)
+			return
+		}
+
+		var op func(string) error
+		switch r.Method {
+		case "POST":
+			op = keyring.InstallKey
+		case "PUT":
+			op = keyring.UseKey
+		case "DELETE":
+			op = keyring.RemoveKey
+		default:
+			w.Header().Set("Allow", "GET, POST, PUT, DELETE")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		var body struct {
+			Key string
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := op(body.Key); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// errorStatus returns the HTTP status of the errors of the operations that
+// only the leader can run.
+func errorStatus(err error) int {
+	if err == raftwal.ErrNotLeader {
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	out, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(out)
+}
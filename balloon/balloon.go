@@ -36,6 +36,7 @@ var (
 type Balloon struct {
 	version uint64
 	hasherF func() hashing.Hasher
+	format  hashing.FormatVersion
 	store   storage.Store
 
 	historyTree *history.HistoryTree
@@ -43,15 +44,18 @@ type Balloon struct {
 	hasher      hashing.Hasher
 }
 
-func NewBalloon(store storage.Store, hasherF func() hashing.Hasher) (*Balloon, error) {
+// NewBalloon creates a balloon whose trees hash their nodes following
+// the given format version.
+func NewBalloon(store storage.Store, hasherF func() hashing.Hasher, format hashing.FormatVersion) (*Balloon, error) {
 
 	// create trees
-	historyTree := history.NewHistoryTree(hasherF, store, 300)
-	hyperTree := hyper.NewHyperTree(hasherF, store, cache.NewFreeCache(hyper.CacheSize))
+	historyTree := history.NewHistoryTree(hasherF, format, store, 300)
+	hyperTree := hyper.NewHyperTree(hasherF, format, store, cache.NewFreeCache(hyper.CacheSize))
 
 	balloon := &Balloon{
 		version:     0,
 		hasherF:     hasherF,
+		format:      format,
 		store:       store,
 		historyTree: historyTree,
 		hyperTree:   hyperTree,
@@ -61,16 +65,27 @@ func NewBalloon(store storage.Store, hasherF func() hashing.Hasher) (*Balloon, e
 	// update version
 	balloon.RefreshVersion()
 
+	// logs written before the hyper tree recorded the copies of its
+	// batches can only be queried as of their last version on
+	if balloon.version > 0 {
+		if err := hyperTree.BackfillVersions(balloon.version - 1); err != nil {
+			return nil, fmt.Errorf("unable to back-fill the hyper tree versions: %v", err)
+		}
+	}
+
 	return balloon, nil
 }
 
 // Snapshot is the struct that has both history and hyper digest and the
-// current version for that rootNode digests.
+// current version for that rootNode digests. The timestamp is the time,
+// in nanoseconds since the epoch, at which the event was logged. It is
+// assigned by the raft leader, so the balloon leaves it empty.
 type Snapshot struct {
 	EventDigest   hashing.Digest
 	HistoryDigest hashing.Digest
 	HyperDigest   hashing.Digest
 	Version       uint64
+	Timestamp     int64
 }
 
 type Verifiable interface {
@@ -115,13 +130,28 @@ func NewMembershipProof(
 // answer and proof are correct and consistent, otherwise false.
 // Run by a client on input that should be verified.
 func (p MembershipProof) DigestVerify(digest hashing.Digest, snapshot *Snapshot) bool {
-	if p.HyperProof == nil || p.HistoryProof == nil {
+	if p.HyperProof == nil {
 		return false
 	}
 
+	// the hyper proof shows either membership or non-membership
+	// of the digest as of the version of the snapshot
 	hyperCorrect := p.HyperProof.Verify(digest, snapshot.HyperDigest)
 
+	// shortcut leaves only hash the version of their key, so a proof of
+	// non-membership ending at the leaf of another key needs the history
+	// tree to show that the event logged at that version is that key
+	if !p.Exists && p.HyperProof.ShortcutKey != nil {
+		if p.HistoryProof == nil || p.HistoryProof.Index != valueAsVersion(p.HyperProof.ShortcutValue) {
+			return false
+		}
+		return hyperCorrect && p.HistoryProof.Verify(p.HyperProof.ShortcutKey, snapshot.HistoryDigest)
+	}
+
 	if p.Exists {
+		if p.HistoryProof == nil {
+			return false
+		}
 		if p.ActualVersion <= p.QueryVersion {
 			historyCorrect := p.HistoryProof.Verify(digest, snapshot.HistoryDigest)
 			return hyperCorrect && historyCorrect
@@ -138,27 +168,42 @@ func (p MembershipProof) Verify(event []byte, snapshot *Snapshot) bool {
 	return p.DigestVerify(p.Hasher.Do(event), snapshot)
 }
 
+// valueAsVersion decodes the version stored as the value of a leaf of the
+// hyper tree.
+func valueAsVersion(value []byte) uint64 {
+	if len(value) < 8 { // TODO GET RID OF THIS: used only to pass tests
+		// the version is stored in the hyper tree with the length of the event digest
+		// if the length of the value is less than the length of a uint64 in bytes, we have to add padding
+		return util.BytesAsUint64(util.AddPaddingToBytes(value, 8))
+	}
+	// if the length of the value is greater or equal than the length of a uint64 in bytes, we have to truncate
+	return util.BytesAsUint64(value[len(value)-8:])
+}
+
 type IncrementalProof struct {
 	Start, End uint64
 	AuditPath  history.AuditPath
 	Hasher     hashing.Hasher
+	Format     hashing.FormatVersion
 }
 
 func NewIncrementalProof(
 	start, end uint64,
 	auditPath history.AuditPath,
 	hasher hashing.Hasher,
+	format hashing.FormatVersion,
 ) *IncrementalProof {
 	return &IncrementalProof{
 		start,
 		end,
 		auditPath,
 		hasher,
+		format,
 	}
 }
 
 func (p IncrementalProof) Verify(snapshotStart, snapshotEnd *Snapshot) bool {
-	ip := history.NewIncrementalProof(p.Start, p.End, p.AuditPath, p.Hasher)
+	ip := history.NewIncrementalProof(p.Start, p.End, p.AuditPath, p.Hasher, p.Format)
 	return ip.Verify(snapshotStart.HistoryDigest, snapshotEnd.HistoryDigest)
 }
 
@@ -166,28 +211,46 @@ func (b Balloon) Version() uint64 {
 	return b.version
 }
 
+// Format returns the format version of the balloon trees.
+func (b Balloon) Format() hashing.FormatVersion {
+	return b.format
+}
+
 func (b *Balloon) RefreshVersion() error {
+	version, err := StoredVersion(b.store)
+	if err != nil {
+		return err
+	}
+	if version > 0 {
+		b.version = version
+	}
+	return nil
+}
+
+// StoredVersion returns the number of events of the balloon kept in the
+// given store, without loading its trees.
+func StoredVersion(store storage.Store) (uint64, error) {
 	// get last stored version
-	kv, err := b.store.GetLast(storage.HistoryTable)
+	kv, err := store.GetLast(storage.HistoryTable)
 	if err != nil {
 		if err != storage.ErrKeyNotFound {
-			return err
+			return 0, err
 		}
-	} else {
-		b.version = util.BytesAsUint64(kv.Key[:8]) + 1
+		return 0, nil
 	}
-	return nil
+	return util.BytesAsUint64(kv.Key[:8]) + 1, nil
 }
 
 func (b *Balloon) Add(event []byte) (*Snapshot, []*storage.Mutation, error) {
+	return b.add(b.hasher.Do(event))
+}
+
+func (b *Balloon) add(eventDigest hashing.Digest) (*Snapshot, []*storage.Mutation, error) {
 
 	// Get version
 	version := b.version
 	b.version++
 
-	// Hash event
-	eventDigest := b.hasher.Do(event)
-
 	// Update trees
 	var historyDigest hashing.Digest
 	var historyMutations []*storage.Mutation
@@ -213,6 +276,7 @@ func (b *Balloon) Add(event []byte) (*Snapshot, []*storage.Mutation, error) {
 
 	// Append trees mutations
 	mutations = append(mutations, historyMutations...)
+	mutations = append(mutations, indexMutation(version, eventDigest))
 
 	snapshot := &Snapshot{
 		EventDigest:   eventDigest,
@@ -226,15 +290,23 @@ func (b *Balloon) Add(event []byte) (*Snapshot, []*storage.Mutation, error) {
 
 func (b *Balloon) AddBulk(bulk [][]byte) ([]*Snapshot, []*storage.Mutation, error) {
 
+	var eventBulkDigest []hashing.Digest
+	for _, event := range bulk {
+		// Hash event
+		eventBulkDigest = append(eventBulkDigest, b.hasher.Do(event))
+	}
+
+	return b.addBulk(eventBulkDigest)
+}
+
+func (b *Balloon) addBulk(eventBulkDigest []hashing.Digest) ([]*Snapshot, []*storage.Mutation, error) {
+
 	// Get version
 	version := b.version
-	b.version += uint64(len(bulk))
+	b.version += uint64(len(eventBulkDigest))
 
-	var eventBulkDigest []hashing.Digest
 	var eventVersions []uint64
-	for i, event := range bulk {
-		// Hash event
-		eventBulkDigest = append(eventBulkDigest, b.hasher.Do(event))
+	for i := range eventBulkDigest {
 		eventVersions = append(eventVersions, version+uint64(i))
 	}
 
@@ -263,6 +335,9 @@ func (b *Balloon) AddBulk(bulk [][]byte) ([]*Snapshot, []*storage.Mutation, erro
 
 	// Append trees mutations
 	mutations = append(mutations, historyMutations...)
+	for i, eventDigest := range eventBulkDigest {
+		mutations = append(mutations, indexMutation(eventVersions[i], eventDigest))
+	}
 
 	snapshotBulk := make([]*Snapshot, 0)
 	for i, _ := range eventBulkDigest {
@@ -277,6 +352,140 @@ func (b *Balloon) AddBulk(bulk [][]byte) ([]*Snapshot, []*storage.Mutation, erro
 	return snapshotBulk, mutations, nil
 }
 
+// indexMutation records the digest of the event logged at the version.
+func indexMutation(version uint64, eventDigest hashing.Digest) *storage.Mutation {
+	return storage.NewMutation(storage.IndexTable, util.Uint64AsBytes(version), eventDigest)
+}
+
+// AddDigest adds an event already hashed by the producer. The digest must
+// have the length of the digests of the balloon hasher.
+func (b *Balloon) AddDigest(eventDigest hashing.Digest) (*Snapshot, []*storage.Mutation, error) {
+	if err := b.checkDigest(eventDigest); err != nil {
+		return nil, nil, err
+	}
+	return b.add(eventDigest)
+}
+
+// AddDigestBulk adds a bulk of events already hashed by the producer, as
+// AddDigest does. Nothing is added if any of the digests is invalid.
+func (b *Balloon) AddDigestBulk(eventBulkDigest []hashing.Digest) ([]*Snapshot, []*storage.Mutation, error) {
+	for _, eventDigest := range eventBulkDigest {
+		if err := b.checkDigest(eventDigest); err != nil {
+			return nil, nil, err
+		}
+	}
+	return b.addBulk(eventBulkDigest)
+}
+
+func (b Balloon) checkDigest(eventDigest hashing.Digest) error {
+	if expected := int(b.hasher.Len() / 8); len(eventDigest) != expected {
+		return fmt.Errorf("invalid digest length: got %d bytes but the hasher produces %d", len(eventDigest), expected)
+	}
+	return nil
+}
+
+// AddIfAbsent adds the event unless its digest is already in the balloon.
+// In that case nothing is mutated, and it returns the snapshot taken right
+// after the existing event was added along with a membership proof that
+// verifies against it. The proof is nil when the event is added.
+func (b *Balloon) AddIfAbsent(event []byte) (*Snapshot, *MembershipProof, []*storage.Mutation, error) {
+
+	eventDigest := b.hasher.Do(event)
+
+	snapshot, proof, err := b.queryExisting(eventDigest)
+	if err != nil || snapshot != nil {
+		return snapshot, proof, nil, err
+	}
+
+	snapshot, mutations, err := b.add(eventDigest)
+	return snapshot, nil, mutations, err
+}
+
+// AddBulkIfAbsent adds the events of the bulk that are not already in the
+// balloon, as AddIfAbsent does. Repeated events in the bulk are added only
+// once and share the same snapshot and proof.
+func (b *Balloon) AddBulkIfAbsent(bulk [][]byte) ([]*Snapshot, []*MembershipProof, []*storage.Mutation, error) {
+
+	snapshots := make([]*Snapshot, len(bulk))
+	proofs := make([]*MembershipProof, len(bulk))
+
+	first := make(map[string]int) // digest -> first position in the bulk
+	repeated := make(map[int]int) // position -> first position of the same digest
+	var eventBulkDigest []hashing.Digest
+	var added []int
+	for i, event := range bulk {
+		eventDigest := b.hasher.Do(event)
+		if j, ok := first[string(eventDigest)]; ok {
+			repeated[i] = j
+			continue
+		}
+		first[string(eventDigest)] = i
+
+		var err error
+		snapshots[i], proofs[i], err = b.queryExisting(eventDigest)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if snapshots[i] == nil {
+			eventBulkDigest = append(eventBulkDigest, eventDigest)
+			added = append(added, i)
+		}
+	}
+
+	var mutations []*storage.Mutation
+	if len(eventBulkDigest) > 0 {
+		snapshotBulk, bulkMutations, err := b.addBulk(eventBulkDigest)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		for k, i := range added {
+			snapshots[i] = snapshotBulk[k]
+		}
+		mutations = bulkMutations
+	}
+
+	for i, j := range repeated {
+		snapshots[i], proofs[i] = snapshots[j], proofs[j]
+	}
+
+	return snapshots, proofs, mutations, nil
+}
+
+// queryExisting returns the snapshot taken right after the given event
+// digest was added and a membership proof that verifies against it, or
+// nil if the digest is not in the balloon.
+func (b Balloon) queryExisting(eventDigest hashing.Digest) (*Snapshot, *MembershipProof, error) {
+
+	if b.version == 0 {
+		return nil, nil, nil
+	}
+
+	proof, err := b.QueryDigestMembership(eventDigest, b.version-1)
+	if err != nil || !proof.Exists {
+		return nil, nil, err
+	}
+	if proof.ActualVersion < proof.QueryVersion {
+		proof, err = b.QueryDigestMembership(eventDigest, proof.ActualVersion)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	historyDigest, err := b.QueryHistoryDigest(proof.ActualVersion)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	snapshot := &Snapshot{
+		EventDigest:   eventDigest,
+		HistoryDigest: historyDigest,
+		HyperDigest:   proof.HyperProof.RootHash(),
+		Version:       proof.ActualVersion,
+	}
+
+	return snapshot, proof, nil
+}
+
 func (b Balloon) QueryDigestMembership(keyDigest hashing.Digest, version uint64) (*MembershipProof, error) {
 
 	var proof MembershipProof
@@ -290,7 +499,13 @@ func (b Balloon) QueryDigestMembership(keyDigest hashing.Digest, version uint64)
 		version = proof.CurrentVersion
 	}
 
-	proof.HyperProof, err = b.hyperTree.QueryMembership(keyDigest)
+	if version == proof.CurrentVersion {
+		proof.HyperProof, err = b.hyperTree.QueryMembership(keyDigest)
+	} else {
+		// the proof must verify against the hyper digest of the snapshot
+		// at the queried version, so we query the tree as it was then
+		proof.HyperProof, err = b.hyperTree.QueryMembershipAt(keyDigest, version)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("unable to get proof from hyper tree: %v", err)
 	}
@@ -298,18 +513,24 @@ func (b Balloon) QueryDigestMembership(keyDigest hashing.Digest, version uint64)
 	if len(proof.HyperProof.Value) == 0 {
 		proof.Exists = false
 		proof.ActualVersion = version
+		if proof.HyperProof.ShortcutKey == nil {
+			return &proof, nil
+		}
+		// the path ends at the shortcut leaf of another key, which is
+		// bound to its key by the event logged at its version
+		shortcutVersion := valueAsVersion(proof.HyperProof.ShortcutValue)
+		if shortcutVersion > version {
+			return nil, fmt.Errorf("query version %d is lower than the version %d of the shortcut leaf", version, shortcutVersion)
+		}
+		proof.HistoryProof, err = b.historyTree.ProveMembership(shortcutVersion, version)
+		if err != nil {
+			return nil, fmt.Errorf("unable to get proof from history tree: %v", err)
+		}
 		return &proof, nil
 	}
 
 	proof.Exists = true
-	if versionLen := len(proof.HyperProof.Value); versionLen < 8 { // TODO GET RID OF THIS: used only to pass tests
-		// the version is stored in the hyper tree with the length of the event digest
-		// if the length of the value is less than the length of a uint64 in bytes, we have to add padding
-		proof.ActualVersion = util.BytesAsUint64(util.AddPaddingToBytes(proof.HyperProof.Value, 8-versionLen))
-	} else {
-		// if the length of the value is greater or equal than the length of a uint64 in bytes, we have to truncate
-		proof.ActualVersion = util.BytesAsUint64(proof.HyperProof.Value[versionLen-8:])
-	}
+	proof.ActualVersion = valueAsVersion(proof.HyperProof.Value)
 
 	if proof.ActualVersion <= version {
 		proof.HistoryProof, err = b.historyTree.ProveMembership(proof.ActualVersion, version)
@@ -339,6 +560,7 @@ func (b Balloon) QueryConsistency(start, end uint64) (*IncrementalProof, error)
 	proof.Start = start
 	proof.End = end
 	proof.Hasher = b.hasherF()
+	proof.Format = b.format
 
 	historyProof, err := b.historyTree.ProveConsistency(start, end)
 	if err != nil {
@@ -349,6 +571,182 @@ func (b Balloon) QueryConsistency(start, end uint64) (*IncrementalProof, error)
 	return &proof, nil
 }
 
+// QueryHistoryDigest returns the history digest of the balloon as it was
+// at the given version.
+func (b Balloon) QueryHistoryDigest(version uint64) (hashing.Digest, error) {
+
+	if version >= b.version {
+		return nil, errors.New("unable to get digest from history tree: invalid version")
+	}
+
+	digest, err := b.historyTree.RootHash(version)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get digest from history tree: %v", err)
+	}
+
+	return digest, nil
+}
+
+// QueryHyperDigest returns the hyper digest of the balloon as it was at
+// the given version, kept in the root batch of the hyper tree.
+func (b Balloon) QueryHyperDigest(version uint64) (hashing.Digest, error) {
+
+	if version >= b.version {
+		return nil, errors.New("unable to get digest from hyper tree: invalid version")
+	}
+
+	var digest hashing.Digest
+	var err error
+	if version == b.version-1 {
+		digest = b.hyperTree.RootHash()
+	} else {
+		digest, err = b.hyperTree.RootHashAt(version)
+		if err != nil {
+			return nil, fmt.Errorf("unable to get digest from hyper tree: %v", err)
+		}
+	}
+	if digest == nil {
+		return nil, errors.New("unable to get digest from hyper tree: no root")
+	}
+
+	return digest, nil
+}
+
+// RebuildDigests recomputes the digests of both trees at the last version
+// from their leaves, checking every stored node against its children and
+// the leaves of the history tree against the event digests of the index.
+// It reads the whole balloon, so it is meant for offline checks such as
+// restores. Both digests are nil if the balloon is empty.
+func (b Balloon) RebuildDigests() (historyDigest, hyperDigest hashing.Digest, err error) {
+
+	if b.version == 0 {
+		return nil, nil, nil
+	}
+
+	historyDigest, err = b.historyTree.RebuildRootHash(b.version - 1)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to rebuild the history tree: %v", err)
+	}
+	err = storage.ForEach(b.store, storage.IndexTable, func(kv *storage.KVPair) error {
+		version := util.BytesAsUint64(kv.Key)
+		if version >= b.version {
+			return fmt.Errorf("event digest of version %d beyond the last one", version)
+		}
+		return b.historyTree.CheckLeaf(version, kv.Value)
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to rebuild the history tree: %v", err)
+	}
+
+	hyperDigest, err = b.hyperTree.RebuildRootHash()
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to rebuild the hyper tree: %v", err)
+	}
+
+	return historyDigest, hyperDigest, nil
+}
+
+// Export emits the nodes of both trees added by the versions between from
+// and until, both included. Loading them into an empty store rebuilds the
+// balloon as it was at until; loading them into a store that holds the
+// versions before from brings it up to until. The range is checked with
+// CheckExportRange.
+func (b Balloon) Export(from, until uint64, emit storage.EntryFunc) error {
+
+	if err := b.CheckExportRange(from, until); err != nil {
+		return err
+	}
+
+	if err := b.historyTree.Export(from, until, emit); err != nil {
+		return fmt.Errorf("unable to export the history tree: %v", err)
+	}
+	if err := b.hyperTree.Export(from, until, emit); err != nil {
+		return fmt.Errorf("unable to export the hyper tree: %v", err)
+	}
+	return nil
+}
+
+// CheckExportRange tells whether the versions between from and until,
+// both included, can be exported. The range cannot split a bulk, as its
+// versions share their hyper tree nodes.
+func (b Balloon) CheckExportRange(from, until uint64) error {
+
+	if until >= b.version || from > until {
+		return errors.New("unable to export the balloon: invalid versions")
+	}
+	for _, version := range []uint64{from, until + 1} {
+		if version == b.version {
+			continue
+		}
+		start, err := b.hyperTree.BulkStart(version)
+		if err != nil {
+			return fmt.Errorf("unable to export the balloon: %v", err)
+		}
+		if start != version {
+			return fmt.Errorf("unable to export the balloon: the range splits the bulk that starts at version %d", start)
+		}
+	}
+	return nil
+}
+
+// QueryLeafHashes returns the hashes of the history tree leaves stored
+// between the start and end versions, both included.
+func (b Balloon) QueryLeafHashes(start, end uint64) ([]hashing.Digest, error) {
+
+	if start >= b.version || end >= b.version || start > end {
+		return nil, errors.New("unable to get leaves from history tree: invalid range")
+	}
+
+	hashes := make([]hashing.Digest, 0, end-start+1)
+	for index := start; index <= end; index++ {
+		hash, err := b.historyTree.LeafHash(index)
+		if err != nil {
+			return nil, fmt.Errorf("unable to get leaf %d from history tree: %v", index, err)
+		}
+		hashes = append(hashes, hash)
+	}
+
+	return hashes, nil
+}
+
+// QueryEventDigests returns the digests of the events logged between the
+// start and end versions, both included. Events logged before the index
+// was kept have a nil digest.
+func (b Balloon) QueryEventDigests(start, end uint64) ([]hashing.Digest, error) {
+
+	if start >= b.version || end >= b.version || start > end {
+		return nil, errors.New("unable to get digests from index: invalid range")
+	}
+
+	entries, err := b.store.GetRange(storage.IndexTable, util.Uint64AsBytes(start), util.Uint64AsBytes(end))
+	if err != nil {
+		return nil, fmt.Errorf("unable to get digests from index: %v", err)
+	}
+
+	digests := make([]hashing.Digest, end-start+1)
+	for _, kv := range entries {
+		digests[util.BytesAsUint64(kv.Key)-start] = kv.Value
+	}
+
+	return digests, nil
+}
+
+// QueryHistoryMembership returns a proof that the event logged at the
+// given index is in the history tree as it was at the given version.
+func (b Balloon) QueryHistoryMembership(index, version uint64) (*history.MembershipProof, error) {
+
+	if version >= b.version || index > version {
+		return nil, errors.New("unable to get proof from history tree: invalid version")
+	}
+
+	proof, err := b.historyTree.ProveMembership(index, version)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get proof from history tree: %v", err)
+	}
+
+	return proof, nil
+}
+
 func (b *Balloon) Close() {
 	b.historyTree.Close()
 	b.hyperTree.Close()
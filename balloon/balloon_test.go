@@ -26,6 +26,7 @@ import (
 
 	"github.com/bbva/qed/hashing"
 	"github.com/bbva/qed/log"
+	"github.com/bbva/qed/storage"
 	metrics_utils "github.com/bbva/qed/testutils/metrics"
 	"github.com/bbva/qed/testutils/rand"
 	storage_utils "github.com/bbva/qed/testutils/storage"
@@ -39,7 +40,7 @@ func TestAdd(t *testing.T) {
 	store, closeF := storage_utils.OpenBPlusTreeStore()
 	defer closeF()
 
-	balloon, err := NewBalloon(store, hashing.NewSha256Hasher)
+	balloon, err := NewBalloon(store, hashing.NewSha256Hasher, hashing.CurrentFormat)
 	require.NoError(t, err)
 
 	for i := uint64(0); i < 9; i++ {
@@ -63,7 +64,7 @@ func TestAddBulk(t *testing.T) {
 	store, closeF := storage_utils.OpenBPlusTreeStore()
 	defer closeF()
 
-	balloon, err := NewBalloon(store, hashing.NewSha256Hasher)
+	balloon, err := NewBalloon(store, hashing.NewSha256Hasher, hashing.CurrentFormat)
 	require.NoError(t, err)
 
 	events := [][]byte{
@@ -91,6 +92,94 @@ func TestAddBulk(t *testing.T) {
 	}
 }
 
+func TestAddIfAbsent(t *testing.T) {
+
+	log.SetLogger("TestAddIfAbsent", log.SILENT)
+
+	store, closeF := storage_utils.OpenBPlusTreeStore()
+	defer closeF()
+
+	balloon, err := NewBalloon(store, hashing.NewSha256Hasher, hashing.CurrentFormat)
+	require.NoError(t, err)
+
+	events := [][]byte{
+		[]byte("The year’s at the spring,"),
+		[]byte("And day's at the morn;"),
+		[]byte("Morning's at seven;"),
+	}
+
+	var added []*Snapshot
+	for _, event := range events {
+		snapshot, proof, mutations, err := balloon.AddIfAbsent(event)
+		require.NoError(t, err)
+		require.Nil(t, proof, "New events should not have a proof")
+		require.NoError(t, store.Mutate(mutations))
+		added = append(added, snapshot)
+	}
+
+	for i, event := range events {
+		snapshot, proof, mutations, err := balloon.AddIfAbsent(event)
+		require.NoError(t, err)
+		require.Emptyf(t, mutations, "Existing events should not mutate the balloon in test %d", i)
+		require.NotNilf(t, proof, "Existing events should have a proof in test %d", i)
+		assert.Equalf(t, added[i], snapshot, "The snapshot should be the one of the existing event in test %d", i)
+		assert.Truef(t, proof.Verify(event, snapshot), "The proof should verify against the existing snapshot in test %d", i)
+	}
+	assert.Equal(t, uint64(len(events)), balloon.Version(), "No events should have been added")
+
+	bulk := [][]byte{
+		events[1],
+		[]byte("The hill-side’s dew-pearled;"),
+		[]byte("The hill-side’s dew-pearled;"),
+	}
+	snapshots, proofs, mutations, err := balloon.AddBulkIfAbsent(bulk)
+	require.NoError(t, err)
+	require.NoError(t, store.Mutate(mutations))
+
+	assert.Equal(t, added[1], snapshots[0], "The existing event should keep its snapshot")
+	assert.NotNil(t, proofs[0], "The existing event should have a proof")
+	assert.Equal(t, uint64(len(events)), snapshots[1].Version, "The new event should be added once")
+	assert.Nil(t, proofs[1], "The new event should not have a proof")
+	assert.Equal(t, snapshots[1], snapshots[2], "Repeated events should share the snapshot")
+	assert.Equal(t, uint64(len(events)+1), balloon.Version(), "Only one event should have been added")
+}
+
+func TestAddDigest(t *testing.T) {
+
+	log.SetLogger("TestAddDigest", log.SILENT)
+
+	store, closeF := storage_utils.OpenBPlusTreeStore()
+	defer closeF()
+
+	balloon, err := NewBalloon(store, hashing.NewSha256Hasher, hashing.CurrentFormat)
+	require.NoError(t, err)
+
+	event := []byte("The lark's on the wing;")
+	eventDigest := hashing.NewSha256Hasher().Do(event)
+
+	snapshot, mutations, err := balloon.AddDigest(eventDigest)
+	require.NoError(t, err)
+	require.NoError(t, store.Mutate(mutations))
+	assert.Equal(t, eventDigest, snapshot.EventDigest, "The digest should be added as is")
+
+	proof, err := balloon.QueryMembership(event, snapshot.Version)
+	require.NoError(t, err)
+	assert.True(t, proof.Verify(event, snapshot), "The event should be a member of the balloon")
+
+	_, _, err = balloon.AddDigest(eventDigest[:16])
+	assert.Error(t, err, "Digests shorter than the hasher ones should be rejected")
+
+	_, _, err = balloon.AddDigestBulk([]hashing.Digest{eventDigest, append(eventDigest, 0x00)})
+	assert.Error(t, err, "Bulks with an invalid digest should be rejected")
+	assert.Equal(t, uint64(1), balloon.Version(), "Rejected digests should not be added")
+
+	snapshots, mutations, err := balloon.AddDigestBulk([]hashing.Digest{eventDigest, eventDigest})
+	require.NoError(t, err)
+	require.NoError(t, store.Mutate(mutations))
+	assert.Len(t, snapshots, 2)
+	assert.Equal(t, uint64(3), balloon.Version())
+}
+
 func TestQueryMembership(t *testing.T) {
 
 	log.SetLogger("TestQueryMembership", log.SILENT)
@@ -107,7 +196,7 @@ func TestQueryMembership(t *testing.T) {
 	for i, c := range testCases {
 		store, closeF := storage_utils.OpenBPlusTreeStore()
 
-		balloon, err := NewBalloon(store, hashing.NewSha256Hasher)
+		balloon, err := NewBalloon(store, hashing.NewSha256Hasher, hashing.CurrentFormat)
 		require.NoError(t, err)
 
 		if c.key != nil {
@@ -134,6 +223,39 @@ func TestQueryMembership(t *testing.T) {
 
 }
 
+func TestQueryMembershipAtPreviousVersions(t *testing.T) {
+
+	log.SetLogger("TestQueryMembershipAtPreviousVersions", log.SILENT)
+
+	store, closeF := storage_utils.OpenBPlusTreeStore()
+	defer closeF()
+
+	balloon, err := NewBalloon(store, hashing.NewSha256Hasher, hashing.CurrentFormat)
+	require.NoError(t, err)
+
+	size := 10
+	events := make([][]byte, size)
+	snapshots := make([]*Snapshot, size)
+	for i := 0; i < size; i++ {
+		events[i] = []byte(fmt.Sprintf("Never knows %d best", i))
+		snapshot, mutations, err := balloon.Add(events[i])
+		require.NoError(t, err)
+		require.NoError(t, store.Mutate(mutations))
+		snapshots[i] = snapshot
+	}
+
+	// every proof must verify against the snapshot of the queried version
+	for v, snapshot := range snapshots {
+		for i, event := range events {
+			proof, err := balloon.QueryMembership(event, snapshot.Version)
+			require.NoErrorf(t, err, "Error querying event %d at version %d", i, v)
+			assert.Equalf(t, i <= v, proof.Exists, "Wrong membership for event %d at version %d", i, v)
+			assert.Truef(t, proof.Verify(event, snapshot), "The proof for event %d should verify at version %d", i, v)
+		}
+	}
+
+}
+
 func TestQueryConsistencyProof(t *testing.T) {
 
 	log.SetLogger("TestQueryConsistencyProof", log.SILENT)
@@ -149,7 +271,7 @@ func TestQueryConsistencyProof(t *testing.T) {
 	for i, c := range testCases {
 		store, closeF := storage_utils.OpenBPlusTreeStore()
 		defer closeF()
-		balloon, err := NewBalloon(store, hashing.NewFakeXorHasher)
+		balloon, err := NewBalloon(store, hashing.NewFakeXorHasher, hashing.CurrentFormat)
 		require.NoError(t, err)
 
 		for j := 0; j <= int(c.additions); j++ {
@@ -172,6 +294,341 @@ func TestQueryConsistencyProof(t *testing.T) {
 	}
 }
 
+func TestQueryHistoryDigestAndLeaves(t *testing.T) {
+
+	log.SetLogger("TestQueryHistoryDigestAndLeaves", log.SILENT)
+
+	store, closeF := storage_utils.OpenBPlusTreeStore()
+	defer closeF()
+
+	balloon, err := NewBalloon(store, hashing.NewSha256Hasher, hashing.CurrentFormat)
+	require.NoError(t, err)
+
+	_, err = balloon.QueryHistoryDigest(0)
+	require.Error(t, err, "An empty balloon should have no history digest")
+
+	var snapshots []*Snapshot
+	for i := 0; i < 10; i++ {
+		snapshot, mutations, err := balloon.Add(rand.Bytes(128))
+		require.NoError(t, err)
+		require.NoError(t, store.Mutate(mutations))
+		snapshots = append(snapshots, snapshot)
+	}
+
+	for _, snapshot := range snapshots {
+		digest, err := balloon.QueryHistoryDigest(snapshot.Version)
+		require.NoError(t, err)
+		assert.Equalf(t, snapshot.HistoryDigest, digest, "The history digest should match for version %d", snapshot.Version)
+	}
+
+	hashes, err := balloon.QueryLeafHashes(2, 7)
+	require.NoError(t, err)
+	assert.Len(t, hashes, 6, "There should be a leaf hash for every version in range")
+
+	_, err = balloon.QueryLeafHashes(7, 10)
+	require.Error(t, err, "Leaves beyond the last version should not be returned")
+}
+
+func TestQueryEventDigests(t *testing.T) {
+
+	log.SetLogger("TestQueryEventDigests", log.SILENT)
+
+	store, closeF := storage_utils.OpenBPlusTreeStore()
+	defer closeF()
+
+	balloon, err := NewBalloon(store, hashing.NewSha256Hasher, hashing.CurrentFormat)
+	require.NoError(t, err)
+
+	var snapshots []*Snapshot
+	snapshot, mutations, err := balloon.Add(rand.Bytes(128))
+	require.NoError(t, err)
+	require.NoError(t, store.Mutate(mutations))
+	snapshots = append(snapshots, snapshot)
+
+	bulk, mutations, err := balloon.AddBulk([][]byte{rand.Bytes(128), rand.Bytes(128)})
+	require.NoError(t, err)
+	require.NoError(t, store.Mutate(mutations))
+	snapshots = append(snapshots, bulk...)
+
+	snapshot, mutations, err = balloon.AddDigest(hashing.NewSha256Hasher().Do(rand.Bytes(128)))
+	require.NoError(t, err)
+	require.NoError(t, store.Mutate(mutations))
+	snapshots = append(snapshots, snapshot)
+
+	digests, err := balloon.QueryEventDigests(0, 3)
+	require.NoError(t, err)
+	require.Len(t, digests, 4)
+
+	last := snapshots[len(snapshots)-1]
+	for i, snapshot := range snapshots {
+		assert.Equalf(t, snapshot.EventDigest, digests[i], "The digest should be indexed for version %d", snapshot.Version)
+
+		proof, err := balloon.QueryHistoryMembership(snapshot.Version, last.Version)
+		require.NoError(t, err)
+		assert.Truef(t, proof.Verify(digests[i], last.HistoryDigest), "The proof should verify for version %d", snapshot.Version)
+
+		proof, err = balloon.QueryHistoryMembership(snapshot.Version, snapshot.Version)
+		require.NoError(t, err)
+		assert.Truef(t, proof.Verify(digests[i], snapshot.HistoryDigest), "The proof should verify at version %d", snapshot.Version)
+	}
+
+	_, err = balloon.QueryEventDigests(2, 4)
+	require.Error(t, err, "Digests beyond the last version should not be returned")
+	_, err = balloon.QueryHistoryMembership(2, 1)
+	require.Error(t, err, "Events should not be proved against previous versions")
+}
+
+func TestQueryHyperDigest(t *testing.T) {
+
+	log.SetLogger("TestQueryHyperDigest", log.SILENT)
+
+	store, closeF := storage_utils.OpenBPlusTreeStore()
+	defer closeF()
+
+	balloon, err := NewBalloon(store, hashing.NewSha256Hasher, hashing.CurrentFormat)
+	require.NoError(t, err)
+
+	_, err = balloon.QueryHyperDigest(0)
+	require.Error(t, err, "An empty balloon should have no hyper digest")
+
+	var snapshots []*Snapshot
+	for i := 0; i < 10; i++ {
+		snapshot, mutations, err := balloon.Add(rand.Bytes(128))
+		require.NoError(t, err)
+		require.NoError(t, store.Mutate(mutations))
+		snapshots = append(snapshots, snapshot)
+	}
+
+	for _, snapshot := range snapshots {
+		digest, err := balloon.QueryHyperDigest(snapshot.Version)
+		require.NoError(t, err)
+		assert.Equalf(t, snapshot.HyperDigest, digest, "The hyper digest should match for version %d", snapshot.Version)
+	}
+}
+
+func TestNewBalloonBackfillsVersions(t *testing.T) {
+
+	log.SetLogger("TestNewBalloonBackfillsVersions", log.SILENT)
+
+	store, closeF := storage_utils.OpenBPlusTreeStore()
+	defer closeF()
+
+	balloon, err := NewBalloon(store, hashing.NewSha256Hasher, hashing.CurrentFormat)
+	require.NoError(t, err)
+
+	// events logged before the hyper tree recorded the copies of its batches
+	var snapshots []*Snapshot
+	for i := 0; i < 10; i++ {
+		snapshot, mutations, err := balloon.Add(rand.Bytes(128))
+		require.NoError(t, err)
+		var unversioned []*storage.Mutation
+		for _, m := range mutations {
+			if m.Table != storage.HyperVersionsTable {
+				unversioned = append(unversioned, m)
+			}
+		}
+		require.NoError(t, store.Mutate(unversioned))
+		snapshots = append(snapshots, snapshot)
+	}
+	_, err = balloon.QueryHyperDigest(5)
+	require.Error(t, err, "The past versions of an unversioned log cannot be queried")
+
+	balloon, err = NewBalloon(store, hashing.NewSha256Hasher, hashing.CurrentFormat)
+	require.NoError(t, err)
+	_, mutations, err := balloon.Add(rand.Bytes(128))
+	require.NoError(t, err)
+	require.NoError(t, store.Mutate(mutations))
+
+	digest, err := balloon.QueryHyperDigest(9)
+	require.NoError(t, err, "The last version should be back-filled")
+	assert.Equal(t, snapshots[9].HyperDigest, digest)
+	_, err = balloon.QueryHyperDigest(5)
+	require.Error(t, err, "The versions before the back-fill cannot be recovered")
+}
+
+func TestRebuildDigests(t *testing.T) {
+
+	log.SetLogger("TestRebuildDigests", log.SILENT)
+
+	store, closeF := storage_utils.OpenBPlusTreeStore()
+	defer closeF()
+
+	balloon, err := NewBalloon(store, hashing.NewSha256Hasher, hashing.CurrentFormat)
+	require.NoError(t, err)
+
+	historyDigest, hyperDigest, err := balloon.RebuildDigests()
+	require.NoError(t, err)
+	require.Nil(t, historyDigest, "An empty balloon should have no history digest")
+	require.Nil(t, hyperDigest, "An empty balloon should have no hyper digest")
+
+	_, mutations, err := balloon.AddBulk([][]byte{rand.Bytes(128), rand.Bytes(128), rand.Bytes(128)})
+	require.NoError(t, err)
+	require.NoError(t, store.Mutate(mutations))
+	snapshot, mutations, err := balloon.Add(rand.Bytes(128))
+	require.NoError(t, err)
+	require.NoError(t, store.Mutate(mutations))
+
+	historyDigest, hyperDigest, err = balloon.RebuildDigests()
+	require.NoError(t, err)
+	assert.Equal(t, snapshot.HistoryDigest, historyDigest, "The rebuilt history digest should match")
+	assert.Equal(t, snapshot.HyperDigest, hyperDigest, "The rebuilt hyper digest should match")
+
+	// the index must match the leaves of the history tree
+	require.NoError(t, store.Mutate([]*storage.Mutation{
+		indexMutation(1, hashing.NewSha256Hasher().Do([]byte("altered"))),
+	}))
+	_, _, err = balloon.RebuildDigests()
+	require.Error(t, err, "An altered event digest should be detected")
+}
+
+func TestExport(t *testing.T) {
+
+	log.SetLogger("TestExport", log.SILENT)
+
+	store, closeF := storage_utils.OpenBPlusTreeStore()
+	defer closeF()
+
+	balloon, err := NewBalloon(store, hashing.NewSha256Hasher, hashing.CurrentFormat)
+	require.NoError(t, err)
+
+	var snapshots []*Snapshot
+	for i := 0; i < 20; i++ {
+		snapshot, mutations, err := balloon.Add(rand.Bytes(128))
+		require.NoError(t, err)
+		require.NoError(t, store.Mutate(mutations))
+		snapshots = append(snapshots, snapshot)
+	}
+
+	require.Error(t, balloon.Export(0, 20, nil), "Versions not yet added should not be exported")
+	require.Error(t, balloon.Export(5, 4, nil), "The range should not be reversed")
+
+	load := func(store storage.Store) storage.EntryFunc {
+		return func(table storage.Table, key, value []byte) error {
+			return store.Mutate([]*storage.Mutation{storage.NewMutation(table, key, value)})
+		}
+	}
+	checkDigests := func(b *Balloon, snapshot *Snapshot) {
+		require.Equal(t, snapshot.Version+1, b.Version(), "The restored balloon should end at the exported version")
+		historyDigest, err := b.QueryHistoryDigest(snapshot.Version)
+		require.NoError(t, err)
+		assert.Equalf(t, snapshot.HistoryDigest, historyDigest, "The history digest of version %d should match", snapshot.Version)
+		hyperDigest, err := b.QueryHyperDigest(snapshot.Version)
+		require.NoError(t, err)
+		assert.Equalf(t, snapshot.HyperDigest, hyperDigest, "The hyper digest of version %d should match", snapshot.Version)
+		historyDigest, hyperDigest, err = b.RebuildDigests()
+		require.NoError(t, err)
+		assert.Equalf(t, snapshot.HistoryDigest, historyDigest, "The rebuilt history digest of version %d should match", snapshot.Version)
+		assert.Equalf(t, snapshot.HyperDigest, hyperDigest, "The rebuilt hyper digest of version %d should match", snapshot.Version)
+	}
+
+	// a point-in-time export
+	exported, closeExported := storage_utils.OpenBPlusTreeStore()
+	defer closeExported()
+	require.NoError(t, balloon.Export(0, 9, load(exported)))
+
+	restored, err := NewBalloon(exported, hashing.NewSha256Hasher, hashing.CurrentFormat)
+	require.NoError(t, err)
+	checkDigests(restored, snapshots[9])
+	version, err := StoredVersion(exported)
+	require.NoError(t, err)
+	require.Equal(t, uint64(10), version, "The stored version should match the exported one")
+
+	// followed by an incremental one
+	require.NoError(t, balloon.Export(10, 19, load(exported)))
+
+	restored, err = NewBalloon(exported, hashing.NewSha256Hasher, hashing.CurrentFormat)
+	require.NoError(t, err)
+	checkDigests(restored, snapshots[19])
+}
+
+func TestExportBulks(t *testing.T) {
+
+	log.SetLogger("TestExportBulks", log.SILENT)
+
+	store, closeF := storage_utils.OpenBPlusTreeStore()
+	defer closeF()
+
+	balloon, err := NewBalloon(store, hashing.NewSha256Hasher, hashing.CurrentFormat)
+	require.NoError(t, err)
+
+	// two bulks, of versions 0 to 4 and 5 to 9
+	for i := 0; i < 2; i++ {
+		bulk := make([][]byte, 5)
+		for j := range bulk {
+			bulk[j] = rand.Bytes(128)
+		}
+		_, mutations, err := balloon.AddBulk(bulk)
+		require.NoError(t, err)
+		require.NoError(t, store.Mutate(mutations))
+	}
+
+	emit := func(table storage.Table, key, value []byte) error { return nil }
+	require.NoError(t, balloon.Export(0, 4, emit))
+	require.NoError(t, balloon.Export(5, 9, emit))
+	require.Error(t, balloon.Export(0, 6, emit), "A range ending in the middle of a bulk should be rejected")
+	require.Error(t, balloon.Export(2, 9, emit), "A range starting in the middle of a bulk should be rejected")
+}
+
+func TestNonMembershipWithShortcut(t *testing.T) {
+
+	log.SetLogger("TestNonMembershipWithShortcut", log.SILENT)
+
+	store, closeF := storage_utils.OpenBPlusTreeStore()
+	defer closeF()
+
+	balloon, err := NewBalloon(store, hashing.NewSha256Hasher, hashing.CurrentFormat)
+	require.NoError(t, err)
+
+	var snapshot *Snapshot
+	events := make([][]byte, 5)
+	for i := range events {
+		events[i] = []byte(fmt.Sprintf("Never knows %d best", i))
+		var mutations []*storage.Mutation
+		snapshot, mutations, err = balloon.Add(events[i])
+		require.NoError(t, err)
+		require.NoError(t, store.Mutate(mutations))
+	}
+
+	// a digest sharing the whole path of an event but the last bit ends
+	// at the shortcut leaf of that event
+	present := hashing.NewSha256Hasher().Do(events[2])
+	absent := make(hashing.Digest, len(present))
+	copy(absent, present)
+	absent[len(absent)-1] ^= 0x01
+
+	proof, err := balloon.QueryDigestMembership(absent, snapshot.Version)
+	require.NoError(t, err)
+	require.False(t, proof.Exists, "The digest should not be a member")
+	require.Equal(t, []byte(present), proof.HyperProof.ShortcutKey, "The proof should end at the leaf of the event")
+	assert.True(t, proof.DigestVerify(absent, snapshot), "The non-membership proof should verify")
+
+	// the leaf of the event is bound to it by the history tree
+	forged := *proof
+	forgedHyper := *proof.HyperProof
+	forgedHyper.ShortcutKey = hashing.NewSha256Hasher().Do(events[3])
+	forged.HyperProof = &forgedHyper
+	assert.False(t, forged.DigestVerify(absent, snapshot), "A shortcut leaf of another event should not verify")
+
+	forged = *proof
+	forged.HistoryProof = nil
+	assert.False(t, forged.DigestVerify(absent, snapshot), "A shortcut leaf without history proof should not verify")
+
+	// the membership proof of the event cannot be turned into a proof of
+	// its non-membership
+	membership, err := balloon.QueryDigestMembership(present, snapshot.Version)
+	require.NoError(t, err)
+	require.True(t, membership.DigestVerify(present, snapshot))
+
+	forged = *membership
+	forged.Exists = false
+	forgedHyper = *membership.HyperProof
+	forgedHyper.ShortcutKey, forgedHyper.ShortcutValue = present, membership.HyperProof.Value
+	forgedHyper.Value = nil
+	forged.HyperProof = &forgedHyper
+	assert.False(t, forged.DigestVerify(present, snapshot), "The leaf of the event should not prove its non-membership")
+
+}
+
 func TestConsistencyProofVerify(t *testing.T) {
 	// Tests already done in history>proof_test.go
 }
@@ -183,7 +640,7 @@ func TestAddQueryAndVerify(t *testing.T) {
 	defer closeF()
 
 	// start balloon
-	b, err := NewBalloon(store, hashing.NewSha256Hasher)
+	b, err := NewBalloon(store, hashing.NewSha256Hasher, hashing.CurrentFormat)
 	assert.NoError(t, err)
 
 	event := hashing.Digest("Never knows best")
@@ -210,7 +667,7 @@ func TestCacheWarmingUp(t *testing.T) {
 	defer closeF()
 
 	// start balloon
-	balloon, err := NewBalloon(store, hashing.NewSha256Hasher)
+	balloon, err := NewBalloon(store, hashing.NewSha256Hasher, hashing.CurrentFormat)
 	require.NoError(t, err)
 
 	// add 100 elements
@@ -228,7 +685,7 @@ func TestCacheWarmingUp(t *testing.T) {
 	balloon = nil
 
 	// open balloon again
-	balloon, err = NewBalloon(store, hashing.NewSha256Hasher)
+	balloon, err = NewBalloon(store, hashing.NewSha256Hasher, hashing.CurrentFormat)
 	require.NoError(t, err)
 
 	// query for all elements
@@ -246,7 +703,7 @@ func TestGenIncrementalAndVerify(t *testing.T) {
 	store, closeF := storage_utils.OpenRocksDBStore(t, "/var/tmp/balloon.test.3")
 	defer closeF()
 
-	b, err := NewBalloon(store, hashing.NewSha256Hasher)
+	b, err := NewBalloon(store, hashing.NewSha256Hasher, hashing.CurrentFormat)
 	assert.NoError(t, err)
 
 	size := 10
@@ -275,7 +732,7 @@ func BenchmarkAddRocksDB(b *testing.B) {
 	store, closeF := storage_utils.OpenRocksDBStore(b, "/var/tmp/balloon_bench.db")
 	defer closeF()
 
-	balloon, err := NewBalloon(store, hashing.NewSha256Hasher)
+	balloon, err := NewBalloon(store, hashing.NewSha256Hasher, hashing.CurrentFormat)
 	require.NoError(b, err)
 
 	balloonMetrics := metrics_utils.CustomRegister(AddTotal)
@@ -301,7 +758,7 @@ func BenchmarkAddBulkRocksDB(b *testing.B) {
 	store, closeF := storage_utils.OpenRocksDBStore(b, "/var/tmp/balloon_bench.db")
 	defer closeF()
 
-	balloon, err := NewBalloon(store, hashing.NewSha256Hasher)
+	balloon, err := NewBalloon(store, hashing.NewSha256Hasher, hashing.CurrentFormat)
 	require.NoError(b, err)
 
 	balloonMetrics := metrics_utils.CustomRegister(AddTotal)
@@ -327,7 +784,7 @@ func BenchmarkQueryRocksDB(b *testing.B) {
 	store, closeF := storage_utils.OpenRocksDBStore(b, "/var/tmp/ballon_bench.db")
 	defer closeF()
 
-	balloon, err := NewBalloon(store, hashing.NewSha256Hasher)
+	balloon, err := NewBalloon(store, hashing.NewSha256Hasher, hashing.CurrentFormat)
 	require.NoError(b, err)
 
 	b.N = 1000000
@@ -353,7 +810,7 @@ func BenchmarkQueryRocksDBParallel(b *testing.B) {
 	store, closeF := storage_utils.OpenRocksDBStore(b, "/var/tmp/ballon_bench.db")
 	defer closeF()
 
-	balloon, err := NewBalloon(store, hashing.NewSha256Hasher)
+	balloon, err := NewBalloon(store, hashing.NewSha256Hasher, hashing.CurrentFormat)
 	require.NoError(b, err)
 
 	b.N = 1000000
@@ -25,14 +25,16 @@ import (
 
 type auditPathVisitor struct {
 	hasher hashing.Hasher
+	format hashing.FormatVersion
 	cache  cache.Cache
 
 	auditPath AuditPath
 }
 
-func newAuditPathVisitor(hasher hashing.Hasher, cache cache.Cache) *auditPathVisitor {
+func newAuditPathVisitor(hasher hashing.Hasher, format hashing.FormatVersion, cache cache.Cache) *auditPathVisitor {
 	return &auditPathVisitor{
 		hasher:    hasher,
+		format:    format,
 		cache:     cache,
 		auditPath: make(AuditPath),
 	}
@@ -43,18 +45,18 @@ func (v auditPathVisitor) Result() AuditPath {
 }
 
 func (v *auditPathVisitor) VisitLeafHashOp(op leafHashOp) hashing.Digest {
-	return v.hasher.Salted(op.Position().Bytes(), op.Value)
+	return v.format.Leaf(v.hasher, op.Position().Bytes(), op.Value)
 }
 
 func (v *auditPathVisitor) VisitInnerHashOp(op innerHashOp) hashing.Digest {
 	leftHash := op.Left.Accept(v)
 	rightHash := op.Right.Accept(v)
-	return v.hasher.Salted(op.Position().Bytes(), leftHash, rightHash)
+	return v.format.Interior(v.hasher, op.Position().Bytes(), leftHash, rightHash)
 }
 
 func (v *auditPathVisitor) VisitPartialInnerHashOp(op partialInnerHashOp) hashing.Digest {
 	leftHash := op.Left.Accept(v)
-	return v.hasher.Salted(op.Position().Bytes(), leftHash)
+	return v.format.Interior(v.hasher, op.Position().Bytes(), leftHash)
 }
 
 func (v *auditPathVisitor) VisitGetCacheOp(op getCacheOp) hashing.Digest {
@@ -98,7 +98,7 @@ func TestAuditPathVisitor(t *testing.T) {
 	}
 
 	for i, c := range testCases {
-		visitor := newAuditPathVisitor(hashing.NewFakeXorHasher(), cache.NewFakeCache([]byte{0x0}))
+		visitor := newAuditPathVisitor(hashing.NewFakeXorHasher(), hashing.FormatV0, cache.NewFakeCache([]byte{0x0}))
 		c.op.Accept(visitor)
 		auditPath := visitor.Result()
 		require.Equalf(t, c.expectedAuditPath, auditPath, "The audit path %v should be equal to the expected %v in test case %d", auditPath, c.expectedAuditPath, i)
@@ -25,29 +25,31 @@ import (
 
 type computeHashVisitor struct {
 	hasher hashing.Hasher
+	format hashing.FormatVersion
 	cache  cache.Cache
 }
 
-func newComputeHashVisitor(hasher hashing.Hasher, cache cache.Cache) *computeHashVisitor {
+func newComputeHashVisitor(hasher hashing.Hasher, format hashing.FormatVersion, cache cache.Cache) *computeHashVisitor {
 	return &computeHashVisitor{
 		hasher: hasher,
+		format: format,
 		cache:  cache,
 	}
 }
 
 func (v *computeHashVisitor) VisitLeafHashOp(op leafHashOp) hashing.Digest {
-	return v.hasher.Salted(op.Position().Bytes(), op.Value)
+	return v.format.Leaf(v.hasher, op.Position().Bytes(), op.Value)
 }
 
 func (v *computeHashVisitor) VisitInnerHashOp(op innerHashOp) hashing.Digest {
 	leftHash := op.Left.Accept(v)
 	rightHash := op.Right.Accept(v)
-	return v.hasher.Salted(op.Position().Bytes(), leftHash, rightHash)
+	return v.format.Interior(v.hasher, op.Position().Bytes(), leftHash, rightHash)
 }
 
 func (v *computeHashVisitor) VisitPartialInnerHashOp(op partialInnerHashOp) hashing.Digest {
 	leftHash := op.Left.Accept(v)
-	return v.hasher.Salted(op.Position().Bytes(), leftHash)
+	return v.format.Interior(v.hasher, op.Position().Bytes(), leftHash)
 }
 
 func (v *computeHashVisitor) VisitGetCacheOp(op getCacheOp) hashing.Digest {
@@ -72,7 +72,7 @@ func TestComputeHashVisitor(t *testing.T) {
 		},
 	}
 
-	visitor := newComputeHashVisitor(hashing.NewFakeXorHasher(), cache.NewFakeCache([]byte{0x0}))
+	visitor := newComputeHashVisitor(hashing.NewFakeXorHasher(), hashing.FormatV0, cache.NewFakeCache([]byte{0x0}))
 
 	for i, c := range testCases {
 		digest := c.op.Accept(visitor)
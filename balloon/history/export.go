@@ -0,0 +1,43 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package history
+
+import (
+	"github.com/bbva/qed/storage"
+	"github.com/bbva/qed/util"
+)
+
+// Export emits the nodes of the tree frozen by the versions between from
+// and until, both included. A node is stored once its last leaf is added,
+// so exporting from version zero emits every node the tree had at until.
+func (t *HistoryTree) Export(from, until uint64, emit storage.EntryFunc) error {
+	return storage.ForEach(t.store, storage.HistoryTable, func(kv *storage.KVPair) error {
+		if len(kv.Key) != keySize {
+			return nil
+		}
+		index := util.BytesAsUint64(kv.Key[:8])
+		height := util.BytesAsUint16(kv.Key[8:])
+		if height >= 64 {
+			return nil
+		}
+		frozen := index + 1<<height - 1
+		if frozen < from || frozen > until {
+			return nil
+		}
+		return emit(storage.HistoryTable, kv.Key, kv.Value)
+	})
+}
@@ -0,0 +1,79 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package history
+
+import (
+	"testing"
+
+	"github.com/bbva/qed/hashing"
+	"github.com/bbva/qed/log"
+	"github.com/bbva/qed/storage"
+	"github.com/bbva/qed/storage/bplus"
+	"github.com/bbva/qed/testutils/rand"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExport(t *testing.T) {
+
+	log.SetLogger("TestExport", log.SILENT)
+
+	store := bplus.NewBPlusTreeStore()
+	tree := NewHistoryTree(hashing.NewSha256Hasher, hashing.CurrentFormat, store, 30)
+	hasher := hashing.NewSha256Hasher()
+
+	rootHashes := make([]hashing.Digest, 20)
+	for i := range rootHashes {
+		var mutations []*storage.Mutation
+		var err error
+		rootHashes[i], mutations, err = tree.Add(hasher.Do(rand.Bytes(32)), uint64(i))
+		require.NoError(t, err)
+		require.NoError(t, store.Mutate(mutations))
+	}
+
+	load := func(store storage.Store) storage.EntryFunc {
+		return func(table storage.Table, key, value []byte) error {
+			return store.Mutate([]*storage.Mutation{storage.NewMutation(table, key, value)})
+		}
+	}
+
+	for _, until := range []uint64{0, 6, 7, 12, 19} {
+		exported := bplus.NewBPlusTreeStore()
+		require.NoError(t, tree.Export(0, until, load(exported)))
+
+		restored := NewHistoryTree(hashing.NewSha256Hasher, hashing.CurrentFormat, exported, 30)
+		for version := uint64(0); version <= until; version++ {
+			rootHash, err := restored.RootHash(version)
+			require.NoError(t, err)
+			assert.Equalf(t, rootHashes[version], rootHash, "The root hash of version %d should match after exporting until %d", version, until)
+		}
+		_, err := restored.LeafHash(until + 1)
+		require.Equalf(t, storage.ErrKeyNotFound, err, "Leaves after version %d should not be exported", until)
+	}
+
+	// an incremental export brings a partial export up to date
+	exported := bplus.NewBPlusTreeStore()
+	require.NoError(t, tree.Export(0, 9, load(exported)))
+	require.NoError(t, tree.Export(10, 19, load(exported)))
+
+	restored := NewHistoryTree(hashing.NewSha256Hasher, hashing.CurrentFormat, exported, 30)
+	for version, expected := range rootHashes {
+		rootHash, err := restored.RootHash(uint64(version))
+		require.NoError(t, err)
+		assert.Equalf(t, expected, rootHash, "The root hash of version %d should match after an incremental export", version)
+	}
+}
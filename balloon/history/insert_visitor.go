@@ -26,15 +26,17 @@ import (
 
 type insertVisitor struct {
 	hasher       hashing.Hasher
+	format       hashing.FormatVersion
 	cache        cache.ModifiableCache
 	storageTable storage.Table // TODO shall i remove this?
 
 	mutations []*storage.Mutation
 }
 
-func newInsertVisitor(hasher hashing.Hasher, cache cache.ModifiableCache, storageTable storage.Table) *insertVisitor {
+func newInsertVisitor(hasher hashing.Hasher, format hashing.FormatVersion, cache cache.ModifiableCache, storageTable storage.Table) *insertVisitor {
 	return &insertVisitor{
 		hasher:       hasher,
+		format:       format,
 		cache:        cache,
 		storageTable: storageTable,
 		mutations:    make([]*storage.Mutation, 0),
@@ -46,18 +48,18 @@ func (v insertVisitor) Result() []*storage.Mutation {
 }
 
 func (v *insertVisitor) VisitLeafHashOp(op leafHashOp) hashing.Digest {
-	return v.hasher.Salted(op.Position().Bytes(), op.Value)
+	return v.format.Leaf(v.hasher, op.Position().Bytes(), op.Value)
 }
 
 func (v *insertVisitor) VisitInnerHashOp(op innerHashOp) hashing.Digest {
 	leftHash := op.Left.Accept(v)
 	rightHash := op.Right.Accept(v)
-	return v.hasher.Salted(op.Position().Bytes(), leftHash, rightHash)
+	return v.format.Interior(v.hasher, op.Position().Bytes(), leftHash, rightHash)
 }
 
 func (v *insertVisitor) VisitPartialInnerHashOp(op partialInnerHashOp) hashing.Digest {
 	leftHash := op.Left.Accept(v)
-	return v.hasher.Salted(op.Position().Bytes(), leftHash)
+	return v.format.Interior(v.hasher, op.Position().Bytes(), leftHash)
 }
 
 func (v *insertVisitor) VisitGetCacheOp(op getCacheOp) hashing.Digest {
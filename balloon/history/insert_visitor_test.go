@@ -75,7 +75,7 @@ func TestInsertVisitor(t *testing.T) {
 
 	for i, c := range testCases {
 		cache := cache.NewFakeCache([]byte{0x0})
-		visitor := newInsertVisitor(hashing.NewFakeXorHasher(), cache, storage.HistoryTable)
+		visitor := newInsertVisitor(hashing.NewFakeXorHasher(), hashing.FormatV0, cache, storage.HistoryTable)
 
 		c.op.Accept(visitor)
 
@@ -62,14 +62,16 @@ type MembershipProof struct {
 	AuditPath      AuditPath
 	Index, Version uint64
 	hasher         hashing.Hasher // TODO should we remove this and pass as an argument when verifying?
+	format         hashing.FormatVersion
 }
 
-func NewMembershipProof(index, version uint64, auditPath AuditPath, hasher hashing.Hasher) *MembershipProof {
+func NewMembershipProof(index, version uint64, auditPath AuditPath, hasher hashing.Hasher, format hashing.FormatVersion) *MembershipProof {
 	return &MembershipProof{
 		AuditPath: auditPath,
 		Index:     index,
 		Version:   version,
 		hasher:    hasher,
+		format:    format,
 	}
 }
 
@@ -79,7 +81,7 @@ func (p MembershipProof) Verify(eventDigest []byte, expectedRootHash hashing.Dig
 	log.Debugf("Verifying membership proof for index %d and version %d", p.Index, p.Version)
 
 	// build a visitable pruned tree and then visit it to recompute root hash
-	visitor := newComputeHashVisitor(p.hasher, p.AuditPath)
+	visitor := newComputeHashVisitor(p.hasher, p.format, p.AuditPath)
 	recomputed := pruneToVerify(p.Index, p.Version, eventDigest).Accept(visitor)
 
 	return bytes.Equal(recomputed, expectedRootHash)
@@ -89,14 +91,16 @@ type IncrementalProof struct {
 	AuditPath                AuditPath
 	StartVersion, EndVersion uint64
 	hasher                   hashing.Hasher
+	format                   hashing.FormatVersion
 }
 
-func NewIncrementalProof(start, end uint64, auditPath AuditPath, hasher hashing.Hasher) *IncrementalProof {
+func NewIncrementalProof(start, end uint64, auditPath AuditPath, hasher hashing.Hasher, format hashing.FormatVersion) *IncrementalProof {
 	return &IncrementalProof{
 		AuditPath:    auditPath,
 		StartVersion: start,
 		EndVersion:   end,
 		hasher:       hasher,
+		format:       format,
 	}
 }
 
@@ -105,7 +109,7 @@ func (p IncrementalProof) Verify(startDigest, endDigest hashing.Digest) (correct
 	log.Debugf("Verifying incremental proof between versions %d and %d", p.StartVersion, p.EndVersion)
 
 	// build two visitable pruned trees and then visit them to recompute root hash
-	visitor := newComputeHashVisitor(p.hasher, p.AuditPath)
+	visitor := newComputeHashVisitor(p.hasher, p.format, p.AuditPath)
 	startRecomputed := pruneToVerifyIncrementalStart(p.StartVersion).Accept(visitor)
 	endRecomputed := pruneToVerifyIncrementalEnd(p.StartVersion, p.EndVersion).Accept(visitor)
 
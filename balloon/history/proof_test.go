@@ -320,7 +320,7 @@ func TestVerifyMembershipProof(t *testing.T) {
 	}
 
 	for _, c := range testCases {
-		proof := NewMembershipProof(c.index, c.version, c.auditPath, hashing.NewFakeXorHasher())
+		proof := NewMembershipProof(c.index, c.version, c.auditPath, hashing.NewFakeXorHasher(), hashing.FormatV0)
 		correct := proof.Verify(c.expectedDigest, c.eventDigest)
 		assert.Equalf(t, c.verifies, correct, "The membership proof should be valid for test case with index %d and version %d", c.index, c.version)
 	}
@@ -425,7 +425,7 @@ func TestVerifyIncrementalProof(t *testing.T) {
 
 	hasher := hashing.NewFakeXorHasher()
 	for _, c := range testCases {
-		proof := NewIncrementalProof(c.start, c.end, c.auditPath, hasher)
+		proof := NewIncrementalProof(c.start, c.end, c.auditPath, hasher, hashing.FormatV0)
 		correct := proof.Verify(c.expectedStartDigest, c.expectedEndDigest)
 		assert.Equalf(t, c.verifies, correct, "The incremental proof between %d and %d should be valid", c.start, c.end)
 	}
@@ -0,0 +1,90 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+
+package history
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/bbva/qed/hashing"
+)
+
+// RebuildRootHash recomputes the root hash of the tree as it was at the
+// given version from the stored leaves, checking every stored node frozen
+// by then against the hash of its children. Unlike RootHash, it reads the
+// whole tree, so altered nodes below the top ones are detected too.
+func (t *HistoryTree) RebuildRootHash(version uint64) (hashing.Digest, error) {
+
+	hasher := t.hasherF()
+
+	var rebuild func(pos *position) (hashing.Digest, error)
+	rebuild = func(pos *position) (hashing.Digest, error) {
+
+		if pos.IsLeaf() {
+			hash, ok := t.readCache.Get(pos.Bytes())
+			if !ok {
+				return nil, fmt.Errorf("missing leaf at position %v", pos)
+			}
+			return hash, nil
+		}
+
+		left, err := rebuild(pos.Left())
+		if err != nil {
+			return nil, err
+		}
+
+		var hash hashing.Digest
+		rightPos := pos.Right()
+		if rightPos.Index > version { // partial
+			hash = t.format.Interior(hasher, pos.Bytes(), left)
+		} else {
+			right, err := rebuild(rightPos)
+			if err != nil {
+				return nil, err
+			}
+			hash = t.format.Interior(hasher, pos.Bytes(), left, right)
+		}
+
+		if pos.LastDescendant().Index <= version { // frozen
+			stored, ok := t.readCache.Get(pos.Bytes())
+			if !ok {
+				return nil, fmt.Errorf("missing node at position %v", pos)
+			}
+			if !bytes.Equal(stored, hash) {
+				return nil, fmt.Errorf("the node at position %v does not match its children", pos)
+			}
+		}
+		return hash, nil
+	}
+
+	return rebuild(newRootPosition(version))
+}
+
+// CheckLeaf tells whether the stored leaf at the given index is the hash
+// of the given event digest.
+func (t *HistoryTree) CheckLeaf(index uint64, eventDigest hashing.Digest) error {
+	pos := newPosition(index, 0)
+	stored, ok := t.readCache.Get(pos.Bytes())
+	if !ok {
+		return fmt.Errorf("missing leaf at position %v", pos)
+	}
+	if !bytes.Equal(stored, t.format.Leaf(t.hasherF(), pos.Bytes(), eventDigest)) {
+		return fmt.Errorf("the leaf at position %v does not match the event digest %x", pos, eventDigest)
+	}
+	return nil
+}
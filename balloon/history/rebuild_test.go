@@ -0,0 +1,79 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+
+package history
+
+import (
+	"testing"
+
+	"github.com/bbva/qed/hashing"
+	"github.com/bbva/qed/log"
+	"github.com/bbva/qed/storage"
+	"github.com/bbva/qed/storage/bplus"
+	"github.com/bbva/qed/testutils/rand"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRebuildRootHash(t *testing.T) {
+
+	log.SetLogger("TestRebuildRootHash", log.SILENT)
+
+	store := bplus.NewBPlusTreeStore()
+	tree := NewHistoryTree(hashing.NewSha256Hasher, hashing.CurrentFormat, store, 30)
+	hasher := hashing.NewSha256Hasher()
+
+	digests := make([]hashing.Digest, 10)
+	rootHashes := make([]hashing.Digest, len(digests))
+	for i := range digests {
+		digests[i] = hasher.Do(rand.Bytes(32))
+		var mutations []*storage.Mutation
+		var err error
+		rootHashes[i], mutations, err = tree.Add(digests[i], uint64(i))
+		require.NoError(t, err)
+		require.NoError(t, store.Mutate(mutations))
+	}
+
+	for version, expected := range rootHashes {
+		rootHash, err := tree.RebuildRootHash(uint64(version))
+		require.NoError(t, err)
+		assert.Equalf(t, expected, rootHash, "The rebuilt root hash of version %d should match", version)
+	}
+	for index, digest := range digests {
+		require.NoErrorf(t, tree.CheckLeaf(uint64(index), digest), "The leaf %d should match its event digest", index)
+	}
+	require.Error(t, tree.CheckLeaf(0, digests[1]), "A leaf should not match another event digest")
+
+	// an altered node below the top ones is detected
+	altered := newPosition(4, 1)
+	require.NoError(t, store.Mutate([]*storage.Mutation{
+		storage.NewMutation(storage.HistoryTable, altered.Bytes(), hasher.Do([]byte("altered"))),
+	}))
+	_, err := tree.RootHash(9)
+	require.NoError(t, err)
+	_, err = tree.RebuildRootHash(9)
+	require.Error(t, err, "An altered node should be detected")
+	_, err = tree.RebuildRootHash(3)
+	require.NoError(t, err, "Versions before the altered node should rebuild")
+
+	// a missing leaf is detected
+	require.NoError(t, store.Mutate([]*storage.Mutation{
+		storage.NewDeletion(storage.HistoryTable, newPosition(2, 0).Bytes()),
+	}))
+	_, err = tree.RebuildRootHash(3)
+	require.Error(t, err, "A missing leaf should be detected")
+}
@@ -25,11 +25,13 @@ import (
 type HistoryTree struct {
 	hasherF    func() hashing.Hasher
 	hasher     hashing.Hasher
+	format     hashing.FormatVersion
+	store      storage.Store
 	writeCache cache.ModifiableCache
 	readCache  cache.Cache
 }
 
-func NewHistoryTree(hasherF func() hashing.Hasher, store storage.Store, cacheSize uint16) *HistoryTree {
+func NewHistoryTree(hasherF func() hashing.Hasher, format hashing.FormatVersion, store storage.Store, cacheSize uint16) *HistoryTree {
 
 	// create cache for Adding
 	writeCache := cache.NewLruReadThroughCache(storage.HistoryTable, store, cacheSize)
@@ -40,6 +42,8 @@ func NewHistoryTree(hasherF func() hashing.Hasher, store storage.Store, cacheSiz
 	return &HistoryTree{
 		hasherF:    hasherF,
 		hasher:     hasherF(),
+		format:     format,
+		store:      store,
 		writeCache: writeCache,
 		readCache:  readCache,
 	}
@@ -50,7 +54,7 @@ func (t *HistoryTree) Add(eventDigest hashing.Digest, version uint64) (hashing.D
 	// log.Debugf("Adding new event digest %x with version %d", eventDigest, version)
 
 	// build a visitable pruned tree and then visit it to generate the root hash
-	visitor := newInsertVisitor(t.hasher, t.writeCache, storage.HistoryTable)
+	visitor := newInsertVisitor(t.hasher, t.format, t.writeCache, storage.HistoryTable)
 	rh := pruneToInsert(version, eventDigest).Accept(visitor)
 
 	return rh, visitor.Result(), nil
@@ -58,7 +62,7 @@ func (t *HistoryTree) Add(eventDigest hashing.Digest, version uint64) (hashing.D
 
 func (t *HistoryTree) AddBulk(eventDigests []hashing.Digest, versions []uint64) ([]hashing.Digest, []*storage.Mutation, error) {
 
-	visitor := newInsertVisitor(t.hasher, t.writeCache, storage.HistoryTable)
+	visitor := newInsertVisitor(t.hasher, t.format, t.writeCache, storage.HistoryTable)
 
 	rootHashes := make([]hashing.Digest, 0)
 	for i, e := range eventDigests {
@@ -74,14 +78,14 @@ func (t *HistoryTree) ProveMembership(index, version uint64) (*MembershipProof,
 	//log.Debugf("Proving membership for index %d with version %d", index, version)
 
 	// build a visitable pruned tree and then visit it to collect the audit path
-	visitor := newAuditPathVisitor(t.hasherF(), t.readCache)
+	visitor := newAuditPathVisitor(t.hasherF(), t.format, t.readCache)
 	if index == version {
 		pruneToFind(index).Accept(visitor) // faster pruning
 	} else {
 		pruneToFindConsistent(index, version).Accept(visitor)
 	}
 
-	proof := NewMembershipProof(index, version, visitor.Result(), t.hasherF())
+	proof := NewMembershipProof(index, version, visitor.Result(), t.hasherF(), t.format)
 	return proof, nil
 }
 
@@ -90,14 +94,33 @@ func (t *HistoryTree) ProveConsistency(start, end uint64) (*IncrementalProof, er
 	//log.Debugf("Proving consistency between versions %d and %d", start, end)
 
 	// build a visitable pruned tree and then visit it to collect the audit path
-	visitor := newAuditPathVisitor(t.hasherF(), t.readCache)
+	visitor := newAuditPathVisitor(t.hasherF(), t.format, t.readCache)
 	pruneToCheckConsistency(start, end).Accept(visitor)
 
-	proof := NewIncrementalProof(start, end, visitor.Result(), t.hasherF())
+	proof := NewIncrementalProof(start, end, visitor.Result(), t.hasherF(), t.format)
 
 	return proof, nil
 }
 
+// RootHash returns the root hash of the tree as it was at the given
+// version. The version must have already been added to the tree.
+func (t *HistoryTree) RootHash(version uint64) (hashing.Digest, error) {
+
+	// every node needed to rebuild the root at that version is frozen,
+	// so we can compute it from the stored hashes
+	visitor := newComputeHashVisitor(t.hasherF(), t.format, t.readCache)
+	return pruneToVerifyIncrementalStart(version).Accept(visitor), nil
+}
+
+// LeafHash returns the stored hash of the leaf at the given index.
+func (t *HistoryTree) LeafHash(index uint64) (hashing.Digest, error) {
+	hash, ok := t.readCache.Get(newPosition(index, 0).Bytes())
+	if !ok {
+		return nil, storage.ErrKeyNotFound
+	}
+	return hash, nil
+}
+
 func (t *HistoryTree) Close() {
 	t.hasher = nil
 	t.writeCache = nil
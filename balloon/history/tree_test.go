@@ -21,6 +21,7 @@ import (
 
 	"github.com/bbva/qed/hashing"
 	"github.com/bbva/qed/log"
+	"github.com/bbva/qed/storage"
 	"github.com/bbva/qed/storage/bplus"
 	metrics_utils "github.com/bbva/qed/testutils/metrics"
 	"github.com/bbva/qed/testutils/rand"
@@ -91,7 +92,7 @@ func TestAdd(t *testing.T) {
 	}
 
 	store := bplus.NewBPlusTreeStore()
-	tree := NewHistoryTree(hashing.NewFakeXorHasher, store, 30)
+	tree := NewHistoryTree(hashing.NewFakeXorHasher, hashing.FormatV0, store, 30)
 
 	for i, c := range testCases {
 		index := uint64(i)
@@ -135,7 +136,7 @@ func TestAddBulk(t *testing.T) {
 	store, closeF := storage_utils.OpenBPlusTreeStore()
 	defer closeF()
 
-	tree := NewHistoryTree(hashing.NewFakeXorHasher, store, 30)
+	tree := NewHistoryTree(hashing.NewFakeXorHasher, hashing.FormatV0, store, 30)
 
 	for i, c := range testCases {
 		rootHash, mutations, err := tree.AddBulk(c.eventDigests, c.versions)
@@ -308,7 +309,7 @@ func TestProveMembership(t *testing.T) {
 	}
 
 	store := bplus.NewBPlusTreeStore()
-	tree := NewHistoryTree(hashing.NewFakeXorHasher, store, 30)
+	tree := NewHistoryTree(hashing.NewFakeXorHasher, hashing.FormatV0, store, 30)
 
 	for _, c := range testCases {
 		_, mutations, err := tree.Add(c.eventDigest, c.index)
@@ -419,7 +420,7 @@ func TestProveConsistency(t *testing.T) {
 	}
 
 	store := bplus.NewBPlusTreeStore()
-	tree := NewHistoryTree(hashing.NewFakeXorHasher, store, 30)
+	tree := NewHistoryTree(hashing.NewFakeXorHasher, hashing.FormatV0, store, 30)
 
 	for i, c := range testCases {
 		index := uint64(i)
@@ -490,7 +491,7 @@ func TestProveConsistencySameVersions(t *testing.T) {
 	}
 
 	store := bplus.NewBPlusTreeStore()
-	tree := NewHistoryTree(hashing.NewFakeXorHasher, store, 30)
+	tree := NewHistoryTree(hashing.NewFakeXorHasher, hashing.FormatV0, store, 30)
 
 	for i, c := range testCases {
 		_, mutations, err := tree.Add(c.eventDigest, c.index)
@@ -505,6 +506,79 @@ func TestProveConsistencySameVersions(t *testing.T) {
 	}
 }
 
+func TestProofsWithFormats(t *testing.T) {
+
+	log.SetLogger("TestProofsWithFormats", log.SILENT)
+
+	hasher := hashing.NewSha256Hasher()
+	rootHashes := make(map[hashing.FormatVersion][]hashing.Digest)
+
+	for _, format := range []hashing.FormatVersion{hashing.FormatV0, hashing.FormatV1} {
+
+		store := bplus.NewBPlusTreeStore()
+		tree := NewHistoryTree(hashing.NewSha256Hasher, format, store, 30)
+
+		digests := make([]hashing.Digest, 10)
+		for i := range digests {
+			digests[i] = hasher.Do(rand.Bytes(32))
+			rootHash, mutations, err := tree.Add(digests[i], uint64(i))
+			require.NoError(t, err)
+			require.NoError(t, store.Mutate(mutations))
+			rootHashes[format] = append(rootHashes[format], rootHash)
+		}
+
+		last := uint64(len(digests) - 1)
+		for i := range digests {
+			index := uint64(i)
+
+			mp, err := tree.ProveMembership(index, last)
+			require.NoError(t, err)
+			assert.Truef(t, mp.Verify(digests[i], rootHashes[format][last]), "The membership proof for %d should verify with format %d", i, format)
+
+			ip, err := tree.ProveConsistency(index, last)
+			require.NoError(t, err)
+			assert.Truef(t, ip.Verify(rootHashes[format][i], rootHashes[format][last]), "The incremental proof from %d should verify with format %d", i, format)
+
+			// proofs are bound to the format of the tree
+			other := NewMembershipProof(index, last, mp.AuditPath, hashing.NewSha256Hasher(), hashing.FormatV1-format)
+			assert.Falsef(t, other.Verify(digests[i], rootHashes[format][last]), "The membership proof for %d should not verify with another format", i)
+		}
+	}
+
+	assert.NotEqual(t, rootHashes[hashing.FormatV0], rootHashes[hashing.FormatV1], "Formats should produce different root hashes")
+}
+
+func TestRootAndLeafHashes(t *testing.T) {
+
+	log.SetLogger("TestRootAndLeafHashes", log.SILENT)
+
+	store := bplus.NewBPlusTreeStore()
+	tree := NewHistoryTree(hashing.NewSha256Hasher, hashing.CurrentFormat, store, 30)
+	hasher := hashing.NewSha256Hasher()
+
+	rootHashes := make([]hashing.Digest, 10)
+	for i := range rootHashes {
+		var mutations []*storage.Mutation
+		var err error
+		rootHashes[i], mutations, err = tree.Add(hasher.Do(rand.Bytes(32)), uint64(i))
+		require.NoError(t, err)
+		require.NoError(t, store.Mutate(mutations))
+	}
+
+	for i, expected := range rootHashes {
+		rootHash, err := tree.RootHash(uint64(i))
+		require.NoError(t, err)
+		assert.Equalf(t, expected, rootHash, "The root hash should match for version %d", i)
+
+		leafHash, err := tree.LeafHash(uint64(i))
+		require.NoError(t, err)
+		assert.NotEmptyf(t, leafHash, "The leaf hash should be stored for index %d", i)
+	}
+
+	_, err := tree.LeafHash(uint64(len(rootHashes)))
+	require.Equal(t, storage.ErrKeyNotFound, err, "Leaves not yet added should not be found")
+}
+
 func max(x, y int) int {
 	if x > y {
 		return x
@@ -519,7 +593,7 @@ func BenchmarkAdd(b *testing.B) {
 	store, closeF := storage_utils.OpenRocksDBStore(b, "/var/tmp/history_tree_test.db")
 	defer closeF()
 
-	tree := NewHistoryTree(hashing.NewSha256Hasher, store, 300)
+	tree := NewHistoryTree(hashing.NewSha256Hasher, hashing.CurrentFormat, store, 300)
 	hasher := hashing.NewSha256Hasher()
 
 	historyMetrics := metrics_utils.CustomRegister(AddTotal)
@@ -543,7 +617,7 @@ func BenchmarkAddBulk(b *testing.B) {
 	store, closeF := storage_utils.OpenRocksDBStore(b, "/var/tmp/history_tree_test.db")
 	defer closeF()
 
-	tree := NewHistoryTree(hashing.NewSha256Hasher, store, 300)
+	tree := NewHistoryTree(hashing.NewSha256Hasher, hashing.CurrentFormat, store, 300)
 	hasher := hashing.NewSha256Hasher()
 
 	historyMetrics := metrics_utils.CustomRegister(AddTotal)
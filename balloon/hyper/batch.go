@@ -64,8 +64,10 @@ func (b batchNode) AddLeafAt(i int8, hash hashing.Digest, key, value []byte) {
 	b.batch[2*i+2] = append(value, byte(2))
 }
 
+// The returned slices are capped at the node size, so appending to them
+// never overwrites the flag of the serialized node they come from.
 func (b batchNode) GetLeafKVAt(i int8) ([]byte, []byte) {
-	return b.batch[2*i+1][:b.nodeSize], b.batch[2*i+2][:b.nodeSize]
+	return b.batch[2*i+1][:b.nodeSize:b.nodeSize], b.batch[2*i+2][:b.nodeSize:b.nodeSize]
 }
 
 func (b batchNode) HasElementAt(i int8) bool {
@@ -73,7 +75,7 @@ func (b batchNode) HasElementAt(i int8) bool {
 }
 
 func (b batchNode) GetElementAt(i int8) []byte {
-	return b.batch[i][:b.nodeSize]
+	return b.batch[i][:b.nodeSize:b.nodeSize]
 }
 
 func (b batchNode) ResetElementAt(i int8) {
@@ -0,0 +1,135 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package hyper
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/bbva/qed/storage"
+	"github.com/bbva/qed/util"
+)
+
+// Export emits the copies of the batches made by the versions between from
+// and until, both included, along with the batches below the cache height
+// as they were at until, if they changed in between. Exporting from version
+// zero emits a tree that answers queries as the tree did at until.
+//
+// The copies of a bulk are made at the first version of the bulk, so the
+// range must start at the first version of a bulk, and the version after
+// until, if it has been added, must start another one, as BulkStart tells.
+// Logs written before the copies were recorded cannot export the versions
+// before the first copy, so they fail with ErrUnversioned.
+func (t *HyperTree) Export(from, until uint64, emit storage.EntryFunc) error {
+
+	if start, err := t.BulkStart(from); err != nil {
+		return err
+	} else if start != from {
+		return fmt.Errorf("the range splits the bulk that starts at version %d", start)
+	}
+	if versioned, err := t.isVersioned(until); err != nil {
+		return err
+	} else if !versioned {
+		return fmt.Errorf("unable to export version %d: %v", until, ErrUnversioned)
+	}
+
+	// the copies of a position are sorted by version, the last one
+	// not greater than until is its batch at that version
+	var pos []byte
+	var last *storage.KVPair
+	var lastVersion uint64
+	flush := func() error {
+		if last == nil || lastVersion < from {
+			return nil
+		}
+		if util.BytesAsUint16(pos[:2]) > t.cacheHeightLimit {
+			return nil
+		}
+		return emit(storage.HyperTable, pos, last.Value)
+	}
+
+	err := storage.ForEach(t.store, storage.HyperVersionsTable, func(kv *storage.KVPair) error {
+		if len(kv.Key) < 10 {
+			return nil
+		}
+		keyPos := kv.Key[:len(kv.Key)-8]
+		version := util.BytesAsUint64(kv.Key[len(kv.Key)-8:])
+		if !bytes.Equal(keyPos, pos) {
+			if err := flush(); err != nil {
+				return err
+			}
+			pos = append(pos[:0:0], keyPos...)
+			last = nil
+		}
+		if version > until {
+			return nil
+		}
+		if version >= from {
+			if err := emit(storage.HyperVersionsTable, kv.Key, kv.Value); err != nil {
+				return err
+			}
+		}
+		last, lastVersion = kv, version
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return flush()
+}
+
+// ErrUnversioned is returned for the versions of the tree added before the
+// copies of its batches were recorded, which cannot be queried nor
+// exported as they were.
+var ErrUnversioned = errors.New("the version predates the copies of the hyper tree batches")
+
+// isVersioned tells whether the copies of the batches were recorded at the
+// given version. Every version records a copy of the root batch, or its
+// bulk does, so the version is versioned if the root batch has a copy at
+// or before it.
+func (t *HyperTree) isVersioned(version uint64) (bool, error) {
+	root := newRootPosition(t.hasher.Len() / 8)
+	kv, err := t.store.GetFloor(storage.HyperVersionsTable, versionedKey(root, util.Uint64AsBytes(version)))
+	if err == storage.ErrKeyNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	key := kv.Key
+	return len(key) == len(root.Bytes())+8 && bytes.Equal(key[:len(key)-8], root.Bytes()), nil
+}
+
+// BulkStart returns the first version of the bulk that added the given
+// version, whose copies are made under it. Every version added before
+// the copies were recorded is taken as a bulk of its own.
+func (t *HyperTree) BulkStart(version uint64) (uint64, error) {
+	root := newRootPosition(t.hasher.Len() / 8)
+	kv, err := t.store.GetFloor(storage.HyperVersionsTable, versionedKey(root, util.Uint64AsBytes(version)))
+	if err == storage.ErrKeyNotFound {
+		return version, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	key := kv.Key
+	if len(key) != len(root.Bytes())+8 || !bytes.Equal(key[:len(key)-8], root.Bytes()) {
+		return version, nil
+	}
+	return util.BytesAsUint64(key[len(key)-8:]), nil
+}
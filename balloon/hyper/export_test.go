@@ -0,0 +1,164 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package hyper
+
+import (
+	"testing"
+
+	"github.com/bbva/qed/balloon/cache"
+	"github.com/bbva/qed/hashing"
+	"github.com/bbva/qed/log"
+	"github.com/bbva/qed/storage"
+	"github.com/bbva/qed/testutils/rand"
+	storage_utils "github.com/bbva/qed/testutils/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExport(t *testing.T) {
+
+	log.SetLogger("TestExport", log.SILENT)
+
+	store, closeF := storage_utils.OpenBPlusTreeStore()
+	defer closeF()
+	hasherF := hashing.NewSha256Hasher
+	hasher := hasherF()
+
+	tree := NewHyperTree(hasherF, hashing.CurrentFormat, store, cache.NewSimpleCache(10))
+
+	numEvents := 30
+	keys := make([]hashing.Digest, numEvents)
+	rootHashes := make([]hashing.Digest, numEvents)
+	for i := 0; i < numEvents; i++ {
+		keys[i] = hasher.Do(rand.Bytes(32))
+		rootHash, mutations, err := tree.Add(keys[i], uint64(i))
+		require.NoError(t, err)
+		require.NoError(t, store.Mutate(mutations))
+		rootHashes[i] = rootHash
+	}
+
+	load := func(store storage.Store) storage.EntryFunc {
+		return func(table storage.Table, key, value []byte) error {
+			return store.Mutate([]*storage.Mutation{storage.NewMutation(table, key, value)})
+		}
+	}
+
+	for _, until := range []uint64{0, 11, 29} {
+		exported, closeExported := storage_utils.OpenBPlusTreeStore()
+		require.NoError(t, tree.Export(0, until, load(exported)))
+
+		restored := NewHyperTree(hasherF, hashing.CurrentFormat, exported, cache.NewSimpleCache(10))
+		for i, key := range keys {
+			proof, err := restored.QueryMembership(key)
+			require.NoError(t, err)
+			if uint64(i) <= until {
+				assert.NotEmptyf(t, proof.Value, "Key %d should be a member after exporting until %d", i, until)
+			} else {
+				assert.Emptyf(t, proof.Value, "Key %d should not be a member after exporting until %d", i, until)
+			}
+			assert.Truef(t, proof.Verify(key, rootHashes[until]), "The proof for key %d should verify after exporting until %d", i, until)
+		}
+		closeExported()
+	}
+
+	// an incremental export brings a partial export up to date
+	exported, closeExported := storage_utils.OpenBPlusTreeStore()
+	defer closeExported()
+	require.NoError(t, tree.Export(0, 14, load(exported)))
+	require.NoError(t, tree.Export(15, 29, load(exported)))
+
+	restored := NewHyperTree(hasherF, hashing.CurrentFormat, exported, cache.NewSimpleCache(10))
+	for i, key := range keys {
+		proof, err := restored.QueryMembership(key)
+		require.NoError(t, err)
+		assert.Truef(t, proof.Verify(key, rootHashes[numEvents-1]), "The proof for key %d should verify after an incremental export", i)
+
+		proof, err = restored.QueryMembershipAt(key, 14)
+		require.NoError(t, err)
+		assert.Truef(t, proof.Verify(key, rootHashes[14]), "The proof for key %d should verify at version 14 after an incremental export", i)
+	}
+}
+
+func TestExportBulks(t *testing.T) {
+
+	log.SetLogger("TestExportBulks", log.SILENT)
+
+	store, closeF := storage_utils.OpenBPlusTreeStore()
+	defer closeF()
+	hasherF := hashing.NewSha256Hasher
+	hasher := hasherF()
+
+	tree := NewHyperTree(hasherF, hashing.CurrentFormat, store, cache.NewSimpleCache(10))
+
+	// two bulks, of versions 0 to 4 and 5 to 9
+	for _, versions := range [][]uint64{{0, 1, 2, 3, 4}, {5, 6, 7, 8, 9}} {
+		keys := make([]hashing.Digest, len(versions))
+		for i := range versions {
+			keys[i] = hasher.Do(rand.Bytes(32))
+		}
+		_, mutations, err := tree.AddBulk(keys, versions)
+		require.NoError(t, err)
+		require.NoError(t, store.Mutate(mutations))
+	}
+
+	for version, expected := range []uint64{0, 0, 0, 0, 0, 5, 5, 5, 5, 5} {
+		start, err := tree.BulkStart(uint64(version))
+		require.NoError(t, err)
+		assert.Equalf(t, expected, start, "Wrong bulk start for version %d", version)
+	}
+
+	emit := func(table storage.Table, key, value []byte) error { return nil }
+	require.NoError(t, tree.Export(5, 9, emit))
+	require.Error(t, tree.Export(3, 9, emit), "A range starting in the middle of a bulk should be rejected")
+}
+
+func TestExportUnversioned(t *testing.T) {
+
+	log.SetLogger("TestExportUnversioned", log.SILENT)
+
+	store, closeF := storage_utils.OpenBPlusTreeStore()
+	defer closeF()
+	hasherF := hashing.NewSha256Hasher
+	hasher := hasherF()
+
+	tree := NewHyperTree(hasherF, hashing.CurrentFormat, store, cache.NewSimpleCache(10))
+
+	// the first versions are written without copies, as logs did
+	// before they were recorded
+	for i := 0; i < 20; i++ {
+		_, mutations, err := tree.Add(hasher.Do(rand.Bytes(32)), uint64(i))
+		require.NoError(t, err)
+		if i < 10 {
+			var unversioned []*storage.Mutation
+			for _, m := range mutations {
+				if m.Table != storage.HyperVersionsTable {
+					unversioned = append(unversioned, m)
+				}
+			}
+			mutations = unversioned
+		}
+		require.NoError(t, store.Mutate(mutations))
+	}
+
+	discard := func(table storage.Table, key, value []byte) error { return nil }
+	for _, until := range []uint64{0, 9} {
+		err := tree.Export(0, until, discard)
+		require.Errorf(t, err, "Exporting until %d should fail", until)
+		require.Contains(t, err.Error(), ErrUnversioned.Error())
+	}
+	require.NoError(t, tree.Export(0, 10, discard), "The versioned versions should be exported")
+}
@@ -17,10 +17,13 @@
 package hyper
 
 import (
+	"bytes"
+
 	"github.com/bbva/qed/log"
 
 	"github.com/bbva/qed/balloon/cache"
 	"github.com/bbva/qed/storage"
+	"github.com/bbva/qed/util"
 )
 
 type batchLoader interface {
@@ -69,3 +72,41 @@ func (l defaultBatchLoader) loadBatchFromStore(pos position) *batchNode {
 	batch := parseBatchNode(len(pos.Index), kv.Value)
 	return batch
 }
+
+// versionedBatchLoader loads every batch, regardless of its height,
+// as it was at a given version of the tree. It reads the copies
+// stored in the versions table on every insertion.
+type versionedBatchLoader struct {
+	version []byte
+	store   storage.Store
+}
+
+func NewVersionedBatchLoader(store storage.Store, version uint64) *versionedBatchLoader {
+	return &versionedBatchLoader{
+		version: util.Uint64AsBytes(version),
+		store:   store,
+	}
+}
+
+func (l versionedBatchLoader) Load(pos position) *batchNode {
+	// the greatest key less or equal than position+version is the last
+	// copy of the batch at that version, if it has the same position
+	kv, err := l.store.GetFloor(storage.HyperVersionsTable, versionedKey(pos, l.version))
+	if err != nil {
+		if err == storage.ErrKeyNotFound {
+			return newEmptyBatchNode(len(pos.Index))
+		}
+		log.Fatalf("Oops, something went wrong. Unable to load batch: %v", err)
+	}
+	if len(kv.Key) != len(pos.Bytes())+len(l.version) || !bytes.HasPrefix(kv.Key, pos.Bytes()) {
+		return newEmptyBatchNode(len(pos.Index))
+	}
+	return parseBatchNode(len(pos.Index), kv.Value)
+}
+
+// versionedKey returns the key of a batch copy in the versions table.
+func versionedKey(pos position, version []byte) []byte {
+	key := make([]byte, 0, len(pos.Bytes())+len(version))
+	key = append(key, pos.Bytes()...)
+	return append(key, version...)
+}
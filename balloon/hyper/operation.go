@@ -17,19 +17,30 @@
 package hyper
 
 import (
+	"fmt"
+
 	"github.com/bbva/qed/balloon/cache"
 	"github.com/bbva/qed/hashing"
-	"github.com/bbva/qed/log"
 	"github.com/bbva/qed/storage"
 )
 
 type pruningContext struct {
 	Hasher        hashing.Hasher
+	Format        hashing.FormatVersion
 	Cache         cache.ModifiableCache
 	DefaultHashes []hashing.Digest
 	Mutations     []*storage.Mutation
 	AuditPath     AuditPath
 	Value         []byte
+	// ShortcutKey and ShortcutValue are those of the shortcut leaf of
+	// another key found at the end of the path of a searched index.
+	ShortcutKey, ShortcutValue []byte
+	// Version is the version that every modified batch is copied
+	// under in the versions table. Nil means no copies are made.
+	Version []byte
+	// Err holds the first error found while interpreting the operations,
+	// such as a position missing from the audit path of a proof.
+	Err error
 }
 
 type operationCode int
@@ -45,6 +56,7 @@ const (
 	mutateBatchCode
 	collectValueCode
 	collectHashCode
+	collectShortcutCode
 	getFromPathCode
 	useHashCode
 	noOpCode
@@ -63,7 +75,7 @@ func leafHash(pos position, value []byte) *operation {
 		Code: leafHashCode,
 		Pos:  pos,
 		Interpret: func(ops *operationsStack, c *pruningContext) hashing.Digest {
-			return c.Hasher.Salted(pos.Bytes(), value)
+			return c.Format.Leaf(c.Hasher, pos.Bytes(), value)
 		},
 	}
 }
@@ -75,7 +87,7 @@ func innerHash(pos position) *operation {
 		Interpret: func(ops *operationsStack, c *pruningContext) hashing.Digest {
 			leftHash := ops.Pop().Interpret(ops, c)
 			rightHash := ops.Pop().Interpret(ops, c)
-			return c.Hasher.Salted(pos.Bytes(), leftHash, rightHash)
+			return c.Format.Interior(c.Hasher, pos.Bytes(), leftHash, rightHash)
 		},
 	}
 }
@@ -130,7 +142,11 @@ func putInCache(pos position, batch *batchNode) *operation {
 		Pos:  pos,
 		Interpret: func(ops *operationsStack, c *pruningContext) hashing.Digest {
 			hash := ops.Pop().Interpret(ops, c)
-			c.Cache.Put(pos.Bytes(), batch.Serialize())
+			serialized := batch.Serialize()
+			c.Cache.Put(pos.Bytes(), serialized)
+			if c.Version != nil {
+				c.Mutations = append(c.Mutations, storage.NewMutation(storage.HyperVersionsTable, versionedKey(pos, c.Version), serialized))
+			}
 			return hash
 		},
 	}
@@ -142,7 +158,11 @@ func mutateBatch(pos position, batch *batchNode) *operation {
 		Pos:  pos,
 		Interpret: func(ops *operationsStack, c *pruningContext) hashing.Digest {
 			hash := ops.Pop().Interpret(ops, c)
-			c.Mutations = append(c.Mutations, storage.NewMutation(storage.HyperTable, pos.Bytes(), batch.Serialize()))
+			serialized := batch.Serialize()
+			c.Mutations = append(c.Mutations, storage.NewMutation(storage.HyperTable, pos.Bytes(), serialized))
+			if c.Version != nil {
+				c.Mutations = append(c.Mutations, storage.NewMutation(storage.HyperVersionsTable, versionedKey(pos, c.Version), serialized))
+			}
 			return hash
 		},
 	}
@@ -172,14 +192,27 @@ func collectHash(pos position) *operation {
 	}
 }
 
+func collectShortcut(pos position, key, value []byte) *operation {
+	return &operation{
+		Code: collectShortcutCode,
+		Pos:  pos,
+		Interpret: func(ops *operationsStack, c *pruningContext) hashing.Digest {
+			hash := ops.Pop().Interpret(ops, c)
+			c.ShortcutKey = key
+			c.ShortcutValue = value
+			return hash
+		},
+	}
+}
+
 func getFromPath(pos position) *operation {
 	return &operation{
 		Code: getFromPathCode,
 		Pos:  pos,
 		Interpret: func(ops *operationsStack, c *pruningContext) hashing.Digest {
 			hash, ok := c.AuditPath.Get(pos)
-			if !ok {
-				log.Fatalf("Oops, something went wrong. Invalid position in audit path")
+			if !ok && c.Err == nil {
+				c.Err = fmt.Errorf("invalid audit path: no hash at position %v", pos)
 			}
 			return hash
 		},
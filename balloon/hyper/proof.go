@@ -18,6 +18,7 @@ package hyper
 
 import (
 	"bytes"
+	"fmt"
 
 	"github.com/bbva/qed/hashing"
 	"github.com/bbva/qed/log"
@@ -34,24 +35,37 @@ func NewAuditPath() AuditPath {
 	return make(AuditPath, 0)
 }
 
+// QueryProof proves the membership of a key, or its non-membership if it
+// has no value. A non-membership path ends either at an empty subtree or
+// at the shortcut leaf of another key, whose key and value are carried by
+// the proof so the verifier can hash the leaf itself.
 type QueryProof struct {
-	AuditPath  AuditPath
-	Key, Value []byte
-	hasher     hashing.Hasher
+	AuditPath                  AuditPath
+	Key, Value                 []byte
+	ShortcutKey, ShortcutValue []byte
+	hasher                     hashing.Hasher
+	format                     hashing.FormatVersion
 }
 
-func NewQueryProof(key, value []byte, auditPath AuditPath, hasher hashing.Hasher) *QueryProof {
+func NewQueryProof(key, value []byte, auditPath AuditPath, hasher hashing.Hasher, format hashing.FormatVersion) *QueryProof {
 	return &QueryProof{
 		Key:       key,
 		Value:     value,
 		AuditPath: auditPath,
 		hasher:    hasher,
+		format:    format,
 	}
 }
 
 // Verify verifies a membership query for a provided key from an expected
-// root hash that fixes the hyper tree. Returns true if the proof is valid,
+// root hash that fixes the hyper tree. If the proof has no value, it is
+// verified as a proof of non-membership. Returns true if the proof is valid,
 // false otherwise.
+//
+// Shortcut leaves hash the value but not the key, so a non-membership
+// proof ending at the leaf of another key is only sound if that key is
+// also shown to be the event logged at the version of the leaf, which
+// balloon.MembershipProof checks against the history tree.
 func (p QueryProof) Verify(key []byte, expectedRootHash hashing.Digest) (valid bool) {
 
 	log.Debugf("Verifying query proof for key %x", p.Key)
@@ -61,14 +75,56 @@ func (p QueryProof) Verify(key []byte, expectedRootHash hashing.Digest) (valid b
 		return false
 	}
 
+	recomputed, err := p.rootHash(key)
+	if err != nil {
+		log.Debugf("Unable to verify query proof: %v", err)
+		return false
+	}
+
+	return bytes.Equal(key, p.Key) && bytes.Equal(recomputed, expectedRootHash)
+
+}
+
+// RootHash recomputes the root hash of the hyper tree that the proof
+// was generated from. It returns nil if the audit path is empty or
+// malformed.
+func (p QueryProof) RootHash() hashing.Digest {
+	if len(p.AuditPath) == 0 {
+		return nil
+	}
+	hash, err := p.rootHash(p.Key)
+	if err != nil {
+		return nil
+	}
+	return hash
+}
+
+func (p QueryProof) rootHash(key []byte) (hashing.Digest, error) {
+
+	if len(p.AuditPath) > int(p.hasher.Len()) {
+		return nil, fmt.Errorf("invalid audit path: %d hashes for a tree of height %d", len(p.AuditPath), p.hasher.Len())
+	}
+
 	// build a stack of operations and then interpret it to recompute the root hash
-	ops := pruneToVerify(key, p.Value, p.hasher.Len()-uint16(len(p.AuditPath)))
+	var ops *operationsStack
 	ctx := &pruningContext{
 		Hasher:    p.hasher,
+		Format:    p.format,
 		AuditPath: p.AuditPath,
 	}
-	recomputed := ops.Pop().Interpret(ops, ctx)
-
-	return bytes.Equal(key, p.Key) && bytes.Equal(recomputed, expectedRootHash)
-
+	if len(p.Value) == 0 {
+		var ok bool
+		ops, ok = pruneToVerifyNonMembership(key, p.ShortcutKey, p.ShortcutValue, p.hasher.Len()-uint16(len(p.AuditPath)))
+		if !ok {
+			return nil, fmt.Errorf("invalid shortcut leaf for key %x", key)
+		}
+		ctx.DefaultHashes = computeDefaultHashes(p.hasher)
+	} else {
+		ops = pruneToVerify(key, p.Value, p.hasher.Len()-uint16(len(p.AuditPath)))
+	}
+	hash := ops.Pop().Interpret(ops, ctx)
+	if ctx.Err != nil {
+		return nil, ctx.Err
+	}
+	return hash, nil
 }
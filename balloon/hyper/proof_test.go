@@ -26,10 +26,11 @@ import (
 func TestProofVerify(t *testing.T) {
 
 	testCases := []struct {
-		key, value   []byte
-		auditPath    AuditPath
-		rootHash     hashing.Digest
-		verifyResult bool
+		key, value                 []byte
+		shortcutKey, shortcutValue []byte
+		auditPath                  AuditPath
+		rootHash                   hashing.Digest
+		verifyResult               bool
 	}{
 		{
 			// verify key=0 with empty audit path
@@ -65,10 +66,92 @@ func TestProofVerify(t *testing.T) {
 			rootHash:     hashing.Digest{0x1},
 			verifyResult: false,
 		},
+		{
+			// verify non-membership of key=1 ending at the shortcut leaf of key=0
+			key:           []byte{1},
+			value:         nil,
+			shortcutKey:   []byte{0},
+			shortcutValue: []byte{1},
+			auditPath: AuditPath{
+				"0x80|7": hashing.Digest{0x0},
+				"0x40|6": hashing.Digest{0x0},
+				"0x20|5": hashing.Digest{0x0},
+				"0x10|4": hashing.Digest{0x0},
+			},
+			rootHash:     hashing.Digest{0x1},
+			verifyResult: true,
+		},
+		{
+			// the shortcut leaf ending the path cannot be the one of the key
+			key:           []byte{1},
+			value:         nil,
+			shortcutKey:   []byte{1},
+			shortcutValue: []byte{1},
+			auditPath: AuditPath{
+				"0x80|7": hashing.Digest{0x0},
+				"0x40|6": hashing.Digest{0x0},
+				"0x20|5": hashing.Digest{0x0},
+				"0x10|4": hashing.Digest{0x0},
+			},
+			rootHash:     hashing.Digest{0x1},
+			verifyResult: false,
+		},
+		{
+			// verify non-membership of key=128 ending at an empty subtree
+			key:   []byte{128},
+			value: nil,
+			auditPath: AuditPath{
+				"0x00|7": hashing.Digest{0x1},
+			},
+			rootHash:     hashing.Digest{0x1},
+			verifyResult: true,
+		},
+		{
+			// verify non-membership of key=128 with a wrong root hash
+			key:   []byte{128},
+			value: nil,
+			auditPath: AuditPath{
+				"0x00|7": hashing.Digest{0x1},
+			},
+			rootHash:     hashing.Digest{0x0},
+			verifyResult: false,
+		},
+		{
+			// verify key=0 with an audit path missing a sibling
+			key:   []byte{0},
+			value: []byte{0},
+			auditPath: AuditPath{
+				"0x80|7": hashing.Digest{0x0},
+				"0x40|6": hashing.Digest{0x0},
+				"0x20|5": hashing.Digest{0x0},
+				"0x01|0": hashing.Digest{0x0},
+			},
+			rootHash:     hashing.Digest{0x0},
+			verifyResult: false,
+		},
+		{
+			// verify key=0 with more hashes than the height of the tree
+			key:   []byte{0},
+			value: []byte{0},
+			auditPath: AuditPath{
+				"0x80|7": hashing.Digest{0x0},
+				"0x40|6": hashing.Digest{0x0},
+				"0x20|5": hashing.Digest{0x0},
+				"0x10|4": hashing.Digest{0x0},
+				"0x08|3": hashing.Digest{0x0},
+				"0x04|2": hashing.Digest{0x0},
+				"0x02|1": hashing.Digest{0x0},
+				"0x01|0": hashing.Digest{0x0},
+				"0x00|0": hashing.Digest{0x0},
+			},
+			rootHash:     hashing.Digest{0x0},
+			verifyResult: false,
+		},
 	}
 
 	for i, c := range testCases {
-		proof := NewQueryProof(c.key, c.value, c.auditPath, hashing.NewFakeXorHasher())
+		proof := NewQueryProof(c.key, c.value, c.auditPath, hashing.NewFakeXorHasher(), hashing.FormatV0)
+		proof.ShortcutKey, proof.ShortcutValue = c.shortcutKey, c.shortcutValue
 		correct := proof.Verify(c.key, c.rootHash)
 		assert.Equalf(t, c.verifyResult, correct, "The verification result should match for test case %d", i)
 	}
@@ -18,6 +18,12 @@ package hyper
 
 import (
 	"bytes"
+	"fmt"
+
+	"github.com/bbva/qed/balloon/cache"
+	"github.com/bbva/qed/hashing"
+	"github.com/bbva/qed/storage"
+	"github.com/bbva/qed/util"
 )
 
 func pruneToRebuild(index, serializedBatch []byte, cacheHeightLimit uint16, batches batchLoader) *operationsStack {
@@ -77,3 +83,160 @@ func pruneToRebuild(index, serializedBatch []byte, cacheHeightLimit uint16, batc
 	return ops
 
 }
+
+// RebuildRootHash walks every batch stored below the cache height and
+// checks each of its nodes against its children: the inner nodes against
+// the nodes below them, the shortcut leaves against their values, and the
+// leaves of the batch against the roots of the batches below. It returns
+// the root hash recomputed from the checked batches, so altered nodes
+// below the top ones are detected. The copies kept to query past versions
+// are not checked.
+func (t *HyperTree) RebuildRootHash() (hashing.Digest, error) {
+	t.RLock()
+	defer t.RUnlock()
+
+	nodeSize := int(t.hasher.Len() / 8)
+	hasher := t.hasherF()
+
+	// the positions of the batches below the checked ones
+	referenced := make(map[string]struct{})
+
+	var checkBatch func(pos position, batch *batchNode, iBatch int8) error
+	checkBatch = func(pos position, batch *batchNode, iBatch int8) error {
+
+		if !batch.HasElementAt(iBatch) {
+			return nil
+		}
+		hash := batch.GetElementAt(iBatch)
+		flag := batch.batch[iBatch][nodeSize]
+
+		// at the end of the batch tree, the root of the next batch
+		if iBatch >= 15 {
+			if flag == 1 {
+				return fmt.Errorf("unexpected leaf at position %v", pos)
+			}
+			kv, err := t.store.Get(storage.HyperTable, pos.Bytes())
+			if err == storage.ErrKeyNotFound && flag == 2 {
+				// the key or value of a shortcut pushed down is left
+				// behind, and used as the hash of an empty subtree
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("missing batch at position %v: %v", pos, err)
+			}
+			if err := checkSerializedBatch(nodeSize, kv.Value); err != nil {
+				return fmt.Errorf("invalid batch at position %v: %v", pos, err)
+			}
+			next := parseBatchNode(nodeSize, kv.Value)
+			if !next.HasElementAt(0) || !bytes.Equal(next.GetElementAt(0), hash) {
+				return fmt.Errorf("the node at position %v does not match the batch below", pos)
+			}
+			referenced[string(pos.Bytes())] = struct{}{}
+			return nil
+		}
+
+		// a shortcut leaf under its own position
+		if flag == 1 {
+			if !batch.HasElementAt(2*iBatch+1) || !batch.HasElementAt(2*iBatch+2) {
+				return fmt.Errorf("missing shortcut at position %v", pos)
+			}
+			key, value := batch.GetLeafKVAt(iBatch)
+			if !covers(pos, key) {
+				return fmt.Errorf("the shortcut of %x is out of position %v", key, pos)
+			}
+			if !bytes.Equal(t.format.Leaf(hasher, pos.Bytes(), value), hash) {
+				return fmt.Errorf("the shortcut at position %v does not match its value", pos)
+			}
+			return nil
+		}
+		if flag == 2 && !batch.HasElementAt(2*iBatch+1) && !batch.HasElementAt(2*iBatch+2) {
+			// left behind by a shortcut pushed down, as above
+			return nil
+		}
+		if flag > 2 {
+			return fmt.Errorf("unexpected node at position %v", pos)
+		}
+
+		children := make([][]byte, 2)
+		for i, child := range []position{pos.Left(), pos.Right()} {
+			iChild := 2*iBatch + 1 + int8(i)
+			if err := checkBatch(child, batch, iChild); err != nil {
+				return err
+			}
+			if batch.HasElementAt(iChild) {
+				children[i] = batch.GetElementAt(iChild)
+			} else {
+				children[i] = t.defaultHashes[child.Height]
+			}
+		}
+		// the operations pop the right child first, so it is hashed first
+		if !bytes.Equal(t.format.Interior(hasher, pos.Bytes(), children[1], children[0]), hash) {
+			return fmt.Errorf("the node at position %v does not match its children", pos)
+		}
+		return nil
+	}
+
+	err := storage.ForEach(t.store, storage.HyperTable, func(kv *storage.KVPair) error {
+		if len(kv.Key) != 2+nodeSize {
+			return fmt.Errorf("invalid batch key %x", kv.Key)
+		}
+		pos := newPosition(kv.Key[2:], util.BytesAsUint16(kv.Key[:2]))
+		if pos.Height > t.cacheHeightLimit || (t.cacheHeightLimit-pos.Height)%4 != 0 {
+			return fmt.Errorf("unexpected batch at position %v", pos)
+		}
+		if err := checkSerializedBatch(nodeSize, kv.Value); err != nil {
+			return fmt.Errorf("invalid batch at position %v: %v", pos, err)
+		}
+		return checkBatch(pos, parseBatchNode(nodeSize, kv.Value), 0)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// every batch below the cache height hangs from the batch above it
+	err = storage.ForEach(t.store, storage.HyperTable, func(kv *storage.KVPair) error {
+		if _, ok := referenced[string(kv.Key)]; !ok && util.BytesAsUint16(kv.Key[:2]) != t.cacheHeightLimit {
+			return fmt.Errorf("the batch at %x does not belong to the tree", kv.Key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// the nodes above the cache height are recomputed from the checked
+	// batches
+	rebuilt := NewHyperTree(t.hasherF, t.format, t.store, cache.NewSimpleCache(0))
+	return rebuilt.RootHash(), nil
+}
+
+// covers tells whether the given index is under the position.
+func covers(pos position, index []byte) bool {
+	if len(index) != len(pos.Index) {
+		return false
+	}
+	for bit := 0; bit < int(pos.numBits-pos.Height); bit++ {
+		if bitIsSet(index, bit) != bitIsSet(pos.Index, bit) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkSerializedBatch tells whether a serialized batch holds as many
+// nodes as its bitmap sets, so that it can be parsed.
+func checkSerializedBatch(nodeSize int, value []byte) error {
+	if len(value) < 4 {
+		return fmt.Errorf("missing bitmap")
+	}
+	var nodes int
+	for i := 0; i < 31; i++ {
+		if bitIsSet(value[:4], i) {
+			nodes++
+		}
+	}
+	if len(value) != 4+nodes*(nodeSize+1) {
+		return fmt.Errorf("expected %d nodes", nodes)
+	}
+	return nil
+}
@@ -21,6 +21,10 @@ import (
 
 	"github.com/bbva/qed/balloon/cache"
 	"github.com/bbva/qed/hashing"
+	"github.com/bbva/qed/log"
+	"github.com/bbva/qed/storage"
+	"github.com/bbva/qed/testutils/rand"
+	storage_utils "github.com/bbva/qed/testutils/storage"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -209,3 +213,74 @@ func TestRebuildInterpretation(t *testing.T) {
 		}
 	}
 }
+
+func TestRebuild(t *testing.T) {
+
+	log.SetLogger("TestRebuild", log.SILENT)
+
+	store, closeF := storage_utils.OpenBPlusTreeStore()
+	defer closeF()
+
+	tree := NewHyperTree(hashing.NewSha256Hasher, hashing.CurrentFormat, store, cache.NewSimpleCache(10))
+
+	// keys sharing their first bytes push shortcuts down below the cache
+	newKey := func() hashing.Digest {
+		key := hashing.Digest(rand.Bytes(32))
+		key[0], key[1], key[2] = 0, 0, key[2]%4
+		return key
+	}
+
+	var version uint64
+	var keys []hashing.Digest
+	for i := 0; i < 50; i++ {
+		key := newKey()
+		_, mutations, err := tree.Add(key, version)
+		require.NoError(t, err)
+		require.NoError(t, store.Mutate(mutations))
+		keys = append(keys, key)
+		version++
+	}
+	for i := 0; i < 5; i++ {
+		bulk := make([]hashing.Digest, 20)
+		versions := make([]uint64, len(bulk))
+		for j := range bulk {
+			bulk[j], versions[j] = newKey(), version
+			version++
+		}
+		_, mutations, err := tree.AddBulk(bulk, versions)
+		require.NoError(t, err)
+		require.NoError(t, store.Mutate(mutations))
+	}
+	// a key added again is updated with its last version
+	expected, mutations, err := tree.Add(keys[3], version)
+	require.NoError(t, err)
+	require.NoError(t, store.Mutate(mutations))
+
+	assert.Equal(t, expected, tree.RootHash(), "The root hash should match")
+	rootHash, err := tree.RebuildRootHash()
+	require.NoError(t, err)
+	assert.Equal(t, expected, rootHash, "The rebuilt root hash should match")
+
+	batches, err := store.GetRange(storage.HyperTable, []byte{0x00}, []byte{0xff})
+	require.NoError(t, err)
+	require.NotEmpty(t, batches)
+	// the deepest batch hangs from another one
+	batch := batches[0]
+
+	// an altered batch is detected
+	altered := append([]byte{}, batch.Value...)
+	altered[len(altered)-2] ^= 0xff
+	require.NoError(t, store.Mutate([]*storage.Mutation{storage.NewMutation(storage.HyperTable, batch.Key, altered)}))
+	_, err = tree.RebuildRootHash()
+	require.Error(t, err, "An altered batch should be detected")
+
+	// a batch below the cache height cannot go missing
+	require.NoError(t, store.Mutate([]*storage.Mutation{storage.NewDeletion(storage.HyperTable, batch.Key)}))
+	_, err = tree.RebuildRootHash()
+	require.Error(t, err, "A missing batch should be detected")
+
+	// a truncated batch is rejected
+	require.NoError(t, store.Mutate([]*storage.Mutation{storage.NewMutation(storage.HyperTable, batch.Key, batch.Value[:10])}))
+	_, err = tree.RebuildRootHash()
+	require.Error(t, err, "A truncated batch should be rejected")
+}
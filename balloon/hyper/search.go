@@ -60,10 +60,12 @@ func pruneToFind(index []byte, batches batchLoader) *operationsStack {
 		if batch.HasLeafAt(iBatch) {
 			// regardless if the key of the shortcut matches the searched index
 			// we must stop traversing because there are no more leaves below
-			ops.Push(getProvidedHash(pos, iBatch, batch)) // not collected
+			ops.Push(getProvidedHash(pos, iBatch, batch))
 			k, v := batch.GetLeafKVAt(iBatch)
 			if bytes.Equal(k, index) {
 				ops.Push(collectValue(pos, v)) // collect value if the key matches the queried index
+			} else {
+				ops.Push(collectShortcut(pos, k, v)) // collect the leaf of another key to prove non-membership
 			}
 			return
 		}
@@ -115,6 +115,7 @@ func TestPruneToFind(t *testing.T) {
 				{innerHashCode, pos(0, 5)},
 				{collectHashCode, pos(16, 4)},
 				{getDefaultHashCode, pos(16, 4)},
+				{collectShortcutCode, pos(0, 4)},
 				{getProvidedHashCode, pos(0, 4)}, // stop at the position of the shorcut (index=0)
 			},
 		},
@@ -219,6 +220,7 @@ func TestPruneToFind(t *testing.T) {
 				{innerHashCode, pos(0, 5)},
 				{collectHashCode, pos(16, 4)},
 				{getDefaultHashCode, pos(16, 4)},
+				{collectShortcutCode, pos(0, 4)},
 				{getProvidedHashCode, pos(0, 4)}, // stop at the position of the shorcut (index=0)
 			},
 		},
@@ -321,10 +323,11 @@ func TestPruneToFind(t *testing.T) {
 func TestSearchInterpretation(t *testing.T) {
 
 	testCases := []struct {
-		index             []byte
-		cachedBatches     map[string][]byte
-		storedBatches     map[string][]byte
-		expectedAuditPath AuditPath
+		index               []byte
+		cachedBatches       map[string][]byte
+		storedBatches       map[string][]byte
+		expectedAuditPath   AuditPath
+		expectedShortcutKey []byte
 	}{
 		{
 			// search for index=0 on empty tree
@@ -389,6 +392,7 @@ func TestSearchInterpretation(t *testing.T) {
 				pos(32, 5).StringId():  []byte{0x0},
 				pos(16, 4).StringId():  []byte{0x0},
 			},
+			expectedShortcutKey: []byte{0x0}, // shortcut of another index
 		},
 		{
 			// search for index=1 on tree with 2 leaves ([index=0, value=0], [index=1, value=1])
@@ -466,6 +470,7 @@ func TestSearchInterpretation(t *testing.T) {
 				pos(32, 5).StringId():  []byte{0x0},
 				pos(16, 4).StringId():  []byte{0x0},
 			},
+			expectedShortcutKey: []byte{0x0}, // shortcut of another index
 		},
 		{
 			// search for index=12 on tree with 2 leaves ([index:0, value:0], [index:8, value:8])
@@ -550,6 +555,7 @@ func TestSearchInterpretation(t *testing.T) {
 
 		ops.Pop().Interpret(ops, ctx)
 		assert.Equalf(t, c.expectedAuditPath, ctx.AuditPath, "Audit path error in test case %d", i)
+		assert.Equalf(t, c.expectedShortcutKey, ctx.ShortcutKey, "Shortcut key error in test case %d", i)
 
 	}
 }
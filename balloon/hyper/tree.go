@@ -30,12 +30,17 @@ import (
 const (
 	//CacheSize int = (1118481) * ((31 * 33) + 34) // (2^0+2^4 + 2^8 + 2^12 + 2^16 + 2^20) batches * batchSize (31 nodes * 33 bytes + 34 bytes from key)
 	CacheSize int = (2000000) * ((31 * 33) + 34)
+
+	// backfillBatchSize is the number of batch copies written at once
+	// by BackfillVersions.
+	backfillBatchSize = 1000
 )
 
 type HyperTree struct {
 	store   storage.Store
 	cache   cache.ModifiableCache
 	hasherF func() hashing.Hasher
+	format  hashing.FormatVersion
 
 	hasher           hashing.Hasher
 	cacheHeightLimit uint16
@@ -45,7 +50,7 @@ type HyperTree struct {
 	sync.RWMutex
 }
 
-func NewHyperTree(hasherF func() hashing.Hasher, store storage.Store, cache cache.ModifiableCache) *HyperTree {
+func NewHyperTree(hasherF func() hashing.Hasher, format hashing.FormatVersion, store storage.Store, cache cache.ModifiableCache) *HyperTree {
 
 	hasher := hasherF()
 	numBits := hasher.Len()
@@ -55,17 +60,13 @@ func NewHyperTree(hasherF func() hashing.Hasher, store storage.Store, cache cach
 		store:            store,
 		cache:            cache,
 		hasherF:          hasherF,
+		format:           format,
 		hasher:           hasher,
 		cacheHeightLimit: cacheHeightLimit,
-		defaultHashes:    make([]hashing.Digest, numBits),
+		defaultHashes:    computeDefaultHashes(hasher),
 		batchLoader:      NewDefaultBatchLoader(store, cache, cacheHeightLimit),
 	}
 
-	tree.defaultHashes[0] = tree.hasher.Do([]byte{0x0}, []byte{0x0})
-	for i := uint16(1); i < hasher.Len(); i++ {
-		tree.defaultHashes[i] = tree.hasher.Do(tree.defaultHashes[i-1], tree.defaultHashes[i-1])
-	}
-
 	// warm-up cache
 	tree.RebuildCache()
 
@@ -84,9 +85,11 @@ func (t *HyperTree) Add(eventDigest hashing.Digest, version uint64) (hashing.Dig
 	ops := pruneToInsert(eventDigest, versionAsBytes, t.cacheHeightLimit, t.batchLoader)
 	ctx := &pruningContext{
 		Hasher:        t.hasher,
+		Format:        t.format,
 		Cache:         t.cache,
 		DefaultHashes: t.defaultHashes,
 		Mutations:     make([]*storage.Mutation, 0),
+		Version:       versionAsBytes,
 	}
 
 	rh := ops.Pop().Interpret(ops, ctx)
@@ -109,10 +112,16 @@ func (t *HyperTree) AddBulk(eventDigests []hashing.Digest, versions []uint64) (h
 	ops := pruneToInsertBulk(digestsAsBytes, versionsAsBytes, t.cacheHeightLimit, t.batchLoader)
 	ctx := &pruningContext{
 		Hasher:        t.hasher,
+		Format:        t.format,
 		Cache:         t.cache,
 		DefaultHashes: t.defaultHashes,
 		Mutations:     make([]*storage.Mutation, 0),
 	}
+	if len(versionsAsBytes) > 0 {
+		// every snapshot of the bulk shares the same hyper root,
+		// so the copies are made under the first version of the bulk
+		ctx.Version = versionsAsBytes[0]
+	}
 
 	rh := ops.Pop().Interpret(ops, ctx)
 
@@ -129,6 +138,7 @@ func (t *HyperTree) QueryMembership(eventDigest hashing.Digest) (proof *QueryPro
 	ops := pruneToFind(eventDigest, t.batchLoader)
 	ctx := &pruningContext{
 		Hasher:        t.hasher,
+		Format:        t.format,
 		Cache:         t.cache,
 		DefaultHashes: t.defaultHashes,
 		AuditPath:     make(AuditPath, 0),
@@ -137,7 +147,173 @@ func (t *HyperTree) QueryMembership(eventDigest hashing.Digest) (proof *QueryPro
 	ops.Pop().Interpret(ops, ctx)
 
 	// ctx.Value is nil if the digest does not exist
-	return NewQueryProof(eventDigest, ctx.Value, ctx.AuditPath, t.hasherF()), nil
+	proof = NewQueryProof(eventDigest, ctx.Value, ctx.AuditPath, t.hasherF(), t.format)
+	proof.ShortcutKey, proof.ShortcutValue = ctx.ShortcutKey, ctx.ShortcutValue
+	return proof, nil
+}
+
+// QueryMembershipAt builds a membership proof for the given event digest
+// against the root of the tree as it was at the given version. If the
+// digest was not present at that version, the proof shows non-membership.
+//
+// The tree is rebuilt from the copies of its batches, so the versions
+// added before the copies were recorded return ErrUnversioned. Logs written
+// before then are back-filled with BackfillVersions, which makes them
+// answer from the version it is given on.
+func (t *HyperTree) QueryMembershipAt(eventDigest hashing.Digest, version uint64) (proof *QueryProof, err error) {
+	t.RLock()
+	defer t.RUnlock()
+
+	if err := t.checkVersioned(version); err != nil {
+		return nil, err
+	}
+
+	// build a stack of operations and then interpret it to generate the audit path
+	ops := pruneToFind(eventDigest, NewVersionedBatchLoader(t.store, version))
+	ctx := &pruningContext{
+		Hasher:        t.hasher,
+		Format:        t.format,
+		DefaultHashes: t.defaultHashes,
+		AuditPath:     make(AuditPath, 0),
+	}
+
+	ops.Pop().Interpret(ops, ctx)
+
+	// ctx.Value is nil if the digest does not exist at that version
+	proof = NewQueryProof(eventDigest, ctx.Value, ctx.AuditPath, t.hasherF(), t.format)
+	proof.ShortcutKey, proof.ShortcutValue = ctx.ShortcutKey, ctx.ShortcutValue
+	return proof, nil
+}
+
+// RootHash returns the root hash of the tree, kept in the root batch.
+// It is nil if the tree is empty.
+func (t *HyperTree) RootHash() hashing.Digest {
+	t.RLock()
+	defer t.RUnlock()
+	return t.rootHash(t.batchLoader)
+}
+
+// RootHashAt returns the root hash of the tree as it was at the given
+// version. It is nil if the tree was empty. Like QueryMembershipAt, it
+// returns ErrUnversioned for the versions added before the copies of the
+// batches were recorded.
+func (t *HyperTree) RootHashAt(version uint64) (hashing.Digest, error) {
+	t.RLock()
+	defer t.RUnlock()
+	if err := t.checkVersioned(version); err != nil {
+		return nil, err
+	}
+	return t.rootHash(NewVersionedBatchLoader(t.store, version)), nil
+}
+
+// checkVersioned returns ErrUnversioned if the tree cannot be rebuilt as
+// it was at the given version. Empty trees have no copies to miss.
+func (t *HyperTree) checkVersioned(version uint64) error {
+	versioned, err := t.isVersioned(version)
+	if err != nil || versioned {
+		return err
+	}
+	if _, ok := t.cache.Get(newRootPosition(t.hasher.Len() / 8).Bytes()); !ok {
+		return nil
+	}
+	return ErrUnversioned
+}
+
+// BackfillVersions records a copy of every batch of the tree at the given
+// version, which must be the last one, if the tree has no copies at it.
+// Logs written before the copies were recorded are migrated this way, so
+// that they answer queries at that version and the later ones. The
+// versions before it cannot be recovered and keep returning
+// ErrUnversioned.
+func (t *HyperTree) BackfillVersions(version uint64) error {
+	t.Lock()
+	defer t.Unlock()
+
+	if versioned, err := t.isVersioned(version); err != nil || versioned {
+		return err
+	}
+	nodeSize := int(t.hasher.Len() / 8)
+	root := newRootPosition(uint16(nodeSize))
+	rootBatch, ok := t.cache.Get(root.Bytes())
+	if !ok {
+		// the tree is empty
+		return nil
+	}
+	log.Infof("Recording a copy of every hyper tree batch at version %d...", version)
+
+	versionAsBytes := util.Uint64AsBytes(version)
+	mutations := make([]*storage.Mutation, 0, backfillBatchSize)
+	var copies int
+	backfill := func(pos position, value []byte) error {
+		mutations = append(mutations, storage.NewMutation(storage.HyperVersionsTable, versionedKey(pos, versionAsBytes), value))
+		copies++
+		if len(mutations) < backfillBatchSize {
+			return nil
+		}
+		err := t.store.Mutate(mutations)
+		mutations = mutations[:0]
+		return err
+	}
+
+	// the batches above the cache height hang from the leaves of the
+	// batches above them, down from the root
+	var walk func(pos position, batch *batchNode, iBatch int8) error
+	walk = func(pos position, batch *batchNode, iBatch int8) error {
+		if !batch.HasElementAt(iBatch) {
+			return nil
+		}
+		if iBatch >= 15 {
+			if pos.Height <= t.cacheHeightLimit {
+				return nil
+			}
+			value, ok := t.cache.Get(pos.Bytes())
+			if !ok {
+				// the key or value of a shortcut pushed down
+				return nil
+			}
+			if err := backfill(pos, value); err != nil {
+				return err
+			}
+			return walk(pos, parseBatchNode(nodeSize, value), 0)
+		}
+		if batch.batch[iBatch][nodeSize] == 1 {
+			// a shortcut leaf, whose children are its key and value
+			return nil
+		}
+		if err := walk(pos.Left(), batch, 2*iBatch+1); err != nil {
+			return err
+		}
+		return walk(pos.Right(), batch, 2*iBatch+2)
+	}
+	if err := backfill(root, rootBatch); err != nil {
+		return err
+	}
+	if err := walk(root, parseBatchNode(nodeSize, rootBatch), 0); err != nil {
+		return err
+	}
+
+	// and the batches below it are stored
+	err := storage.ForEach(t.store, storage.HyperTable, func(kv *storage.KVPair) error {
+		return backfill(newPosition(kv.Key[2:], util.BytesAsUint16(kv.Key[:2])), kv.Value)
+	})
+	if err != nil {
+		return err
+	}
+	if len(mutations) > 0 {
+		if err := t.store.Mutate(mutations); err != nil {
+			return err
+		}
+	}
+	log.Infof("Recorded %d hyper tree batches at version %d", copies, version)
+	return nil
+}
+
+func (t *HyperTree) rootHash(batches batchLoader) hashing.Digest {
+	root := batches.Load(newRootPosition(t.hasher.Len() / 8))
+	if !root.HasElementAt(0) {
+		return nil
+	}
+	return root.GetElementAt(0)
 }
 
 func (t *HyperTree) RebuildCache() {
@@ -162,6 +338,7 @@ func (t *HyperTree) RebuildCache() {
 		ops := pruneToRebuild(node.Key[2:], node.Value, t.cacheHeightLimit, t.batchLoader)
 		ctx := &pruningContext{
 			Hasher:        t.hasher,
+			Format:        t.format,
 			Cache:         t.cache,
 			DefaultHashes: t.defaultHashes,
 		}
@@ -180,6 +357,15 @@ func (t *HyperTree) Close() {
 	t.batchLoader = nil
 }
 
+func computeDefaultHashes(hasher hashing.Hasher) []hashing.Digest {
+	defaultHashes := make([]hashing.Digest, hasher.Len())
+	defaultHashes[0] = hasher.Do([]byte{0x0}, []byte{0x0})
+	for i := uint16(1); i < hasher.Len(); i++ {
+		defaultHashes[i] = hasher.Do(defaultHashes[i-1], defaultHashes[i-1])
+	}
+	return defaultHashes
+}
+
 func min(x, y uint16) uint16 {
 	if x < y {
 		return x
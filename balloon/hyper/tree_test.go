@@ -55,7 +55,7 @@ func TestAdd(t *testing.T) {
 	store, closeF := storage_utils.OpenBPlusTreeStore()
 	defer closeF()
 
-	tree := NewHyperTree(hashing.NewFakeXorHasher, store, cache.NewSimpleCache(10))
+	tree := NewHyperTree(hashing.NewFakeXorHasher, hashing.FormatV0, store, cache.NewSimpleCache(10))
 
 	for i, c := range testCases {
 		version := uint64(i)
@@ -97,7 +97,7 @@ func TestAddBulk(t *testing.T) {
 	store, closeF := storage_utils.OpenBPlusTreeStore()
 	defer closeF()
 
-	tree := NewHyperTree(hashing.NewFakeXorHasher, store, cache.NewSimpleCache(10))
+	tree := NewHyperTree(hashing.NewFakeXorHasher, hashing.FormatV0, store, cache.NewSimpleCache(10))
 
 	for i, c := range testCases {
 		rootHash, mutations, err := tree.AddBulk(c.eventDigests, c.versions)
@@ -138,12 +138,12 @@ func TestConsistencyBetweenAddAndAddBulk(t *testing.T) {
 	store, closeF := storage_utils.OpenBPlusTreeStore()
 	defer closeF()
 	addCache := cache.NewSimpleCache(10)
-	addTree := NewHyperTree(hashing.NewFakeXorHasher, store, addCache)
+	addTree := NewHyperTree(hashing.NewFakeXorHasher, hashing.FormatV0, store, addCache)
 
 	store2, closeF2 := storage_utils.OpenBPlusTreeStore()
 	defer closeF2()
 	addBulkCache := cache.NewSimpleCache(10)
-	addBulkTree := NewHyperTree(hashing.NewFakeXorHasher, store2, addBulkCache)
+	addBulkTree := NewHyperTree(hashing.NewFakeXorHasher, hashing.FormatV0, store2, addBulkCache)
 
 	for i, c := range testCases {
 		// Add
@@ -246,7 +246,7 @@ func TestProveMembership(t *testing.T) {
 		store, closeF := storage_utils.OpenBPlusTreeStore()
 		defer closeF()
 		simpleCache := cache.NewSimpleCache(10)
-		tree := NewHyperTree(hashing.NewFakeXorHasher, store, simpleCache)
+		tree := NewHyperTree(hashing.NewFakeXorHasher, hashing.FormatV0, store, simpleCache)
 
 		for index, digest := range c.addedKeys {
 			_, mutations, err := tree.Add(digest, index)
@@ -283,7 +283,7 @@ func TestAddAndVerify(t *testing.T) {
 		store, closeF := storage_utils.OpenBPlusTreeStore()
 		defer closeF()
 		simpleCache := cache.NewSimpleCache(10)
-		tree := NewHyperTree(c.hasherF, store, simpleCache)
+		tree := NewHyperTree(c.hasherF, hashing.CurrentFormat, store, simpleCache)
 
 		key := hasher.Do(hashing.Digest("a test event"))
 		valueBytes := util.Uint64AsPaddedBytes(value, len(key))
@@ -303,6 +303,242 @@ func TestAddAndVerify(t *testing.T) {
 
 }
 
+func TestQueryMembershipAt(t *testing.T) {
+
+	log.SetLogger("TestQueryMembershipAt", log.SILENT)
+
+	store, closeF := storage_utils.OpenBPlusTreeStore()
+	defer closeF()
+	hasherF := hashing.NewSha256Hasher
+	hasher := hasherF()
+
+	tree := NewHyperTree(hasherF, hashing.CurrentFormat, store, cache.NewSimpleCache(10))
+
+	// add some events and keep the root hash of every version
+	numEvents := 50
+	keys := make([]hashing.Digest, numEvents)
+	rootHashes := make([]hashing.Digest, numEvents)
+	for i := 0; i < numEvents; i++ {
+		keys[i] = hasher.Do(rand.Bytes(32))
+		rootHash, mutations, err := tree.Add(keys[i], uint64(i))
+		require.NoErrorf(t, err, "Add operation should not fail for version %d", i)
+		require.NoError(t, store.Mutate(mutations))
+		rootHashes[i] = rootHash
+	}
+
+	for version := 0; version < numEvents; version++ {
+		for i, key := range keys {
+			proof, err := tree.QueryMembershipAt(key, uint64(version))
+			require.NoErrorf(t, err, "The membership query should not fail for key %d at version %d", i, version)
+			if i <= version {
+				assert.NotEmptyf(t, proof.Value, "Key %d should be a member at version %d", i, version)
+			} else {
+				assert.Emptyf(t, proof.Value, "Key %d should not be a member at version %d", i, version)
+			}
+			assert.Truef(t, proof.Verify(key, rootHashes[version]), "The proof for key %d should verify at version %d", i, version)
+		}
+	}
+
+}
+
+func TestNonMembershipWithShortcut(t *testing.T) {
+
+	log.SetLogger("TestNonMembershipWithShortcut", log.SILENT)
+
+	store, closeF := storage_utils.OpenBPlusTreeStore()
+	defer closeF()
+	hasherF := hashing.NewSha256Hasher
+	hasher := hasherF()
+
+	tree := NewHyperTree(hasherF, hashing.CurrentFormat, store, cache.NewSimpleCache(10))
+
+	key := hasher.Do([]byte("present"))
+	rootHash, mutations, err := tree.Add(key, 0)
+	require.NoError(t, err)
+	require.NoError(t, store.Mutate(mutations))
+
+	// a key sharing the whole path of the present one but the last bit
+	// ends at the shortcut leaf of the present key
+	absent := make(hashing.Digest, len(key))
+	copy(absent, key)
+	absent[len(absent)-1] ^= 0x01
+
+	proof, err := tree.QueryMembership(absent)
+	require.NoError(t, err)
+	assert.Empty(t, proof.Value, "The key should not be a member")
+	assert.Equal(t, []byte(key), proof.ShortcutKey, "The proof should carry the shortcut leaf of the present key")
+	assert.True(t, proof.Verify(absent, rootHash), "The non-membership proof should verify")
+
+	// forge a non-membership proof of the present key using its own
+	// leaf as the shortcut found at the end of the path
+	membership, err := tree.QueryMembership(key)
+	require.NoError(t, err)
+	require.True(t, membership.Verify(key, rootHash))
+
+	forged := NewQueryProof(key, nil, membership.AuditPath, hasherF(), hashing.CurrentFormat)
+	forged.ShortcutKey, forged.ShortcutValue = key, membership.Value
+	assert.False(t, forged.Verify(key, rootHash), "The leaf of the key itself should not prove its non-membership")
+
+	// forge it again putting the hash of its leaf at the end of its
+	// membership path instead
+	end := descendTo(key, hasher.Len()-uint16(len(membership.AuditPath)))
+	value := util.AddPaddingToBytes(membership.Value, len(key))
+	forgedPath := NewAuditPath()
+	for k, v := range membership.AuditPath {
+		forgedPath[k] = v
+	}
+	forgedPath[end.StringId()] = hashing.CurrentFormat.Leaf(hasher, end.Bytes(), value[len(value)-len(key):])
+
+	forged = NewQueryProof(key, nil, forgedPath, hasherF(), hashing.CurrentFormat)
+	assert.False(t, forged.Verify(key, rootHash), "A leaf hash in the audit path should not prove non-membership")
+
+}
+
+func TestQueryMembershipAtAfterBulk(t *testing.T) {
+
+	log.SetLogger("TestQueryMembershipAtAfterBulk", log.SILENT)
+
+	store, closeF := storage_utils.OpenBPlusTreeStore()
+	defer closeF()
+	hasherF := hashing.NewSha256Hasher
+	hasher := hasherF()
+
+	tree := NewHyperTree(hasherF, hashing.CurrentFormat, store, cache.NewSimpleCache(10))
+
+	first := hasher.Do([]byte("first"))
+	firstRootHash, mutations, err := tree.Add(first, 0)
+	require.NoError(t, err)
+	require.NoError(t, store.Mutate(mutations))
+
+	// every snapshot of a bulk shares the same root hash
+	bulk := []hashing.Digest{hasher.Do([]byte("second")), hasher.Do([]byte("third"))}
+	bulkRootHash, mutations, err := tree.AddBulk(bulk, []uint64{1, 2})
+	require.NoError(t, err)
+	require.NoError(t, store.Mutate(mutations))
+
+	proof, err := tree.QueryMembershipAt(bulk[1], 0)
+	require.NoError(t, err)
+	assert.Empty(t, proof.Value, "The key should not be a member before the bulk")
+	assert.True(t, proof.Verify(bulk[1], firstRootHash), "The non-membership proof should verify before the bulk")
+
+	for _, version := range []uint64{1, 2} {
+		proof, err := tree.QueryMembershipAt(bulk[1], version)
+		require.NoError(t, err)
+		assert.NotEmptyf(t, proof.Value, "The key should be a member at version %d", version)
+		assert.Truef(t, proof.Verify(bulk[1], bulkRootHash), "The proof should verify at version %d", version)
+	}
+
+}
+
+func TestBackfillVersions(t *testing.T) {
+
+	log.SetLogger("TestBackfillVersions", log.SILENT)
+
+	store, closeF := storage_utils.OpenBPlusTreeStore()
+	defer closeF()
+	hasherF := hashing.NewSha256Hasher
+	hasher := hasherF()
+
+	tree := NewHyperTree(hasherF, hashing.CurrentFormat, store, cache.NewSimpleCache(10))
+
+	// a log written before the copies of the batches were recorded, with
+	// some keys close enough to be stored below the cache height
+	numEvents := 200
+	keys := make([]hashing.Digest, numEvents+10)
+	rootHashes := make([]hashing.Digest, numEvents+10)
+	for i := range keys {
+		keys[i] = hasher.Do(rand.Bytes(32))
+		if i%2 == 0 {
+			keys[i][0], keys[i][1], keys[i][2] = 0, 0, byte(i%4)
+		}
+	}
+	for i := 0; i < numEvents; i++ {
+		rootHash, mutations, err := tree.Add(keys[i], uint64(i))
+		require.NoError(t, err)
+		var unversioned []*storage.Mutation
+		for _, m := range mutations {
+			if m.Table != storage.HyperVersionsTable {
+				unversioned = append(unversioned, m)
+			}
+		}
+		require.NoError(t, store.Mutate(unversioned))
+		rootHashes[i] = rootHash
+	}
+
+	_, err := tree.QueryMembershipAt(keys[0], 10)
+	require.Equal(t, ErrUnversioned, err, "Unversioned versions should not be queried")
+	_, err = tree.RootHashAt(10)
+	require.Equal(t, ErrUnversioned, err)
+
+	last := uint64(numEvents - 1)
+	require.NoError(t, tree.BackfillVersions(last))
+	copies, err := store.GetRange(storage.HyperVersionsTable, make([]byte, 2), []byte{0xff, 0xff})
+	require.NoError(t, err)
+	require.NoError(t, tree.BackfillVersions(last))
+	again, err := store.GetRange(storage.HyperVersionsTable, make([]byte, 2), []byte{0xff, 0xff})
+	require.NoError(t, err)
+	require.Equal(t, len(copies), len(again), "Back-filling a versioned tree should do nothing")
+
+	// the versions from the back-filled one on are answered
+	for i := numEvents; i < len(keys); i++ {
+		rootHash, mutations, err := tree.Add(keys[i], uint64(i))
+		require.NoError(t, err)
+		require.NoError(t, store.Mutate(mutations))
+		rootHashes[i] = rootHash
+	}
+	for _, version := range []uint64{last, last + 5} {
+		rootHash, err := tree.RootHashAt(version)
+		require.NoError(t, err)
+		require.Equalf(t, rootHashes[version], rootHash, "The root hash should match at version %d", version)
+		for i, key := range keys {
+			proof, err := tree.QueryMembershipAt(key, version)
+			require.NoError(t, err)
+			assert.Equalf(t, uint64(i) <= version, len(proof.Value) > 0, "Wrong membership of key %d at version %d", i, version)
+			assert.Truef(t, proof.Verify(key, rootHashes[version]), "The proof for key %d should verify at version %d", i, version)
+		}
+	}
+	_, err = tree.QueryMembershipAt(keys[0], last-1)
+	require.Equal(t, ErrUnversioned, err, "The versions before the back-filled one cannot be recovered")
+}
+
+func TestQueryMembershipWithFormats(t *testing.T) {
+
+	log.SetLogger("TestQueryMembershipWithFormats", log.SILENT)
+
+	hasher := hashing.NewSha256Hasher()
+	keys := make([]hashing.Digest, 10)
+	for i := range keys {
+		keys[i] = hasher.Do(rand.Bytes(32))
+	}
+
+	rootHashes := make(map[hashing.FormatVersion]hashing.Digest)
+	for _, format := range []hashing.FormatVersion{hashing.FormatV0, hashing.FormatV1} {
+
+		store, closeF := storage_utils.OpenBPlusTreeStore()
+		defer closeF()
+		tree := NewHyperTree(hashing.NewSha256Hasher, format, store, cache.NewSimpleCache(10))
+
+		for i, key := range keys {
+			rootHash, mutations, err := tree.Add(key, uint64(i))
+			require.NoError(t, err)
+			require.NoError(t, store.Mutate(mutations))
+			rootHashes[format] = rootHash
+		}
+
+		for i, key := range keys {
+			proof, err := tree.QueryMembership(key)
+			require.NoError(t, err)
+			assert.Truef(t, proof.Verify(key, rootHashes[format]), "The proof for key %d should verify with format %d", i, format)
+
+			// proofs are bound to the format of the tree
+			other := NewQueryProof(proof.Key, proof.Value, proof.AuditPath, hashing.NewSha256Hasher(), hashing.FormatV1-format)
+			assert.Falsef(t, other.Verify(key, rootHashes[format]), "The proof for key %d should not verify with another format", i)
+		}
+	}
+
+	assert.NotEqual(t, rootHashes[hashing.FormatV0], rootHashes[hashing.FormatV1], "Formats should produce different root hashes")
+}
+
 func TestDeterministicAdd(t *testing.T) {
 
 	log.SetLogger("TestDeterministicAdd", log.SILENT)
@@ -316,8 +552,8 @@ func TestDeterministicAdd(t *testing.T) {
 	store2, closeF2 := storage_utils.OpenBPlusTreeStore()
 	defer closeF1()
 	defer closeF2()
-	tree1 := NewHyperTree(hashing.NewSha256Hasher, store1, cache1)
-	tree2 := NewHyperTree(hashing.NewSha256Hasher, store2, cache2)
+	tree1 := NewHyperTree(hashing.NewSha256Hasher, hashing.CurrentFormat, store1, cache1)
+	tree2 := NewHyperTree(hashing.NewSha256Hasher, hashing.CurrentFormat, store2, cache2)
 
 	// insert a bunch of events in both trees
 	for i := 0; i < 100; i++ {
@@ -370,7 +606,7 @@ func TestRebuildCache(t *testing.T) {
 	hasher := hasherF()
 
 	firstCache := cache.NewSimpleCache(10)
-	tree := NewHyperTree(hasherF, store, firstCache)
+	tree := NewHyperTree(hasherF, hashing.CurrentFormat, store, firstCache)
 	require.True(t, firstCache.Size() == 0, "The cache should be empty")
 
 	// store multiple elements
@@ -384,7 +620,7 @@ func TestRebuildCache(t *testing.T) {
 	// Close tree and reopen with a new fresh cache
 	tree.Close()
 	secondCache := cache.NewSimpleCache(10)
-	tree = NewHyperTree(hasherF, store, secondCache)
+	tree = NewHyperTree(hasherF, hashing.CurrentFormat, store, secondCache)
 
 	require.Equal(t, expectedSize, secondCache.Size(), "The size of the caches should match")
 	require.True(t, firstCache.Equal(secondCache), "The caches should be equal")
@@ -399,7 +635,7 @@ func BenchmarkAdd(b *testing.B) {
 
 	hasher := hashing.NewSha256Hasher()
 	freeCache := cache.NewFreeCache(CacheSize)
-	tree := NewHyperTree(hashing.NewSha256Hasher, store, freeCache)
+	tree := NewHyperTree(hashing.NewSha256Hasher, hashing.CurrentFormat, store, freeCache)
 
 	hyperMetrics := metrics_utils.CustomRegister(AddTotal)
 	srvCloseF := metrics_utils.StartMetricsServer(hyperMetrics) //, store)
@@ -428,7 +664,7 @@ func BenchmarkAddBulk(b *testing.B) {
 
 	hasher := hashing.NewSha256Hasher()
 	freeCache := cache.NewFreeCache(CacheSize)
-	tree := NewHyperTree(hashing.NewSha256Hasher, store, freeCache)
+	tree := NewHyperTree(hashing.NewSha256Hasher, hashing.CurrentFormat, store, freeCache)
 
 	hyperMetrics := metrics_utils.CustomRegister(AddTotal)
 	srvCloseF := metrics_utils.StartMetricsServer(hyperMetrics) //, store)
@@ -54,3 +54,73 @@ func pruneToVerify(index, value []byte, auditPathHeight uint16) *operationsStack
 	return ops
 
 }
+
+// pruneToVerifyNonMembership builds the stack of operations needed to
+// recompute the root hash from the audit path of an index that is not
+// present in the tree. The path ends at the given height either at an
+// empty subtree, whose hash is the default one for its height, or at the
+// shortcut leaf of another key, which is hashed from its value. It returns
+// false if the shortcut leaf cannot end the path of the index.
+func pruneToVerifyNonMembership(index, shortcutKey, shortcutValue []byte, auditPathHeight uint16) (*operationsStack, bool) {
+
+	var shortcut *operation
+	if shortcutKey != nil {
+		// the leaf must be the only one below the end of the path,
+		// so it cannot be the index itself
+		if len(shortcutKey) != len(index) || bytes.Equal(shortcutKey, index) {
+			return nil, false
+		}
+		end := descendTo(index, auditPathHeight)
+		if !bytes.Equal(descendTo(shortcutKey, auditPathHeight).Bytes(), end.Bytes()) {
+			return nil, false
+		}
+		value := util.AddPaddingToBytes(shortcutValue, len(index))
+		shortcut = leafHash(end, value[len(value)-len(index):])
+	}
+
+	var traverse func(pos position, ops *operationsStack)
+
+	traverse = func(pos position, ops *operationsStack) {
+
+		if pos.Height <= auditPathHeight {
+			if shortcut != nil {
+				ops.Push(shortcut)
+			} else {
+				ops.Push(getDefaultHash(pos))
+			}
+			return
+		}
+
+		rightPos := pos.Right()
+		if bytes.Compare(index, rightPos.Index) < 0 { // go to left
+			traverse(pos.Left(), ops)
+			ops.Push(getFromPath(rightPos))
+		} else { // go to right
+			ops.Push(getFromPath(pos.Left()))
+			traverse(rightPos, ops)
+		}
+
+		ops.Push(innerHash(pos))
+
+	}
+
+	ops := newOperationsStack()
+	traverse(newRootPosition(uint16(len(index))), ops)
+	return ops, true
+
+}
+
+// descendTo returns the position at the given height on the path
+// from the root to the given index.
+func descendTo(index []byte, height uint16) position {
+	pos := newRootPosition(uint16(len(index)))
+	for pos.Height > height {
+		rightPos := pos.Right()
+		if bytes.Compare(index, rightPos.Index) < 0 {
+			pos = pos.Left()
+		} else {
+			pos = rightPos
+		}
+	}
+	return pos
+}
@@ -117,3 +117,77 @@ func TestVerifyInterpretation(t *testing.T) {
 
 	}
 }
+
+func TestPruneToVerifyNonMembership(t *testing.T) {
+
+	testCases := []struct {
+		index                      []byte
+		shortcutKey, shortcutValue []byte
+		auditPathHeight            uint16
+		valid                      bool
+		expectedOps                []op
+	}{
+		{
+			// verify index=1 on a tree with one leaf (index=0)
+			// the path ends at the shortcut leaf of index=0
+			index:           []byte{1},
+			shortcutKey:     []byte{0},
+			shortcutValue:   []byte{0},
+			auditPathHeight: 4,
+			valid:           true,
+			expectedOps: []op{
+				{innerHashCode, pos(0, 8)},
+				{getFromPathCode, pos(128, 7)},
+				{innerHashCode, pos(0, 7)},
+				{getFromPathCode, pos(64, 6)},
+				{innerHashCode, pos(0, 6)},
+				{getFromPathCode, pos(32, 5)},
+				{innerHashCode, pos(0, 5)},
+				{getFromPathCode, pos(16, 4)},
+				{leafHashCode, pos(0, 4)},
+			},
+		},
+		{
+			// verify index=128 on a tree with one leaf (index=0)
+			// the path ends at an empty subtree
+			index:           []byte{128},
+			auditPathHeight: 7,
+			valid:           true,
+			expectedOps: []op{
+				{innerHashCode, pos(0, 8)},
+				{getDefaultHashCode, pos(128, 7)},
+				{getFromPathCode, pos(0, 7)},
+			},
+		},
+		{
+			// the shortcut leaf cannot be the one of the index itself
+			index:           []byte{1},
+			shortcutKey:     []byte{1},
+			shortcutValue:   []byte{0},
+			auditPathHeight: 4,
+			valid:           false,
+		},
+		{
+			// the shortcut leaf must be below the end of the path
+			index:           []byte{1},
+			shortcutKey:     []byte{128},
+			shortcutValue:   []byte{0},
+			auditPathHeight: 4,
+			valid:           false,
+		},
+	}
+
+	for i, c := range testCases {
+		ops, valid := pruneToVerifyNonMembership(c.index, c.shortcutKey, c.shortcutValue, c.auditPathHeight)
+		require.Equalf(t, c.valid, valid, "The validity of the shortcut leaf should match for test case %d", i)
+		if !valid {
+			continue
+		}
+		prunedOps := ops.List()
+		require.Truef(t, len(c.expectedOps) == len(prunedOps), "The size of the pruned ops should match the expected for test case %d", i)
+		for j := 0; j < len(prunedOps); j++ {
+			assert.Equalf(t, c.expectedOps[j].Code, prunedOps[j].Code, "The pruned operation's code should match for test case %d", i)
+			assert.Equalf(t, c.expectedOps[j].Pos, prunedOps[j].Pos, "The pruned operation's position should match for test case %d", i)
+		}
+	}
+}
@@ -24,14 +24,14 @@ import (
 
 func NewFakeQueryProof(shouldVerify bool, value []byte, hasher hashing.Hasher) *hyper.QueryProof {
 	if shouldVerify {
-		return hyper.NewQueryProof([]byte{0}, value, hyper.AuditPath{"128|7": value}, hasher)
+		return hyper.NewQueryProof([]byte{0}, value, hyper.AuditPath{"128|7": value}, hasher, hashing.FormatV0)
 	}
-	return hyper.NewQueryProof([]byte{0}, []byte{0}, hyper.AuditPath{}, hasher)
+	return hyper.NewQueryProof([]byte{0}, []byte{0}, hyper.AuditPath{}, hasher, hashing.FormatV0)
 }
 
 func NewFakeMembershipProof(shouldVerify bool, hasher hashing.Hasher) *history.MembershipProof {
 	if shouldVerify {
-		return history.NewMembershipProof(0, 0, history.AuditPath{}, hasher)
+		return history.NewMembershipProof(0, 0, history.AuditPath{}, hasher, hashing.FormatV0)
 	}
-	return history.NewMembershipProof(1, 1, history.AuditPath{}, hasher)
+	return history.NewMembershipProof(1, 1, history.AuditPath{}, hasher, hashing.FormatV0)
 }
@@ -18,6 +18,7 @@
 package client
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
@@ -25,6 +26,8 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -41,16 +44,24 @@ type HTTPClient struct {
 	topology            *topology
 	apiKey              string
 	readPreference      ReadPref
+	readConsistency     protocol.ReadConsistency
 	maxRetries          int
 	healthCheckEnabled  bool
 	healthCheckTimeout  time.Duration
 	healthCheckInterval time.Duration
 	discoveryEnabled    bool
+	trustedKeys         TrustedKeys            // their windows are updated under mu
+	keysRefreshInterval time.Duration          // minimum time between two requests of the key windows
+	hasher              string                 // pinned hashing algorithm, if any
+	treeFormat          *hashing.FormatVersion // pinned tree format, if any
 
 	mu                sync.RWMutex // guards the next block
 	running           bool
-	healthCheckStopCh chan bool // notify healthchecker to stop, and notify back
-	discoveryStopCh   chan bool // notify sniffer to stop, and notify back
+	healthCheckStopCh chan bool             // notify healthchecker to stop, and notify back
+	discoveryStopCh   chan bool             // notify sniffer to stop, and notify back
+	hasherF           func() hashing.Hasher // hasher of the log, once resolved
+	format            hashing.FormatVersion // tree format of the log, once resolved
+	keysRefreshedAt   time.Time             // last time the key windows were asked
 }
 
 // NewSimpleHTTPClient creates a new short-lived client thath can be
@@ -116,7 +127,6 @@ func NewHTTPClientFromConfig(conf *Config) (*HTTPClient, error) {
 // The client, by default, is meant to be long-lived and shared across
 // your application. If you need a short-lived client, e.g. for request-scope,
 // consider using NewSimpleHttpClient instead.
-//
 func NewHTTPClient(options ...HTTPClientOptionF) (*HTTPClient, error) {
 
 	client := &HTTPClient{
@@ -126,6 +136,7 @@ func NewHTTPClient(options ...HTTPClientOptionF) (*HTTPClient, error) {
 		healthCheckTimeout:  DefaultHealthCheckTimeout,
 		healthCheckInterval: DefaultHealthCheckInterval,
 		discoveryEnabled:    DefaultTopologyDiscoveryEnabled,
+		keysRefreshInterval: DefaultKeysRefreshInterval,
 		readPreference:      Primary,
 		maxRetries:          DefaultMaxRetries,
 		healthCheckStopCh:   make(chan bool),
@@ -228,6 +239,12 @@ func (c *HTTPClient) startDiscoverer() {
 }
 
 func (c *HTTPClient) callPrimary(method, path string, data []byte) ([]byte, error) {
+	return c.callPrimaryWithHeader(method, path, data, nil)
+}
+
+// callPrimaryWithHeader calls the primary endpoint adding the given header
+// to the request. The header is sent again on every retry.
+func (c *HTTPClient) callPrimaryWithHeader(method, path string, data []byte, header http.Header) ([]byte, error) {
 
 	var endpoint *endpoint
 	var err error
@@ -253,7 +270,7 @@ func (c *HTTPClient) callPrimary(method, path string, data []byte) ([]byte, erro
 		}
 		break
 	}
-	return c.doReq(method, endpoint, path, data)
+	return c.doReq(method, endpoint, path, data, header)
 }
 
 func (c *HTTPClient) callAny(method, path string, data []byte) ([]byte, error) {
@@ -273,7 +290,7 @@ func (c *HTTPClient) callAny(method, path string, data []byte) ([]byte, error) {
 			}
 			return nil, err
 		}
-		result, err = c.doReq(method, endpoint, path, data)
+		result, err = c.doReq(method, endpoint, path, data, nil)
 		if err == nil {
 			break
 		}
@@ -282,7 +299,25 @@ func (c *HTTPClient) callAny(method, path string, data []byte) ([]byte, error) {
 	return result, err
 }
 
-func (c *HTTPClient) doReq(method string, endpoint *endpoint, path string, data []byte) ([]byte, error) {
+// callQuery sends a proof query with the read consistency of the client.
+// Linearizable queries can only be answered by the primary node.
+func (c *HTTPClient) callQuery(method, path string, data []byte) ([]byte, error) {
+	if c.readConsistency.Level == "" || c.readConsistency.Level == protocol.Stale {
+		return c.callAny(method, path, data)
+	}
+
+	sep := "?"
+	if strings.Contains(path, "?") {
+		sep = "&"
+	}
+	path = path + sep + c.readConsistency.Values().Encode()
+	if c.readConsistency.Level == protocol.Linearizable {
+		return c.callPrimary(method, path, data)
+	}
+	return c.callAny(method, path, data)
+}
+
+func (c *HTTPClient) doReq(method string, endpoint *endpoint, path string, data []byte, header http.Header) ([]byte, error) {
 
 	url, err := url.Parse(endpoint.URL() + path)
 	if err != nil {
@@ -296,6 +331,9 @@ func (c *HTTPClient) doReq(method string, endpoint *endpoint, path string, data
 	}
 
 	// Set headers
+	for k, v := range header {
+		req.Header[k] = v
+	}
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Api-Key", c.apiKey)
 
@@ -321,6 +359,12 @@ func (c *HTTPClient) doReq(method string, endpoint *endpoint, path string, data
 		return nil, fmt.Errorf("Invalid request %v", string(bodyBytes))
 	}
 
+	// the node cannot answer with the requested read consistency, but
+	// another one may
+	if resp.StatusCode == http.StatusServiceUnavailable {
+		return nil, fmt.Errorf("Unavailable %v", string(bodyBytes))
+	}
+
 	// we successfully made a request to this endpoint
 	endpoint.MarkAsHealthy()
 
@@ -388,7 +432,7 @@ func (c *HTTPClient) discover() error {
 			return err
 		}
 
-		body, err := c.doReq("GET", e, "/info/shards", nil)
+		body, err := c.doReq("GET", e, "/info/shards", nil, nil)
 		if err == nil {
 			var shards protocol.Shards
 			err = json.Unmarshal(body, &shards)
@@ -484,6 +528,112 @@ func (c *HTTPClient) AddBulk(events []string) ([]*protocol.Snapshot, error) {
 	return bs, nil
 }
 
+// AddDigest will do a request to the server with a post data to store a new
+// event already hashed, so the event itself is never sent. The digest must
+// be computed with the hasher of the server, see HasherF.
+func (c *HTTPClient) AddDigest(eventDigest hashing.Digest) (*protocol.Snapshot, error) {
+
+	data, _ := json.Marshal(&protocol.EventDigest{Digest: eventDigest})
+	body, err := c.callPrimary("POST", "/events/digest", data)
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshot protocol.Snapshot
+	err = json.Unmarshal(body, &snapshot)
+	if err != nil {
+		return nil, err
+	}
+
+	return &snapshot, nil
+}
+
+// AddDigestBulk will do a request to the server with a post data to store a
+// bulk of new events already hashed.
+func (c *HTTPClient) AddDigestBulk(eventBulkDigest []hashing.Digest) ([]*protocol.Snapshot, error) {
+
+	data, _ := json.Marshal(&protocol.EventDigestsBulk{Digests: eventBulkDigest})
+	body, err := c.callPrimary("POST", "/events/digest/bulk", data)
+	if err != nil {
+		return nil, err
+	}
+
+	bs := []*protocol.Snapshot{}
+	err = json.Unmarshal(body, &bs)
+	if err != nil {
+		return nil, err
+	}
+
+	return bs, nil
+}
+
+// AddIdempotent will do a request to the server with a post data to store a
+// new event, identified by the given key. Retrying with the same key is safe:
+// the event is stored only once and every call returns the same snapshot.
+// The server forgets the key 24 hours after the first call.
+func (c *HTTPClient) AddIdempotent(event, key string) (*protocol.Snapshot, error) {
+
+	data, _ := json.Marshal(&protocol.Event{Event: []byte(event)})
+	header := http.Header{}
+	header.Set("Idempotency-Key", key)
+	body, err := c.callPrimaryWithHeader("POST", "/events", data, header)
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshot protocol.Snapshot
+	err = json.Unmarshal(body, &snapshot)
+	if err != nil {
+		return nil, err
+	}
+
+	return &snapshot, nil
+}
+
+// AddIfAbsent will do a request to the server with a post data to store a new
+// event only if it is not already stored. If it is, the result contains the
+// snapshot of the existing event along with a membership proof.
+func (c *HTTPClient) AddIfAbsent(event string) (*protocol.AddResult, error) {
+
+	data, _ := json.Marshal(&protocol.Event{Event: []byte(event), IfAbsent: true})
+	body, err := c.callPrimary("POST", "/events", data)
+	if err != nil {
+		return nil, err
+	}
+
+	var result protocol.AddResult
+	err = json.Unmarshal(body, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// AddBulkIfAbsent will do a request to the server with a post data to store
+// the events of a bulk that are not already stored.
+func (c *HTTPClient) AddBulkIfAbsent(events []string) ([]*protocol.AddResult, error) {
+
+	eventBulk := protocol.EventsBulk{IfAbsent: true}
+	for _, e := range events {
+		eventBulk.Events = append(eventBulk.Events, []byte(e))
+	}
+
+	data, _ := json.Marshal(eventBulk)
+	body, err := c.callPrimary("POST", "/events/bulk", data)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []*protocol.AddResult{}
+	err = json.Unmarshal(body, &results)
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
 // Membership will ask for a Proof to the server.
 func (c *HTTPClient) Membership(key []byte, version uint64) (*protocol.MembershipResult, error) {
 
@@ -492,7 +642,7 @@ func (c *HTTPClient) Membership(key []byte, version uint64) (*protocol.Membershi
 		Version: version,
 	})
 
-	body, err := c.callAny("POST", "/proofs/membership", query)
+	body, err := c.callQuery("POST", "/proofs/membership", query)
 	if err != nil {
 		return nil, err
 	}
@@ -512,7 +662,7 @@ func (c *HTTPClient) MembershipDigest(keyDigest hashing.Digest, version uint64)
 		Version:   version,
 	})
 
-	body, err := c.callAny("POST", "/proofs/digest-membership", query)
+	body, err := c.callQuery("POST", "/proofs/digest-membership", query)
 	if err != nil {
 		return nil, err
 	}
@@ -524,6 +674,48 @@ func (c *HTTPClient) MembershipDigest(keyDigest hashing.Digest, version uint64)
 
 }
 
+// Receipt will ask the server for a receipt of the event with the given
+// digest, which can be verified offline with Receipt.Verify. A non-zero
+// laterVersion adds a consistency proof up to that version.
+func (c *HTTPClient) Receipt(eventDigest hashing.Digest, laterVersion uint64) (*protocol.Receipt, error) {
+
+	query, _ := json.Marshal(&protocol.ReceiptQuery{
+		KeyDigest:    eventDigest,
+		LaterVersion: laterVersion,
+	})
+
+	body, err := c.callQuery("POST", "/proofs/receipt", query)
+	if err != nil {
+		return nil, err
+	}
+
+	var receipt protocol.Receipt
+	err = json.Unmarshal(body, &receipt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &receipt, nil
+}
+
+// Keys will ask the server for the public keys that sign, or signed, the
+// snapshots of the log, ordered by activation.
+func (c *HTTPClient) Keys() ([]protocol.KeyInfo, error) {
+
+	body, err := c.callAny("GET", "/info/keys", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var response protocol.KeysResponse
+	err = json.Unmarshal(body, &response)
+	if err != nil {
+		return nil, err
+	}
+
+	return response.Keys, nil
+}
+
 // Incremental will ask for an IncrementalProof to the server.
 func (c *HTTPClient) Incremental(start, end uint64) (*protocol.IncrementalResponse, error) {
 
@@ -532,7 +724,7 @@ func (c *HTTPClient) Incremental(start, end uint64) (*protocol.IncrementalRespon
 		End:   end,
 	})
 
-	body, err := c.callAny("POST", "/proofs/incremental", query)
+	body, err := c.callQuery("POST", "/proofs/incremental", query)
 	if err != nil {
 		return nil, err
 	}
@@ -543,41 +735,237 @@ func (c *HTTPClient) Incremental(start, end uint64) (*protocol.IncrementalRespon
 	return response, nil
 }
 
+// EventByVersion will ask the server for the digest of the event indexed
+// at the given version, along with a proof of its membership in the
+// history tree at queryVersion. A zero queryVersion proves it against the
+// last version.
+func (c *HTTPClient) EventByVersion(version, queryVersion uint64) (*protocol.VersionEntry, error) {
+
+	path := "/events/by-version/" + strconv.FormatUint(version, 10)
+	if queryVersion > 0 {
+		path += "?version=" + strconv.FormatUint(queryVersion, 10)
+	}
+
+	body, err := c.callQuery("GET", path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var entry protocol.VersionEntry
+	err = json.Unmarshal(body, &entry)
+	if err != nil {
+		return nil, err
+	}
+
+	return &entry, nil
+}
+
+// EventsByVersion will ask the server for the events indexed between start
+// and end, both included, proved against queryVersion. A zero end or
+// queryVersion stands for the last version. The server caps the number of
+// versions of each response, so callers should page through long ranges
+// resuming after the end version of the response.
+func (c *HTTPClient) EventsByVersion(start, end, queryVersion uint64) (*protocol.VersionEntries, error) {
+
+	params := url.Values{}
+	params.Set("start", strconv.FormatUint(start, 10))
+	if end > 0 {
+		params.Set("end", strconv.FormatUint(end, 10))
+	}
+	if queryVersion > 0 {
+		params.Set("version", strconv.FormatUint(queryVersion, 10))
+	}
+
+	body, err := c.callQuery("GET", "/events/by-version?"+params.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var response protocol.VersionEntries
+	err = json.Unmarshal(body, &response)
+	if err != nil {
+		return nil, err
+	}
+
+	return &response, nil
+}
+
+// Export will ask the server for the entries of the log between from and
+// to, both included, along with the snapshot of the to version signed by
+// the server. A zero to stands for the last version. A non-zero limit caps
+// the number of entries returned, so callers page through the log asking
+// again from the end version of the header, keeping its to version.
+//
+// If the stream is cut before its end version, the entries received are
+// returned along with an error, and the export can be resumed after the
+// last of them.
+func (c *HTTPClient) Export(from, to, limit uint64) (*protocol.ExportHeader, []*protocol.ExportEntry, error) {
+
+	params := url.Values{}
+	params.Set("from", strconv.FormatUint(from, 10))
+	if to > 0 {
+		params.Set("to", strconv.FormatUint(to, 10))
+	}
+	if limit > 0 {
+		params.Set("limit", strconv.FormatUint(limit, 10))
+	}
+
+	body, err := c.callQuery("GET", "/events/export?"+params.Encode(), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	var header protocol.ExportHeader
+	if err := decoder.Decode(&header); err != nil {
+		return nil, nil, err
+	}
+
+	entries := make([]*protocol.ExportEntry, 0, header.End-header.From+1)
+	for next := header.From; next <= header.End; next++ {
+		var entry protocol.ExportEntry
+		if err := decoder.Decode(&entry); err != nil || entry.Version != next {
+			return &header, entries, fmt.Errorf("the export was cut at version %d", next)
+		}
+		entries = append(entries, &entry)
+	}
+
+	return &header, entries, nil
+}
+
+// HasherF returns the hasher constructor of the log: the pinned one or,
+// if none is, the one advertised by the servers.
+func (c *HTTPClient) HasherF() (func() hashing.Hasher, error) {
+	hasherF, _, err := c.treeHashing()
+	return hasherF, err
+}
+
+// Format returns the format version of the log trees: the pinned one or,
+// if none is, the one advertised by the servers.
+func (c *HTTPClient) Format() (hashing.FormatVersion, error) {
+	_, format, err := c.treeHashing()
+	return format, err
+}
+
+// treeHashing returns the hasher constructor and the tree format that
+// verify the proofs of the log.
+func (c *HTTPClient) treeHashing() (func() hashing.Hasher, hashing.FormatVersion, error) {
+	if err := c.loadInfo(); err != nil {
+		return nil, 0, err
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.hasherF, c.format, nil
+}
+
+// loadInfo resolves the hashing algorithm and the tree format of the log.
+// Pinned values are used as they are, and only those not pinned are asked
+// to the info endpoint, which must agree with the pinned ones. Both are
+// fixed at bootstrap, so they are resolved only once.
+func (c *HTTPClient) loadInfo() error {
+	c.mu.RLock()
+	loaded := c.hasherF != nil
+	c.mu.RUnlock()
+	if loaded {
+		return nil
+	}
+
+	hasher, format := c.hasher, c.treeFormat
+	if hasher == "" || format == nil {
+		body, err := c.callAny("GET", "/info", nil)
+		if err != nil {
+			return err
+		}
+
+		var info struct {
+			Hasher string
+			Format *hashing.FormatVersion
+		}
+		if err := json.Unmarshal(body, &info); err != nil {
+			return err
+		}
+
+		switch {
+		case info.Hasher == "" && hasher == "":
+			return errors.New("the server does not advertise its hasher, set it in the client config")
+		case info.Hasher != "" && hasher != "" && info.Hasher != hasher:
+			return fmt.Errorf("the server uses the %s hasher but the client is set to %s", info.Hasher, hasher)
+		case hasher == "":
+			hasher = info.Hasher
+		}
+
+		switch {
+		case info.Format == nil && format == nil:
+			return errors.New("the server does not advertise its tree format, set it in the client config")
+		case info.Format != nil && format != nil && *info.Format != *format:
+			return fmt.Errorf("the server uses the tree format %d but the client is set to %d", *info.Format, *format)
+		case format == nil:
+			format = info.Format
+		}
+	}
+
+	hasherF, err := hashing.NewHasherF(hasher)
+	if err != nil {
+		return fmt.Errorf("%s: %s", err, hasher)
+	}
+
+	c.mu.Lock()
+	c.hasherF = hasherF
+	c.format = *format
+	c.mu.Unlock()
+
+	return nil
+}
+
+// offlineFormat returns the tree format of the proofs verified offline,
+// with a given hasher: the pinned one, or the format of new logs.
+func (c *HTTPClient) offlineFormat() hashing.FormatVersion {
+	if c.treeFormat != nil {
+		return *c.treeFormat
+	}
+	return hashing.CurrentFormat
+}
+
 // Verify will compute the Proof given in Membership and the snapshot from the
-// add and returns a proof of existence.
+// add and returns a proof of existence. It does not ask the servers: the
+// proof is verified with the given hasher and the pinned tree format or,
+// if none is, the format of new logs.
 func (c *HTTPClient) Verify(
 	result *protocol.MembershipResult,
 	snap *protocol.Snapshot,
 	hasherF func() hashing.Hasher,
 ) bool {
 
-	proof := protocol.ToBalloonProof(result, hasherF)
+	proof := protocol.ToBalloonProof(result, hasherF, c.offlineFormat())
 	balloonSnapshot := balloon.Snapshot(*snap)
 
 	return proof.Verify(snap.EventDigest, &balloonSnapshot)
 }
 
-// Verify will compute the Proof given in Membership and the snapshot from the
-// add and returns a proof of existence.
+// DigestVerify will compute the Proof given in Membership and the snapshot
+// from the add and returns a proof of existence. Like Verify, it does not
+// ask the servers.
 func (c *HTTPClient) DigestVerify(
 	result *protocol.MembershipResult,
 	snap *protocol.Snapshot,
 	hasherF func() hashing.Hasher,
 ) bool {
 
-	proof := protocol.ToBalloonProof(result, hasherF)
+	proof := protocol.ToBalloonProof(result, hasherF, c.offlineFormat())
 	balloonSnapshot := balloon.Snapshot(*snap)
 
 	return proof.DigestVerify(snap.EventDigest, &balloonSnapshot)
 }
 
+// VerifyIncremental checks the incremental proof between both snapshots.
+// Like Verify, it does not ask the servers.
 func (c *HTTPClient) VerifyIncremental(
 	result *protocol.IncrementalResponse,
 	startSnapshot, endSnapshot *protocol.Snapshot,
 	hasher hashing.Hasher,
 ) bool {
 
-	proof := protocol.ToIncrementalProof(result, hasher)
+	proof := protocol.ToIncrementalProof(result, hasher, c.offlineFormat())
 
 	s := balloon.Snapshot(*startSnapshot)
 	start := &s
@@ -587,3 +975,102 @@ func (c *HTTPClient) VerifyIncremental(
 
 	return proof.Verify(start, end)
 }
+
+// VerifyMembership checks the membership proof of the event of the
+// snapshot with the hasher and the tree format of the log. It only fails
+// when they cannot be resolved.
+func (c *HTTPClient) VerifyMembership(result *protocol.MembershipResult, snap *protocol.Snapshot) (bool, error) {
+	hasherF, format, err := c.treeHashing()
+	if err != nil {
+		return false, err
+	}
+
+	proof := protocol.ToBalloonProof(result, hasherF, format)
+	balloonSnapshot := balloon.Snapshot(*snap)
+
+	return proof.DigestVerify(snap.EventDigest, &balloonSnapshot), nil
+}
+
+// VerifyIncrementalProof checks the incremental proof between both
+// snapshots with the hasher and the tree format of the log. It only fails
+// when they cannot be resolved.
+func (c *HTTPClient) VerifyIncrementalProof(result *protocol.IncrementalResponse, startSnapshot, endSnapshot *protocol.Snapshot) (bool, error) {
+	hasherF, format, err := c.treeHashing()
+	if err != nil {
+		return false, err
+	}
+
+	proof := protocol.ToIncrementalProof(result, hasherF(), format)
+
+	s := balloon.Snapshot(*startSnapshot)
+	e := balloon.Snapshot(*endSnapshot)
+
+	return proof.Verify(&s, &e), nil
+}
+
+// VerifyVersionEntry checks that the event digest of the entry is the one
+// indexed at its version in the history tree whose root at the query
+// version of the entry is historyDigest.
+func (c *HTTPClient) VerifyVersionEntry(entry *protocol.VersionEntry, historyDigest hashing.Digest) bool {
+
+	hasherF, format, err := c.treeHashing()
+	if err != nil {
+		log.Infof("Unable to get the QED hashing rules: %v", err)
+		return false
+	}
+
+	proof := protocol.ToHistoryProof(entry, hasherF, format)
+
+	return proof.Verify(entry.EventDigest, historyDigest)
+}
+
+// HasTrustedKeys tells whether the client has any key to verify the
+// snapshot signatures with.
+func (c *HTTPClient) HasTrustedKeys() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.trustedKeys) > 0
+}
+
+// VerifySignature checks that the snapshot has been signed by one of the
+// trusted keys of the client, which had not been retired at the snapshot
+// timestamp. It returns a *SignatureError otherwise.
+func (c *HTTPClient) VerifySignature(signed *protocol.SignedSnapshot) error {
+	if err := c.loadKeyWindows(signed); err != nil {
+		return &SignatureError{Version: signed.Snapshot.Version, Reason: fmt.Sprintf("unable to get the windows of the keys: %v", err)}
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.trustedKeys.VerifySignature(signed)
+}
+
+// loadKeyWindows asks the info endpoint for the windows of the signing
+// keys when the snapshot is signed by a trusted key that has not been
+// retired yet, as it may have been since it was last asked. The windows
+// are asked at most once per refresh interval, so a retirement is only
+// learnt once the interval has passed. Retired keys keep their window, so
+// they are not asked again.
+func (c *HTTPClient) loadKeyWindows(signed *protocol.SignedSnapshot) error {
+	if signed == nil || signed.Snapshot == nil {
+		return nil
+	}
+	c.mu.RLock()
+	key, ok := c.trustedKeys[signed.KeyID]
+	retired := ok && key.ValidUntil != 0
+	fresh := !c.keysRefreshedAt.IsZero() && time.Since(c.keysRefreshedAt) < c.keysRefreshInterval
+	c.mu.RUnlock()
+	if !ok || retired || fresh {
+		return nil
+	}
+
+	keys, err := c.Keys()
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.trustedKeys.SetWindows(keys)
+	c.keysRefreshedAt = time.Now()
+	c.mu.Unlock()
+	return nil
+}
@@ -18,22 +18,27 @@ package client
 
 import (
 	"bytes"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/require"
 
+	"github.com/bbva/qed/balloon"
 	"github.com/bbva/qed/hashing"
 	"github.com/pkg/errors"
 
 	"github.com/bbva/qed/log"
 	"github.com/bbva/qed/protocol"
+	"github.com/bbva/qed/storage/bplus"
+	"github.com/bbva/qed/testutils/keys"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -44,9 +49,13 @@ func setupServer(input []byte) (string, func()) {
 	mux.HandleFunc("/info/shards", infoHandler(server.URL))
 	mux.HandleFunc("/events", defaultHandler(input))
 	mux.HandleFunc("/events/bulk", defaultHandler(input))
+	mux.HandleFunc("/events/digest", defaultHandler(input))
+	mux.HandleFunc("/events/digest/bulk", defaultHandler(input))
 	mux.HandleFunc("/proofs/membership", defaultHandler(input))
 	mux.HandleFunc("/proofs/incremental", defaultHandler(input))
 	mux.HandleFunc("/proofs/digest-membership", defaultHandler(input))
+	mux.HandleFunc("/proofs/receipt", defaultHandler(input))
+	mux.HandleFunc("/info/keys", defaultHandler(input))
 	mux.HandleFunc("/healthcheck", defaultHandler(nil))
 
 	return server.URL, func() {
@@ -462,6 +471,167 @@ func TestAddBulkSuccess(t *testing.T) {
 	assert.Equal(t, bulk, snapshotBulk, "The snapshots should match")
 }
 
+func TestAddDigestSuccess(t *testing.T) {
+
+	log.SetLogger("TestAddDigestSuccess", log.SILENT)
+
+	eventDigest := hashing.NewSha256Hasher().Do([]byte("Hello world!"))
+	snap := &protocol.Snapshot{
+		HistoryDigest: []byte("history"),
+		HyperDigest:   []byte("hyper"),
+		Version:       0,
+		EventDigest:   eventDigest,
+	}
+	input, _ := json.Marshal(snap)
+
+	serverURL, tearDown := setupServer(input)
+	defer tearDown()
+	client := setupClient(t, []string{serverURL})
+
+	snapshot, err := client.AddDigest(eventDigest)
+	assert.NoError(t, err)
+	assert.Equal(t, snap, snapshot, "The snapshots should match")
+}
+
+func TestAddDigestBulkSuccess(t *testing.T) {
+
+	log.SetLogger("TestAddDigestBulkSuccess", log.SILENT)
+
+	hasher := hashing.NewSha256Hasher()
+	digestBulk := []hashing.Digest{hasher.Do([]byte("This is event 1")), hasher.Do([]byte("This is event 2"))}
+	bulk := []*protocol.Snapshot{
+		{
+			HistoryDigest: []byte("history"),
+			HyperDigest:   []byte("hyper"),
+			Version:       0,
+			EventDigest:   digestBulk[0],
+		},
+		{
+			HistoryDigest: []byte("history"),
+			HyperDigest:   []byte("hyper"),
+			Version:       1,
+			EventDigest:   digestBulk[1],
+		},
+	}
+	input, _ := json.Marshal(bulk)
+
+	serverURL, tearDown := setupServer(input)
+	defer tearDown()
+	client := setupClient(t, []string{serverURL})
+
+	snapshotBulk, err := client.AddDigestBulk(digestBulk)
+	assert.NoError(t, err)
+	assert.Equal(t, bulk, snapshotBulk, "The snapshots should match")
+}
+
+func TestAddIdempotentRetries(t *testing.T) {
+
+	log.SetLogger("TestAddIdempotentRetries", log.SILENT)
+
+	event := "Hello world!"
+	snap := &protocol.Snapshot{
+		HistoryDigest: []byte("history"),
+		HyperDigest:   []byte("hyper"),
+		Version:       0,
+		EventDigest:   []byte(event),
+	}
+	input, _ := json.Marshal(snap)
+
+	// the first request times out on the server side
+	var keys []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keys = append(keys, r.Header.Get("Idempotency-Key"))
+		if len(keys) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write(input)
+	}))
+	defer server.Close()
+
+	httpClient := http.DefaultClient
+	client, err := NewHTTPClient(
+		SetHttpClient(httpClient),
+		SetAPIKey("my-awesome-api-key"),
+		SetURLs(server.URL),
+		SetReadPreference(Primary),
+		SetMaxRetries(1),
+		SetTopologyDiscovery(false),
+		SetHealthChecks(false),
+	)
+	require.NoError(t, err)
+
+	snapshot, err := client.AddIdempotent(event, "my-key")
+	require.NoError(t, err)
+	assert.Equal(t, snap, snapshot, "The snapshots should match")
+	assert.Equal(t, []string{"my-key", "my-key"}, keys, "Every retry should send the idempotency key")
+}
+
+func TestAddIfAbsentSuccess(t *testing.T) {
+
+	log.SetLogger("TestAddIfAbsentSuccess", log.SILENT)
+
+	event := "Hello world!"
+	result := &protocol.AddResult{
+		Snapshot: &protocol.Snapshot{
+			HistoryDigest: []byte("history"),
+			HyperDigest:   []byte("hyper"),
+			Version:       0,
+			EventDigest:   []byte(event),
+		},
+		Proof: &protocol.MembershipResult{
+			Exists:        true,
+			QueryVersion:  0,
+			ActualVersion: 0,
+			KeyDigest:     []byte(event),
+			Key:           []byte(event),
+		},
+	}
+	input, _ := json.Marshal(result)
+
+	serverURL, tearDown := setupServer(input)
+	defer tearDown()
+	client := setupClient(t, []string{serverURL})
+
+	added, err := client.AddIfAbsent(event)
+	assert.NoError(t, err)
+	assert.Equal(t, result, added, "The results should match")
+}
+
+func TestAddBulkIfAbsentSuccess(t *testing.T) {
+
+	log.SetLogger("TestAddBulkIfAbsentSuccess", log.SILENT)
+
+	eventBulk := []string{"This is event 1", "This is event 2"}
+	results := []*protocol.AddResult{
+		{
+			Snapshot: &protocol.Snapshot{
+				HistoryDigest: []byte("history"),
+				HyperDigest:   []byte("hyper"),
+				Version:       0,
+				EventDigest:   []byte(eventBulk[0]),
+			},
+		},
+		{
+			Snapshot: &protocol.Snapshot{
+				HistoryDigest: []byte("history"),
+				HyperDigest:   []byte("hyper"),
+				Version:       1,
+				EventDigest:   []byte(eventBulk[1]),
+			},
+		},
+	}
+	input, _ := json.Marshal(results)
+
+	serverURL, tearDown := setupServer(input)
+	defer tearDown()
+	client := setupClient(t, []string{serverURL})
+
+	added, err := client.AddBulkIfAbsent(eventBulk)
+	assert.NoError(t, err)
+	assert.Equal(t, results, added, "The results should match")
+}
+
 func TestAddWithServerFailure(t *testing.T) {
 
 	log.SetLogger("TestAddWithServerFailure", log.SILENT)
@@ -543,6 +713,339 @@ func TestMembershipWithServerFailure(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func TestMembershipReadConsistency(t *testing.T) {
+
+	log.SetLogger("TestMembershipReadConsistency", log.SILENT)
+
+	fakeResult := &protocol.MembershipResult{Key: []byte("Hello world!"), Exists: true}
+	inputJSON, _ := json.Marshal(fakeResult)
+
+	// the secondary lags behind the leader
+	var queries []string
+	newServer := func(name string, status int) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			queries = append(queries, name+"?"+r.URL.RawQuery)
+			w.WriteHeader(status)
+			_, _ = w.Write(inputJSON)
+		}))
+	}
+	primary := newServer("primary", http.StatusOK)
+	defer primary.Close()
+	secondary := newServer("secondary", http.StatusServiceUnavailable)
+	defer secondary.Close()
+
+	testCases := []struct {
+		consistency     protocol.ReadConsistency
+		expectedQueries []string
+		expectedErr     bool
+	}{
+		{
+			protocol.ReadConsistency{Level: protocol.Stale},
+			[]string{"secondary?"},
+			true,
+		},
+		{
+			protocol.ReadConsistency{Level: protocol.Bounded, MaxLag: 5 * time.Second},
+			[]string{"secondary?consistency=bounded&max_lag=5s"},
+			true,
+		},
+		{
+			protocol.ReadConsistency{Level: protocol.Linearizable},
+			[]string{"primary?consistency=linearizable"},
+			false,
+		},
+	}
+
+	for i, c := range testCases {
+		queries = nil
+		client, err := NewHTTPClient(
+			SetHttpClient(http.DefaultClient),
+			SetURLs(primary.URL, secondary.URL),
+			SetRequestRetrier(NewNoRequestRetrier(http.DefaultClient)),
+			SetReadPreference(Secondary),
+			SetReadConsistency(c.consistency),
+			SetTopologyDiscovery(false),
+			SetHealthChecks(false),
+		)
+		require.NoError(t, err)
+
+		result, err := client.Membership([]byte("Hello world!"), 0)
+		assert.Equalf(t, c.expectedQueries, queries, "Wrong queries in test case %d", i)
+		if c.expectedErr {
+			assert.Errorf(t, err, "The lagging secondary should not answer in test case %d", i)
+			continue
+		}
+		assert.NoErrorf(t, err, "Unexpected error in test case %d", i)
+		assert.Equalf(t, fakeResult, result, "Wrong result in test case %d", i)
+	}
+}
+
+func TestReceipt(t *testing.T) {
+
+	log.SetLogger("TestReceipt", log.SILENT)
+
+	eventDigest := hashing.Digest("digest")
+	receipt := &protocol.Receipt{
+		Version:     protocol.ReceiptVersion,
+		Hasher:      hashing.Sha256,
+		EventDigest: eventDigest,
+		KeyID:       "5c3e8b8a1f4d2e6a",
+		Snapshot: &protocol.SignedSnapshot{
+			Snapshot: &protocol.Snapshot{
+				EventDigest:   eventDigest,
+				HistoryDigest: []byte("history"),
+				HyperDigest:   []byte("hyper"),
+				Version:       2,
+			},
+			Signature: []byte("signature"),
+		},
+		Membership: &protocol.MembershipResult{
+			Exists:        true,
+			QueryVersion:  2,
+			ActualVersion: 2,
+			KeyDigest:     eventDigest,
+		},
+	}
+	input, _ := json.Marshal(receipt)
+
+	serverURL, tearDown := setupServer(input)
+	defer tearDown()
+	client := setupClient(t, []string{serverURL})
+
+	result, err := client.Receipt(eventDigest, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, receipt, result, "The receipts should match")
+}
+
+func TestKeys(t *testing.T) {
+
+	log.SetLogger("TestKeys", log.SILENT)
+
+	response := &protocol.KeysResponse{
+		Keys: []protocol.KeyInfo{
+			{KeyID: "5c3e8b8a1f4d2e6a", PublicKey: []byte("old"), ValidUntil: 100},
+			{KeyID: "9d1f0a2b3c4e5f60", PublicKey: []byte("new"), ValidFrom: 100},
+		},
+	}
+	input, _ := json.Marshal(response)
+
+	serverURL, tearDown := setupServer(input)
+	defer tearDown()
+	client := setupClient(t, []string{serverURL})
+
+	keys, err := client.Keys()
+	assert.NoError(t, err)
+	assert.Equal(t, response.Keys, keys, "The keys should match")
+}
+
+func TestMutualTLS(t *testing.T) {
+
+	log.SetLogger("TestMutualTLS", log.SILENT)
+
+	path, err := ioutil.TempDir("", "qed-client-tls")
+	require.NoError(t, err)
+	defer os.RemoveAll(path)
+	_, err = keys.GenerateTlsCert(path)
+	require.NoError(t, err)
+
+	// the server only accepts clients with a certificate signed by the CA
+	serverTLS, err := NewTLSConfig(path+"/cert.pem", path+"/cert.pem", path+"/key.pem", false)
+	require.NoError(t, err)
+	serverTLS.ClientAuth = tls.RequireAndVerifyClientCert
+	serverTLS.ClientCAs = serverTLS.RootCAs
+
+	input, _ := json.Marshal(&protocol.KeysResponse{})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/info/keys", defaultHandler(input))
+	server := httptest.NewUnstartedServer(mux)
+	server.TLS = serverTLS
+	server.StartTLS()
+	defer server.Close()
+
+	conf := DefaultConfig()
+	conf.Endpoints = []string{server.URL}
+	conf.EnableTopologyDiscovery = false
+	conf.EnableHealthChecks = false
+	conf.MaxRetries = 0
+	conf.CACertificate = path + "/cert.pem"
+
+	client, err := NewHTTPClientFromConfig(conf)
+	require.NoError(t, err)
+	_, err = client.Keys()
+	require.Error(t, err, "Clients without certificate should be rejected")
+
+	conf.ClientCertificate = path + "/cert.pem"
+	conf.ClientCertificateKey = path + "/key.pem"
+	client, err = NewHTTPClientFromConfig(conf)
+	require.NoError(t, err)
+	_, err = client.Keys()
+	require.NoError(t, err, "Clients with a certificate signed by the CA should be accepted")
+}
+
+func TestEventsByVersion(t *testing.T) {
+
+	log.SetLogger("TestEventsByVersion", log.SILENT)
+
+	fakeResult := &protocol.VersionEntries{
+		Start:        2,
+		End:          3,
+		QueryVersion: 8,
+		Entries: []*protocol.VersionEntry{
+			{Version: 2, EventDigest: hashing.Digest{0x2}, QueryVersion: 8},
+			{Version: 3, EventDigest: hashing.Digest{0x3}, QueryVersion: 8},
+		},
+	}
+	inputJSON, _ := json.Marshal(fakeResult)
+
+	var queries []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries = append(queries, r.URL.RequestURI())
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(inputJSON)
+	}))
+	defer server.Close()
+
+	testCases := []struct {
+		start, end, queryVersion uint64
+		consistency              protocol.ReadConsistency
+		expectedQuery            string
+	}{
+		{2, 0, 0, protocol.ReadConsistency{}, "/events/by-version?start=2"},
+		{2, 3, 8, protocol.ReadConsistency{}, "/events/by-version?end=3&start=2&version=8"},
+		{2, 3, 0, protocol.ReadConsistency{Level: protocol.Linearizable}, "/events/by-version?end=3&start=2&consistency=linearizable"},
+	}
+
+	for i, c := range testCases {
+		queries = nil
+		client, err := NewHTTPClient(
+			SetHttpClient(http.DefaultClient),
+			SetURLs(server.URL),
+			SetRequestRetrier(NewNoRequestRetrier(http.DefaultClient)),
+			SetReadConsistency(c.consistency),
+			SetTopologyDiscovery(false),
+			SetHealthChecks(false),
+		)
+		require.NoError(t, err)
+
+		result, err := client.EventsByVersion(c.start, c.end, c.queryVersion)
+		assert.NoErrorf(t, err, "Unexpected error in test case %d", i)
+		assert.Equalf(t, []string{c.expectedQuery}, queries, "Wrong queries in test case %d", i)
+		assert.Equalf(t, fakeResult, result, "Wrong result in test case %d", i)
+	}
+}
+
+func TestEventByVersion(t *testing.T) {
+
+	log.SetLogger("TestEventByVersion", log.SILENT)
+
+	store := bplus.NewBPlusTreeStore()
+	defer store.Close()
+	b, err := balloon.NewBalloon(store, hashing.NewSha256Hasher, hashing.CurrentFormat)
+	require.NoError(t, err)
+
+	var snapshot *balloon.Snapshot
+	for i := 0; i < 5; i++ {
+		s, mutations, err := b.Add([]byte(fmt.Sprintf("event %d", i)))
+		require.NoError(t, err)
+		require.NoError(t, store.Mutate(mutations))
+		snapshot = s
+	}
+
+	digests, err := b.QueryEventDigests(2, 2)
+	require.NoError(t, err)
+	proof, err := b.QueryHistoryMembership(2, 4)
+	require.NoError(t, err)
+	entry := &protocol.VersionEntry{
+		Version:      2,
+		EventDigest:  digests[0],
+		QueryVersion: 4,
+		AuditPath:    proof.AuditPath.Serialize(),
+	}
+	inputJSON, _ := json.Marshal(entry)
+	infoJSON, _ := json.Marshal(map[string]interface{}{
+		"Hasher": hashing.Sha256,
+		"Format": hashing.CurrentFormat,
+	})
+
+	var queries []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/info", defaultHandler(infoJSON))
+	mux.HandleFunc("/events/by-version/", func(w http.ResponseWriter, r *http.Request) {
+		queries = append(queries, r.URL.RequestURI())
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(inputJSON)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	client := setupClient(t, []string{server.URL})
+
+	result, err := client.EventByVersion(2, 4)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/events/by-version/2?version=4"}, queries, "Wrong query")
+	assert.Equal(t, entry, result, "The entries should match")
+
+	assert.True(t, client.VerifyVersionEntry(result, snapshot.HistoryDigest), "The entry should verify")
+	result.EventDigest = hashing.Digest("forged")
+	assert.False(t, client.VerifyVersionEntry(result, snapshot.HistoryDigest), "A forged entry should not verify")
+}
+
+func TestExport(t *testing.T) {
+
+	log.SetLogger("TestExport", log.SILENT)
+
+	header := &protocol.ExportHeader{
+		From: 2,
+		End:  3,
+		To:   6,
+		Snapshot: &protocol.SignedSnapshot{
+			Snapshot:  &protocol.Snapshot{HistoryDigest: hashing.Digest{0x6}, Version: 6},
+			Signature: []byte("signature"),
+		},
+	}
+	entries := []*protocol.ExportEntry{
+		{Version: 2, EventDigest: hashing.Digest{0x2}, HistoryDigest: hashing.Digest{0x2}},
+		{Version: 3, EventDigest: hashing.Digest{0x3}, HistoryDigest: hashing.Digest{0x3}},
+	}
+
+	var stream bytes.Buffer
+	encoder := json.NewEncoder(&stream)
+	_ = encoder.Encode(header)
+	for _, entry := range entries {
+		_ = encoder.Encode(entry)
+	}
+
+	testCases := []struct {
+		body            []byte
+		expectedEntries []*protocol.ExportEntry
+		expectedErr     bool
+	}{
+		{stream.Bytes(), entries, false},
+		{stream.Bytes()[:stream.Len()-10], entries[:1], true}, // cut in the middle of a line
+	}
+
+	var queries []string
+	for i, c := range testCases {
+		queries = nil
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			queries = append(queries, r.URL.RequestURI())
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write(c.body)
+		}))
+		client := setupClient(t, []string{server.URL})
+
+		result, received, err := client.Export(2, 6, 2)
+		server.Close()
+		assert.Equalf(t, []string{"/events/export?from=2&limit=2&to=6"}, queries, "Wrong queries in test case %d", i)
+		assert.Equalf(t, header, result, "Wrong header in test case %d", i)
+		assert.Equalf(t, c.expectedEntries, received, "Wrong entries in test case %d", i)
+		if c.expectedErr {
+			assert.Errorf(t, err, "A cut export should fail in test case %d", i)
+			continue
+		}
+		assert.NoErrorf(t, err, "Unexpected error in test case %d", i)
+	}
+}
+
 func TestIncremental(t *testing.T) {
 
 	log.SetLogger("TestIncremental", log.SILENT)
@@ -578,6 +1081,93 @@ func TestIncrementalWithServerFailure(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func TestServerInfo(t *testing.T) {
+
+	log.SetLogger("TestServerInfo", log.SILENT)
+
+	tests := []struct {
+		info             string
+		options          []HTTPClientOptionF
+		expectedHash     hashing.Digest
+		expectedFormat   hashing.FormatVersion
+		expectedRequests int
+		expectedError    bool
+	}{
+		{`{"Hasher":"sha3-256","Format":1}`, nil, hashing.NewSha3_256Hasher().Do([]byte("qed")), hashing.FormatV1, 1, false},
+		{`{"Hasher":"blake2b-256","Format":1}`, nil, hashing.NewBlake2b256Hasher().Do([]byte("qed")), hashing.FormatV1, 1, false},
+		{`{"Hasher":"sha256","Format":0}`, nil, hashing.NewSha256Hasher().Do([]byte("qed")), hashing.FormatV0, 1, false},
+		{`{"Hasher":"md5","Format":1}`, nil, nil, hashing.FormatV0, 1, true},
+		// servers that do not advertise them need them pinned
+		{`{}`, nil, nil, hashing.FormatV0, 1, true},
+		{`{"Hasher":"sha256"}`, nil, nil, hashing.FormatV0, 1, true},
+		{`{}`, []HTTPClientOptionF{SetHasher(hashing.Sha256)}, nil, hashing.FormatV0, 1, true},
+		{`{}`, []HTTPClientOptionF{SetHasher(hashing.Sha256), SetTreeFormat(hashing.FormatV0)}, hashing.NewSha256Hasher().Do([]byte("qed")), hashing.FormatV0, 0, false},
+		{`{"Hasher":"sha256"}`, []HTTPClientOptionF{SetTreeFormat(hashing.FormatV0)}, hashing.NewSha256Hasher().Do([]byte("qed")), hashing.FormatV0, 1, false},
+		// pinned values are not discovered, and others are not accepted
+		{`{"Hasher":"sha256","Format":0}`, []HTTPClientOptionF{SetHasher(hashing.Sha3_256), SetTreeFormat(hashing.FormatV1)}, hashing.NewSha3_256Hasher().Do([]byte("qed")), hashing.FormatV1, 0, false},
+		{`{"Hasher":"sha256","Format":1}`, []HTTPClientOptionF{SetHasher(hashing.Sha256)}, hashing.NewSha256Hasher().Do([]byte("qed")), hashing.FormatV1, 1, false},
+		{`{"Hasher":"sha256","Format":0}`, []HTTPClientOptionF{SetHasher(hashing.Sha3_256)}, nil, hashing.FormatV0, 1, true},
+		{`{"Hasher":"sha256","Format":0}`, []HTTPClientOptionF{SetTreeFormat(hashing.FormatV1)}, nil, hashing.FormatV0, 1, true},
+	}
+
+	for i, test := range tests {
+		var numRequests int
+		httpClient := NewTestHttpClient(func(req *http.Request) (*http.Response, error) {
+			numRequests++
+			return buildResponse(http.StatusOK, test.info), nil
+		})
+
+		options := append([]HTTPClientOptionF{
+			SetHttpClient(httpClient),
+			SetURLs("http://primary.foo"),
+			SetTopologyDiscovery(false),
+			SetHealthChecks(false),
+		}, test.options...)
+		client, err := NewHTTPClient(options...)
+		require.NoError(t, err)
+
+		hasherF, err := client.HasherF()
+		assert.Equalf(t, test.expectedRequests, numRequests, "Wrong number of info requests in test case %d", i)
+		if test.expectedError {
+			assert.Errorf(t, err, "The hashing rules should not be resolved in test case %d", i)
+			continue
+		}
+		assert.NoErrorf(t, err, "Error resolving the hasher in test case %d", i)
+		assert.Equalf(t, test.expectedHash, hasherF().Do([]byte("qed")), "The hashers should match in test case %d", i)
+
+		format, err := client.Format()
+		assert.NoError(t, err)
+		assert.Equalf(t, test.expectedFormat, format, "The formats should match in test case %d", i)
+		assert.Equalf(t, test.expectedRequests, numRequests, "The server info should be asked at most once in test case %d", i)
+	}
+}
+
+func TestPinnedHashingConfig(t *testing.T) {
+	config := DefaultConfig()
+	config.EnableTopologyDiscovery = false
+	config.EnableHealthChecks = false
+
+	config.TreeFormat = "one"
+	_, err := NewHTTPClientFromConfig(config)
+	assert.Error(t, err, "Tree formats must be numbers")
+
+	config.TreeFormat = "9"
+	_, err = NewHTTPClientFromConfig(config)
+	assert.Error(t, err, "Unknown tree formats should be rejected")
+
+	config.TreeFormat = "0"
+	config.Hasher = "md5"
+	_, err = NewHTTPClientFromConfig(config)
+	assert.Error(t, err, "Unknown hashers should be rejected")
+
+	config.Hasher = hashing.Sha512_256
+	client, err := NewHTTPClientFromConfig(config)
+	require.NoError(t, err)
+	format, err := client.Format()
+	require.NoError(t, err, "Pinned values should not be asked to the servers")
+	assert.Equal(t, hashing.FormatV0, format)
+}
+
 // TODO implement a test to verify proofs using fake hash function
 
 func defaultHandler(input []byte) func(http.ResponseWriter, *http.Request) {
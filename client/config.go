@@ -18,10 +18,14 @@ package client
 
 import (
 	"time"
+
+	"github.com/bbva/qed/protocol"
 )
 
 // ReadPref specifies the preferred type of node in the cluster
-// to send request to.
+// to send request to. The freshness required from that node is set
+// with the read consistency: linearizable queries always go to the
+// primary node.
 type ReadPref int
 
 const (
@@ -84,6 +88,10 @@ const (
 	// of the nodes in the QED cluster.
 	DefaultHealthCheckInterval = 60 * time.Second
 
+	// DefaultKeysRefreshInterval is the default minimum interval between two
+	// requests of the windows of the trusted keys.
+	DefaultKeysRefreshInterval = 60 * time.Second
+
 	// DefaultTopologyDiscoveryEnabled specifies if the discoverer is enabled by default.
 	DefaultTopologyDiscoveryEnabled = true
 
@@ -106,6 +114,16 @@ type Config struct {
 	// and host name, allowing MiTM vector attacks.
 	Insecure bool `desc:"Set it to true to disable the verification of the server's certificate chain"`
 
+	// CACertificate is the path to the CA bundle that verifies the server
+	// certificates. The system roots are used if it is empty.
+	CACertificate string `desc:"Path to the CA bundle that verifies the server certificates"`
+
+	// ClientCertificate and ClientCertificateKey are the paths to the
+	// certificate, and its key, that the client presents to servers that
+	// require mutual TLS.
+	ClientCertificate    string `desc:"Path to the client certificate for servers that require mutual TLS"`
+	ClientCertificateKey string `desc:"Path to the key of the client certificate"`
+
 	// Timeout is the time to wait for a request to QED.
 	Timeout time.Duration `desc:"Time to wait for a request to QED"`
 
@@ -115,9 +133,37 @@ type Config struct {
 	// HandshakeTimeout is the time to wait for a handshake negotiation.
 	HandshakeTimeout time.Duration `desc:"Time to wait for a handshake negotiation"`
 
+	// TrustedKeysPaths are the paths to the public keys, in OpenSSH or PEM
+	// format, of the QED servers whose snapshot signatures are trusted.
+	TrustedKeysPaths []string `desc:"Paths to the public keys of the QED servers whose snapshot signatures are trusted"`
+
+	// KeysRefreshInterval is the minimum interval between two requests of
+	// the windows of the trusted keys, which tell when they were retired.
+	KeysRefreshInterval time.Duration `desc:"Minimum interval between two requests of the windows of the trusted keys"`
+
+	// Hasher and TreeFormat pin the hashing algorithm and the tree format
+	// of the log, which verify the proofs. Those not set are asked to the
+	// servers, whose info endpoint is not authenticated, and servers that
+	// advertise other ones are rejected.
+	Hasher     string `desc:"Hashing algorithm of the log: sha256, sha512-256, sha3-256 or blake2b-256. Asked to the servers if empty"`
+	TreeFormat string `desc:"Tree format version of the log. Asked to the servers if empty"`
+
 	// Controls how the client will route all queries to members of the cluster.
 	ReadPreference ReadPref `flag:"-"`
 
+	// ReadConsistency is the consistency required from the nodes that
+	// answer the proof queries: stale, bounded or linearizable.
+	ReadConsistency string `desc:"Consistency of the proof queries: stale, bounded or linearizable"`
+
+	// MaxLag bounds the time since the node that answers a bounded query
+	// last heard from the leader. Bounded queries require it.
+	MaxLag time.Duration `desc:"Maximum time since the node last heard from the leader in bounded queries"`
+
+	// MaxLagVersions bounds the number of events that the node that answers
+	// a bounded query has received but not applied yet. It only narrows
+	// MaxLag, as events the node has not received are not counted.
+	MaxLagVersions uint64 `desc:"Maximum number of events not applied yet by the node in bounded queries"`
+
 	// MaxRetries sets the maximum number of retries before giving up
 	// when performing an HTTP request to QED.
 	MaxRetries int `desc:"Sets the maximum number of retries before giving up"`
@@ -151,11 +197,13 @@ func DefaultConfig() *Config {
 		DialTimeout:              DefaultDialTimeout,
 		HandshakeTimeout:         DefaultHandshakeTimeout,
 		ReadPreference:           Primary,
+		ReadConsistency:          string(protocol.Stale),
 		MaxRetries:               DefaultMaxRetries,
 		EnableTopologyDiscovery:  DefaultTopologyDiscoveryEnabled,
 		EnableHealthChecks:       DefaultHealthCheckEnabled,
 		HealthCheckTimeout:       DefaultHealthCheckTimeout,
 		HealthCheckInterval:      DefaultHealthCheckInterval,
+		KeysRefreshInterval:      DefaultKeysRefreshInterval,
 		AttemptToReviveEndpoints: false,
 	}
 }
@@ -18,10 +18,17 @@ package client
 
 import (
 	"crypto/tls"
+	"crypto/x509"
 	"errors"
+	"fmt"
+	"io/ioutil"
 	"net"
 	"net/http"
+	"strconv"
 	"time"
+
+	"github.com/bbva/qed/hashing"
+	"github.com/bbva/qed/protocol"
 )
 
 // HTTPClientOptionF is a function that configures an HTTPClient.
@@ -38,12 +45,46 @@ func configToOptions(conf *Config) ([]HTTPClientOptionF, error) {
 			SetHealthChecks(conf.EnableHealthChecks),
 			SetHealthCheckTimeout(conf.HealthCheckTimeout),
 			SetHealthCheckInterval(conf.HealthCheckInterval),
+			SetKeysRefreshInterval(conf.KeysRefreshInterval),
 			SetAttemptToReviveEndpoints(conf.AttemptToReviveEndpoints),
 		}
+		if conf.ReadConsistency != "" {
+			consistency, err := protocol.ParseReadConsistency(protocol.ReadConsistency{
+				Level:          protocol.ConsistencyLevel(conf.ReadConsistency),
+				MaxLag:         conf.MaxLag,
+				MaxLagVersions: conf.MaxLagVersions,
+			}.Values())
+			if err != nil {
+				return nil, err
+			}
+			options = append(options, SetReadConsistency(consistency))
+		}
+		if conf.Hasher != "" {
+			options = append(options, SetHasher(conf.Hasher))
+		}
+		if conf.TreeFormat != "" {
+			format, err := strconv.ParseUint(conf.TreeFormat, 10, 16)
+			if err != nil {
+				return nil, fmt.Errorf("invalid tree format %s", conf.TreeFormat)
+			}
+			options = append(options, SetTreeFormat(hashing.FormatVersion(format)))
+		}
+		if len(conf.TrustedKeysPaths) > 0 {
+			keys, err := LoadTrustedKeys(conf.TrustedKeysPaths)
+			if err != nil {
+				return nil, err
+			}
+			options = append(options, SetTrustedKeys(keys))
+		}
 		if len(conf.Endpoints) > 0 {
 			options = append(options, SetURLs(conf.Endpoints[0], conf.Endpoints[1:]...))
 		}
 
+		tlsConfig, err := NewTLSConfig(conf.CACertificate, conf.ClientCertificate, conf.ClientCertificateKey, conf.Insecure)
+		if err != nil {
+			return nil, err
+		}
+
 		defaultTransport := http.DefaultTransport.(*http.Transport)
 		options = append(options, SetHttpClient(&http.Client{
 			Timeout: conf.Timeout,
@@ -56,7 +97,7 @@ func configToOptions(conf *Config) ([]HTTPClientOptionF, error) {
 				MaxIdleConns:          defaultTransport.MaxIdleConns,
 				IdleConnTimeout:       defaultTransport.IdleConnTimeout,
 				ExpectContinueTimeout: defaultTransport.ExpectContinueTimeout,
-				TLSClientConfig:       &tls.Config{InsecureSkipVerify: conf.Insecure},
+				TLSClientConfig:       tlsConfig,
 				TLSHandshakeTimeout:   conf.HandshakeTimeout,
 			},
 		}))
@@ -64,6 +105,37 @@ func configToOptions(conf *Config) ([]HTTPClientOptionF, error) {
 	return options, nil
 }
 
+// NewTLSConfig returns the TLS configuration of a client that verifies the
+// server certificates with the given CA bundle, or the system roots if the
+// path is empty, and that presents the given client certificate, if any,
+// to servers that require mutual TLS. Clients that build their own HTTP
+// client can use it along with SetHttpClient.
+func NewTLSConfig(caPath, certPath, keyPath string, insecure bool) (*tls.Config, error) {
+
+	config := &tls.Config{InsecureSkipVerify: insecure}
+
+	if caPath != "" {
+		pem, err := ioutil.ReadFile(caPath)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", caPath)
+		}
+	}
+
+	if certPath != "" || keyPath != "" {
+		cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
+
 func SetHttpClient(client *http.Client) HTTPClientOptionF {
 	return func(c *HTTPClient) error {
 		c.httpClient = client
@@ -105,6 +177,47 @@ func SetAPIKey(key string) HTTPClientOptionF {
 	}
 }
 
+func SetTrustedKeys(keys TrustedKeys) HTTPClientOptionF {
+	return func(c *HTTPClient) error {
+		c.trustedKeys = keys
+		return nil
+	}
+}
+
+// SetKeysRefreshInterval sets the minimum interval between two requests
+// of the windows of the trusted keys. A zero interval asks for them on
+// every signature verification.
+func SetKeysRefreshInterval(interval time.Duration) HTTPClientOptionF {
+	return func(c *HTTPClient) error {
+		c.keysRefreshInterval = interval
+		return nil
+	}
+}
+
+// SetHasher pins the hashing algorithm of the log, so that the proofs are
+// verified with it and servers that advertise another one are rejected.
+func SetHasher(name string) HTTPClientOptionF {
+	return func(c *HTTPClient) error {
+		if _, err := hashing.NewHasherF(name); err != nil {
+			return fmt.Errorf("%s: %s", err, name)
+		}
+		c.hasher = name
+		return nil
+	}
+}
+
+// SetTreeFormat pins the tree format of the log, so that the proofs are
+// verified with it and servers that advertise another one are rejected.
+func SetTreeFormat(format hashing.FormatVersion) HTTPClientOptionF {
+	return func(c *HTTPClient) error {
+		if format > hashing.CurrentFormat {
+			return fmt.Errorf("unknown tree format %d", format)
+		}
+		c.treeFormat = &format
+		return nil
+	}
+}
+
 func SetReadPreference(preference ReadPref) HTTPClientOptionF {
 	return func(c *HTTPClient) error {
 		c.readPreference = preference
@@ -112,6 +225,15 @@ func SetReadPreference(preference ReadPref) HTTPClientOptionF {
 	}
 }
 
+// SetReadConsistency sets the consistency required from the nodes that
+// answer the proof queries.
+func SetReadConsistency(consistency protocol.ReadConsistency) HTTPClientOptionF {
+	return func(c *HTTPClient) error {
+		c.readConsistency = consistency
+		return nil
+	}
+}
+
 func SetMaxRetries(retries int) HTTPClientOptionF {
 	return func(c *HTTPClient) error {
 		c.maxRetries = retries
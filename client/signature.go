@@ -0,0 +1,146 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package client
+
+import (
+	"fmt"
+
+	"github.com/bbva/qed/protocol"
+	"github.com/bbva/qed/sign"
+)
+
+// SignatureError is returned when the signature of a snapshot cannot be
+// verified with any of the trusted public keys.
+type SignatureError struct {
+	Version uint64
+	Reason  string
+}
+
+func (e *SignatureError) Error() string {
+	return fmt.Sprintf("invalid signature of snapshot %d: %s", e.Version, e.Reason)
+}
+
+// TrustedKey is a public key whose signatures are trusted along with the
+// time, in nanoseconds since the epoch, at which a key rotation retired it,
+// or zero while it has not been retired.
+//
+// The window only bounds the snapshots a retired key may sign. It does not
+// revoke a compromised key, which still signs any snapshot dated before
+// its retirement: such a key must be removed from the trusted keys.
+type TrustedKey struct {
+	sign.Signer
+	ValidUntil int64
+}
+
+// validAt returns true unless the key was retired at or before the given
+// snapshot timestamp. Snapshots without timestamp, of events logged before
+// timestamps were recorded, cannot be placed in the window, so only keys
+// that have not been retired verify them.
+//
+// The start of the window is not checked: receipts, exports and backups
+// of past versions are signed again with the key active when they are
+// requested.
+func (k *TrustedKey) validAt(timestamp int64) bool {
+	if k.ValidUntil == 0 {
+		return true
+	}
+	return timestamp != 0 && timestamp < k.ValidUntil
+}
+
+// TrustedKeys is a set of public keys, indexed by their key id, whose
+// signatures are trusted.
+type TrustedKeys map[string]*TrustedKey
+
+// NewTrustedKeys returns the set of trusted keys made of the given verifiers.
+func NewTrustedKeys(verifiers ...sign.Signer) TrustedKeys {
+	keys := make(TrustedKeys, len(verifiers))
+	for _, v := range verifiers {
+		keys[sign.KeyID(v.PublicKey())] = &TrustedKey{Signer: v}
+	}
+	return keys
+}
+
+// SetWindows records when the trusted keys were retired, as the
+// /info/keys endpoint of the log reports it. The keys of the log that are
+// not trusted are ignored.
+func (k TrustedKeys) SetWindows(infos []protocol.KeyInfo) {
+	for _, info := range infos {
+		if key, ok := k[info.KeyID]; ok {
+			key.ValidUntil = info.ValidUntil
+		}
+	}
+}
+
+// LoadTrustedKeys reads the public keys stored in the given paths, either
+// in the OpenSSH authorized_keys format or as PEM encoded PKIX keys.
+func LoadTrustedKeys(paths []string) (TrustedKeys, error) {
+	verifiers := make([]sign.Signer, 0, len(paths))
+	for _, path := range paths {
+		verifier, err := sign.NewVerifierFromFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load trusted key %s: %v", path, err)
+		}
+		verifiers = append(verifiers, verifier)
+	}
+	return NewTrustedKeys(verifiers...), nil
+}
+
+// VerifySignature checks that the snapshot has been signed by one of the
+// trusted keys, which had not been retired at the snapshot timestamp. It
+// returns a *SignatureError otherwise, also when there are no trusted keys
+// at all.
+func (k TrustedKeys) VerifySignature(signed *protocol.SignedSnapshot) error {
+	if signed == nil || signed.Snapshot == nil {
+		return &SignatureError{Reason: "missing snapshot"}
+	}
+	if len(k) == 0 {
+		return &SignatureError{Version: signed.Snapshot.Version, Reason: "no trusted keys"}
+	}
+
+	payload, err := signed.SigningPayload()
+	if err != nil {
+		return &SignatureError{Version: signed.Snapshot.Version, Reason: err.Error()}
+	}
+
+	// snapshots signed before keys had IDs are checked against every key
+	if signed.KeyID != "" {
+		verifier, ok := k[signed.KeyID]
+		if !ok {
+			return &SignatureError{Version: signed.Snapshot.Version, Reason: fmt.Sprintf("key %s is not trusted", signed.KeyID)}
+		}
+		if signed.Algorithm != "" && signed.Algorithm != verifier.Algorithm() {
+			return &SignatureError{Version: signed.Snapshot.Version, Reason: fmt.Sprintf("key %s is not a %s key", signed.KeyID, signed.Algorithm)}
+		}
+		if ok, err := verifier.Verify(payload, signed.Signature); err != nil || !ok {
+			return &SignatureError{Version: signed.Snapshot.Version, Reason: fmt.Sprintf("signature does not verify with key %s", signed.KeyID)}
+		}
+		if !verifier.validAt(signed.Snapshot.Timestamp) {
+			if signed.Snapshot.Timestamp == 0 {
+				return &SignatureError{Version: signed.Snapshot.Version, Reason: fmt.Sprintf("key %s was retired at %d and the snapshot has no timestamp", signed.KeyID, verifier.ValidUntil)}
+			}
+			return &SignatureError{Version: signed.Snapshot.Version, Reason: fmt.Sprintf("key %s was retired at %d, before the snapshot", signed.KeyID, verifier.ValidUntil)}
+		}
+		return nil
+	}
+	for _, verifier := range k {
+		ok, err := verifier.Verify(payload, signed.Signature)
+		if err == nil && ok && verifier.validAt(signed.Snapshot.Timestamp) {
+			return nil
+		}
+	}
+	return &SignatureError{Version: signed.Snapshot.Version, Reason: "not signed by any trusted key"}
+}
@@ -0,0 +1,206 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package client
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/bbva/qed/hashing"
+	"github.com/bbva/qed/protocol"
+	"github.com/bbva/qed/sign"
+	"github.com/bbva/qed/testutils/keys"
+	"github.com/stretchr/testify/require"
+)
+
+func signSnapshot(t *testing.T, signer sign.Signer, snapshot *protocol.Snapshot) *protocol.SignedSnapshot {
+	signed := &protocol.SignedSnapshot{Snapshot: snapshot, Hasher: hashing.Sha256}
+	payload, err := signed.SigningPayload()
+	require.NoError(t, err)
+	signed.Signature, err = signer.Sign(payload)
+	require.NoError(t, err)
+	return signed
+}
+
+func TestVerifySignature(t *testing.T) {
+
+	trusted := sign.NewEd25519Signer()
+	untrusted := sign.NewEd25519Signer()
+
+	snapshot := &protocol.Snapshot{
+		HistoryDigest: []byte{0x01},
+		HyperDigest:   []byte{0x02},
+		Version:       3,
+		EventDigest:   []byte{0x04},
+	}
+
+	tamperedSnapshot := *snapshot
+	tamperedSnapshot.Version = 4
+	tampered := signSnapshot(t, trusted, snapshot)
+	tampered.Snapshot = &tamperedSnapshot
+
+	testCases := []struct {
+		keys   TrustedKeys
+		signed *protocol.SignedSnapshot
+		valid  bool
+	}{
+		{NewTrustedKeys(trusted), signSnapshot(t, trusted, snapshot), true},
+		{NewTrustedKeys(untrusted, trusted), signSnapshot(t, trusted, snapshot), true},
+		{NewTrustedKeys(trusted), signSnapshot(t, untrusted, snapshot), false},
+		{NewTrustedKeys(trusted), tampered, false},
+		{NewTrustedKeys(), signSnapshot(t, trusted, snapshot), false},
+		{nil, signSnapshot(t, trusted, snapshot), false},
+		{NewTrustedKeys(trusted), &protocol.SignedSnapshot{}, false},
+	}
+
+	for i, c := range testCases {
+		client, err := NewHTTPClient(
+			SetURLs("http://127.0.0.1:8800"),
+			SetTopologyDiscovery(false),
+			SetHealthChecks(false),
+			SetTrustedKeys(c.keys),
+		)
+		require.NoError(t, err)
+
+		err = client.VerifySignature(c.signed)
+		if c.valid {
+			require.NoErrorf(t, err, "The signature should verify in test case %d", i)
+			continue
+		}
+		require.Errorf(t, err, "The signature should not verify in test case %d", i)
+		require.IsTypef(t, &SignatureError{}, err, "Wrong error type in test case %d", i)
+	}
+}
+
+func TestVerifySignatureAfterRotation(t *testing.T) {
+
+	oldKey, newKey := sign.NewEd25519Signer(), sign.NewECDSASigner()
+	keyring := sign.NewKeyring(nil, oldKey, newKey)
+	trusted := NewTrustedKeys(oldKey, newKey)
+
+	before := signSnapshot(t, keyring, &protocol.Snapshot{Version: 1, EventDigest: []byte{0x01}})
+	before.KeyID = sign.KeyID(oldKey.PublicKey())
+
+	keyring = sign.NewKeyring(func() string { return sign.KeyID(newKey.PublicKey()) }, oldKey, newKey)
+	after := signSnapshot(t, keyring, &protocol.Snapshot{Version: 2, EventDigest: []byte{0x02}})
+	after.KeyID = sign.KeyID(newKey.PublicKey())
+	after.Algorithm = newKey.Algorithm()
+
+	require.NoError(t, trusted.VerifySignature(before), "Snapshots signed before the rotation should verify")
+	require.NoError(t, trusted.VerifySignature(after), "Snapshots signed after the rotation should verify")
+
+	// the algorithm must be the one of the key
+	after.Algorithm = sign.Ed25519
+	require.IsType(t, &SignatureError{}, trusted.VerifySignature(after), "The snapshot should not verify with another algorithm")
+	after.Algorithm = newKey.Algorithm()
+
+	// the key ID must name the key that signed the snapshot
+	after.KeyID = before.KeyID
+	require.IsType(t, &SignatureError{}, trusted.VerifySignature(after), "The snapshot should not verify with another key")
+
+	// and the key must be trusted
+	err := NewTrustedKeys(oldKey).VerifySignature(signSnapshot(t, newKey, &protocol.Snapshot{Version: 3}))
+	require.IsType(t, &SignatureError{}, err, "Untrusted keys should not verify")
+}
+
+func TestVerifySignatureWithRetiredKey(t *testing.T) {
+
+	oldKey, newKey := sign.NewEd25519Signer(), sign.NewEd25519Signer()
+	rotation := int64(1550566416000000000)
+	windows := []protocol.KeyInfo{
+		{KeyID: sign.KeyID(oldKey.PublicKey()), PublicKey: oldKey.PublicKey(), ValidUntil: rotation},
+		{KeyID: sign.KeyID(newKey.PublicKey()), PublicKey: newKey.PublicKey(), ValidFrom: rotation},
+	}
+
+	signWith := func(key sign.Signer, timestamp int64) *protocol.SignedSnapshot {
+		signed := signSnapshot(t, key, &protocol.Snapshot{Version: 1, EventDigest: []byte{0x01}, Timestamp: timestamp})
+		signed.KeyID = sign.KeyID(key.PublicKey())
+		return signed
+	}
+
+	trusted := NewTrustedKeys(oldKey, newKey)
+	require.NoError(t, trusted.VerifySignature(signWith(oldKey, rotation+1)), "Keys without window should verify")
+
+	trusted.SetWindows(windows)
+	require.NoError(t, trusted.VerifySignature(signWith(oldKey, rotation-1)), "Snapshots before the rotation should verify with the retired key")
+	require.NoError(t, trusted.VerifySignature(signWith(newKey, 0)), "Snapshots without timestamp should verify with the active key")
+	err := trusted.VerifySignature(signWith(oldKey, 0))
+	require.IsType(t, &SignatureError{}, err, "Snapshots without timestamp should not verify with the retired key")
+	require.NoError(t, trusted.VerifySignature(signWith(newKey, rotation-1)), "Past snapshots signed again should verify with the active key")
+	require.NoError(t, trusted.VerifySignature(signWith(newKey, rotation+1)), "Snapshots after the rotation should verify with the active key")
+	err = trusted.VerifySignature(signWith(oldKey, rotation))
+	require.IsType(t, &SignatureError{}, err, "Snapshots after the rotation should not verify with the retired key")
+
+	// the client asks the server for the windows of the keys
+	retired, requests := false, 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/info/keys", r.URL.Path)
+		requests++
+		keys := []protocol.KeyInfo{{KeyID: windows[0].KeyID, PublicKey: windows[0].PublicKey}}
+		if retired {
+			keys = windows
+		}
+		out, _ := json.Marshal(protocol.KeysResponse{Keys: keys})
+		_, _ = w.Write(out)
+	}))
+	defer server.Close()
+
+	client, err := NewHTTPClient(
+		SetURLs(server.URL),
+		SetTopologyDiscovery(false),
+		SetHealthChecks(false),
+		SetTrustedKeys(NewTrustedKeys(oldKey, newKey)),
+	)
+	require.NoError(t, err)
+
+	require.NoError(t, client.VerifySignature(signWith(oldKey, rotation+1)), "The key is not retired yet")
+	retired = true
+	require.NoError(t, client.VerifySignature(signWith(oldKey, rotation+1)), "The windows should not be asked again before the refresh interval")
+	require.Equal(t, 1, requests, "The windows should be asked once per refresh interval")
+
+	client.keysRefreshedAt = client.keysRefreshedAt.Add(-DefaultKeysRefreshInterval)
+	err = client.VerifySignature(signWith(oldKey, rotation+1))
+	require.IsType(t, &SignatureError{}, err, "The client should learn that the key was retired")
+	require.NoError(t, client.VerifySignature(signWith(oldKey, rotation-1)))
+	require.Equal(t, 2, requests, "Retired keys should not ask for the windows again")
+}
+
+func TestLoadTrustedKeys(t *testing.T) {
+
+	path, err := ioutil.TempDir("", "qed-keys")
+	require.NoError(t, err)
+	defer os.RemoveAll(path)
+
+	privateKeyPath, err := keys.GenerateSignKey(path)
+	require.NoError(t, err)
+	signer, err := sign.NewEd25519SignerFromFile(privateKeyPath)
+	require.NoError(t, err)
+
+	trusted, err := LoadTrustedKeys([]string{privateKeyPath + ".pub"})
+	require.NoError(t, err)
+	require.Contains(t, trusted, sign.KeyID(signer.PublicKey()), "The key should be trusted")
+
+	snapshot := &protocol.Snapshot{Version: 1, EventDigest: []byte{0x01}}
+	require.NoError(t, trusted.VerifySignature(signSnapshot(t, signer, snapshot)))
+
+	_, err = LoadTrustedKeys([]string{path + "/missing.pub"})
+	require.Error(t, err, "Loading a missing key should fail")
+}
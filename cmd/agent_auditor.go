@@ -21,7 +21,6 @@ import (
 
 	"github.com/bbva/qed/client"
 	"github.com/bbva/qed/gossip"
-	"github.com/bbva/qed/hashing"
 	"github.com/bbva/qed/log"
 	"github.com/bbva/qed/protocol"
 	"github.com/bbva/qed/util"
@@ -176,20 +175,20 @@ func (i membershipFactory) New(ctx context.Context) gossip.Task {
 			return err
 		}
 
-		storedSnap, err := a.SnapshotStore.GetSnapshot(proof.CurrentVersion)
-		if err != nil {
-			log.Infof("Unable to get snapshot from storage: %v", err)
-			return err
-		}
-
+		// the proof is built against the trees at the snapshot version
+		// so it must verify with the digests of the gossiped snapshot
 		checkSnap := &protocol.Snapshot{
 			HistoryDigest: s.Snapshot.HistoryDigest,
-			HyperDigest:   storedSnap.Snapshot.HyperDigest,
+			HyperDigest:   s.Snapshot.HyperDigest,
 			Version:       s.Snapshot.Version,
 			EventDigest:   s.Snapshot.EventDigest,
 		}
 
-		ok := a.Qed.DigestVerify(proof, checkSnap, hashing.NewSha256Hasher)
+		ok, err := a.Qed.VerifyMembership(proof, checkSnap)
+		if err != nil {
+			log.Infof("Auditor is unable to get the hashing rules of the log: %v", err)
+			return err
+		}
 		if !ok {
 			a.Notifier.Alert(fmt.Sprintf("Unable to verify snapshot %v", s.Snapshot))
 			log.Infof("Unable to verify snapshot %v", s.Snapshot)
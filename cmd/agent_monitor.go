@@ -23,7 +23,6 @@ import (
 
 	"github.com/bbva/qed/client"
 	"github.com/bbva/qed/gossip"
-	"github.com/bbva/qed/hashing"
 	"github.com/bbva/qed/log"
 	"github.com/bbva/qed/protocol"
 	"github.com/bbva/qed/util"
@@ -175,7 +174,11 @@ func (i incrementalFactory) New(ctx context.Context) gossip.Task {
 			log.Infof("Monitor is unable to get incremental proof from QED server: %s", err.Error())
 			return err
 		}
-		ok := a.Qed.VerifyIncremental(resp, first, last, hashing.NewSha256Hasher())
+		ok, err := a.Qed.VerifyIncrementalProof(resp, first, last)
+		if err != nil {
+			log.Infof("Monitor is unable to get the hashing rules of the log: %v", err)
+			return err
+		}
 		if !ok {
 			a.Notifier.Alert(fmt.Sprintf("Monitor is unable to verify incremental proof from %d to %d", first.Version, last.Version))
 			log.Infof("Monitor is unable to verify incremental proof from %d to %d", first.Version, last.Version)
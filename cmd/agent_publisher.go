@@ -19,6 +19,7 @@ import (
 	"context"
 	"fmt"
 
+	"github.com/bbva/qed/client"
 	"github.com/bbva/qed/gossip"
 	"github.com/bbva/qed/log"
 	"github.com/bbva/qed/protocol"
@@ -72,6 +73,10 @@ type publisherConfig struct {
 	Notifier *gossip.SimpleNotifierConfig
 	Store    *gossip.RestSnapshotStoreConfig
 	Tasks    *gossip.SimpleTasksManagerConfig
+
+	// TrustedKeysPaths are the paths to the public keys of the QED
+	// servers whose snapshot signatures are trusted.
+	TrustedKeysPaths []string `desc:"Paths to the public keys of the QED servers whose snapshot signatures are trusted"`
 }
 
 func newPublisherConfig() *publisherConfig {
@@ -100,6 +105,13 @@ func runAgentPublisher(cmd *cobra.Command, args []string) error {
 
 	log.SetLogger("publisher", agentConfig.Log)
 
+	keys, err := client.LoadTrustedKeys(conf.TrustedKeysPaths)
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 && !agentConfig.InsecureSkipSignatureVerification {
+		return gossip.ErrNoTrustedKeys
+	}
 	notifier := gossip.NewSimpleNotifierFromConfig(conf.Notifier)
 	tm := gossip.NewSimpleTasksManagerFromConfig(conf.Tasks)
 	store := gossip.NewRestSnapshotStoreFromConfig(conf.Store)
@@ -108,6 +120,13 @@ func runAgentPublisher(cmd *cobra.Command, args []string) error {
 	if err != nil {
 		return err
 	}
+	// publishers do not talk to QED, so they check the signatures
+	// of the snapshots with their own set of trusted keys
+	if len(keys) > 0 {
+		agent.Verifier = keys
+	} else {
+		log.Infof("No trusted keys: the snapshot signatures will not be verified")
+	}
 
 	bp := gossip.NewBatchProcessor(agent, []gossip.TaskFactory{gossip.PrinterFactory{}, publisherFactory{}})
 	agent.In.Subscribe(gossip.BatchMessageType, bp, 255)
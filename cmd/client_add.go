@@ -21,6 +21,7 @@ import (
 
 	"github.com/bbva/qed/client"
 	"github.com/bbva/qed/log"
+	"github.com/bbva/qed/protocol"
 	"github.com/spf13/cobra"
 )
 
@@ -30,12 +31,18 @@ var clientAddCmd *cobra.Command = &cobra.Command{
 	RunE:  runClientAdd,
 }
 
-var clientAddEvent string
+var (
+	clientAddEvent          string
+	clientAddIfAbsent       bool
+	clientAddIdempotencyKey string
+)
 
 func init() {
 
 	clientAddCmd.Flags().StringVar(&clientAddEvent, "event", "", "Event to append to QED")
 	clientAddCmd.MarkFlagRequired("event")
+	clientAddCmd.Flags().BoolVar(&clientAddIfAbsent, "if-absent", false, "Append the event only if it is not already in QED")
+	clientAddCmd.Flags().StringVar(&clientAddIdempotencyKey, "idempotency-key", "", "Unique key that makes retries of the same add safe")
 
 	clientCmd.AddCommand(clientAddCmd)
 }
@@ -54,7 +61,26 @@ func runClientAdd(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
-	snapshot, err := client.Add(clientAddEvent)
+	if clientAddIfAbsent && clientAddIdempotencyKey != "" {
+		return fmt.Errorf("The if-absent and idempotency-key flags cannot be used together")
+	}
+
+	var snapshot *protocol.Snapshot
+	switch {
+	case clientAddIfAbsent:
+		result, err := client.AddIfAbsent(clientAddEvent)
+		if err != nil {
+			return err
+		}
+		if result.Proof != nil {
+			fmt.Printf("\nThe event was already in QED.\n")
+		}
+		snapshot = result.Snapshot
+	case clientAddIdempotencyKey != "":
+		snapshot, err = client.AddIdempotent(clientAddEvent, clientAddIdempotencyKey)
+	default:
+		snapshot, err = client.Add(clientAddEvent)
+	}
 	if err != nil {
 		return err
 	}
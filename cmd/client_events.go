@@ -0,0 +1,134 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/octago/sflags/gen/gpflag"
+	"github.com/spf13/cobra"
+
+	"github.com/bbva/qed/client"
+	"github.com/bbva/qed/log"
+)
+
+var clientEventsCmd *cobra.Command = &cobra.Command{
+	Use:   "events",
+	Short: "List the events logged between two versions",
+	Long: `List the digests of the events logged between two versions, both
+included, along with their timestamps. The proofs of membership in the
+history tree are verified too if the flag is enabled.`,
+	RunE: runClientEvents,
+}
+
+var clientEventsCtx context.Context
+
+func init() {
+	clientEventsCtx = configClientEvents()
+	clientCmd.AddCommand(clientEventsCmd)
+}
+
+type eventsParams struct {
+	Start        uint64 `desc:"First version to list"`
+	End          uint64 `desc:"Last version to list (defaults to the last version of the log)"`
+	QueryVersion uint64 `desc:"Version to prove the events against (defaults to the last version of the log)"`
+	Verify       bool   `desc:"Set to enable proof verification process"`
+}
+
+func configClientEvents() context.Context {
+
+	conf := &eventsParams{}
+
+	err := gpflag.ParseTo(conf, clientEventsCmd.PersistentFlags())
+	if err != nil {
+		log.Fatalf("err: %v", err)
+	}
+	return context.WithValue(Ctx, k("client.events.params"), conf)
+}
+
+func runClientEvents(cmd *cobra.Command, args []string) error {
+
+	// SilenceUsage is set to true -> https://github.com/spf13/cobra/issues/340
+	cmd.SilenceUsage = true
+	params := clientEventsCtx.Value(k("client.events.params")).(*eventsParams)
+
+	if params.End > 0 && params.End < params.Start {
+		return fmt.Errorf("The end version cannot be lower than the start version")
+	}
+
+	config := clientCtx.Value(k("client.config")).(*client.Config)
+	log.SetLogger("client", config.Log)
+
+	client, err := client.NewHTTPClientFromConfig(config)
+	if err != nil {
+		return err
+	}
+
+	// the first page pins the query version, so every proof verifies
+	// against the same history digest
+	var historyDigest []byte
+	start, end, queryVersion := params.Start, params.End, params.QueryVersion
+	for {
+		response, err := client.EventsByVersion(start, end, queryVersion)
+		if err != nil {
+			return err
+		}
+		if queryVersion == 0 {
+			queryVersion = response.QueryVersion
+			fmt.Printf("\nListing events proved against version [ %d ]\n\n", queryVersion)
+		}
+		if end == 0 {
+			end = queryVersion
+		}
+
+		if params.Verify && historyDigest == nil && len(response.Entries) > 0 {
+			for {
+				digest := readLine(fmt.Sprintf("Please, provide the historyDigest for version [ %d ]: ", queryVersion))
+				historyDigest, err = hex.DecodeString(digest)
+				if digest != "" && err == nil {
+					break
+				}
+			}
+		}
+
+		for _, entry := range response.Entries {
+			timestamp := "-"
+			if entry.Timestamp > 0 {
+				timestamp = time.Unix(0, entry.Timestamp).UTC().Format(time.RFC3339Nano)
+			}
+			line := fmt.Sprintf(" %d\t%x\t%s", entry.Version, entry.EventDigest, timestamp)
+			if params.Verify {
+				if client.VerifyVersionEntry(entry, historyDigest) {
+					line += "\tOK"
+				} else {
+					line += "\tKO"
+				}
+			}
+			fmt.Println(line)
+		}
+
+		if response.End >= end {
+			break
+		}
+		start = response.End + 1
+	}
+
+	return nil
+}
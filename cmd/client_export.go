@@ -0,0 +1,229 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/octago/sflags/gen/gpflag"
+	"github.com/spf13/cobra"
+
+	"github.com/bbva/qed/client"
+	"github.com/bbva/qed/log"
+	"github.com/bbva/qed/protocol"
+)
+
+var clientExportCmd *cobra.Command = &cobra.Command{
+	Use:   "export",
+	Short: "Export the log to JSON Lines",
+	Long: `Export the digests of the events of the log, along with the history
+digest at every version, to JSON Lines. The first line is the header of the
+export, with the snapshot of its last version signed by the server, and
+every other line is the entry of a version. An interrupted export to a file
+can be resumed with the resume flag.`,
+	RunE: runClientExport,
+}
+
+var clientExportCtx context.Context
+
+func init() {
+	clientExportCtx = configClientExport()
+	clientCmd.AddCommand(clientExportCmd)
+}
+
+type exportParams struct {
+	From   uint64 `desc:"First version to export"`
+	To     uint64 `desc:"Last version to export (defaults to the last version of the log)"`
+	Output string `desc:"File to write the export to instead of the standard output"`
+	Resume bool   `desc:"Set to resume an interrupted export to the output file"`
+	Verify bool   `desc:"Set to check the export against its signed snapshot"`
+}
+
+// exportPageSize is the number of entries asked to the server at once.
+const exportPageSize = 10000
+
+func configClientExport() context.Context {
+
+	conf := &exportParams{}
+
+	err := gpflag.ParseTo(conf, clientExportCmd.PersistentFlags())
+	if err != nil {
+		log.Fatalf("err: %v", err)
+	}
+	return context.WithValue(Ctx, k("client.export.params"), conf)
+}
+
+func runClientExport(cmd *cobra.Command, args []string) error {
+
+	// SilenceUsage is set to true -> https://github.com/spf13/cobra/issues/340
+	cmd.SilenceUsage = true
+	params := clientExportCtx.Value(k("client.export.params")).(*exportParams)
+
+	if params.Resume && params.Output == "" {
+		return fmt.Errorf("Only exports to an output file can be resumed")
+	}
+	if params.To > 0 && params.To < params.From {
+		return fmt.Errorf("The to version cannot be lower than the from version")
+	}
+
+	config := clientCtx.Value(k("client.config")).(*client.Config)
+	log.SetLogger("client", config.Log)
+
+	client, err := client.NewHTTPClientFromConfig(config)
+	if err != nil {
+		return err
+	}
+
+	var header *protocol.ExportHeader
+	var last *protocol.ExportEntry
+	var out io.Writer = os.Stdout
+	from, to := params.From, params.To
+
+	if params.Output != "" {
+		var f *os.File
+		if params.Resume {
+			f, header, last, err = openExport(params.Output)
+		} else {
+			f, err = os.Create(params.Output)
+		}
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+	if header != nil {
+		from, to = header.From, header.To
+		if last != nil {
+			from = last.Version + 1
+		}
+	}
+
+	w := bufio.NewWriter(out)
+	encoder := json.NewEncoder(w)
+	for from <= to || header == nil {
+		page, entries, cutErr := client.Export(from, to, exportPageSize)
+		if page == nil {
+			return cutErr
+		}
+
+		// the first page pins the last version of the export
+		if header == nil {
+			header, to = page, page.To
+			if err := encoder.Encode(header); err != nil {
+				return err
+			}
+		}
+		for _, entry := range entries {
+			if err := encoder.Encode(entry); err != nil {
+				return err
+			}
+			last = entry
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		if cutErr != nil {
+			if params.Output != "" {
+				return fmt.Errorf("%v, it can be resumed with the resume flag", cutErr)
+			}
+			return cutErr
+		}
+		from = page.End + 1
+	}
+
+	if params.Verify {
+		return verifyExport(client, header, last)
+	}
+
+	return nil
+}
+
+// openExport opens an interrupted export to resume it, returning its
+// header and its last entry. An incomplete last line is dropped.
+func openExport(path string) (*os.File, *protocol.ExportHeader, *protocol.ExportEntry, error) {
+
+	f, err := os.OpenFile(path, os.O_RDWR, 0644)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	var header *protocol.ExportHeader
+	var last *protocol.ExportEntry
+	var offset int64
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err != nil {
+			break
+		}
+		if header == nil {
+			header = new(protocol.ExportHeader)
+			err = json.Unmarshal(line, header)
+		} else {
+			entry := new(protocol.ExportEntry)
+			if err = json.Unmarshal(line, entry); err == nil {
+				last = entry
+			}
+		}
+		if err != nil {
+			f.Close()
+			return nil, nil, nil, fmt.Errorf("Invalid export line at offset %d: %v", offset, err)
+		}
+		offset += int64(len(line))
+	}
+	if header == nil {
+		f.Close()
+		return nil, nil, nil, fmt.Errorf("The export %s has no header", path)
+	}
+
+	if err := f.Truncate(offset); err != nil {
+		f.Close()
+		return nil, nil, nil, err
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, nil, nil, err
+	}
+	return f, header, last, nil
+}
+
+// verifyExport checks that the export ends with the history digest of its
+// signed snapshot, and that the signature is trusted. The results go to
+// the standard error, which does not mix with an export to the standard
+// output, and a failed verification is returned as an error so that the
+// command exits with a non-zero status.
+func verifyExport(client *client.HTTPClient, header *protocol.ExportHeader, last *protocol.ExportEntry) error {
+
+	snapshot := header.Snapshot.Snapshot
+	if last == nil || last.Version != header.To || !bytes.Equal(last.HistoryDigest, snapshot.HistoryDigest) {
+		fmt.Fprintf(os.Stderr, "\nVerify: KO, the export does not end with the history digest of version [ %d ]\n\n", header.To)
+		return fmt.Errorf("verification of the export failed")
+	}
+	if err := client.VerifySignature(header.Snapshot); err != nil {
+		fmt.Fprintf(os.Stderr, "\nVerify: KO, %v\n\n", err)
+		return fmt.Errorf("verification of the export failed: %v", err)
+	}
+	fmt.Fprintf(os.Stderr, "\nVerify: OK\n\n")
+	return nil
+}
@@ -22,7 +22,6 @@ import (
 	"fmt"
 
 	"github.com/bbva/qed/client"
-	"github.com/bbva/qed/hashing"
 	"github.com/bbva/qed/log"
 	"github.com/bbva/qed/protocol"
 	"github.com/octago/sflags/gen/gpflag"
@@ -121,7 +120,11 @@ func runClientIncremental(cmd *cobra.Command, args []string) error {
 		fmt.Printf(" HistoryDigest for start version [ %d ]: %s\n", params.Start, startDigest)
 		fmt.Printf(" HistoryDigest for end version [ %d ]: %s\n", params.End, endDigest)
 
-		if client.VerifyIncremental(proof, startSnapshot, endSnapshot, hashing.NewSha256Hasher()) {
+		ok, err := client.VerifyIncrementalProof(proof, startSnapshot, endSnapshot)
+		if err != nil {
+			return err
+		}
+		if ok {
 			fmt.Printf("\nVerify: OK\n\n")
 		} else {
 			fmt.Printf("\nVerify: KO\n\n")
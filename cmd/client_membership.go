@@ -70,8 +70,6 @@ func configClientMembership() context.Context {
 
 func runClientMembership(cmd *cobra.Command, args []string) error {
 
-	hasherF := hashing.NewSha256Hasher
-
 	var membershipResult *protocol.MembershipResult
 	var digest hashing.Digest
 	var err error
@@ -81,21 +79,25 @@ func runClientMembership(cmd *cobra.Command, args []string) error {
 	// SilenceUsage is set to true -> https://github.com/spf13/cobra/issues/340
 	cmd.SilenceUsage = true
 
+	config := clientCtx.Value(k("client.config")).(*client.Config)
+
+	client, err := client.NewHTTPClientFromConfig(config)
+	if err != nil {
+		return err
+	}
+
 	if params.EventDigest == "" {
 		fmt.Printf("\nQuerying key [ %s ] with version [ %d ]\n", params.Event, params.Version)
+		hasherF, err := client.HasherF()
+		if err != nil {
+			return err
+		}
 		digest = hasherF().Do([]byte(params.Event))
 	} else {
 		fmt.Printf("\nQuerying digest [ %s ] with version [ %d ]\n", params.EventDigest, params.Version)
 		digest, _ = hex.DecodeString(params.EventDigest)
 	}
 
-	config := clientCtx.Value(k("client.config")).(*client.Config)
-
-	client, err := client.NewHTTPClientFromConfig(config)
-	if err != nil {
-		return err
-	}
-
 	membershipResult, err = client.MembershipDigest(digest, params.Version)
 	if err != nil {
 		return err
@@ -132,7 +134,11 @@ func runClientMembership(cmd *cobra.Command, args []string) error {
 		fmt.Printf("\nVerifying with Snapshot: \n\n EventDigest: %x\n HyperDigest: %s\n HistoryDigest: %s\n Version: %d\n",
 			digest, hyperDigest, historyDigest, params.Version)
 
-		if client.DigestVerify(membershipResult, snapshot, hasherF) {
+		ok, err := client.VerifyMembership(membershipResult, snapshot)
+		if err != nil {
+			return err
+		}
+		if ok {
 			fmt.Printf("\nVerify: OK\n\n")
 		} else {
 			fmt.Printf("\nVerify: KO\n\n")
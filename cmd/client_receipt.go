@@ -0,0 +1,115 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+package cmd
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/octago/sflags/gen/gpflag"
+	"github.com/spf13/cobra"
+
+	"github.com/bbva/qed/client"
+	"github.com/bbva/qed/hashing"
+	"github.com/bbva/qed/log"
+)
+
+var clientReceiptCmd *cobra.Command = &cobra.Command{
+	Use:   "receipt",
+	Short: "Query for a receipt of an event",
+	Long: `Query for a receipt of an event, a self-contained proof that can be
+verified offline with the "qed verify receipt" command.`,
+	RunE: runClientReceipt,
+}
+
+var clientReceiptCtx context.Context
+
+func init() {
+	clientReceiptCtx = configClientReceipt()
+	clientCmd.AddCommand(clientReceiptCmd)
+}
+
+type receiptParams struct {
+	Event        string `desc:"QED event to get the receipt of"`
+	EventDigest  string `desc:"QED event digest to get the receipt of"`
+	LaterVersion uint64 `desc:"Later version to add a consistency proof up to"`
+	Output       string `desc:"File to write the receipt to instead of the standard output"`
+}
+
+func configClientReceipt() context.Context {
+
+	conf := &receiptParams{}
+
+	err := gpflag.ParseTo(conf, clientReceiptCmd.PersistentFlags())
+	if err != nil {
+		log.Fatalf("err: %v", err)
+	}
+	return context.WithValue(Ctx, k("client.receipt.params"), conf)
+}
+
+func runClientReceipt(cmd *cobra.Command, args []string) error {
+
+	var digest hashing.Digest
+
+	params := clientReceiptCtx.Value(k("client.receipt.params")).(*receiptParams)
+
+	// SilenceUsage is set to true -> https://github.com/spf13/cobra/issues/340
+	cmd.SilenceUsage = true
+
+	if params.Event == "" && params.EventDigest == "" {
+		return fmt.Errorf("Either the event or its digest must be provided")
+	}
+
+	config := clientCtx.Value(k("client.config")).(*client.Config)
+	log.SetLogger("client", config.Log)
+
+	client, err := client.NewHTTPClientFromConfig(config)
+	if err != nil {
+		return err
+	}
+
+	if params.EventDigest == "" {
+		hasherF, err := client.HasherF()
+		if err != nil {
+			return err
+		}
+		digest = hasherF().Do([]byte(params.Event))
+	} else {
+		digest, err = hex.DecodeString(params.EventDigest)
+		if err != nil {
+			return fmt.Errorf("Invalid event digest: %v", err)
+		}
+	}
+
+	receipt, err := client.Receipt(digest, params.LaterVersion)
+	if err != nil {
+		return err
+	}
+
+	out, err := json.MarshalIndent(receipt, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if params.Output == "" {
+		fmt.Println(string(out))
+		return nil
+	}
+	return ioutil.WriteFile(params.Output, out, 0644)
+}
@@ -0,0 +1,418 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/octago/sflags/gen/gpflag"
+	"github.com/spf13/cobra"
+
+	"github.com/bbva/qed/client"
+	"github.com/bbva/qed/log"
+	"github.com/bbva/qed/protocol"
+)
+
+var clusterCmd *cobra.Command = &cobra.Command{
+	Use:   "cluster",
+	Short: "Manages the QED cluster through the management API",
+	Long: `Manages the raft cluster of the QED servers through their management
+API. The endpoint can be the management address of any node: the commands
+that only the leader can run are sent to the leader.`,
+	TraverseChildren: true,
+}
+
+var clusterCtx context.Context = configCluster()
+
+func init() {
+	Root.AddCommand(clusterCmd)
+	clusterCmd.AddCommand(
+		&cobra.Command{
+			Use:   "status",
+			Short: "Prints the raft and FSM state of every node",
+			Args:  cobra.NoArgs,
+			RunE:  runClusterStatus,
+		},
+		&cobra.Command{
+			Use:   "nodes",
+			Short: "Lists the nodes of the cluster and their metadata",
+			Args:  cobra.NoArgs,
+			RunE:  runClusterNodes,
+		},
+		&cobra.Command{
+			Use:   "leave",
+			Short: "Removes the node of the endpoint from the cluster",
+			Args:  cobra.NoArgs,
+			RunE:  runClusterLeave,
+		},
+		&cobra.Command{
+			Use:   "remove <node id>",
+			Short: "Removes a node from the cluster",
+			Args:  cobra.ExactArgs(1),
+			RunE:  runClusterRemove,
+		},
+		&cobra.Command{
+			Use:   "transfer-leader [node id]",
+			Short: "Hands the leadership over to the given node, or to the most up to date follower",
+			Args:  cobra.MaximumNArgs(1),
+			RunE:  runClusterTransferLeader,
+		},
+		&cobra.Command{
+			Use:   "snapshot",
+			Short: "Makes the node of the endpoint take a raft snapshot",
+			Args:  cobra.NoArgs,
+			RunE:  runClusterSnapshot,
+		},
+		&cobra.Command{
+			Use:   "metadata <node id> [key=value...]",
+			Short: "Prints or adds metadata of a node",
+			Args:  cobra.MinimumNArgs(1),
+			RunE:  runClusterMetadata,
+		},
+	)
+}
+
+type clusterConfig struct {
+	// Management endpoint of any node of the cluster.
+	Endpoint string `desc:"QED management endpoint http://ip:port"`
+
+	// API key with the admin role.
+	APIKey string `desc:"Set API Key with the admin role to talk to the management API"`
+
+	// Insecure disables the verification of the server's certificate chain.
+	Insecure bool `desc:"Set it to true to disable the verification of the server's certificate chain"`
+
+	// Certificates to talk to a management API that requires TLS.
+	CACertificate        string `desc:"Path to the CA certificate that signs the server certificates"`
+	ClientCertificate    string `desc:"Path to the client certificate presented to the server"`
+	ClientCertificateKey string `desc:"Path to the private key of the client certificate"`
+
+	// Timeout of each request.
+	Timeout time.Duration `desc:"Timeout of each request to the management API"`
+}
+
+func configCluster() context.Context {
+
+	conf := &clusterConfig{
+		Endpoint: "http://127.0.0.1:8700",
+		Timeout:  10 * time.Second,
+	}
+
+	err := gpflag.ParseTo(conf, clusterCmd.PersistentFlags())
+	if err != nil {
+		log.Fatalf("err: %v", err)
+	}
+	return context.WithValue(Ctx, k("cluster.config"), conf)
+}
+
+// mgmtClient talks to the management API of the nodes of the cluster.
+type mgmtClient struct {
+	conf   *clusterConfig
+	scheme string
+	http   *http.Client
+}
+
+func newMgmtClient(cmd *cobra.Command) (*mgmtClient, error) {
+
+	// SilenceUsage is set to true -> https://github.com/spf13/cobra/issues/340
+	cmd.SilenceUsage = true
+
+	conf := clusterCtx.Value(k("cluster.config")).(*clusterConfig)
+
+	endpoint, err := url.Parse(conf.Endpoint)
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid endpoint %q", conf.Endpoint)
+	}
+
+	tlsConfig, err := client.NewTLSConfig(conf.CACertificate, conf.ClientCertificate, conf.ClientCertificateKey, conf.Insecure)
+	if err != nil {
+		return nil, err
+	}
+
+	return &mgmtClient{
+		conf:   conf,
+		scheme: endpoint.Scheme,
+		http: &http.Client{
+			Timeout:   conf.Timeout,
+			Transport: &http.Transport{TLSClientConfig: tlsConfig},
+		},
+	}, nil
+}
+
+// do sends a request to the given management endpoint and decodes the
+// response into out, if any.
+func (c *mgmtClient) do(method, endpoint, path string, body, out interface{}) error {
+	var payload []byte
+	if body != nil {
+		var err error
+		payload, err = json.Marshal(body)
+		if err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequest(method, strings.TrimSuffix(endpoint, "/")+path, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Api-Key", c.conf.APIKey)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	buf, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s %s failed [status=%d]: %s", method, path, resp.StatusCode, bytes.TrimSpace(buf))
+	}
+	if out != nil {
+		return json.Unmarshal(buf, out)
+	}
+	return nil
+}
+
+func (c *mgmtClient) nodes() ([]protocol.ClusterNode, error) {
+	var nodes protocol.NodesResponse
+	if err := c.do("GET", c.conf.Endpoint, "/cluster/nodes", nil, &nodes); err != nil {
+		return nil, err
+	}
+	return nodes.Nodes, nil
+}
+
+// nodeEndpoint returns the management endpoint that the node registered
+// when it joined the cluster.
+func (c *mgmtClient) nodeEndpoint(node protocol.ClusterNode) (string, error) {
+	addr, ok := node.Metadata["MgmtAddr"]
+	if !ok {
+		return "", fmt.Errorf("unknown management address of node %s", node.ID)
+	}
+	return fmt.Sprintf("%s://%s", c.scheme, addr), nil
+}
+
+// leaderEndpoint returns the management endpoint of the leader.
+func (c *mgmtClient) leaderEndpoint() (string, error) {
+	nodes, err := c.nodes()
+	if err != nil {
+		return "", err
+	}
+	for _, node := range nodes {
+		if node.Leader {
+			return c.nodeEndpoint(node)
+		}
+	}
+	return "", fmt.Errorf("the cluster has no leader")
+}
+
+func runClusterStatus(cmd *cobra.Command, args []string) error {
+	c, err := newMgmtClient(cmd)
+	if err != nil {
+		return err
+	}
+
+	nodes, err := c.nodes()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSTATE\tTERM\tLAST LOG\tCOMMIT\tAPPLIED\tSNAPSHOT\tVERSION\tHASHER\tFORMAT\tACTIVE KEY")
+	for _, node := range nodes {
+		var status protocol.NodeStatus
+		endpoint, err := c.nodeEndpoint(node)
+		if err == nil {
+			err = c.do("GET", endpoint, "/cluster/status", nil, &status)
+		}
+		if err != nil {
+			fmt.Fprintf(w, "%s\tUnreachable: %v\n", node.ID, err)
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t%d\t%d\t%d\t%s\t%d\t%s\n",
+			status.ID, status.State, status.Term, status.LastLogIndex, status.CommitIndex,
+			status.AppliedIndex, status.LastSnapshotIndex, status.FSM.Version,
+			status.FSM.Hasher, status.FSM.Format, status.FSM.ActiveKeyID)
+	}
+	return w.Flush()
+}
+
+func runClusterNodes(cmd *cobra.Command, args []string) error {
+	c, err := newMgmtClient(cmd)
+	if err != nil {
+		return err
+	}
+
+	nodes, err := c.nodes()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tRAFT ADDR\tROLE\tVOTER\tMETADATA")
+	for _, node := range nodes {
+		role := "follower"
+		if node.Leader {
+			role = "leader"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%t\t%s\n", node.ID, node.Addr, role, node.Voter, formatMetadata(node.Metadata))
+	}
+	return w.Flush()
+}
+
+func runClusterLeave(cmd *cobra.Command, args []string) error {
+	c, err := newMgmtClient(cmd)
+	if err != nil {
+		return err
+	}
+
+	var status protocol.NodeStatus
+	if err := c.do("GET", c.conf.Endpoint, "/cluster/status", nil, &status); err != nil {
+		return err
+	}
+
+	return removeNode(c, status.ID)
+}
+
+func runClusterRemove(cmd *cobra.Command, args []string) error {
+	c, err := newMgmtClient(cmd)
+	if err != nil {
+		return err
+	}
+	return removeNode(c, args[0])
+}
+
+func removeNode(c *mgmtClient, id string) error {
+	leader, err := c.leaderEndpoint()
+	if err != nil {
+		return err
+	}
+
+	body := map[string]string{"ID": id}
+	if err := c.do("POST", leader, "/cluster/remove", body, nil); err != nil {
+		return err
+	}
+	fmt.Printf("Node %s removed from the cluster\n", id)
+	return nil
+}
+
+func runClusterTransferLeader(cmd *cobra.Command, args []string) error {
+	c, err := newMgmtClient(cmd)
+	if err != nil {
+		return err
+	}
+
+	leader, err := c.leaderEndpoint()
+	if err != nil {
+		return err
+	}
+
+	body := map[string]string{}
+	if len(args) > 0 {
+		body["ID"] = args[0]
+	}
+	if err := c.do("POST", leader, "/cluster/transfer-leader", body, nil); err != nil {
+		return err
+	}
+	fmt.Println("Leadership transferred")
+	return nil
+}
+
+func runClusterSnapshot(cmd *cobra.Command, args []string) error {
+	c, err := newMgmtClient(cmd)
+	if err != nil {
+		return err
+	}
+
+	if err := c.do("POST", c.conf.Endpoint, "/cluster/snapshot", nil, nil); err != nil {
+		return err
+	}
+	fmt.Println("Snapshot taken")
+	return nil
+}
+
+func runClusterMetadata(cmd *cobra.Command, args []string) error {
+	c, err := newMgmtClient(cmd)
+	if err != nil {
+		return err
+	}
+
+	id := args[0]
+
+	// without key-value pairs, print the metadata of the node
+	if len(args) == 1 {
+		nodes, err := c.nodes()
+		if err != nil {
+			return err
+		}
+		for _, node := range nodes {
+			if node.ID == id {
+				fmt.Println(formatMetadata(node.Metadata))
+				return nil
+			}
+		}
+		return fmt.Errorf("node %s is not in the cluster", id)
+	}
+
+	metadata := make(map[string]string)
+	for _, arg := range args[1:] {
+		kv := strings.SplitN(arg, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return fmt.Errorf("invalid metadata %q, expected key=value", arg)
+		}
+		metadata[kv[0]] = kv[1]
+	}
+
+	leader, err := c.leaderEndpoint()
+	if err != nil {
+		return err
+	}
+
+	body := map[string]interface{}{"ID": id, "Metadata": metadata}
+	if err := c.do("POST", leader, "/cluster/metadata", body, nil); err != nil {
+		return err
+	}
+	fmt.Printf("Metadata of node %s updated\n", id)
+	return nil
+}
+
+func formatMetadata(metadata map[string]string) string {
+	keys := make([]string, 0, len(metadata))
+	for k := range metadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, k+"="+metadata[k])
+	}
+	return strings.Join(pairs, ",")
+}
@@ -0,0 +1,196 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/spf13/cobra"
+
+	"github.com/bbva/qed/client"
+	"github.com/bbva/qed/log"
+	"github.com/bbva/qed/server"
+)
+
+var serverBackupCmd *cobra.Command = &cobra.Command{
+	Use:   "backup",
+	Short: "Takes a backup of a running QED server",
+	Long: `Takes a consistent backup of a running QED server through its management
+API, which needs an API key with the admin role. The last snapshot of the
+log is signed by the server, so that restores can check the backup.
+
+With --until, the backup holds the log as it was at that version. With
+--from, the backup is incremental and holds the versions from that one on,
+to be restored on top of a backup ending right before it.`,
+	Args: cobra.NoArgs,
+	RunE: runServerBackup,
+}
+
+var serverRestoreCmd *cobra.Command = &cobra.Command{
+	Use:   "restore",
+	Short: "Rebuilds the database of an offline QED server from a backup",
+	Long: `Rebuilds the database of an offline QED server from a backup. The raft
+directory must be empty, and so must be the database directory unless the
+backup is incremental, in which case it must hold the log restored from
+the previous backups. The backup is restored into a copy of the database,
+whose history and hyper digests are rebuilt from the leaves of both trees
+and checked against the signed snapshot of the backup. The copy only
+replaces the database if they match. The signature of the snapshot must
+verify with a signing key of the server configuration or with one of the
+public keys given with --trusted-keys. The restored server starts a new
+cluster.`,
+	Args: cobra.NoArgs,
+	RunE: runServerRestore,
+}
+
+var (
+	serverBackupOut   string
+	serverBackupFrom  uint64
+	serverBackupUntil uint64
+	serverRestoreIn   string
+	serverRestoreKeys []string
+)
+
+func init() {
+
+	serverBackupCmd.Flags().StringVar(&serverBackupOut, "out", "", "File to write the backup to")
+	serverBackupCmd.MarkFlagRequired("out")
+	serverBackupCmd.Flags().Uint64Var(&serverBackupFrom, "from", 0, "First version of an incremental backup")
+	serverBackupCmd.Flags().Uint64Var(&serverBackupUntil, "until", 0, "Last version of the backup (defaults to the last version of the log)")
+	serverRestoreCmd.Flags().StringVar(&serverRestoreIn, "in", "", "Backup file to restore")
+	serverRestoreCmd.MarkFlagRequired("in")
+	serverRestoreCmd.Flags().StringSliceVar(&serverRestoreKeys, "trusted-keys", nil, "Paths to the public keys whose backup signatures are trusted")
+
+	serverCmd.AddCommand(serverBackupCmd, serverRestoreCmd)
+}
+
+func runServerBackup(cmd *cobra.Command, args []string) error {
+
+	// SilenceUsage is set to true -> https://github.com/spf13/cobra/issues/340
+	cmd.SilenceUsage = true
+
+	conf := serverCtx.Value(k("server.config")).(*server.Config)
+	log.SetLogger("server", conf.Log)
+
+	// nodes present their server certificate as client certificate too
+	scheme := "http"
+	var tlsConfig *tls.Config
+	if conf.EnableMgmtTLS {
+		var err error
+		scheme = "https"
+		tlsConfig, err = client.NewTLSConfig(conf.SSLCACertificate, conf.SSLCertificate, conf.SSLCertificateKey, false)
+		if err != nil {
+			return err
+		}
+	}
+
+	query := url.Values{}
+	if cmd.Flags().Changed("from") {
+		query.Set("from", strconv.FormatUint(serverBackupFrom, 10))
+	}
+	if cmd.Flags().Changed("until") {
+		query.Set("until", strconv.FormatUint(serverBackupUntil, 10))
+	}
+	endpoint := fmt.Sprintf("%s://%s/backup", scheme, conf.MgmtAddr)
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequest("GET", endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Api-Key", conf.APIKey)
+
+	httpClient := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		buf, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("backup failed [status=%d]: %s", resp.StatusCode, bytes.TrimSpace(buf))
+	}
+
+	// the backup is written to a temporary file first, so that an
+	// interrupted backup is never taken for a complete one
+	tmp, err := ioutil.TempFile(filepath.Dir(serverBackupOut), filepath.Base(serverBackupOut)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	size, err := io.Copy(tmp, resp.Body)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("backup failed: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), serverBackupOut); err != nil {
+		return err
+	}
+
+	fmt.Printf("Backup of %s written to %s (%d bytes)\n", conf.MgmtAddr, serverBackupOut, size)
+	return nil
+}
+
+func runServerRestore(cmd *cobra.Command, args []string) error {
+
+	// SilenceUsage is set to true -> https://github.com/spf13/cobra/issues/340
+	cmd.SilenceUsage = true
+
+	conf := serverCtx.Value(k("server.config")).(*server.Config)
+	log.SetLogger("server", conf.Log)
+
+	in, err := os.Open(serverRestoreIn)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	signed, err := server.Restore(conf, in, serverRestoreKeys)
+	if err != nil {
+		return fmt.Errorf("restore failed: %v", err)
+	}
+
+	fmt.Printf("\nRestored %s into %s\n", serverRestoreIn, conf.DBPath)
+	if signed == nil {
+		fmt.Printf("The backup holds an empty log.\n")
+		return nil
+	}
+	fmt.Printf("The restored log matches the snapshot signed by key %s:\n\n", signed.KeyID)
+	fmt.Printf(" Version: %d\n", signed.Snapshot.Version)
+	fmt.Printf(" HistoryDigest: %x\n", signed.Snapshot.HistoryDigest)
+	fmt.Printf(" HyperDigest: %x\n", signed.Snapshot.HyperDigest)
+	fmt.Printf(" Timestamp: %d\n\n", signed.Snapshot.Timestamp)
+	return nil
+}
@@ -0,0 +1,126 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/octago/sflags/gen/gpflag"
+	"github.com/spf13/cobra"
+
+	"github.com/bbva/qed/hashing"
+	"github.com/bbva/qed/log"
+	"github.com/bbva/qed/protocol"
+	"github.com/bbva/qed/sign"
+)
+
+var verifyCmd *cobra.Command = &cobra.Command{
+	Use:   "verify",
+	Short: "Verifies QED proofs offline",
+}
+
+var verifyReceiptCmd *cobra.Command = &cobra.Command{
+	Use:   "receipt",
+	Short: "Verify a receipt",
+	Long: `Verify a receipt of an event without contacting any server. It checks
+the signatures of the snapshots with the given public key, the membership
+proof of the event and, if present, the consistency proof.`,
+	RunE: runVerifyReceipt,
+}
+
+var verifyReceiptCtx context.Context
+
+func init() {
+	verifyReceiptCtx = configVerifyReceipt()
+	verifyCmd.AddCommand(verifyReceiptCmd)
+	Root.AddCommand(verifyCmd)
+}
+
+type verifyReceiptParams struct {
+	Receipt       string `desc:"File with the receipt to verify"`
+	PublicKeyPath string `desc:"Public key of the server that signed the receipt, in OpenSSH or PEM format"`
+	Event         string `desc:"QED event that the receipt should belong to"`
+}
+
+func configVerifyReceipt() context.Context {
+
+	conf := &verifyReceiptParams{}
+
+	err := gpflag.ParseTo(conf, verifyReceiptCmd.PersistentFlags())
+	if err != nil {
+		log.Fatalf("err: %v", err)
+	}
+	return context.WithValue(Ctx, k("verify.receipt.params"), conf)
+}
+
+func runVerifyReceipt(cmd *cobra.Command, args []string) error {
+
+	params := verifyReceiptCtx.Value(k("verify.receipt.params")).(*verifyReceiptParams)
+
+	// SilenceUsage is set to true -> https://github.com/spf13/cobra/issues/340
+	cmd.SilenceUsage = true
+
+	if params.Receipt == "" || params.PublicKeyPath == "" {
+		return fmt.Errorf("Both the receipt and the public key must be provided")
+	}
+
+	data, err := ioutil.ReadFile(params.Receipt)
+	if err != nil {
+		return err
+	}
+	var receipt protocol.Receipt
+	if err := json.Unmarshal(data, &receipt); err != nil {
+		return fmt.Errorf("Invalid receipt: %v", err)
+	}
+
+	verifier, err := sign.NewVerifierFromFile(params.PublicKeyPath)
+	if err != nil {
+		return err
+	}
+
+	if params.Event != "" {
+		hasherF, err := hashing.NewHasherF(receipt.Hasher)
+		if err != nil {
+			return fmt.Errorf("%s: %s", err, receipt.Hasher)
+		}
+		if !bytes.Equal(hasherF().Do([]byte(params.Event)), receipt.EventDigest) {
+			fmt.Printf("\nVerify: KO\n\n")
+			return fmt.Errorf("The receipt does not belong to the event")
+		}
+	}
+
+	fmt.Printf("\nVerifying receipt with values:\n\n")
+	fmt.Printf(" EventDigest: %x\n", receipt.EventDigest)
+	fmt.Printf(" KeyID: %s\n", receipt.KeyID)
+	if receipt.Snapshot != nil && receipt.Snapshot.Snapshot != nil {
+		fmt.Printf(" Version: %d\n", receipt.Snapshot.Snapshot.Version)
+	}
+	if receipt.LaterSnapshot != nil && receipt.LaterSnapshot.Snapshot != nil {
+		fmt.Printf(" LaterVersion: %d\n", receipt.LaterSnapshot.Snapshot.Version)
+	}
+
+	if err := receipt.Verify(verifier); err != nil {
+		fmt.Printf("\nVerify: KO\n\n")
+		return err
+	}
+
+	fmt.Printf("\nVerify: OK\n\n")
+	return nil
+}
@@ -24,6 +24,7 @@ import (
 	"github.com/bbva/qed/client"
 	"github.com/bbva/qed/log"
 	"github.com/bbva/qed/metrics"
+	"github.com/bbva/qed/sign"
 	"github.com/hashicorp/memberlist"
 	"github.com/prometheus/client_golang/prometheus"
 )
@@ -95,6 +96,11 @@ type Agent struct {
 	// Client to a running QED
 	Qed *client.HTTPClient
 
+	// Verifier checks the signatures of the snapshots
+	// received from the gossip network. If nil, they are
+	// rejected unless the verification is skipped explicitly.
+	Verifier SignatureVerifier
+
 	//Client to a notification service
 	Notifier Notifier
 
@@ -103,17 +109,29 @@ type Agent struct {
 
 	//Client to a task manager service
 	Tasks TasksManager
+
+	// identity signs the messages originated by this agent
+	identity sign.Signer
+
+	// peerKeys is the allow list of peers whose messages
+	// are accepted. If empty, messages are not verified.
+	peerKeys PeerKeys
+
+	// encryptionKeys are the keys used to encrypt the
+	// gossip traffic. The first one is the primary key.
+	encryptionKeys [][]byte
 }
 
 // Creates new agent from a configuration object
 // It does not create external clients like QED, SnapshotStore or Notifier, nor
 // a task manager.
-func NewAgentFromConfig(conf *Config) (agent *Agent, err error) {
+// Extra options are applied after the ones of the configuration.
+func NewAgentFromConfig(conf *Config, extra ...AgentOptionF) (agent *Agent, err error) {
 	options, err := configToOptions(conf)
 	if err != nil {
 		return nil, err
 	}
-	return NewAgent(options...)
+	return NewAgent(append(options, extra...)...)
 }
 
 // Returns a new agent with all the APIs initialized and
@@ -124,6 +142,17 @@ func NewDefaultAgent(conf *Config, qed *client.HTTPClient, s SnapshotStore, t Ta
 		return nil, err
 	}
 	options = append(options, SetQEDClient(qed), SetSnapshotStore(s), SetTasksManager(t), SetNotifier(n))
+	if conf == nil || (len(conf.TrustedPeersKeysPaths) == 0 && !conf.InsecureSkipPeerVerification) {
+		return nil, ErrNoTrustedPeers
+	}
+	if qed != nil && qed.HasTrustedKeys() {
+		options = append(options, SetSignatureVerifier(qed))
+	} else if qed != nil {
+		if conf == nil || !conf.InsecureSkipSignatureVerification {
+			return nil, ErrNoTrustedKeys
+		}
+		log.Infof("The QED client has no trusted keys: the snapshot signatures will not be verified")
+	}
 
 	return NewAgent(options...)
 }
@@ -169,6 +198,17 @@ func NewAgent(options ...AgentOptionF) (*Agent, error) {
 	agent.config.MemberlistConfig.Name = agent.config.NodeName
 	agent.config.MemberlistConfig.Logger = log.GetLogger()
 
+	// Encrypt the gossip traffic if there are keys
+	if len(agent.encryptionKeys) > 0 {
+		keyring, err := memberlist.NewKeyring(agent.encryptionKeys, agent.encryptionKeys[0])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid encryption keys: %s", err)
+		}
+		agent.config.MemberlistConfig.Keyring = keyring
+		agent.config.MemberlistConfig.GossipVerifyIncoming = true
+		agent.config.MemberlistConfig.GossipVerifyOutgoing = true
+	}
+
 	// Configure delegates
 	agent.config.MemberlistConfig.Delegate = newAgentDelegate(agent)
 	agent.config.MemberlistConfig.Events = &eventDelegate{agent}
@@ -298,6 +338,14 @@ func (a *Agent) Send(msg *Message) {
 		return
 	}
 
+	// sign the messages originated by this agent
+	if msg.Signature == nil && a.identity != nil {
+		if err := msg.Sign(a.config.NodeName, a.identity); err != nil {
+			log.Infof("Agent Send unable to sign message: %v", err)
+			return
+		}
+	}
+
 	msg.TTL--
 	wire, err := msg.Encode()
 	if err != nil {
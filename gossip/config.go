@@ -118,6 +118,30 @@ type Config struct {
 	// Cache size in bytes to store agent temporal objects.
 	// This cache will evict old objects by default
 	CacheSize int `desc:"Cache size in bytes to store agent temporal objects"`
+
+	// EncryptionKeys are the base64 encoded keys used to encrypt the
+	// gossip traffic. The first one is the primary key. If empty, the
+	// traffic is not encrypted.
+	EncryptionKeys []string `desc:"Base64 encoded gossip encryption keys (16, 24 or 32 bytes), the first one is the primary key"`
+
+	// IdentityKeyPath is the private key that signs the messages
+	// originated by this agent.
+	IdentityKeyPath string `desc:"Path to the private key that signs the messages originated by this agent"`
+
+	// InsecureSkipSignatureVerification lets the agents that process the
+	// snapshots run without trusted keys, accepting the snapshots without
+	// verifying their signatures. Otherwise, they refuse to start.
+	InsecureSkipSignatureVerification bool `desc:"Accept the snapshots without verifying their signatures if there are no trusted keys"`
+
+	// TrustedPeersKeysPaths are the public keys of the peers whose
+	// messages are accepted. If empty, every message is dropped unless
+	// the verification of the peers is skipped explicitly.
+	TrustedPeersKeysPaths []string `desc:"Public keys of the peers whose messages are accepted"`
+
+	// InsecureSkipPeerVerification lets the agent accept the messages of
+	// any peer when it has no trusted peer keys. Otherwise, the agents that
+	// process the snapshots refuse to start and the rest drop the messages.
+	InsecureSkipPeerVerification bool `desc:"Accept the gossip messages of any peer if there are no trusted peer keys"`
 }
 
 // AddrParts returns the parts of the BindAddr that should be
@@ -82,6 +82,18 @@ func (d *agentDelegate) NotifyMsg(msg []byte) {
 	err := m.Decode(msg)
 	if err != nil {
 		log.Infof("Agent Deletage unable to decode gossip message!: %v", err)
+		return
+	}
+	// without trusted peers every message is dropped, unless the
+	// verification is skipped explicitly
+	if len(d.agent.peerKeys) > 0 {
+		if err := d.agent.peerKeys.Verify(m); err != nil {
+			log.Infof("Agent Delegate dropping message from %s: %v", m.Origin, err)
+			return
+		}
+	} else if !d.agent.config.InsecureSkipPeerVerification {
+		log.Infof("Agent Delegate dropping message from %s: %v", m.Origin, ErrNoTrustedPeers)
+		return
 	}
 	d.agent.In.Publish(m)
 }
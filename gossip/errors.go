@@ -19,3 +19,8 @@ import "errors"
 
 var ChTimedOut error = errors.New("Timeout sending data to channel")
 var NoSubscribersFound error = errors.New("No subscribers found")
+var ErrUntrustedPeer error = errors.New("Message not signed by a trusted peer")
+var ErrInvalidSignature error = errors.New("Invalid message signature")
+var ErrEncryptionDisabled error = errors.New("Gossip encryption is not enabled")
+var ErrNoTrustedPeers error = errors.New("No trusted peer keys to verify the gossip messages, set them or skip the verification explicitly")
+var ErrNoTrustedKeys error = errors.New("No trusted keys to verify the snapshot signatures, set them or skip the verification explicitly")
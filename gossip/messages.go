@@ -19,6 +19,8 @@ package gossip
 import (
 	"bytes"
 
+	"github.com/bbva/qed/sign"
+	"github.com/bbva/qed/util"
 	"github.com/hashicorp/go-msgpack/codec"
 )
 
@@ -36,11 +38,52 @@ const (
 )
 
 // Gossip message code. Up to 255 different messages.
+//
+// Messages can be signed by the peer that creates them, the origin, so
+// that agents only accept messages from allow-listed peers. The key ID
+// names the key that signed it.
 type Message struct {
-	Kind    MessageType
-	From    *Peer
-	TTL     int
-	Payload []byte
+	Kind      MessageType
+	From      *Peer
+	TTL       int
+	Payload   []byte
+	Origin    string
+	KeyID     string
+	Signature []byte
+}
+
+// messageSigningPrefix separates the signatures of the gossip messages
+// from those of any other payload signed with the same key, such as the
+// snapshots when a server signs both.
+const messageSigningPrefix = "qed-gossip-message-v1\x00"
+
+// SigningPayload returns the bytes that the origin signs: a prefix of the
+// gossip messages, the kind, the origin and the payload. The TTL and the
+// sender change while the message travels through the gossip network, so
+// they are not signed.
+func (m *Message) SigningPayload() []byte {
+	var buf bytes.Buffer
+	buf.WriteString(messageSigningPrefix)
+	buf.WriteByte(byte(m.Kind))
+	buf.Write(util.Uint16AsBytes(uint16(len(m.Origin))))
+	buf.WriteString(m.Origin)
+	buf.Write(m.Payload)
+	return buf.Bytes()
+}
+
+// Sign signs the message on behalf of the given origin. The key is resolved
+// once, so that the key ID names the key that signs even if a keyring
+// changes its active key meanwhile.
+func (m *Message) Sign(origin string, signer sign.Signer) error {
+	key := sign.ActiveKey(signer)
+	m.Origin = origin
+	m.KeyID = sign.KeyID(key.PublicKey())
+	signature, err := key.Sign(m.SigningPayload())
+	if err != nil {
+		return err
+	}
+	m.Signature = signature
+	return nil
 }
 
 /*
@@ -63,4 +106,3 @@ func (m *Message) Encode() ([]byte, error) {
 func (m *Message) Decode(buf []byte) error {
 	return codec.NewDecoder(bytes.NewReader(buf), msgpackHandle).Decode(m)
 }
-
@@ -18,7 +18,9 @@ package gossip
 
 import (
 	"testing"
+	"time"
 
+	"github.com/bbva/qed/sign"
 	"github.com/stretchr/testify/require"
 )
 
@@ -37,3 +39,84 @@ func TestMessageEncodeDecode(t *testing.T) {
 	require.Equal(t, &m2, m1, "Messages must be equal")
 
 }
+
+func TestMessageSign(t *testing.T) {
+	signer := sign.NewEd25519Signer()
+	other := sign.NewEd25519Signer()
+	peers := PeerKeys{sign.KeyID(signer.PublicKey()): signer}
+
+	m := &Message{
+		Kind:    BatchMessageType,
+		TTL:     2,
+		Payload: []byte{0x01, 0x02},
+	}
+	require.NoError(t, m.Sign("server0", signer), "Signing must end succesfully")
+	require.NoError(t, peers.Verify(m), "The message should verify")
+
+	// the hops can change the ttl and the sender
+	m.TTL--
+	m.From = &Peer{Name: "auditor0"}
+	require.NoError(t, peers.Verify(m), "The message should verify after a hop")
+
+	buff, err := m.Encode()
+	require.NoError(t, err, "Encoding must end succesfully")
+	var decoded Message
+	require.NoError(t, decoded.Decode(buff), "Decoding must end succesfully")
+	require.NoError(t, peers.Verify(&decoded), "The decoded message should verify")
+
+	tampered := *m
+	tampered.Payload = []byte{0x03}
+	require.Equal(t, ErrInvalidSignature, peers.Verify(&tampered), "A tampered payload should not verify")
+
+	tampered = *m
+	tampered.Origin = "server1"
+	require.Equal(t, ErrInvalidSignature, peers.Verify(&tampered), "A tampered origin should not verify")
+
+	require.NoError(t, m.Sign("server0", other), "Signing must end succesfully")
+	require.Equal(t, ErrUntrustedPeer, peers.Verify(m), "A message from an unknown peer should be rejected")
+
+	unsigned := &Message{Kind: BatchMessageType, Payload: []byte{0x01}}
+	require.Equal(t, ErrUntrustedPeer, peers.Verify(unsigned), "An unsigned message should be rejected")
+
+	// keyrings sign with their active key
+	keyring := sign.NewKeyring(func() string { return sign.KeyID(other.PublicKey()) }, signer, other)
+	require.NoError(t, m.Sign("server0", keyring), "Signing must end succesfully")
+	require.Equal(t, sign.KeyID(other.PublicKey()), m.KeyID, "The message should name the active key")
+	peers[m.KeyID] = other
+	require.NoError(t, peers.Verify(m), "The message should verify with the active key")
+
+	// signatures of other payloads are not valid messages
+	signature, err := signer.Sign(m.Payload)
+	require.NoError(t, err)
+	m.KeyID, m.Signature = sign.KeyID(signer.PublicKey()), signature
+	require.Equal(t, ErrInvalidSignature, peers.Verify(m), "The message signature should be domain separated")
+}
+
+func TestNotifyMsgRequiresTrustedPeers(t *testing.T) {
+
+	conf := DefaultConfig()
+	conf.NodeName = "testNode"
+	conf.Role = "auditor"
+	conf.BindAddr = "127.0.0.1:12346"
+
+	a, err := NewAgentFromConfig(conf)
+	require.NoError(t, err, "Error creating agent!")
+	ts := &testSubscriber{}
+	a.In.Subscribe(BatchMessageType, ts, 1)
+
+	m := &Message{Kind: BatchMessageType, TTL: 1, Payload: []byte{0x01}}
+	require.NoError(t, m.Sign("server0", sign.NewEd25519Signer()))
+	wire, err := m.Encode()
+	require.NoError(t, err)
+
+	d := newAgentDelegate(a)
+	d.NotifyMsg(wire)
+	// give time for the bus to route the message
+	time.Sleep(100 * time.Millisecond)
+	require.Len(t, ts.ch, 0, "Agents without trusted peers must drop every message")
+
+	a.config.InsecureSkipPeerVerification = true
+	d.NotifyMsg(wire)
+	time.Sleep(100 * time.Millisecond)
+	require.Len(t, ts.ch, 1, "Agents can skip the verification of the peers explicitly")
+}
@@ -20,6 +20,7 @@ import (
 
 	"github.com/bbva/qed/client"
 	"github.com/bbva/qed/metrics"
+	"github.com/bbva/qed/sign"
 	"github.com/coocood/freecache"
 )
 
@@ -47,6 +48,32 @@ func configToOptions(conf *Config) ([]AgentOptionF, error) {
 		SetMetricsServer(conf.MetricsAddr),
 		SetCache(conf.CacheSize),
 		SetTimeoutQueues(conf.TimeoutQueues),
+		SetInsecureSkipSignatureVerification(conf.InsecureSkipSignatureVerification),
+		SetInsecureSkipPeerVerification(conf.InsecureSkipPeerVerification),
+	}
+
+	if len(conf.EncryptionKeys) > 0 {
+		keys, err := DecodeEncryptionKeys(conf.EncryptionKeys)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, SetEncryptionKeys(keys))
+	}
+
+	if conf.IdentityKeyPath != "" {
+		identity, err := sign.NewSignerFromFile(conf.IdentityKeyPath)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, SetIdentity(identity))
+	}
+
+	if len(conf.TrustedPeersKeysPaths) > 0 {
+		keys, err := LoadPeerKeys(conf.TrustedPeersKeysPaths)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, SetPeerKeys(keys))
 	}
 
 	return options, nil
@@ -151,6 +178,31 @@ func SetQEDClient(qed *client.HTTPClient) AgentOptionF {
 	}
 }
 
+// SetInsecureSkipSignatureVerification lets the agent accept the snapshots
+// without verifying their signatures when it has no verifier.
+func SetInsecureSkipSignatureVerification(skip bool) AgentOptionF {
+	return func(a *Agent) error {
+		a.config.InsecureSkipSignatureVerification = skip
+		return nil
+	}
+}
+
+// SetInsecureSkipPeerVerification lets the agent accept the messages of
+// any peer when it has no trusted peer keys.
+func SetInsecureSkipPeerVerification(skip bool) AgentOptionF {
+	return func(a *Agent) error {
+		a.config.InsecureSkipPeerVerification = skip
+		return nil
+	}
+}
+
+func SetSignatureVerifier(v SignatureVerifier) AgentOptionF {
+	return func(a *Agent) error {
+		a.Verifier = v
+		return nil
+	}
+}
+
 func SetSnapshotStore(store SnapshotStore) AgentOptionF {
 	return func(a *Agent) error {
 		a.SnapshotStore = store
@@ -183,3 +235,28 @@ func SetProcessors(p map[string]Processor) AgentOptionF {
 		return nil
 	}
 }
+
+// SetEncryptionKeys enables the encryption of the gossip traffic. The
+// first key is the primary key.
+func SetEncryptionKeys(keys [][]byte) AgentOptionF {
+	return func(a *Agent) error {
+		a.encryptionKeys = keys
+		return nil
+	}
+}
+
+// SetIdentity sets the key that signs the messages originated by the agent.
+func SetIdentity(s sign.Signer) AgentOptionF {
+	return func(a *Agent) error {
+		a.identity = s
+		return nil
+	}
+}
+
+// SetPeerKeys sets the allow list of peers whose messages are accepted.
+func SetPeerKeys(keys PeerKeys) AgentOptionF {
+	return func(a *Agent) error {
+		a.peerKeys = keys
+		return nil
+	}
+}
@@ -18,6 +18,7 @@ package gossip
 import (
 	"bytes"
 	"context"
+	"fmt"
 
 	"github.com/bbva/qed/hashing"
 	"github.com/bbva/qed/log"
@@ -38,6 +39,13 @@ type Processor interface {
 	Metrics() []prometheus.Collector
 }
 
+// SignatureVerifier checks that a snapshot has been signed
+// by a trusted QED server.
+// Both *client.HTTPClient and client.TrustedKeys implement it.
+type SignatureVerifier interface {
+	VerifySignature(signed *protocol.SignedSnapshot) error
+}
+
 // Reads agents in queue, and generates a
 // *protocol.BatchSnapshots queue.
 // It also calls the tasks factories and enqueue
@@ -101,6 +109,32 @@ func (d *BatchProcessor) wasProcessed(b *protocol.BatchSnapshots) bool {
 	return false
 }
 
+// This function checks the signature of every snapshot in the batch,
+// alerting about the ones that do not verify. Agents without a verifier
+// reject every batch, unless the verification is skipped explicitly.
+func (d *BatchProcessor) isForged(b *protocol.BatchSnapshots) bool {
+	if d.a.Verifier == nil {
+		if d.a.config.InsecureSkipSignatureVerification {
+			return false
+		}
+		log.Infof("BatchProcessor has no trusted keys to verify the snapshot signatures")
+		return true
+	}
+
+	for _, s := range b.Snapshots {
+		err := d.a.Verifier.VerifySignature(s)
+		if err == nil {
+			continue
+		}
+		log.Infof("BatchProcessor got a snapshot with an invalid signature: %v", err)
+		if d.a.Notifier != nil {
+			_ = d.a.Notifier.Alert(fmt.Sprintf("Snapshot signature verification failed: %v", err))
+		}
+		return true
+	}
+	return false
+}
+
 func (d *BatchProcessor) Subscribe(id int, ch <-chan *Message) {
 	d.id = id
 
@@ -130,6 +164,11 @@ func (d *BatchProcessor) Subscribe(id int, ch <-chan *Message) {
 					continue
 				}
 
+				if d.isForged(batch) {
+					log.Infof("BatchProcessor got a batch with invalid signatures. Dropping message.")
+					continue
+				}
+
 				ctx := context.WithValue(d.ctx, "batch", batch)
 				for _, t := range d.tf {
 					log.Debugf("Batch processor creating a new task")
@@ -25,7 +25,10 @@ import (
 	"testing"
 	"time"
 
+	"github.com/bbva/qed/client"
+	"github.com/bbva/qed/hashing"
 	"github.com/bbva/qed/protocol"
+	"github.com/bbva/qed/sign"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/stretchr/testify/require"
 )
@@ -38,6 +41,7 @@ func TestBatchProcessorLoop(t *testing.T) {
 	conf.NodeName = "testNode"
 	conf.Role = "auditor"
 	conf.BindAddr = "127.0.0.1:12345"
+	conf.InsecureSkipSignatureVerification = true
 
 	a, err := NewAgentFromConfig(conf)
 	require.NoError(t, err, "Error creating agent!")
@@ -81,6 +85,7 @@ func TestBatchProcessorWasProcessed(t *testing.T) {
 	conf.NodeName = "testNode"
 	conf.Role = "auditor"
 	conf.BindAddr = "127.0.0.1:12345"
+	conf.InsecureSkipSignatureVerification = true
 
 	a, err := NewAgentFromConfig(conf)
 	require.NoError(t, err, "Error creating agent!")
@@ -109,6 +114,138 @@ func TestBatchProcessorWasProcessed(t *testing.T) {
 	require.Equal(t, 1, len(ts.ch), "Output queue must be 1, duplicate event must be dropped by processor")
 }
 
+type fakeNotifier struct {
+	alerts []string
+}
+
+func (n *fakeNotifier) Alert(msg string) error {
+	n.alerts = append(n.alerts, msg)
+	return nil
+}
+
+func (n *fakeNotifier) Start() {}
+
+func (n *fakeNotifier) Stop() {}
+
+func TestBatchProcessorDropsForgedBatches(t *testing.T) {
+
+	ts := &testSubscriber{}
+	notifier := &fakeNotifier{}
+	trusted := sign.NewEd25519Signer()
+
+	conf := DefaultConfig()
+	conf.NodeName = "testNode"
+	conf.Role = "auditor"
+	conf.BindAddr = "127.0.0.1:12345"
+
+	a, err := NewAgentFromConfig(conf)
+	require.NoError(t, err, "Error creating agent!")
+	a.Notifier = notifier
+	a.Verifier = client.NewTrustedKeys(trusted)
+
+	p := NewBatchProcessor(a, nil)
+	a.In.Subscribe(BatchMessageType, p, 0)
+	defer p.Stop()
+
+	a.Out.Subscribe(BatchMessageType, ts, 5)
+
+	newMessage := func(signer sign.Signer, version uint64) *Message {
+		snapshot := &protocol.Snapshot{Version: version, EventDigest: []byte{0x01}}
+		signed := &protocol.SignedSnapshot{Snapshot: snapshot, Hasher: hashing.Sha256}
+		payload, err := signed.SigningPayload()
+		require.NoError(t, err)
+		signed.Signature, err = signer.Sign(payload)
+		require.NoError(t, err)
+		batch := &protocol.BatchSnapshots{Snapshots: []*protocol.SignedSnapshot{signed}}
+		buf, err := batch.Encode()
+		require.NoError(t, err)
+		return &Message{Kind: BatchMessageType, Payload: buf}
+	}
+
+	a.In.Publish(newMessage(sign.NewEd25519Signer(), 0))
+	a.In.Publish(newMessage(trusted, 1))
+	// give time for the scheduler to route all the messages
+	time.Sleep(1 * time.Second)
+
+	require.Equal(t, 1, len(ts.ch), "Output queue must be 1, forged batch must be dropped by processor")
+	require.Len(t, notifier.alerts, 1, "The forged batch must raise an alert")
+}
+
+func TestDefaultAgentRequiresTrustedKeys(t *testing.T) {
+
+	conf := DefaultConfig()
+	conf.NodeName = "testNode"
+	conf.Role = "auditor"
+	conf.BindAddr = "127.0.0.1:12345"
+	conf.InsecureSkipPeerVerification = true
+
+	newClient := func(keys client.TrustedKeys) *client.HTTPClient {
+		qed, err := client.NewHTTPClient(
+			client.SetURLs("http://127.0.0.1:8800"),
+			client.SetTopologyDiscovery(false),
+			client.SetHealthChecks(false),
+			client.SetTrustedKeys(keys),
+		)
+		require.NoError(t, err)
+		return qed
+	}
+
+	_, err := NewDefaultAgent(conf, newClient(nil), nil, nil, nil)
+	require.Equal(t, ErrNoTrustedKeys, err, "Agents without trusted keys must not start")
+
+	a, err := NewDefaultAgent(conf, newClient(client.NewTrustedKeys(sign.NewEd25519Signer())), nil, nil, nil)
+	require.NoError(t, err, "Error creating agent!")
+	require.NotNil(t, a.Verifier, "Agents with trusted keys must verify the batches")
+
+	conf.InsecureSkipSignatureVerification = true
+	a, err = NewDefaultAgent(conf, newClient(nil), nil, nil, nil)
+	require.NoError(t, err, "Agents can skip the verification explicitly")
+	require.Nil(t, a.Verifier)
+}
+
+func TestDefaultAgentRequiresTrustedPeers(t *testing.T) {
+
+	conf := DefaultConfig()
+	conf.NodeName = "testNode"
+	conf.Role = "auditor"
+	conf.BindAddr = "127.0.0.1:12345"
+	conf.InsecureSkipSignatureVerification = true
+
+	_, err := NewDefaultAgent(conf, nil, nil, nil, nil)
+	require.Equal(t, ErrNoTrustedPeers, err, "Agents without trusted peers must not start")
+
+	conf.InsecureSkipPeerVerification = true
+	_, err = NewDefaultAgent(conf, nil, nil, nil, nil)
+	require.NoError(t, err, "Agents can skip the verification of the peers explicitly")
+}
+
+func TestBatchProcessorDropsUnverifiedBatches(t *testing.T) {
+
+	ts := &testSubscriber{}
+
+	conf := DefaultConfig()
+	conf.NodeName = "testNode"
+	conf.Role = "auditor"
+	conf.BindAddr = "127.0.0.1:12345"
+
+	a, err := NewAgentFromConfig(conf)
+	require.NoError(t, err, "Error creating agent!")
+
+	p := NewBatchProcessor(a, nil)
+	a.In.Subscribe(BatchMessageType, p, 0)
+	defer p.Stop()
+
+	a.Out.Subscribe(BatchMessageType, ts, 5)
+	batch := &protocol.BatchSnapshots{}
+	buf, _ := batch.Encode()
+
+	a.In.Publish(&Message{Kind: BatchMessageType, Payload: buf})
+	// give time for the scheduler to route all the messages
+	time.Sleep(1 * time.Second)
+
+	require.Equal(t, 0, len(ts.ch), "Agents without a verifier must drop every batch")
+}
+
 type fakeTaskFactory struct{}
 
 func (f fakeTaskFactory) Metrics() []prometheus.Collector {
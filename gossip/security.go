@@ -0,0 +1,131 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package gossip
+
+import (
+	"encoding/base64"
+	"fmt"
+
+	"github.com/bbva/qed/sign"
+)
+
+// PeerKeys is the allow list of the peers whose messages an agent
+// accepts, indexed by key ID.
+type PeerKeys map[string]sign.Signer
+
+// LoadPeerKeys reads the public keys of the trusted peers from the given
+// files.
+func LoadPeerKeys(paths []string) (PeerKeys, error) {
+	keys := make(PeerKeys)
+	for _, path := range paths {
+		verifier, err := sign.NewVerifierFromFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to load peer key %s: %v", path, err)
+		}
+		keys[sign.KeyID(verifier.PublicKey())] = verifier
+	}
+	return keys, nil
+}
+
+// Verify checks that the message was signed by one of the trusted peers.
+func (p PeerKeys) Verify(m *Message) error {
+	verifier, ok := p[m.KeyID]
+	if !ok {
+		return ErrUntrustedPeer
+	}
+	valid, err := verifier.Verify(m.SigningPayload(), m.Signature)
+	if err != nil || !valid {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// DecodeEncryptionKeys decodes a list of base64 encoded memberlist
+// encryption keys. Keys must be 16, 24 or 32 bytes long.
+func DecodeEncryptionKeys(encoded []string) ([][]byte, error) {
+	keys := make([][]byte, 0, len(encoded))
+	for _, e := range encoded {
+		key, err := base64.StdEncoding.DecodeString(e)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid encryption key: %v", err)
+		}
+		switch len(key) {
+		case 16, 24, 32:
+		default:
+			return nil, fmt.Errorf("Invalid encryption key: size must be 16, 24 or 32 bytes")
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// EncryptionKeys returns the base64 encoded keys installed in the gossip
+// keyring. The first one is the primary key, used to encrypt messages.
+func (a *Agent) EncryptionKeys() ([]string, error) {
+	keyring := a.config.MemberlistConfig.Keyring
+	if keyring == nil {
+		return nil, ErrEncryptionDisabled
+	}
+	var keys []string
+	for _, k := range keyring.GetKeys() {
+		keys = append(keys, base64.StdEncoding.EncodeToString(k))
+	}
+	return keys, nil
+}
+
+// InstallKey adds a new key to the gossip keyring. The agent decrypts
+// messages with any installed key, so keys are rotated by installing the
+// new key in every agent before using it.
+func (a *Agent) InstallKey(encoded string) error {
+	keyring := a.config.MemberlistConfig.Keyring
+	if keyring == nil {
+		return ErrEncryptionDisabled
+	}
+	keys, err := DecodeEncryptionKeys([]string{encoded})
+	if err != nil {
+		return err
+	}
+	return keyring.AddKey(keys[0])
+}
+
+// UseKey changes the primary key of the gossip keyring. The key must be
+// already installed.
+func (a *Agent) UseKey(encoded string) error {
+	keyring := a.config.MemberlistConfig.Keyring
+	if keyring == nil {
+		return ErrEncryptionDisabled
+	}
+	keys, err := DecodeEncryptionKeys([]string{encoded})
+	if err != nil {
+		return err
+	}
+	return keyring.UseKey(keys[0])
+}
+
+// RemoveKey removes a key from the gossip keyring. The primary key can
+// not be removed.
+func (a *Agent) RemoveKey(encoded string) error {
+	keyring := a.config.MemberlistConfig.Keyring
+	if keyring == nil {
+		return ErrEncryptionDisabled
+	}
+	keys, err := DecodeEncryptionKeys([]string{encoded})
+	if err != nil {
+		return err
+	}
+	return keyring.RemoveKey(keys[0])
+}
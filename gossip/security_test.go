@@ -0,0 +1,81 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package gossip
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeEncryptionKeys(t *testing.T) {
+	testCases := []struct {
+		key         []byte
+		expectedErr bool
+	}{
+		{make([]byte, 16), false},
+		{make([]byte, 24), false},
+		{make([]byte, 32), false},
+		{make([]byte, 8), true},
+	}
+
+	for i, c := range testCases {
+		keys, err := DecodeEncryptionKeys([]string{base64.StdEncoding.EncodeToString(c.key)})
+		if c.expectedErr {
+			require.Error(t, err, "Wrong expected error in test %d.", i)
+			continue
+		}
+		require.NoError(t, err, "Wrong expected error in test %d.", i)
+		require.Equal(t, [][]byte{c.key}, keys, "Wrong decoded keys in test %d.", i)
+	}
+
+	_, err := DecodeEncryptionKeys([]string{"not base64!"})
+	require.Error(t, err, "Invalid base64 keys should fail")
+}
+
+func TestEncryptionKeysRotation(t *testing.T) {
+	oldKey := base64.StdEncoding.EncodeToString([]byte("0123456789abcdef"))
+	newKey := base64.StdEncoding.EncodeToString([]byte("fedcba9876543210"))
+
+	conf := DefaultConfig()
+	conf.NodeName = "testNode"
+	conf.Role = "auditor"
+	conf.BindAddr = "127.0.0.1:12347"
+	conf.EncryptionKeys = []string{oldKey}
+
+	a, err := NewAgentFromConfig(conf)
+	require.NoError(t, err, "Error creating agent!")
+
+	keys, err := a.EncryptionKeys()
+	require.NoError(t, err)
+	require.Equal(t, []string{oldKey}, keys, "Wrong initial keys")
+
+	require.NoError(t, a.InstallKey(newKey), "Error installing the new key")
+	require.NoError(t, a.UseKey(newKey), "Error using the new key")
+	require.Error(t, a.RemoveKey(newKey), "The primary key can not be removed")
+	require.NoError(t, a.RemoveKey(oldKey), "Error removing the old key")
+
+	keys, err = a.EncryptionKeys()
+	require.NoError(t, err)
+	require.Equal(t, []string{newKey}, keys, "Wrong keys after the rotation")
+
+	conf.EncryptionKeys = nil
+	a, err = NewAgentFromConfig(conf)
+	require.NoError(t, err, "Error creating agent!")
+	require.Equal(t, ErrEncryptionDisabled, a.InstallKey(newKey), "Encryption should be disabled")
+}
@@ -0,0 +1,57 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package hashing
+
+// FormatVersion identifies how the leaves and interior nodes of the
+// trees are hashed. It is fixed when a log is bootstrapped.
+type FormatVersion uint16
+
+const (
+	// FormatV0 salts every node hash with the node position.
+	FormatV0 FormatVersion = iota
+
+	// FormatV1 also prefixes leaf hashes with 0x00 and interior hashes
+	// with 0x01, following RFC 6962, so that a leaf can never be taken
+	// for a subtree.
+	FormatV1
+)
+
+// CurrentFormat is the format used by new logs.
+const CurrentFormat = FormatV1
+
+// Prefixes that separate leaf and interior hashes from FormatV1 onwards.
+var (
+	LeafPrefix     = []byte{0x00}
+	InteriorPrefix = []byte{0x01}
+)
+
+// Leaf hashes a leaf value salted with the given position.
+func (f FormatVersion) Leaf(hasher Hasher, salt []byte, value []byte) Digest {
+	if f == FormatV0 {
+		return hasher.Salted(salt, value)
+	}
+	return hasher.Salted(salt, LeafPrefix, value)
+}
+
+// Interior hashes the digests of the children of an interior node salted
+// with the given position.
+func (f FormatVersion) Interior(hasher Hasher, salt []byte, children ...[]byte) Digest {
+	if f == FormatV0 {
+		return hasher.Salted(salt, children...)
+	}
+	return hasher.Salted(salt, append([][]byte{InteriorPrefix}, children...)...)
+}
@@ -0,0 +1,44 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package hashing
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatVersion(t *testing.T) {
+
+	hasher := NewSha256Hasher()
+	salt := []byte{0x0}
+	left := hasher.Do([]byte("left"))
+	right := hasher.Do([]byte("right"))
+	concat := append(append([]byte{}, left...), right...)
+
+	// the legacy format hashes exactly as the hasher does
+	assert.Equal(t, hasher.Salted(salt, concat), FormatV0.Leaf(hasher, salt, concat), "Legacy leaves should not be prefixed")
+	assert.Equal(t, hasher.Salted(salt, left, right), FormatV0.Interior(hasher, salt, left, right), "Legacy interior nodes should not be prefixed")
+
+	// a leaf holding the children of an interior node collides with it
+	// in the legacy format but not in the domain-separated one
+	assert.Equal(t, FormatV0.Leaf(hasher, salt, concat), FormatV0.Interior(hasher, salt, left, right), "Legacy leaves and interior nodes should collide")
+	assert.NotEqual(t, FormatV1.Leaf(hasher, salt, concat), FormatV1.Interior(hasher, salt, left, right), "Leaves and interior nodes should not collide")
+
+	assert.Equal(t, hasher.Do(LeafPrefix, concat, salt), FormatV1.Leaf(hasher, salt, concat), "Leaves should be prefixed with 0x00")
+	assert.Equal(t, hasher.Do(InteriorPrefix, left, right, salt), FormatV1.Interior(hasher, salt, left, right), "Interior nodes should be prefixed with 0x01")
+}
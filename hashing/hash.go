@@ -18,9 +18,64 @@ package hashing
 
 import (
 	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
 	"hash"
+
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/sha3"
+)
+
+// Names of the hashing algorithms a QED log can be built with. The name
+// is recorded in the FSM state at bootstrap and advertised through the
+// server info endpoint.
+const (
+	Sha256     = "sha256"
+	Sha512_256 = "sha512-256"
+	Sha3_256   = "sha3-256"
+	Blake2b256 = "blake2b-256"
 )
 
+// DefaultHasher is the hashing algorithm used when none is specified.
+const DefaultHasher = Sha256
+
+// ErrUnknownHasher is returned when a hasher name is not supported.
+var ErrUnknownHasher = errors.New("unknown hasher")
+
+// NewHasherF returns the hasher constructor registered under the given name.
+func NewHasherF(name string) (func() Hasher, error) {
+	switch name {
+	case Sha256:
+		return NewSha256Hasher, nil
+	case Sha512_256:
+		return NewSha512_256Hasher, nil
+	case Sha3_256:
+		return NewSha3_256Hasher, nil
+	case Blake2b256:
+		return NewBlake2b256Hasher, nil
+	default:
+		return nil, ErrUnknownHasher
+	}
+}
+
+// HasherID returns the identifier of the hashing algorithm registered
+// under the given name. It identifies the algorithm in binary encodings,
+// like the signing payload of the snapshots, and must never change.
+func HasherID(name string) (byte, error) {
+	switch name {
+	case Sha256:
+		return 0x01, nil
+	case Sha512_256:
+		return 0x02, nil
+	case Sha3_256:
+		return 0x03, nil
+	case Blake2b256:
+		return 0x04, nil
+	default:
+		return 0, ErrUnknownHasher
+	}
+}
+
 type Digest []byte
 
 type Hasher interface {
@@ -76,6 +131,77 @@ func (s *Sha256Hasher) Do(data ...[]byte) Digest {
 
 func (s Sha256Hasher) Len() uint16 { return uint16(256) }
 
+type Sha512_256Hasher struct {
+	underlying hash.Hash
+}
+
+func NewSha512_256Hasher() Hasher {
+	return &Sha512_256Hasher{underlying: sha512.New512_256()}
+}
+
+func (s *Sha512_256Hasher) Salted(salt []byte, data ...[]byte) Digest {
+	data = append(data, salt)
+	return s.Do(data...)
+}
+
+func (s *Sha512_256Hasher) Do(data ...[]byte) Digest {
+	s.underlying.Reset()
+	for i := 0; i < len(data); i++ {
+		s.underlying.Write(data[i])
+	}
+	return s.underlying.Sum(nil)[:]
+}
+
+func (s Sha512_256Hasher) Len() uint16 { return uint16(256) }
+
+type Sha3_256Hasher struct {
+	underlying hash.Hash
+}
+
+func NewSha3_256Hasher() Hasher {
+	return &Sha3_256Hasher{underlying: sha3.New256()}
+}
+
+func (s *Sha3_256Hasher) Salted(salt []byte, data ...[]byte) Digest {
+	data = append(data, salt)
+	return s.Do(data...)
+}
+
+func (s *Sha3_256Hasher) Do(data ...[]byte) Digest {
+	s.underlying.Reset()
+	for i := 0; i < len(data); i++ {
+		s.underlying.Write(data[i])
+	}
+	return s.underlying.Sum(nil)[:]
+}
+
+func (s Sha3_256Hasher) Len() uint16 { return uint16(256) }
+
+type Blake2b256Hasher struct {
+	underlying hash.Hash
+}
+
+func NewBlake2b256Hasher() Hasher {
+	// blake2b only fails with keys longer than 64 bytes.
+	underlying, _ := blake2b.New256(nil)
+	return &Blake2b256Hasher{underlying: underlying}
+}
+
+func (s *Blake2b256Hasher) Salted(salt []byte, data ...[]byte) Digest {
+	data = append(data, salt)
+	return s.Do(data...)
+}
+
+func (s *Blake2b256Hasher) Do(data ...[]byte) Digest {
+	s.underlying.Reset()
+	for i := 0; i < len(data); i++ {
+		s.underlying.Write(data[i])
+	}
+	return s.underlying.Sum(nil)[:]
+}
+
+func (s Blake2b256Hasher) Len() uint16 { return uint16(256) }
+
 // PearsonHasher implements the Hasher interface and computes a 8 bit hash
 // function. Handy for testing hash tree implementations.
 type PearsonHasher struct{}
@@ -79,6 +79,73 @@ func TestSha256Hasher(t *testing.T) {
 	}
 }
 
+func TestSha256FamilyHashers(t *testing.T) {
+	tests := map[string]struct {
+		hasherF          func() Hasher
+		salt             []byte
+		eventA           []byte
+		eventB           []byte
+		expectedHashDo   Digest
+		expectedHashSalt Digest
+	}{
+		"SHA-512/256 LeafHash (0,0)": {
+			NewSha512_256Hasher,
+			[]byte{'0'},
+			[]byte(strconv.Itoa((0))),
+			[]byte{},
+			Digest{0xf3, 0x29, 0xa2, 0x59, 0xce, 0x39, 0x70, 0x1e, 0x25, 0x99, 0x56, 0x81, 0x8e, 0x1b, 0x15, 0xee, 0xce, 0xe5, 0x94, 0x60, 0x15, 0x9d, 0x91, 0x58, 0xa5, 0x5a, 0x88, 0x5f, 0xeb, 0x61, 0x21, 0x10},
+			Digest{0x2a, 0xe6, 0xcb, 0x38, 0xf1, 0x5d, 0x9b, 0x79, 0x69, 0x95, 0x61, 0x45, 0xbf, 0xa0, 0x6e, 0x0, 0x55, 0x4c, 0x31, 0x58, 0xd2, 0x10, 0xea, 0xe3, 0xde, 0x64, 0x12, 0x37, 0xd9, 0xca, 0xdb, 0x98}},
+		"SHA3-256 LeafHash (0,0)": {
+			NewSha3_256Hasher,
+			[]byte{'0'},
+			[]byte(strconv.Itoa((0))),
+			[]byte{},
+			Digest{0xf9, 0xe2, 0xea, 0xaa, 0x42, 0xd9, 0xfe, 0x9e, 0x55, 0x8a, 0x9b, 0x8e, 0xf1, 0xbf, 0x36, 0x6f, 0x19, 0xa, 0xac, 0xaa, 0x83, 0xba, 0xd2, 0x64, 0x1e, 0xe1, 0x6, 0xe9, 0x4, 0x10, 0x96, 0xe4},
+			Digest{0x2e, 0x16, 0xaa, 0xb4, 0x83, 0xcb, 0x95, 0x57, 0x7c, 0x50, 0xd3, 0x8c, 0x8d, 0xd, 0x70, 0x40, 0xf4, 0x67, 0x26, 0x83, 0x23, 0x84, 0x46, 0xc9, 0x90, 0xba, 0xbb, 0xca, 0x5a, 0xe1, 0x33, 0xc8}},
+		"BLAKE2b-256 LeafHash (0,0)": {
+			NewBlake2b256Hasher,
+			[]byte{'0'},
+			[]byte(strconv.Itoa((0))),
+			[]byte{},
+			Digest{0xf, 0xd9, 0x23, 0xca, 0x5e, 0x72, 0x18, 0xc4, 0xba, 0x3c, 0x38, 0x1, 0xc2, 0x6a, 0x61, 0x7e, 0xcd, 0xbf, 0xda, 0xeb, 0xb9, 0xc7, 0x6c, 0xe2, 0xec, 0xa1, 0x66, 0xe7, 0x85, 0x5e, 0xfb, 0xb8},
+			Digest{0xcb, 0xc6, 0x3d, 0xc2, 0xac, 0xb8, 0x6b, 0xd8, 0x96, 0x74, 0x53, 0xef, 0x98, 0xfd, 0x4f, 0x2b, 0xe2, 0xf2, 0x6d, 0x73, 0x37, 0xa0, 0x93, 0x79, 0x58, 0x21, 0x1c, 0x12, 0x8a, 0x18, 0xb4, 0x42}},
+	}
+
+	for testname, test := range tests {
+		hasher := test.hasherF()
+		hashSalt := hasher.Salted(test.salt, test.eventA, test.eventB)
+		hashDo := hasher.Do(test.eventA, test.eventB)
+		assert.Equalf(t, uint16(256), hasher.Len(), "Hash length don't match in test: %s", testname)
+		assert.Equalf(t, test.expectedHashDo, hashDo, "Hash Do don't match in test: %s", testname)
+		assert.Equalf(t, test.expectedHashSalt, hashSalt, "Hash Salt don't match in test: %s", testname)
+		assert.NotEqual(t, hashDo, hashSalt, "Do and Salted hashes should NOT match in test: %s", testname)
+	}
+}
+
+func TestNewHasherF(t *testing.T) {
+	for _, name := range []string{Sha256, Sha512_256, Sha3_256, Blake2b256} {
+		hasherF, err := NewHasherF(name)
+		assert.NoErrorf(t, err, "Hasher %s should be supported", name)
+		assert.Equalf(t, 32, len(hasherF().Do([]byte("qed"))), "Hasher %s should produce 32 byte digests", name)
+	}
+
+	_, err := NewHasherF("md5")
+	assert.Equal(t, ErrUnknownHasher, err, "Unknown hashers should be rejected")
+}
+
+func TestHasherID(t *testing.T) {
+	ids := make(map[byte]string)
+	for _, name := range []string{Sha256, Sha512_256, Sha3_256, Blake2b256} {
+		id, err := HasherID(name)
+		assert.NoErrorf(t, err, "Hasher %s should have an ID", name)
+		assert.NotContainsf(t, ids, id, "Hasher %s should have its own ID", name)
+		ids[id] = name
+	}
+
+	_, err := HasherID("md5")
+	assert.Equal(t, ErrUnknownHasher, err, "Unknown hashers should be rejected")
+}
+
 func TestPearsonHasher(t *testing.T) {
 	tests := map[string]struct {
 		salt             []byte
@@ -0,0 +1,59 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package protocol
+
+import "github.com/bbva/qed/hashing"
+
+// NodeStatus is the public struct that the /cluster/status handler returns.
+// It describes the raft and the FSM state of a single node.
+type NodeStatus struct {
+	ID       string
+	Addr     string
+	LeaderID string
+	// State is the raft state of the node: Leader, Follower, Candidate
+	// or Shutdown
+	State             string
+	Term              uint64
+	LastLogIndex      uint64
+	CommitIndex       uint64
+	AppliedIndex      uint64
+	LastSnapshotIndex uint64
+	FSM               FSMStatus
+}
+
+// FSMStatus is the state of the balloon of a node.
+type FSMStatus struct {
+	Version     uint64
+	Hasher      string
+	Format      hashing.FormatVersion
+	ActiveKeyID string `json:",omitempty"`
+}
+
+// ClusterNode is a member of the raft cluster.
+type ClusterNode struct {
+	ID       string
+	Addr     string
+	Voter    bool
+	Leader   bool
+	Metadata map[string]string `json:",omitempty"`
+}
+
+// NodesResponse is the public struct that the /cluster/nodes handler
+// returns.
+type NodesResponse struct {
+	Nodes []ClusterNode
+}
@@ -0,0 +1,110 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package protocol
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// ConsistencyLevel is the consistency that a query requires from the node
+// that answers it.
+type ConsistencyLevel string
+
+const (
+	// Stale queries are answered with whatever the node has applied.
+	Stale ConsistencyLevel = "stale"
+
+	// Bounded queries are answered by nodes that have heard from the
+	// leader within a maximum time, and optionally have less than a
+	// number of events left to apply.
+	Bounded ConsistencyLevel = "bounded"
+
+	// Linearizable queries are answered by the leader, once it has
+	// confirmed its leadership and applied every committed event.
+	Linearizable ConsistencyLevel = "linearizable"
+)
+
+// ReadConsistency is the consistency level of a query along with the
+// bounds of the bounded level. It travels in the query string of the
+// request, as in /proofs/membership?consistency=bounded&max_lag=5s.
+type ReadConsistency struct {
+	Level ConsistencyLevel
+	// MaxLag is the maximum time since the node last heard from the leader.
+	// Bounded queries require it.
+	MaxLag time.Duration
+	// MaxLagVersions is the maximum number of events that the node has
+	// received but not applied yet. It does not count the events the node
+	// has not received, so it only narrows MaxLag, which bounds those.
+	MaxLagVersions uint64
+}
+
+// Values encodes the read consistency as URL query values.
+func (c ReadConsistency) Values() url.Values {
+	values := url.Values{}
+	if c.Level != "" {
+		values.Set("consistency", string(c.Level))
+	}
+	if c.MaxLag > 0 {
+		values.Set("max_lag", c.MaxLag.String())
+	}
+	if c.MaxLagVersions > 0 {
+		values.Set("max_lag_versions", strconv.FormatUint(c.MaxLagVersions, 10))
+	}
+	return values
+}
+
+// ParseReadConsistency decodes the read consistency of the given URL query
+// values. Queries without a consistency level are stale.
+func ParseReadConsistency(values url.Values) (ReadConsistency, error) {
+	var c ReadConsistency
+	var err error
+
+	c.Level = ConsistencyLevel(values.Get("consistency"))
+	if c.Level == "" {
+		c.Level = Stale
+	}
+
+	if v := values.Get("max_lag"); v != "" {
+		c.MaxLag, err = time.ParseDuration(v)
+		if err != nil || c.MaxLag <= 0 {
+			return c, fmt.Errorf("Invalid max_lag parameter")
+		}
+	}
+	if v := values.Get("max_lag_versions"); v != "" {
+		c.MaxLagVersions, err = strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return c, fmt.Errorf("Invalid max_lag_versions parameter")
+		}
+	}
+
+	switch c.Level {
+	case Stale, Linearizable:
+	case Bounded:
+		// a node cut off from the leader has nothing left to apply, so
+		// only the time since it last heard from the leader bounds it
+		if c.MaxLag == 0 {
+			return c, fmt.Errorf("Bounded consistency needs max_lag")
+		}
+	default:
+		return c, fmt.Errorf("Invalid consistency parameter %q", c.Level)
+	}
+
+	return c, nil
+}
@@ -0,0 +1,61 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package protocol
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseReadConsistency(t *testing.T) {
+	testCases := []struct {
+		query       string
+		expected    ReadConsistency
+		expectedErr bool
+	}{
+		{"", ReadConsistency{Level: Stale}, false},
+		{"consistency=stale", ReadConsistency{Level: Stale}, false},
+		{"consistency=linearizable", ReadConsistency{Level: Linearizable}, false},
+		{"consistency=bounded&max_lag=5s", ReadConsistency{Level: Bounded, MaxLag: 5 * time.Second}, false},
+		{"consistency=bounded&max_lag=5s&max_lag_versions=100", ReadConsistency{Level: Bounded, MaxLag: 5 * time.Second, MaxLagVersions: 100}, false},
+		{"consistency=bounded&max_lag_versions=100", ReadConsistency{}, true},
+		{"consistency=bounded", ReadConsistency{}, true},
+		{"consistency=bounded&max_lag=soon", ReadConsistency{}, true},
+		{"consistency=bounded&max_lag_versions=-1", ReadConsistency{}, true},
+		{"consistency=eventual", ReadConsistency{}, true},
+	}
+
+	for i, c := range testCases {
+		values, err := url.ParseQuery(c.query)
+		require.NoError(t, err)
+
+		consistency, err := ParseReadConsistency(values)
+		if c.expectedErr {
+			require.Errorf(t, err, "Wrong expected error in test case %d", i)
+			continue
+		}
+		require.NoErrorf(t, err, "Wrong expected error in test case %d", i)
+		require.Equalf(t, c.expected, consistency, "Wrong read consistency in test case %d", i)
+
+		decoded, err := ParseReadConsistency(consistency.Values())
+		require.NoError(t, err)
+		require.Equalf(t, consistency, decoded, "The encoded read consistency should decode in test case %d", i)
+	}
+}
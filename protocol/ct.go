@@ -0,0 +1,220 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package protocol
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/bbva/qed/balloon/history"
+	"github.com/bbva/qed/hashing"
+	"github.com/bbva/qed/sign"
+	"github.com/bbva/qed/util"
+)
+
+// Constants of the RFC 6962 TreeHeadSignature and MerkleTreeLeaf
+// structures.
+const (
+	ctVersionV1            byte = 0
+	ctSignatureTreeHash    byte = 1
+	ctLeafTimestampedEntry byte = 0
+
+	// RFC 6962 only defines certificate entries, so events are logged with
+	// the experimental JSON entry type that CT libraries use for any other
+	// data.
+	ctJSONEntryType uint16 = 0x8000
+)
+
+// ctSignatureAlgorithms maps the signing algorithms to the hash and
+// signature identifiers of a TLS DigitallySigned struct. RFC 6962 logs
+// sign with ECDSA over SHA-256, so CT libraries only verify the heads
+// signed with ECDSA P-256 keys. The others use the RFC 8446 identifiers.
+var ctSignatureAlgorithms = map[string][2]byte{
+	sign.ECDSAP256: {4, 3},
+	sign.RSAPSS:    {8, 4},
+	sign.Ed25519:   {8, 7},
+}
+
+// TreeHead is the head of the history tree at a given size, following
+// the Certificate Transparency (RFC 6962) naming. A tree of size N holds
+// the events with versions 0 to N-1.
+type TreeHead struct {
+	TreeSize  uint64
+	Timestamp uint64 // milliseconds since the epoch
+	RootHash  hashing.Digest
+}
+
+// NewTreeHead builds the tree head of the history tree described by the
+// given snapshot.
+func NewTreeHead(snapshot *Snapshot, timestamp uint64) *TreeHead {
+	return &TreeHead{
+		TreeSize:  snapshot.Version + 1,
+		Timestamp: timestamp,
+		RootHash:  snapshot.HistoryDigest,
+	}
+}
+
+// Encode returns the bytes to sign for the tree head. They follow the
+// TreeHeadSignature structure of RFC 6962: version, signature type,
+// timestamp, tree size and root hash.
+func (h *TreeHead) Encode() []byte {
+	var b bytes.Buffer
+	b.WriteByte(ctVersionV1)
+	b.WriteByte(ctSignatureTreeHash)
+	b.Write(util.Uint64AsBytes(h.Timestamp))
+	b.Write(util.Uint64AsBytes(h.TreeSize))
+	b.Write(h.RootHash)
+	return b.Bytes()
+}
+
+// SignedTreeHead is the public struct that the get-sth handler returns.
+// The root hash keeps its RFC 6962 name even when the log uses another
+// hashing algorithm, and the signature is a TLS encoded DigitallySigned
+// struct.
+type SignedTreeHead struct {
+	TreeSize          uint64         `json:"tree_size"`
+	Timestamp         uint64         `json:"timestamp"`
+	RootHash          hashing.Digest `json:"sha256_root_hash"`
+	TreeHeadSignature []byte         `json:"tree_head_signature"`
+}
+
+// ToSignedTreeHead adds the signature, made with the given algorithm, to
+// the tree head.
+func ToSignedTreeHead(head *TreeHead, algorithm string, signature []byte) (*SignedTreeHead, error) {
+	ids, ok := ctSignatureAlgorithms[algorithm]
+	if !ok {
+		return nil, fmt.Errorf("unsupported signing algorithm %s", algorithm)
+	}
+	if len(signature) > 0xffff {
+		return nil, errors.New("the signature is too long")
+	}
+
+	var b bytes.Buffer
+	b.Write(ids[:])
+	b.Write(util.Uint16AsBytes(uint16(len(signature))))
+	b.Write(signature)
+
+	return &SignedTreeHead{
+		TreeSize:          head.TreeSize,
+		Timestamp:         head.Timestamp,
+		RootHash:          head.RootHash,
+		TreeHeadSignature: b.Bytes(),
+	}, nil
+}
+
+// Signature returns the signature of the tree head, taken out of its
+// DigitallySigned struct.
+func (s *SignedTreeHead) Signature() ([]byte, error) {
+	ds := s.TreeHeadSignature
+	if len(ds) < 4 || int(util.BytesAsUint16(ds[2:4])) != len(ds)-4 {
+		return nil, errors.New("invalid tree head signature")
+	}
+	return ds[4:], nil
+}
+
+// TreeHead returns the signed part of the signed tree head.
+func (s *SignedTreeHead) TreeHead() *TreeHead {
+	return &TreeHead{
+		TreeSize:  s.TreeSize,
+		Timestamp: s.Timestamp,
+		RootHash:  s.RootHash,
+	}
+}
+
+// ConsistencyResponse is the public struct that the get-sth-consistency
+// handler returns.
+type ConsistencyResponse struct {
+	Consistency []hashing.Digest `json:"consistency"`
+}
+
+// ProofByHashResponse is the public struct that the get-proof-by-hash
+// handler returns.
+type ProofByHashResponse struct {
+	LeafIndex uint64           `json:"leaf_index"`
+	AuditPath []hashing.Digest `json:"audit_path"`
+}
+
+// Entry is a single leaf of the history tree. The leaf input is a TLS
+// encoded MerkleTreeLeaf of RFC 6962, and the index and the stored leaf
+// hash are added to verify the QED proofs. The history leaf hash follows
+// the QED hashing rules, so it is not SHA-256(0x00 || leaf_input).
+type Entry struct {
+	LeafInput       []byte         `json:"leaf_input"`
+	ExtraData       []byte         `json:"extra_data"`
+	LeafIndex       uint64         `json:"leaf_index"`
+	HistoryLeafHash hashing.Digest `json:"history_leaf_hash"`
+}
+
+// EntryData is the JSON data of the MerkleTreeLeaf of an event. The
+// digest is empty for the events logged before the index was kept.
+type EntryData struct {
+	EventDigest hashing.Digest `json:"event_digest"`
+}
+
+// EncodeMerkleTreeLeaf returns the TLS encoding of the MerkleTreeLeaf of
+// an event logged at the given time, in milliseconds since the epoch. It
+// is a timestamped entry of JSON type, without extensions.
+func EncodeMerkleTreeLeaf(eventDigest hashing.Digest, timestamp uint64) ([]byte, error) {
+	data, err := json.Marshal(&EntryData{EventDigest: eventDigest})
+	if err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+	b.WriteByte(ctVersionV1)
+	b.WriteByte(ctLeafTimestampedEntry)
+	b.Write(util.Uint64AsBytes(timestamp))
+	b.Write(util.Uint16AsBytes(ctJSONEntryType))
+	b.Write(util.Uint64AsBytes(uint64(len(data)))[5:]) // 24 bits length
+	b.Write(data)
+	b.Write(util.Uint16AsBytes(0)) // no extensions
+	return b.Bytes(), nil
+}
+
+// EntriesResponse is the public struct that the get-entries handler
+// returns.
+type EntriesResponse struct {
+	Entries []Entry `json:"entries"`
+}
+
+// ToCTAuditPath flattens a history audit path into a list of digests
+// ordered from the bottom of the tree to the top, and from left to right
+// within the same height.
+func ToCTAuditPath(path history.AuditPath) []hashing.Digest {
+
+	keys := make([][10]byte, 0, len(path))
+	for k := range path {
+		keys = append(keys, k)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		hi, hj := util.BytesAsUint16(keys[i][8:]), util.BytesAsUint16(keys[j][8:])
+		if hi != hj {
+			return hi < hj
+		}
+		return util.BytesAsUint64(keys[i][:8]) < util.BytesAsUint64(keys[j][:8])
+	})
+
+	digests := make([]hashing.Digest, 0, len(keys))
+	for _, k := range keys {
+		digests = append(digests, path[k])
+	}
+	return digests
+}
@@ -0,0 +1,59 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package protocol
+
+import (
+	"testing"
+
+	"github.com/bbva/qed/balloon/history"
+	"github.com/bbva/qed/hashing"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTreeHeadEncode(t *testing.T) {
+	snapshot := &Snapshot{
+		HistoryDigest: hashing.Digest{0xaa, 0xbb},
+		Version:       7,
+	}
+	head := NewTreeHead(snapshot, 0x0102)
+
+	expected := []byte{
+		0x00, 0x01, // version and signature type
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x02, // timestamp
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x08, // tree size
+		0xaa, 0xbb, // root hash
+	}
+	assert.Equal(t, expected, head.Encode(), "Wrong tree head encoding")
+}
+
+func TestToCTAuditPath(t *testing.T) {
+	key := func(index uint64, height uint16) (k [10]byte) {
+		k[7] = byte(index)
+		k[9] = byte(height)
+		return
+	}
+
+	path := history.AuditPath{
+		key(0, 3): hashing.Digest{0x04},
+		key(8, 0): hashing.Digest{0x02},
+		key(2, 0): hashing.Digest{0x01},
+		key(4, 2): hashing.Digest{0x03},
+	}
+
+	expected := []hashing.Digest{{0x01}, {0x02}, {0x03}, {0x04}}
+	assert.Equal(t, expected, ToCTAuditPath(path), "Audit path should be ordered by height and index")
+}
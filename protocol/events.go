@@ -28,13 +28,27 @@ import (
 )
 
 // Event is the public struct that Add handler function uses to
-// parse the post params.
+// parse the post params. IfAbsent asks to add the event only if it
+// is not already in the log.
 type Event struct {
-	Event []byte
+	Event    []byte
+	IfAbsent bool
 }
 
 type EventsBulk struct {
-	Events [][]byte
+	Events   [][]byte
+	IfAbsent bool
+}
+
+// EventDigest is the public struct that AddDigest handler function uses
+// to parse the post params. The digest must be computed by the producer
+// with the hasher of the log.
+type EventDigest struct {
+	Digest hashing.Digest
+}
+
+type EventDigestsBulk struct {
+	Digests []hashing.Digest
 }
 
 // MembershipQuery is the public struct that apihttp.Membership
@@ -52,16 +66,91 @@ type MembershipDigest struct {
 }
 
 // Snapshot is the public struct that apihttp.Add Handler call returns.
+// The timestamp is the time, in nanoseconds since the epoch, at which
+// the event was logged. It is zero for events logged before timestamps
+// were recorded.
 type Snapshot struct {
 	EventDigest   hashing.Digest
 	HistoryDigest hashing.Digest
 	HyperDigest   hashing.Digest
 	Version       uint64
+	Timestamp     int64
 }
 
+// AddResult is the public struct that apihttp.Add Handler call returns
+// when the event is added only if absent. The proof is only set when the
+// event was already in the log, and it verifies against the snapshot.
+type AddResult struct {
+	Snapshot *Snapshot
+	Proof    *MembershipResult
+}
+
+// EventPayload is the public struct that apihttp.GetEvent Handler returns
+// for an event whose payload is stored. The proof verifies the event
+// against the snapshot of its version.
+type EventPayload struct {
+	Version     uint64
+	Event       []byte
+	EventDigest hashing.Digest
+	Timestamp   int64
+	Proof       *MembershipResult
+}
+
+// VersionEntry is the public struct that apihttp.EventByVersion Handler
+// returns: the digest of the event logged at a version along with a proof
+// of its membership in the history tree as it was at the query version.
+type VersionEntry struct {
+	Version      uint64
+	EventDigest  hashing.Digest
+	Timestamp    int64
+	QueryVersion uint64
+	AuditPath    map[string]hashing.Digest
+}
+
+// VersionEntries is the public struct that apihttp.EventsByVersion
+// Handler returns. Start and End are the versions covered by the
+// response, which may hold fewer entries when some events were not
+// indexed. Every proof verifies against the history digest of the same
+// query version.
+type VersionEntries struct {
+	Start        uint64
+	End          uint64
+	QueryVersion uint64
+	Entries      []*VersionEntry
+}
+
+// ExportHeader is the first line of the JSON Lines stream that the
+// apihttp.Export Handler returns. The snapshot of the To version is
+// signed, so the history digest of the last entry of a complete export
+// can be checked against it. End is the last version of this response: an
+// export with a limit continues at End+1 while End is lower than To.
+type ExportHeader struct {
+	From     uint64
+	End      uint64
+	To       uint64
+	Snapshot *SignedSnapshot
+}
+
+// ExportEntry is every other line of the apihttp.Export stream: the
+// digest of the event logged at a version, if it was indexed, and the
+// history digest of the log as it was at that version.
+type ExportEntry struct {
+	Version       uint64
+	EventDigest   hashing.Digest
+	HistoryDigest hashing.Digest
+	Timestamp     int64
+}
+
+// SignedSnapshot is a snapshot signed by a QED server. The hasher is
+// the name of the hashing algorithm of the log, which is part of the
+// signed payload, the key ID names the key that signed it and the
+// algorithm, one of the sign package, how it was signed.
 type SignedSnapshot struct {
 	Snapshot  *Snapshot
 	Signature []byte
+	Hasher    string
+	KeyID     string
+	Algorithm string `json:",omitempty"`
 }
 
 func (b *SignedSnapshot) Encode() ([]byte, error) {
@@ -101,15 +190,21 @@ func (b *BatchSnapshots) Decode(msg []byte) error {
 	return err
 }
 
+// MembershipResult is the public struct of a balloon.MembershipProof.
+// When the key does not exist and its path ends at the leaf of another
+// key, ShortcutKey and ShortcutVersion hold that key and its version, and
+// History proves that key was logged at that version.
 type MembershipResult struct {
-	Exists         bool
-	Hyper          map[string]hashing.Digest
-	History        map[string]hashing.Digest
-	CurrentVersion uint64
-	QueryVersion   uint64
-	ActualVersion  uint64
-	KeyDigest      hashing.Digest
-	Key            []byte
+	Exists          bool
+	Hyper           map[string]hashing.Digest
+	History         map[string]hashing.Digest
+	CurrentVersion  uint64
+	QueryVersion    uint64
+	ActualVersion   uint64
+	KeyDigest       hashing.Digest
+	Key             []byte
+	ShortcutKey     hashing.Digest
+	ShortcutVersion uint64
 }
 
 type IncrementalRequest struct {
@@ -132,36 +227,84 @@ func ToMembershipResult(key []byte, mp *balloon.MembershipProof) *MembershipResu
 		serialized = mp.HistoryProof.AuditPath.Serialize()
 	}
 
-	return &MembershipResult{
-		mp.Exists,
-		mp.HyperProof.AuditPath,
-		serialized,
-		mp.CurrentVersion,
-		mp.QueryVersion,
-		mp.ActualVersion,
-		mp.KeyDigest,
-		key,
+	result := &MembershipResult{
+		Exists:         mp.Exists,
+		Hyper:          mp.HyperProof.AuditPath,
+		History:        serialized,
+		CurrentVersion: mp.CurrentVersion,
+		QueryVersion:   mp.QueryVersion,
+		ActualVersion:  mp.ActualVersion,
+		KeyDigest:      mp.KeyDigest,
+		Key:            key,
+	}
+	if mp.HyperProof.ShortcutKey != nil && mp.HistoryProof != nil {
+		result.ShortcutKey = mp.HyperProof.ShortcutKey
+		result.ShortcutVersion = mp.HistoryProof.Index
+	}
+	return result
+}
+
+// ToAddResult translates the result of an add if absent into the public
+// protocol.AddResult.
+func ToAddResult(event []byte, snapshot *balloon.Snapshot, mp *balloon.MembershipProof) *AddResult {
+	s := Snapshot(*snapshot)
+	result := &AddResult{Snapshot: &s}
+	if mp != nil {
+		result.Proof = ToMembershipResult(event, mp)
 	}
+	return result
+}
+
+// ToHistoryProof translates the public protocol.VersionEntry to the
+// internal history.MembershipProof, which verifies the event digest
+// against the history digest of the query version.
+func ToHistoryProof(entry *VersionEntry, hasherF func() hashing.Hasher, format hashing.FormatVersion) *history.MembershipProof {
+	return history.NewMembershipProof(
+		entry.Version,
+		entry.QueryVersion,
+		history.ParseAuditPath(entry.AuditPath),
+		hasherF(),
+		format,
+	)
 }
 
 // ToBaloonProof translate public protocol.MembershipResult to internal
 // balloon.Proof.
-func ToBalloonProof(mr *MembershipResult, hasherF func() hashing.Hasher) *balloon.MembershipProof {
+func ToBalloonProof(mr *MembershipResult, hasherF func() hashing.Hasher, format hashing.FormatVersion) *balloon.MembershipProof {
+
+	// the history proof of a non-membership ending at the leaf of
+	// another key shows the version of that key
+	index := mr.ActualVersion
+	if !mr.Exists && mr.ShortcutKey != nil {
+		index = mr.ShortcutVersion
+	}
 
 	historyProof := history.NewMembershipProof(
-		mr.ActualVersion,
+		index,
 		mr.QueryVersion,
 		history.ParseAuditPath(mr.History),
 		hasherF(),
+		format,
 	)
 
 	hasher := hasherF()
+
+	// a proof without value shows non-membership
+	var value []byte
+	if mr.Exists {
+		value = util.Uint64AsPaddedBytes(mr.ActualVersion, int(hasher.Len()))
+	}
 	hyperProof := hyper.NewQueryProof(
 		mr.KeyDigest,
-		util.Uint64AsPaddedBytes(mr.ActualVersion, int(hasher.Len())),
+		value,
 		mr.Hyper,
 		hasher,
+		format,
 	)
+	if !mr.Exists && mr.ShortcutKey != nil {
+		hyperProof.ShortcutKey = mr.ShortcutKey
+		hyperProof.ShortcutValue = util.Uint64AsPaddedBytes(mr.ShortcutVersion, int(hasher.Len()))
+	}
 
 	return balloon.NewMembershipProof(
 		mr.Exists,
@@ -184,6 +327,6 @@ func ToIncrementalResponse(proof *balloon.IncrementalProof) *IncrementalResponse
 	}
 }
 
-func ToIncrementalProof(ir *IncrementalResponse, hasher hashing.Hasher) *balloon.IncrementalProof {
-	return balloon.NewIncrementalProof(ir.Start, ir.End, history.ParseAuditPath(ir.AuditPath), hasher)
+func ToIncrementalProof(ir *IncrementalResponse, hasher hashing.Hasher, format hashing.FormatVersion) *balloon.IncrementalProof {
+	return balloon.NewIncrementalProof(ir.Start, ir.End, history.ParseAuditPath(ir.AuditPath), hasher, format)
 }
@@ -0,0 +1,69 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+
+package protocol
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/bbva/qed/balloon"
+	"github.com/bbva/qed/hashing"
+	storage_utils "github.com/bbva/qed/testutils/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMembershipResultWithShortcut(t *testing.T) {
+
+	store, closeF := storage_utils.OpenBPlusTreeStore()
+	defer closeF()
+
+	b, err := balloon.NewBalloon(store, hashing.NewSha256Hasher, hashing.CurrentFormat)
+	require.NoError(t, err)
+
+	var snapshot *balloon.Snapshot
+	for i := 0; i < 5; i++ {
+		s, mutations, err := b.Add([]byte{byte(i)})
+		require.NoError(t, err)
+		require.NoError(t, store.Mutate(mutations))
+		snapshot = s
+	}
+
+	// flipping the last bit of an event digest gives a digest whose path
+	// ends at the leaf of that event
+	absent := hashing.NewSha256Hasher().Do([]byte{0x02})
+	absent[len(absent)-1] ^= 0x01
+
+	proof, err := b.QueryDigestMembership(absent, snapshot.Version)
+	require.NoError(t, err)
+	require.NotNil(t, proof.HyperProof.ShortcutKey)
+
+	encoded, err := json.Marshal(ToMembershipResult(nil, proof))
+	require.NoError(t, err)
+	var result MembershipResult
+	require.NoError(t, json.Unmarshal(encoded, &result))
+	assert.Equal(t, uint64(2), result.ShortcutVersion, "The shortcut leaf should be the event logged at version 2")
+
+	decoded := ToBalloonProof(&result, hashing.NewSha256Hasher, hashing.CurrentFormat)
+	assert.True(t, decoded.DigestVerify(absent, snapshot), "The decoded non-membership proof should verify")
+
+	result.ShortcutVersion = 3
+	decoded = ToBalloonProof(&result, hashing.NewSha256Hasher, hashing.CurrentFormat)
+	assert.False(t, decoded.DigestVerify(absent, snapshot), "A wrong shortcut version should not verify")
+
+}
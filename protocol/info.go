@@ -16,6 +16,8 @@
 
 package protocol
 
+import "github.com/bbva/qed/hashing"
+
 type Scheme string
 
 const (
@@ -34,3 +36,16 @@ type Shards struct {
 	URIScheme Scheme                 `json:"uriScheme"`
 	Shards    map[string]ShardDetail `json:"shards"`
 }
+
+// ServerInfo is the public struct that the /info endpoint returns. It only
+// holds what clients need to talk to the server and verify its proofs,
+// never the configuration of the server.
+type ServerInfo struct {
+	NodeID         string
+	HTTPAddr       string
+	Hasher         string
+	Format         hashing.FormatVersion
+	EnableTLS      bool
+	StorePayloads  bool
+	MaxPayloadSize int
+}
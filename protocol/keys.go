@@ -0,0 +1,36 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package protocol
+
+// KeyInfo describes a key that signs, or signed, the snapshots of a log
+// and the window in which it was active, in nanoseconds since the epoch.
+// The key of a log that has never been rotated has no window, and the
+// active key has no end. The public key is encoded as the signers of its
+// algorithm return it.
+type KeyInfo struct {
+	KeyID      string
+	Algorithm  string `json:",omitempty"`
+	PublicKey  []byte
+	ValidFrom  int64
+	ValidUntil int64 `json:",omitempty"`
+}
+
+// KeysResponse is the public struct that the /info/keys handler returns.
+// The keys are ordered by activation, so the last one is the active key.
+type KeysResponse struct {
+	Keys []KeyInfo
+}
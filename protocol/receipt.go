@@ -0,0 +1,133 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package protocol
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/bbva/qed/balloon"
+	"github.com/bbva/qed/hashing"
+	"github.com/bbva/qed/sign"
+)
+
+// ReceiptVersion is the version of the receipt format.
+const ReceiptVersion = 1
+
+// ReceiptQuery is the public struct that apihttp.Receipt Handler uses to
+// parse the post params. A non-zero LaterVersion asks for a consistency
+// proof between the snapshot of the event and the one of that version.
+type ReceiptQuery struct {
+	KeyDigest    hashing.Digest
+	LaterVersion uint64
+}
+
+// Receipt is a self-contained proof that an event is in the log. It holds
+// everything needed to verify it offline but the public key of the server
+// that signed it, which is identified by KeyID.
+type Receipt struct {
+	Version     int
+	Hasher      string
+	Format      hashing.FormatVersion
+	EventDigest hashing.Digest
+	KeyID       string
+	// Snapshot is the signed snapshot taken right after the event was
+	// added, and Membership proves the event against it.
+	Snapshot   *SignedSnapshot
+	Membership *MembershipResult
+	// Consistency optionally proves that LaterSnapshot extends Snapshot.
+	Consistency   *IncrementalResponse `json:",omitempty"`
+	LaterSnapshot *SignedSnapshot      `json:",omitempty"`
+}
+
+// Verify checks the receipt against the public key of the given verifier:
+// the signatures of the snapshots, the membership of the event digest and,
+// if present, the consistency proof. It returns nil if every check passes.
+func (r *Receipt) Verify(verifier sign.Signer) error {
+
+	if r.Version != ReceiptVersion {
+		return fmt.Errorf("unsupported receipt version %d", r.Version)
+	}
+	if keyID := sign.KeyID(verifier.PublicKey()); r.KeyID != keyID {
+		return fmt.Errorf("the receipt was signed with key %s but the given key is %s", r.KeyID, keyID)
+	}
+	if r.Snapshot == nil || r.Snapshot.Snapshot == nil || r.Membership == nil {
+		return fmt.Errorf("the receipt is incomplete")
+	}
+
+	hasherF, err := hashing.NewHasherF(r.Hasher)
+	if err != nil {
+		return fmt.Errorf("%s: %s", err, r.Hasher)
+	}
+
+	if err := verifySignature(verifier, r.Hasher, r.Snapshot); err != nil {
+		return err
+	}
+	if !bytes.Equal(r.Snapshot.Snapshot.EventDigest, r.EventDigest) {
+		return fmt.Errorf("the snapshot does not belong to the event digest")
+	}
+
+	if !r.Membership.Exists || !bytes.Equal(r.Membership.KeyDigest, r.EventDigest) {
+		return fmt.Errorf("the membership proof does not belong to the event digest")
+	}
+	proof := ToBalloonProof(r.Membership, hasherF, r.Format)
+	snapshot := balloon.Snapshot(*r.Snapshot.Snapshot)
+	if !proof.DigestVerify(r.EventDigest, &snapshot) {
+		return fmt.Errorf("the membership proof does not verify")
+	}
+
+	if r.Consistency == nil {
+		return nil
+	}
+	if r.LaterSnapshot == nil || r.LaterSnapshot.Snapshot == nil {
+		return fmt.Errorf("the consistency proof comes without a later snapshot")
+	}
+	if err := verifySignature(verifier, r.Hasher, r.LaterSnapshot); err != nil {
+		return err
+	}
+	if r.Consistency.Start != snapshot.Version || r.Consistency.End != r.LaterSnapshot.Snapshot.Version {
+		return fmt.Errorf("the consistency proof does not match the versions of the snapshots")
+	}
+	incremental := ToIncrementalProof(r.Consistency, hasherF(), r.Format)
+	later := balloon.Snapshot(*r.LaterSnapshot.Snapshot)
+	if !incremental.Verify(&snapshot, &later) {
+		return fmt.Errorf("the consistency proof does not verify")
+	}
+
+	return nil
+}
+
+func verifySignature(verifier sign.Signer, hasher string, signed *SignedSnapshot) error {
+	if keyID := sign.KeyID(verifier.PublicKey()); signed.KeyID != "" && signed.KeyID != keyID {
+		return fmt.Errorf("the snapshot of version %d was signed with key %s but the given key is %s", signed.Snapshot.Version, signed.KeyID, keyID)
+	}
+	if signed.Algorithm != "" && signed.Algorithm != verifier.Algorithm() {
+		return fmt.Errorf("the snapshot of version %d was signed with %s but the given key is a %s key", signed.Snapshot.Version, signed.Algorithm, verifier.Algorithm())
+	}
+	payload, err := signed.Snapshot.SigningPayload(hasher)
+	if err != nil {
+		return err
+	}
+	ok, err := verifier.Verify(payload, signed.Signature)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("invalid signature for the snapshot of version %d", signed.Snapshot.Version)
+	}
+	return nil
+}
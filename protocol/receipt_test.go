@@ -0,0 +1,103 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package protocol
+
+import (
+	"testing"
+
+	"github.com/bbva/qed/balloon"
+	"github.com/bbva/qed/hashing"
+	"github.com/bbva/qed/sign"
+	storage_utils "github.com/bbva/qed/testutils/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func signSnapshot(t *testing.T, signer sign.Signer, snapshot *balloon.Snapshot) *SignedSnapshot {
+	s := Snapshot(*snapshot)
+	payload, err := s.SigningPayload(hashing.Sha256)
+	require.NoError(t, err)
+	signature, err := signer.Sign(payload)
+	require.NoError(t, err)
+	return &SignedSnapshot{Snapshot: &s, Signature: signature, Hasher: hashing.Sha256}
+}
+
+func newReceipt(t *testing.T, signer sign.Signer) *Receipt {
+
+	store, closeF := storage_utils.OpenBPlusTreeStore()
+	defer closeF()
+
+	b, err := balloon.NewBalloon(store, hashing.NewSha256Hasher, hashing.CurrentFormat)
+	require.NoError(t, err)
+
+	var snapshots []*balloon.Snapshot
+	for i := 0; i < 5; i++ {
+		snapshot, mutations, err := b.Add([]byte{byte(i)})
+		require.NoError(t, err)
+		require.NoError(t, store.Mutate(mutations))
+		snapshots = append(snapshots, snapshot)
+	}
+
+	membership, err := b.QueryMembership([]byte{0x02}, 2)
+	require.NoError(t, err)
+	consistency, err := b.QueryConsistency(2, 4)
+	require.NoError(t, err)
+
+	return &Receipt{
+		Version:       ReceiptVersion,
+		Hasher:        hashing.Sha256,
+		Format:        hashing.CurrentFormat,
+		EventDigest:   snapshots[2].EventDigest,
+		KeyID:         sign.KeyID(signer.PublicKey()),
+		Snapshot:      signSnapshot(t, signer, snapshots[2]),
+		Membership:    ToMembershipResult(nil, membership),
+		Consistency:   ToIncrementalResponse(consistency),
+		LaterSnapshot: signSnapshot(t, signer, snapshots[4]),
+	}
+}
+
+func TestReceiptVerify(t *testing.T) {
+
+	signer := sign.NewEd25519Signer()
+
+	receipt := newReceipt(t, signer)
+	require.NoError(t, receipt.Verify(signer), "The receipt should verify")
+
+	receipt.Consistency, receipt.LaterSnapshot = nil, nil
+	require.NoError(t, receipt.Verify(signer), "The receipt should verify without consistency proof")
+
+	tests := []struct {
+		name   string
+		tamper func(r *Receipt)
+	}{
+		{"unknown version", func(r *Receipt) { r.Version = 0 }},
+		{"another event", func(r *Receipt) { r.EventDigest = hashing.NewSha256Hasher().Do([]byte{0x03}) }},
+		{"forged snapshot", func(r *Receipt) { r.Snapshot.Snapshot.HistoryDigest = hashing.Digest{0x00} }},
+		{"forged signature", func(r *Receipt) { r.Snapshot.Signature[0] ^= 0xff }},
+		{"forged later snapshot", func(r *Receipt) { r.LaterSnapshot.Snapshot.Version = 3 }},
+		{"missing later snapshot", func(r *Receipt) { r.LaterSnapshot = nil }},
+		{"unknown hasher", func(r *Receipt) { r.Hasher = "md5" }},
+	}
+
+	for _, test := range tests {
+		receipt := newReceipt(t, signer)
+		test.tamper(receipt)
+		require.Errorf(t, receipt.Verify(signer), "A receipt with %s should not verify", test.name)
+	}
+
+	other := sign.NewEd25519Signer()
+	require.Error(t, newReceipt(t, signer).Verify(other), "A receipt should not verify with another key")
+}
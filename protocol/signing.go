@@ -0,0 +1,74 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package protocol
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/bbva/qed/hashing"
+	"github.com/bbva/qed/util"
+)
+
+// SigningPayloadVersion is the version of the canonical encoding of the
+// snapshots that servers sign.
+const SigningPayloadVersion byte = 1
+
+// ErrDigestTooLong is returned when a digest does not fit in the signing
+// payload.
+var ErrDigestTooLong = errors.New("digest too long to be signed")
+
+// SigningPayload returns the canonical encoding of the snapshot that
+// servers sign, so that signatures can be verified in any language.
+// All integers are big endian:
+//
+//   version          1 byte, SigningPayloadVersion
+//   hasher           1 byte, the ID of the hasher of the log (hashing.HasherID)
+//   event digest     2 bytes with its length followed by the digest
+//   history digest   2 bytes with its length followed by the digest
+//   hyper digest     2 bytes with its length followed by the digest
+//   snapshot version 8 bytes
+//   timestamp        8 bytes, only if the snapshot has a timestamp
+func (b *Snapshot) SigningPayload(hasher string) ([]byte, error) {
+	id, err := hashing.HasherID(hasher)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte(SigningPayloadVersion)
+	buf.WriteByte(id)
+	for _, digest := range []hashing.Digest{b.EventDigest, b.HistoryDigest, b.HyperDigest} {
+		if len(digest) > 0xffff {
+			return nil, ErrDigestTooLong
+		}
+		buf.Write(util.Uint16AsBytes(uint16(len(digest))))
+		buf.Write(digest)
+	}
+	buf.Write(util.Uint64AsBytes(b.Version))
+	if b.Timestamp != 0 {
+		buf.Write(util.Uint64AsBytes(uint64(b.Timestamp)))
+	}
+
+	return buf.Bytes(), nil
+}
+
+// SigningPayload returns the canonical encoding of the signed snapshot
+// using its hasher.
+func (b *SignedSnapshot) SigningPayload() ([]byte, error) {
+	return b.Snapshot.SigningPayload(b.Hasher)
+}
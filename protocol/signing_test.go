@@ -0,0 +1,117 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package protocol
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+
+	"github.com/bbva/qed/hashing"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ed25519"
+)
+
+type hexBytes []byte
+
+func (h *hexBytes) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	b, err := hex.DecodeString(s)
+	*h = b
+	return err
+}
+
+// The vectors are shared with the verifiers written in other languages,
+// so they must not change while SigningPayloadVersion stays the same.
+func TestSigningPayloadVectors(t *testing.T) {
+
+	data, err := ioutil.ReadFile("testdata/signing_payloads.json")
+	require.NoError(t, err)
+
+	var vectors struct {
+		Seed      hexBytes `json:"seed"`
+		PublicKey hexBytes `json:"public_key"`
+		Vectors   []struct {
+			Hasher        string   `json:"hasher"`
+			EventDigest   hexBytes `json:"event_digest"`
+			HistoryDigest hexBytes `json:"history_digest"`
+			HyperDigest   hexBytes `json:"hyper_digest"`
+			Version       uint64   `json:"version"`
+			Timestamp     int64    `json:"timestamp"`
+			Payload       hexBytes `json:"payload"`
+			Signature     hexBytes `json:"signature"`
+		} `json:"vectors"`
+	}
+	require.NoError(t, json.Unmarshal(data, &vectors))
+	require.NotEmpty(t, vectors.Vectors)
+
+	privateKey := ed25519.NewKeyFromSeed(vectors.Seed)
+	require.Equal(t, []byte(vectors.PublicKey), []byte(privateKey.Public().(ed25519.PublicKey)), "Wrong public key")
+
+	for i, v := range vectors.Vectors {
+		snapshot := &Snapshot{
+			EventDigest:   hashing.Digest(v.EventDigest),
+			HistoryDigest: hashing.Digest(v.HistoryDigest),
+			HyperDigest:   hashing.Digest(v.HyperDigest),
+			Version:       v.Version,
+			Timestamp:     v.Timestamp,
+		}
+
+		payload, err := snapshot.SigningPayload(v.Hasher)
+		require.NoError(t, err)
+		require.Equalf(t, []byte(v.Payload), payload, "Wrong payload in vector %d", i)
+		require.Equalf(t, []byte(v.Signature), ed25519.Sign(privateKey, payload), "Wrong signature in vector %d", i)
+		require.Truef(t, ed25519.Verify(privateKey.Public().(ed25519.PublicKey), payload, v.Signature), "The signature should verify in vector %d", i)
+	}
+}
+
+func TestSigningPayload(t *testing.T) {
+
+	snapshot := &Snapshot{
+		EventDigest:   hashing.Digest{0x01},
+		HistoryDigest: hashing.Digest{0x02, 0x03},
+		HyperDigest:   hashing.Digest{},
+		Version:       5,
+	}
+
+	expected := []byte{
+		0x01, 0x01, // payload version and hasher
+		0x00, 0x01, 0x01, // event digest
+		0x00, 0x02, 0x02, 0x03, // history digest
+		0x00, 0x00, // hyper digest
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x05, // version
+	}
+	payload, err := snapshot.SigningPayload(hashing.Sha256)
+	require.NoError(t, err)
+	require.Equal(t, expected, payload, "Wrong payload without timestamp")
+
+	snapshot.Timestamp = 0x0102
+	payload, err = snapshot.SigningPayload(hashing.Sha256)
+	require.NoError(t, err)
+	require.Equal(t, append(expected, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x02), payload, "Wrong payload with timestamp")
+
+	other, err := snapshot.SigningPayload(hashing.Blake2b256)
+	require.NoError(t, err)
+	require.NotEqual(t, payload, other, "The hasher must be part of the payload")
+
+	_, err = snapshot.SigningPayload("md5")
+	require.Equal(t, hashing.ErrUnknownHasher, err, "Unknown hashers should be rejected")
+}
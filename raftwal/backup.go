@@ -0,0 +1,473 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package raftwal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/bbva/qed/balloon"
+	"github.com/bbva/qed/protocol"
+	"github.com/bbva/qed/sign"
+	"github.com/bbva/qed/storage"
+	"github.com/bbva/qed/util"
+)
+
+// Backups start with a header that carries the last snapshot of the log,
+// signed by the node that took the backup, followed by a raft snapshot:
+//
+// magic (8 bytes) | version (uint32) | from (uint64) | signed snapshot size (uint64) | signed snapshot | raft snapshot
+//
+// Integers are little endian. From is the first version of the log in the
+// backup, zero unless the backup is incremental. The signed snapshot is
+// JSON encoded, and empty when the log is empty. Version 1 headers have no
+// from field.
+var backupMagic = []byte("QEDBKUP\x00")
+
+const backupVersion uint32 = 2
+
+// Backup writes a consistent backup of the log to w. The last snapshot of
+// the log is signed with the active key of the signer, so that restores
+// can check the backup.
+func (fsm *BalloonFSM) Backup(w io.Writer, signer sign.Signer) error {
+
+	// no command is applied while the store is checkpointed
+	fsm.restoreMu.Lock()
+	last, err := fsm.lastSnapshot()
+	var snap *fsmSnapshot
+	if err == nil {
+		snap, err = fsm.snapshot()
+	}
+	fsm.restoreMu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	var signed *protocol.SignedSnapshot
+	if last != nil {
+		signed, err = fsm.signSnapshot(last, signer)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := writeBackupHeader(w, 0, signed); err != nil {
+		return err
+	}
+	return snap.writeTo(w)
+}
+
+// BackupVersions writes a backup of the versions of the log between from
+// and until, both included, while the log keeps applying commands. The
+// backup is signed with the snapshot of until, so a backup from version
+// zero restores the log as it was at until, while a later one brings a
+// log restored up to from-1 to until.
+//
+// The hyper tree of a bulk is stored at its first version, so a backup
+// cannot start or end in the middle of a bulk.
+func (fsm *BalloonFSM) BackupVersions(w io.Writer, from, until uint64, signer sign.Signer) error {
+
+	if until >= fsm.balloon.Version() || from > until {
+		return fmt.Errorf("unable to backup versions %d to %d of a log with %d versions", from, until, fsm.balloon.Version())
+	}
+	if err := fsm.balloon.CheckExportRange(from, until); err != nil {
+		return err
+	}
+
+	snapshot, err := fsm.snapshotAt(until)
+	if err != nil {
+		return err
+	}
+	signed, err := fsm.signSnapshot(snapshot, signer)
+	if err != nil {
+		return err
+	}
+	stateBuff, err := encodeMsgPack(fsm.stateAt(until, snapshot.Timestamp))
+	if err != nil {
+		return err
+	}
+
+	if err := writeBackupHeader(w, from, signed); err != nil {
+		return err
+	}
+	if err := writeSnapshotHeader(w, nil); err != nil {
+		return err
+	}
+
+	emit := storage.NewBackupWriter(w)
+	if err := fsm.balloon.Export(from, until, emit); err != nil {
+		return err
+	}
+	if err := fsm.exportVersions(storage.TimestampsTable, from, until, emit); err != nil {
+		return err
+	}
+	if err := fsm.exportVersions(storage.PayloadsTable, from, until, emit); err != nil {
+		return err
+	}
+	if err := fsm.exportVersions(storage.IndexTable, from, until, emit); err != nil {
+		return err
+	}
+	if err := fsm.exportIdempotencyKeys(from, until, emit); err != nil {
+		return err
+	}
+	return emit(storage.FSMStateTable, storage.FSMStateTableKey, stateBuff.Bytes())
+}
+
+// stateAt returns the state of the log as it was after applying the given
+// version, without the raft index and term. The keys activated later are
+// left out, and the one active at that time has its window reopened.
+func (fsm *BalloonFSM) stateAt(version uint64, timestamp int64) *fsmState {
+	state := &fsmState{
+		BalloonVersion: version,
+		Hasher:         fsm.hasher,
+		Format:         fsm.balloon.Format(),
+		Timestamp:      timestamp,
+	}
+	for _, k := range fsm.Keys() {
+		// events logged before timestamps were recorded keep every key
+		if timestamp > 0 {
+			if k.ValidFrom > timestamp {
+				break
+			}
+			if k.ValidUntil > timestamp {
+				k.ValidUntil = 0
+			}
+		}
+		state.Keys = append(state.Keys, k)
+	}
+	return state
+}
+
+// exportVersionsBatch is the number of versions read at once from the
+// tables keyed by version, which may hold the events themselves.
+const exportVersionsBatch = 1000
+
+// exportVersions emits the entries of a table keyed by version.
+func (fsm *BalloonFSM) exportVersions(table storage.Table, from, until uint64, emit storage.EntryFunc) error {
+	for start := from; ; start += exportVersionsBatch {
+		end := start + exportVersionsBatch - 1
+		if end > until || end < start {
+			end = until
+		}
+		entries, err := fsm.store.GetRange(table, util.Uint64AsBytes(start), util.Uint64AsBytes(end))
+		if err != nil {
+			return err
+		}
+		for _, kv := range entries {
+			if err := emit(table, kv.Key, kv.Value); err != nil {
+				return err
+			}
+		}
+		if end == until {
+			return nil
+		}
+	}
+}
+
+func (fsm *BalloonFSM) exportIdempotencyKeys(from, until uint64, emit storage.EntryFunc) error {
+	return storage.ForEach(fsm.store, storage.IdempotencyKeysTable, func(kv *storage.KVPair) error {
+		var snapshot balloon.Snapshot
+		if err := decodeMsgPack(kv.Value, &snapshot); err != nil {
+			return err
+		}
+		if snapshot.Version < from || snapshot.Version > until {
+			return nil
+		}
+		if err := emit(storage.IdempotencyKeysTable, kv.Key, kv.Value); err != nil {
+			return err
+		}
+		expiry := idempotencyExpiryMutation(&snapshot, string(kv.Key))
+		return emit(expiry.Table, expiry.Key, expiry.Value)
+	})
+}
+
+// signSnapshot signs the snapshot of a backup with the active key of the
+// signer.
+func (fsm *BalloonFSM) signSnapshot(snapshot *protocol.Snapshot, signer sign.Signer) (*protocol.SignedSnapshot, error) {
+	key := sign.ActiveKey(signer)
+	signed := &protocol.SignedSnapshot{
+		Snapshot:  snapshot,
+		Hasher:    fsm.hasher,
+		KeyID:     sign.KeyID(key.PublicKey()),
+		Algorithm: key.Algorithm(),
+	}
+	payload, err := signed.SigningPayload()
+	if err != nil {
+		return nil, err
+	}
+	signed.Signature, err = key.Sign(payload)
+	if err != nil {
+		return nil, err
+	}
+	return signed, nil
+}
+
+// lastSnapshot computes the snapshot of the last version of the log from
+// the stored trees, as snapshotAt does. It returns nil if the log is empty.
+func (fsm *BalloonFSM) lastSnapshot() (*protocol.Snapshot, error) {
+	if fsm.balloon.Version() == 0 {
+		return nil, nil
+	}
+	return fsm.snapshotAt(fsm.balloon.Version() - 1)
+}
+
+// rebuildLastSnapshot computes the snapshot of the last version of the log
+// as lastSnapshot does, but with the digests rebuilt from the leaves of the
+// trees by Balloon.RebuildDigests, which checks every stored node.
+func (fsm *BalloonFSM) rebuildLastSnapshot() (*protocol.Snapshot, error) {
+	last, err := fsm.lastSnapshot()
+	if err != nil || last == nil {
+		return nil, err
+	}
+	last.HistoryDigest, last.HyperDigest, err = fsm.balloon.RebuildDigests()
+	if err != nil {
+		return nil, err
+	}
+	return last, nil
+}
+
+// snapshotAt computes the snapshot of the given version of the log from the
+// nodes stored at the top of both trees: the frozen history nodes covering
+// the version and the hyper root batch of the version. Nodes below them are
+// not read, so the digests are not rebuilt from the leaves.
+func (fsm *BalloonFSM) snapshotAt(version uint64) (*protocol.Snapshot, error) {
+	historyDigest, err := fsm.balloon.QueryHistoryDigest(version)
+	if err != nil {
+		return nil, err
+	}
+	hyperDigest, err := fsm.balloon.QueryHyperDigest(version)
+	if err != nil {
+		return nil, err
+	}
+	timestamp, err := fsm.QueryTimestamp(version)
+	if err != nil {
+		return nil, err
+	}
+
+	return &protocol.Snapshot{
+		HistoryDigest: historyDigest,
+		HyperDigest:   hyperDigest,
+		Version:       version,
+		Timestamp:     timestamp,
+	}, nil
+}
+
+// RestoreBackup rebuilds the log of an offline node from a backup. Full
+// backups need an empty store, while incremental ones need a store that
+// holds every version before the first one of the backup. It rebuilds the
+// history and hyper digests of the last version from the leaves of the
+// restored trees, checking every stored node on the way, and compares them
+// with the signed snapshot of the backup, whose signature must verify
+// with one of the trusted keys. The keys recorded in the log are never
+// trusted, as they come from the backup being restored.
+//
+// The store is left as loaded when the backup does not verify, so callers
+// should restore into a copy of the store they can throw away.
+//
+// The restored log forgets the raft index and the node metadata of the
+// cluster it was taken from, so the node must start a new cluster.
+func RestoreBackup(r io.Reader, store storage.ManagedStore, hasher string, trusted ...sign.Signer) (*protocol.SignedSnapshot, error) {
+
+	br := bufio.NewReader(r)
+	from, signed, err := readBackupHeader(br)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := readSnapshotHeader(br); err != nil {
+		return nil, err
+	}
+
+	stored, err := balloon.StoredVersion(store)
+	if err != nil {
+		return nil, err
+	}
+	if stored != from {
+		return nil, fmt.Errorf("the backup starts at version %d but the store holds %d versions", from, stored)
+	}
+	if err := store.Load(br); err != nil {
+		return nil, err
+	}
+
+	// the state of the restored log sets the hasher and the format
+	fsm, err := NewBalloonFSM(store, hasher)
+	if err != nil {
+		return nil, err
+	}
+	defer fsm.Close()
+
+	last, err := fsm.rebuildLastSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	if err := checkBackupDigests(signed, last, hasher); err != nil {
+		return nil, err
+	}
+	if signed != nil {
+		if err := verifyBackup(signed, trusted); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := fsm.forgetRaftIndex(); err != nil {
+		return nil, err
+	}
+	return signed, nil
+}
+
+// checkBackupDigests compares the snapshot rebuilt from the leaves of a
+// restored log with the signed snapshot of its backup. It detects a backup
+// of another log, a truncated one or one whose nodes were altered.
+func checkBackupDigests(signed *protocol.SignedSnapshot, last *protocol.Snapshot, hasher string) error {
+	if signed == nil || signed.Snapshot == nil {
+		if last != nil {
+			return fmt.Errorf("backup mismatch: the restored log has version %d but the backup has no snapshot", last.Version)
+		}
+		return nil
+	}
+	if last == nil {
+		return fmt.Errorf("backup mismatch: the restored log is empty but the backup snapshot has version %d", signed.Snapshot.Version)
+	}
+	if signed.Hasher != hasher {
+		return fmt.Errorf("backup mismatch: the backup snapshot was signed with hasher %s but the log uses %s", signed.Hasher, hasher)
+	}
+	if last.Version != signed.Snapshot.Version {
+		return fmt.Errorf("backup mismatch: the restored log has version %d but the backup snapshot %d", last.Version, signed.Snapshot.Version)
+	}
+	if !bytes.Equal(last.HistoryDigest, signed.Snapshot.HistoryDigest) {
+		return fmt.Errorf("backup mismatch: history digest %x differs from the backup snapshot %x", last.HistoryDigest, signed.Snapshot.HistoryDigest)
+	}
+	if !bytes.Equal(last.HyperDigest, signed.Snapshot.HyperDigest) {
+		return fmt.Errorf("backup mismatch: hyper digest %x differs from the backup snapshot %x", last.HyperDigest, signed.Snapshot.HyperDigest)
+	}
+	if last.Timestamp != signed.Snapshot.Timestamp {
+		return fmt.Errorf("backup mismatch: timestamp %d differs from the backup snapshot %d", last.Timestamp, signed.Snapshot.Timestamp)
+	}
+	return nil
+}
+
+// verifyBackup checks the signature of the snapshot of a backup with the
+// trusted keys.
+func verifyBackup(signed *protocol.SignedSnapshot, trusted []sign.Signer) error {
+	payload, err := signed.SigningPayload()
+	if err != nil {
+		return err
+	}
+
+	for _, verifier := range trusted {
+		if sign.KeyID(verifier.PublicKey()) != signed.KeyID {
+			continue
+		}
+		if ok, err := verifier.Verify(payload, signed.Signature); err != nil || !ok {
+			return fmt.Errorf("the backup snapshot signature does not verify with key %s", signed.KeyID)
+		}
+		return nil
+	}
+	return fmt.Errorf("the backup snapshot is signed with the untrusted key %s", signed.KeyID)
+}
+
+// forgetRaftIndex resets the raft index and term recorded in the state, so
+// that a restored log applies the commands of a new raft log.
+func (fsm *BalloonFSM) forgetRaftIndex() error {
+	state := *fsm.state
+	state.Index, state.Term = 0, 0
+	stateBuff, err := encodeMsgPack(&state)
+	if err != nil {
+		return err
+	}
+	err = fsm.store.Mutate([]*storage.Mutation{
+		storage.NewMutation(storage.FSMStateTable, storage.FSMStateTableKey, stateBuff.Bytes()),
+	})
+	if err != nil {
+		return err
+	}
+	fsm.state = &state
+	return nil
+}
+
+func writeBackupHeader(w io.Writer, from uint64, signed *protocol.SignedSnapshot) error {
+	var buf []byte
+	if signed != nil {
+		var err error
+		buf, err = json.Marshal(signed)
+		if err != nil {
+			return err
+		}
+	}
+	if _, err := w.Write(backupMagic); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, backupVersion); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, from); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, uint64(len(buf))); err != nil {
+		return err
+	}
+	_, err := w.Write(buf)
+	return err
+}
+
+// readBackupHeader consumes the header of a backup and returns its first
+// version and signed snapshot, leaving the reader at the start of the raft
+// snapshot.
+func readBackupHeader(r io.Reader) (uint64, *protocol.SignedSnapshot, error) {
+	magic := make([]byte, len(backupMagic))
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return 0, nil, fmt.Errorf("unable to read the backup header: %v", err)
+	}
+	if !bytes.Equal(magic, backupMagic) {
+		return 0, nil, fmt.Errorf("not a QED backup")
+	}
+
+	var version uint32
+	if err := binary.Read(r, binary.LittleEndian, &version); err != nil {
+		return 0, nil, err
+	}
+	if version == 0 || version > backupVersion {
+		return 0, nil, fmt.Errorf("unsupported backup version %d", version)
+	}
+
+	var from uint64
+	if version > 1 {
+		if err := binary.Read(r, binary.LittleEndian, &from); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	var size uint64
+	if err := binary.Read(r, binary.LittleEndian, &size); err != nil {
+		return 0, nil, err
+	}
+	if size == 0 {
+		return from, nil, nil
+	}
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return 0, nil, err
+	}
+	var signed protocol.SignedSnapshot
+	if err := json.Unmarshal(buf, &signed); err != nil {
+		return 0, nil, err
+	}
+	return from, &signed, nil
+}
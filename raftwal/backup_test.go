@@ -0,0 +1,362 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package raftwal
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/bbva/qed/hashing"
+	"github.com/bbva/qed/log"
+	"github.com/bbva/qed/protocol"
+	"github.com/bbva/qed/raftwal/commands"
+	"github.com/bbva/qed/sign"
+	"github.com/bbva/qed/storage"
+	storage_utils "github.com/bbva/qed/testutils/storage"
+	"github.com/bbva/qed/util"
+)
+
+func TestBackupHeader(t *testing.T) {
+
+	signed := &protocol.SignedSnapshot{
+		Snapshot:  &protocol.Snapshot{HistoryDigest: []byte{0x1}, HyperDigest: []byte{0x2}, Version: 9, Timestamp: 100},
+		Signature: []byte{0x3},
+		Hasher:    hashing.Sha256,
+		KeyID:     "key",
+	}
+
+	for _, expected := range []*protocol.SignedSnapshot{signed, nil} {
+		for _, from := range []uint64{0, 5} {
+			var buf bytes.Buffer
+			require.NoError(t, writeBackupHeader(&buf, from, expected))
+			buf.WriteString("snapshot")
+
+			restoredFrom, restored, err := readBackupHeader(&buf)
+			require.NoError(t, err)
+			require.Equal(t, from, restoredFrom)
+			require.Equal(t, expected, restored)
+			require.Equal(t, "snapshot", buf.String(), "The header should be consumed before the snapshot")
+		}
+	}
+
+	_, _, err := readBackupHeader(bytes.NewBufferString("QEDSNAP\x00 a raft snapshot"))
+	require.Error(t, err, "Raft snapshots are not backups")
+
+	var buf bytes.Buffer
+	require.NoError(t, writeBackupHeader(&buf, 0, nil))
+	header := buf.Bytes()
+	header[len(backupMagic)]++
+	_, _, err = readBackupHeader(bytes.NewReader(header))
+	require.Error(t, err, "Unknown backup versions should be rejected")
+
+	// version 1 headers have no first version
+	v1 := append([]byte{}, backupMagic...)
+	v1 = append(v1, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0)
+	from, restored, err := readBackupHeader(bytes.NewReader(v1))
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), from)
+	require.Nil(t, restored)
+}
+
+func TestBackupLastSnapshot(t *testing.T) {
+
+	log.SetLogger("TestBackupLastSnapshot", log.SILENT)
+
+	store, closeF := storage_utils.OpenBPlusTreeStore()
+	defer closeF()
+
+	fsm, err := NewBalloonFSM(store, hashing.Sha256)
+	require.NoError(t, err)
+
+	last, err := fsm.lastSnapshot()
+	require.NoError(t, err)
+	require.Nil(t, last, "An empty log should have no last snapshot")
+
+	var r *fsmAddResponse
+	for i, event := range []string{"Morning's at seven;", "The hill-side's dew-pearled;", "The lark's on the wing;"} {
+		command, _ := commands.Encode(commands.AddEventCommandType, &commands.AddEventCommand{Event: []byte(event), Timestamp: int64(100 + i)})
+		r = fsm.Apply(newRaftLog(uint64(i+1), 1, command)).(*fsmAddResponse)
+		require.NoError(t, r.error)
+	}
+
+	last, err = fsm.lastSnapshot()
+	require.NoError(t, err)
+	require.Equal(t, r.snapshot.Version, last.Version)
+	require.Equal(t, r.snapshot.HistoryDigest, last.HistoryDigest)
+	require.Equal(t, r.snapshot.HyperDigest, last.HyperDigest)
+	require.Equal(t, int64(102), last.Timestamp)
+}
+
+func TestBackupVersions(t *testing.T) {
+
+	log.SetLogger("TestBackupVersions", log.SILENT)
+
+	store, closeF := storage_utils.OpenBPlusTreeStore()
+	defer closeF()
+
+	fsm, err := NewBalloonFSM(store, hashing.Sha256)
+	require.NoError(t, err)
+
+	var responses []*fsmAddResponse
+	for i := uint64(1); i <= 10; i++ {
+		command, _ := commands.Encode(commands.AddEventCommandType, &commands.AddEventCommand{Event: []byte{byte(i)}, Timestamp: int64(100 + i), StorePayload: true})
+		r := fsm.Apply(newRaftLog(i, 1, command)).(*fsmAddResponse)
+		require.NoError(t, r.error)
+		responses = append(responses, r)
+	}
+
+	signer := sign.NewEd25519Signer()
+	require.Error(t, fsm.BackupVersions(&bytes.Buffer{}, 0, 10, signer), "Versions not yet added should not be backed up")
+	require.Error(t, fsm.BackupVersions(&bytes.Buffer{}, 5, 4, signer), "The range should not be reversed")
+
+	var backup bytes.Buffer
+	require.NoError(t, fsm.BackupVersions(&backup, 3, 6, signer))
+
+	from, signed, err := readBackupHeader(&backup)
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), from)
+	require.Equal(t, responses[6].snapshot.Version, signed.Snapshot.Version)
+	require.Equal(t, responses[6].snapshot.HistoryDigest, signed.Snapshot.HistoryDigest)
+	require.Equal(t, responses[6].snapshot.HyperDigest, signed.Snapshot.HyperDigest)
+	require.Equal(t, int64(107), signed.Snapshot.Timestamp)
+	require.NoError(t, verifyBackup(signed, []sign.Signer{signer}))
+
+	// only the payloads and digests of the range are backed up
+	br := bufio.NewReader(&backup)
+	_, err = readSnapshotHeader(br)
+	require.NoError(t, err)
+	versions := make(map[storage.Table][]uint64)
+	err = storage.ReadBackup(br, func(table storage.Table, key, value []byte) error {
+		if table == storage.PayloadsTable || table == storage.IndexTable {
+			versions[table] = append(versions[table], util.BytesAsUint64(key))
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, []uint64{3, 4, 5, 6}, versions[storage.PayloadsTable])
+	require.Equal(t, []uint64{3, 4, 5, 6}, versions[storage.IndexTable])
+}
+
+func TestBackupStateAt(t *testing.T) {
+
+	log.SetLogger("TestBackupStateAt", log.SILENT)
+
+	store, closeF := storage_utils.OpenBPlusTreeStore()
+	defer closeF()
+
+	fsm, err := NewBalloonFSM(store, hashing.Sha256)
+	require.NoError(t, err)
+	fsm.keys = []protocol.KeyInfo{
+		{KeyID: "first", ValidFrom: 0, ValidUntil: 100},
+		{KeyID: "second", ValidFrom: 100, ValidUntil: 200},
+		{KeyID: "third", ValidFrom: 200},
+	}
+
+	tests := []struct {
+		timestamp    int64
+		expectedKeys []protocol.KeyInfo
+	}{
+		{0, fsm.keys},
+		{50, []protocol.KeyInfo{{KeyID: "first", ValidFrom: 0}}},
+		{150, []protocol.KeyInfo{{KeyID: "first", ValidFrom: 0, ValidUntil: 100}, {KeyID: "second", ValidFrom: 100}}},
+		{250, fsm.keys},
+	}
+
+	for i, test := range tests {
+		state := fsm.stateAt(7, test.timestamp)
+		require.Equalf(t, uint64(7), state.BalloonVersion, "Unexpected version in test %d", i)
+		require.Equalf(t, hashing.Sha256, state.Hasher, "Unexpected hasher in test %d", i)
+		require.Equalf(t, test.timestamp, state.Timestamp, "Unexpected timestamp in test %d", i)
+		require.Zerof(t, state.Index, "The raft index should be reset in test %d", i)
+		require.Equalf(t, test.expectedKeys, state.Keys, "Unexpected keys in test %d", i)
+	}
+}
+
+func TestCheckBackupDigests(t *testing.T) {
+
+	last := &protocol.Snapshot{HistoryDigest: []byte{0x1}, HyperDigest: []byte{0x2}, Version: 9, Timestamp: 100}
+	signed := func(s protocol.Snapshot, hasher string) *protocol.SignedSnapshot {
+		return &protocol.SignedSnapshot{Snapshot: &s, Hasher: hasher}
+	}
+
+	tests := []struct {
+		signed        *protocol.SignedSnapshot
+		last          *protocol.Snapshot
+		expectedError bool
+	}{
+		{nil, nil, false}, // empty log
+		{signed(*last, hashing.Sha256), last, false}, // same snapshot
+		{nil, last, true},                          // missing snapshot
+		{signed(*last, hashing.Sha256), nil, true}, // missing log
+		{signed(*last, hashing.Sha3_256), last, true},
+		{signed(protocol.Snapshot{HistoryDigest: []byte{0x1}, HyperDigest: []byte{0x2}, Version: 8, Timestamp: 100}, hashing.Sha256), last, true},
+		{signed(protocol.Snapshot{HistoryDigest: []byte{0x3}, HyperDigest: []byte{0x2}, Version: 9, Timestamp: 100}, hashing.Sha256), last, true},
+		{signed(protocol.Snapshot{HistoryDigest: []byte{0x1}, HyperDigest: []byte{0x3}, Version: 9, Timestamp: 100}, hashing.Sha256), last, true},
+		{signed(protocol.Snapshot{HistoryDigest: []byte{0x1}, HyperDigest: []byte{0x2}, Version: 9, Timestamp: 101}, hashing.Sha256), last, true},
+	}
+
+	for i, test := range tests {
+		err := checkBackupDigests(test.signed, test.last, hashing.Sha256)
+		require.Equalf(t, test.expectedError, err != nil, "Unexpected error in test %d: %v", i, err)
+	}
+}
+
+func TestVerifyBackup(t *testing.T) {
+
+	signer, other, untrusted := sign.NewEd25519Signer(), sign.NewECDSASigner(), sign.NewEd25519Signer()
+
+	signWith := func(key sign.Signer) *protocol.SignedSnapshot {
+		signed := &protocol.SignedSnapshot{
+			Snapshot: &protocol.Snapshot{HistoryDigest: []byte{0x1}, HyperDigest: []byte{0x2}, Version: 9},
+			Hasher:   hashing.Sha256,
+			KeyID:    sign.KeyID(key.PublicKey()),
+		}
+		payload, err := signed.SigningPayload()
+		require.NoError(t, err)
+		signed.Signature, err = key.Sign(payload)
+		require.NoError(t, err)
+		return signed
+	}
+	trusted := []sign.Signer{signer, other}
+
+	require.NoError(t, verifyBackup(signWith(signer), trusted), "Trusted keys should verify the backup")
+	require.NoError(t, verifyBackup(signWith(other), trusted), "Every trusted key should verify the backup")
+	require.Error(t, verifyBackup(signWith(untrusted), trusted), "Untrusted keys should not verify the backup")
+
+	tampered := signWith(signer)
+	tampered.Snapshot.Version++
+	require.Error(t, verifyBackup(tampered, trusted), "Tampered snapshots should not verify")
+}
+
+func TestBackupAndRestore(t *testing.T) {
+
+	log.SetLogger("TestBackupAndRestore", log.SILENT)
+
+	store, closeF := storage_utils.OpenRocksDBStore(t, "/var/tmp/backup.test.db")
+	defer closeF()
+
+	fsm, err := NewBalloonFSM(store, hashing.Sha256)
+	require.NoError(t, err)
+
+	for i := uint64(1); i <= 10; i++ {
+		command := newRaftCommand(commands.AddEventCommandType, []byte{byte(i)})
+		require.NoError(t, fsm.Apply(newRaftLog(i, 1, command)).(*fsmAddResponse).error)
+	}
+
+	signer := sign.NewEd25519Signer()
+	var backup bytes.Buffer
+	require.NoError(t, fsm.Backup(&backup, signer))
+
+	store2, close2F := storage_utils.OpenRocksDBStore(t, "/var/tmp/backup.test.2.db")
+	defer close2F()
+
+	_, err = RestoreBackup(bytes.NewReader(backup.Bytes()), store2, hashing.Sha256)
+	require.Error(t, err, "Backups signed with untrusted keys should not be restored")
+
+	store3, close3F := storage_utils.OpenRocksDBStore(t, "/var/tmp/backup.test.3.db")
+	defer close3F()
+
+	signed, err := RestoreBackup(bytes.NewReader(backup.Bytes()), store3, hashing.Sha256, signer)
+	require.NoError(t, err)
+	require.Equal(t, uint64(9), signed.Snapshot.Version)
+
+	// the restored log applies the commands of a new raft log
+	fsm3, err := NewBalloonFSM(store3, hashing.Sha256)
+	require.NoError(t, err)
+	require.Equal(t, uint64(10), fsm3.Version())
+	command := newRaftCommand(commands.AddEventCommandType, []byte{0xff})
+	r := fsm3.Apply(newRaftLog(3, 1, command)).(*fsmAddResponse)
+	require.NoError(t, r.error)
+	require.Equal(t, uint64(10), r.snapshot.Version)
+}
+
+func TestRestoreAlteredBackup(t *testing.T) {
+
+	log.SetLogger("TestRestoreAlteredBackup", log.SILENT)
+
+	store, closeF := storage_utils.OpenRocksDBStore(t, "/var/tmp/backup.altered.test.db")
+	defer closeF()
+
+	fsm, err := NewBalloonFSM(store, hashing.Sha256)
+	require.NoError(t, err)
+
+	for i := uint64(1); i <= 10; i++ {
+		command := newRaftCommand(commands.AddEventCommandType, []byte{byte(i)})
+		require.NoError(t, fsm.Apply(newRaftLog(i, 1, command)).(*fsmAddResponse).error)
+	}
+
+	// the first leaf is below the top nodes the snapshot is computed from
+	leaf := append(util.Uint64AsBytes(0), util.Uint16AsBytes(0)...)
+	require.NoError(t, store.Mutate([]*storage.Mutation{
+		storage.NewMutation(storage.HistoryTable, leaf, hashing.NewSha256Hasher().Do([]byte("altered"))),
+	}))
+
+	signer := sign.NewEd25519Signer()
+	var backup bytes.Buffer
+	require.NoError(t, fsm.Backup(&backup, signer))
+
+	store2, close2F := storage_utils.OpenRocksDBStore(t, "/var/tmp/backup.altered.test.2.db")
+	defer close2F()
+
+	_, err = RestoreBackup(bytes.NewReader(backup.Bytes()), store2, hashing.Sha256, signer)
+	require.Error(t, err, "Backups with altered leaves should not be restored")
+}
+
+func TestBackupVersionsAndRestore(t *testing.T) {
+
+	log.SetLogger("TestBackupVersionsAndRestore", log.SILENT)
+
+	store, closeF := storage_utils.OpenRocksDBStore(t, "/var/tmp/backup.versions.test.db")
+	defer closeF()
+
+	fsm, err := NewBalloonFSM(store, hashing.Sha256)
+	require.NoError(t, err)
+
+	for i := uint64(1); i <= 10; i++ {
+		command := newRaftCommand(commands.AddEventCommandType, []byte{byte(i)})
+		require.NoError(t, fsm.Apply(newRaftLog(i, 1, command)).(*fsmAddResponse).error)
+	}
+
+	signer := sign.NewEd25519Signer()
+	var full, incremental bytes.Buffer
+	require.NoError(t, fsm.BackupVersions(&full, 0, 4, signer))
+	require.NoError(t, fsm.BackupVersions(&incremental, 5, 9, signer))
+
+	store2, close2F := storage_utils.OpenRocksDBStore(t, "/var/tmp/backup.versions.test.2.db")
+	defer close2F()
+
+	_, err = RestoreBackup(bytes.NewReader(incremental.Bytes()), store2, hashing.Sha256, signer)
+	require.Error(t, err, "Incremental backups should not be restored into an empty store")
+
+	signed, err := RestoreBackup(bytes.NewReader(full.Bytes()), store2, hashing.Sha256, signer)
+	require.NoError(t, err)
+	require.Equal(t, uint64(4), signed.Snapshot.Version)
+
+	signed, err = RestoreBackup(bytes.NewReader(incremental.Bytes()), store2, hashing.Sha256, signer)
+	require.NoError(t, err)
+	require.Equal(t, uint64(9), signed.Snapshot.Version)
+
+	_, err = RestoreBackup(bytes.NewReader(full.Bytes()), store2, hashing.Sha256, signer)
+	require.Error(t, err, "Full backups should not be restored into a store with versions")
+
+	fsm2, err := NewBalloonFSM(store2, hashing.Sha256)
+	require.NoError(t, err)
+	require.Equal(t, uint64(10), fsm2.Version())
+}
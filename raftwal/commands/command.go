@@ -31,14 +31,63 @@ const (
 	AddEventsBulkCommandType
 	MetadataSetCommandType
 	MetadataDeleteCommandType
+	AddDigestCommandType
+	AddDigestsBulkCommandType
+	ActivateKeyCommandType
 )
 
+// AddEventCommand carries the event to add and the time, in nanoseconds
+// since the epoch, at which the leader received it. IfAbsent skips events
+// already in the balloon, and a non-empty IdempotencyKey makes retries of
+// the same command return the snapshot of the first one. StorePayload
+// keeps the event itself along with its digest.
 type AddEventCommand struct {
-	Event []byte
+	Event          []byte
+	Timestamp      int64
+	IfAbsent       bool
+	IdempotencyKey string
+	StorePayload   bool
 }
 
+// AddEventsBulkCommand carries the events to add and the time, in
+// nanoseconds since the epoch, at which the leader received them.
+// IfAbsent skips events already in the balloon, and StorePayloads keeps
+// the events added along with their digests, except the ones larger than
+// a positive MaxPayloadSize.
 type AddEventsBulkCommand struct {
-	Events [][]byte
+	Events         [][]byte
+	Timestamp      int64
+	IfAbsent       bool
+	StorePayloads  bool
+	MaxPayloadSize int
+}
+
+// AddDigestCommand carries an event already hashed by the producer and the
+// time, in nanoseconds since the epoch, at which the leader received it.
+type AddDigestCommand struct {
+	Digest    []byte
+	Timestamp int64
+}
+
+// AddDigestsBulkCommand carries a bulk of events already hashed by the
+// producer and the time, in nanoseconds since the epoch, at which the
+// leader received them.
+type AddDigestsBulkCommand struct {
+	Digests   [][]byte
+	Timestamp int64
+}
+
+// ActivateKeyCommand makes the given public key the one that signs the
+// snapshots from the time, in nanoseconds since the epoch, at which the
+// leader received the command. Logs that have never been rotated also
+// record the key that signed until then as the previous one. Algorithms
+// are the ones of the sign package.
+type ActivateKeyCommand struct {
+	PublicKey         []byte
+	Algorithm         string
+	PreviousPublicKey []byte
+	PreviousAlgorithm string
+	Timestamp         int64
 }
 
 type MetadataSetCommand struct {
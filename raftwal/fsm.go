@@ -17,37 +17,57 @@
 package raftwal
 
 import (
+	"bufio"
 	"bytes"
-
 	"encoding/json"
 	"fmt"
 	"io"
 	"sync"
+	"time"
 
 	"github.com/bbva/qed/balloon"
+	"github.com/bbva/qed/balloon/history"
 	"github.com/bbva/qed/hashing"
 	"github.com/bbva/qed/log"
+	"github.com/bbva/qed/protocol"
 	"github.com/bbva/qed/raftwal/commands"
+	"github.com/bbva/qed/sign"
 	"github.com/bbva/qed/storage"
+	"github.com/bbva/qed/util"
 	"github.com/hashicorp/go-msgpack/codec"
 	"github.com/hashicorp/raft"
 )
 
+const (
+	// IdempotencyKeyTTL is how long an idempotency key is honoured after
+	// the add that used it, as logged by the leader. Expired keys can be
+	// used again and are pruned from the store.
+	IdempotencyKeyTTL = 24 * time.Hour
+
+	// idempotencyKeysPruneInterval is the minimum log time between two
+	// prunings of the expired idempotency keys.
+	idempotencyKeysPruneInterval = time.Hour
+)
+
 type fsmGenericResponse struct {
 	error error
 }
 
 type fsmAddResponse struct {
 	snapshot *balloon.Snapshot
+	proof    *balloon.MembershipProof // only set when the event already existed
+	existing bool                     // true when no event was added
 	error    error
 }
 
 type fsmAddBulkResponse struct {
 	snapshotBulk []*balloon.Snapshot
+	proofs       []*balloon.MembershipProof // only set for the events that already existed
 	error        error
 }
 
 type BalloonFSM struct {
+	hasher  string
 	hasherF func() hashing.Hasher
 
 	store   storage.ManagedStore
@@ -57,6 +77,11 @@ type BalloonFSM struct {
 	metaMu sync.RWMutex
 	meta   map[string]map[string]string
 
+	keysMu sync.RWMutex
+	keys   []protocol.KeyInfo // signing keys, also recorded in the state
+
+	lastKeysPrune int64 // log time of the last pruning of idempotency keys
+
 	restoreMu sync.RWMutex // Restore needs exclusive access to database.
 }
 
@@ -65,7 +90,7 @@ func loadState(s storage.ManagedStore) (*fsmState, error) {
 	kvstate, err := s.Get(storage.FSMStateTable, storage.FSMStateTableKey)
 	if err == storage.ErrKeyNotFound {
 		log.Infof("Unable to find previous state: assuming a clean instance")
-		return &fsmState{0, 0, 0}, nil
+		return &fsmState{}, nil
 	}
 	if err != nil {
 		return nil, err
@@ -75,27 +100,118 @@ func loadState(s storage.ManagedStore) (*fsmState, error) {
 	return &state, err
 }
 
-func NewBalloonFSM(store storage.ManagedStore, hasherF func() hashing.Hasher) (*BalloonFSM, error) {
+// checkState verifies that the hasher recorded in the given state matches
+// the one requested. A clean state adopts the requested hasher and the
+// current tree format, while states persisted before they were recorded
+// are assumed to be SHA-256 logs with the legacy format. States restored
+// from a backup have no raft index but keep the recorded ones.
+func checkState(state *fsmState, hasher string) error {
+	if state.Hasher == "" {
+		if state.Index == 0 {
+			state.Hasher = hasher
+			state.Format = hashing.CurrentFormat
+		} else {
+			state.Hasher = hashing.Sha256
+		}
+	}
+	if state.Hasher != hasher {
+		return fmt.Errorf("hasher mismatch: the log was created with %s but %s was requested", state.Hasher, hasher)
+	}
+	return nil
+}
+
+func NewBalloonFSM(store storage.ManagedStore, hasher string) (*BalloonFSM, error) {
 
-	b, err := balloon.NewBalloon(store, hasherF)
+	hasherF, err := hashing.NewHasherF(hasher)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%s: %s", err, hasher)
 	}
 	state, err := loadState(store)
 	if err != nil {
 		log.Infof("There was an error recovering the FSM state!!")
 		return nil, err
 	}
+	if err := checkState(state, hasher); err != nil {
+		return nil, err
+	}
+
+	// Record the hasher and the format as soon as the log is bootstrapped
+	// so that the node cannot be restarted with different ones.
+	stateBuff, err := encodeMsgPack(state)
+	if err != nil {
+		return nil, err
+	}
+	err = store.Mutate([]*storage.Mutation{
+		storage.NewMutation(storage.FSMStateTable, storage.FSMStateTableKey, stateBuff.Bytes()),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := balloon.NewBalloon(store, hasherF, state.Format)
+	if err != nil {
+		return nil, err
+	}
 
 	return &BalloonFSM{
+		hasher:  hasher,
 		hasherF: hasherF,
 		store:   store,
 		balloon: b,
 		state:   state,
 		meta:    make(map[string]map[string]string),
+		keys:    state.Keys,
 	}, nil
 }
 
+// Hasher returns the name of the hashing algorithm used by the balloon.
+func (fsm *BalloonFSM) Hasher() string {
+	return fsm.hasher
+}
+
+// Format returns the format version of the balloon trees.
+func (fsm *BalloonFSM) Format() hashing.FormatVersion {
+	return fsm.balloon.Format()
+}
+
+// SetFormat makes a clean log use the given tree format instead of the
+// current one, so that the node can join a cluster created with an older
+// format. A log that already holds events keeps its format, and asking for
+// a different one is an error.
+func (fsm *BalloonFSM) SetFormat(format hashing.FormatVersion) error {
+	if format > hashing.CurrentFormat {
+		return fmt.Errorf("unknown tree format %d", format)
+	}
+	if format == fsm.balloon.Format() {
+		return nil
+	}
+	if fsm.state.Index != 0 || fsm.balloon.Version() != 0 {
+		return fmt.Errorf("format mismatch: the log uses format %d but %d was requested", fsm.balloon.Format(), format)
+	}
+
+	state := *fsm.state
+	state.Format = format
+	stateBuff, err := encodeMsgPack(&state)
+	if err != nil {
+		return err
+	}
+	err = fsm.store.Mutate([]*storage.Mutation{
+		storage.NewMutation(storage.FSMStateTable, storage.FSMStateTableKey, stateBuff.Bytes()),
+	})
+	if err != nil {
+		return err
+	}
+
+	b, err := balloon.NewBalloon(fsm.store, fsm.hasherF, format)
+	if err != nil {
+		return err
+	}
+	fsm.balloon.Close()
+	fsm.balloon = b
+	fsm.state = &state
+	return nil
+}
+
 func (fsm *BalloonFSM) QueryDigestMembership(keyDigest hashing.Digest, version uint64) (*balloon.MembershipProof, error) {
 	return fsm.balloon.QueryDigestMembership(keyDigest, version)
 }
@@ -108,8 +224,62 @@ func (fsm *BalloonFSM) QueryConsistency(start, end uint64) (*balloon.Incremental
 	return fsm.balloon.QueryConsistency(start, end)
 }
 
+// Version returns the number of events added to the balloon.
+func (fsm *BalloonFSM) Version() uint64 {
+	return fsm.balloon.Version()
+}
+
+func (fsm *BalloonFSM) QueryHistoryDigest(version uint64) (hashing.Digest, error) {
+	return fsm.balloon.QueryHistoryDigest(version)
+}
+
+func (fsm *BalloonFSM) QueryHyperDigest(version uint64) (hashing.Digest, error) {
+	return fsm.balloon.QueryHyperDigest(version)
+}
+
+func (fsm *BalloonFSM) QueryLeafHashes(start, end uint64) ([]hashing.Digest, error) {
+	return fsm.balloon.QueryLeafHashes(start, end)
+}
+
+func (fsm *BalloonFSM) QueryEventDigests(start, end uint64) ([]hashing.Digest, error) {
+	return fsm.balloon.QueryEventDigests(start, end)
+}
+
+func (fsm *BalloonFSM) QueryHistoryMembership(index, version uint64) (*history.MembershipProof, error) {
+	return fsm.balloon.QueryHistoryMembership(index, version)
+}
+
+// QueryTimestamp returns the time, in nanoseconds since the epoch, at
+// which the event with the given version was logged. Events logged before
+// timestamps were recorded have a zero timestamp, and versions that are
+// not in the log return storage.ErrKeyNotFound.
+func (fsm *BalloonFSM) QueryTimestamp(version uint64) (int64, error) {
+	kv, err := fsm.store.Get(storage.TimestampsTable, util.Uint64AsBytes(version))
+	if err == storage.ErrKeyNotFound && version < fsm.balloon.Version() {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return int64(util.BytesAsUint64(kv.Value)), nil
+}
+
+// QueryPayload returns the event with the given version, if its payload
+// has been stored, or storage.ErrKeyNotFound otherwise.
+func (fsm *BalloonFSM) QueryPayload(version uint64) ([]byte, error) {
+	kv, err := fsm.store.Get(storage.PayloadsTable, util.Uint64AsBytes(version))
+	if err != nil {
+		return nil, err
+	}
+	return storage.DecodePayload(kv.Value)
+}
+
 type fsmState struct {
 	Index, Term, BalloonVersion uint64
+	Hasher                      string
+	Format                      hashing.FormatVersion
+	Timestamp                   int64              // last timestamp applied
+	Keys                        []protocol.KeyInfo // signing keys by activation
 }
 
 func (s fsmState) shouldApply(f *fsmState) bool {
@@ -129,7 +299,9 @@ func (s fsmState) shouldApply(f *fsmState) bool {
 
 // Apply applies a Raft log entry to the database.
 func (fsm *BalloonFSM) Apply(l *raft.Log) interface{} {
-	// TODO should i use a restore mutex?
+	// Backups need the database to stay still while they are taken.
+	fsm.restoreMu.RLock()
+	defer fsm.restoreMu.RUnlock()
 
 	buf := l.Data
 	cmdType := commands.CommandType(buf[0])
@@ -140,9 +312,9 @@ func (fsm *BalloonFSM) Apply(l *raft.Log) interface{} {
 		if err := commands.Decode(buf[1:], &cmd); err != nil {
 			return &fsmAddResponse{error: err}
 		}
-		newState := &fsmState{l.Index, l.Term, fsm.balloon.Version()}
+		newState := &fsmState{l.Index, l.Term, fsm.balloon.Version(), fsm.hasher, fsm.balloon.Format(), fsm.nextTimestamp(cmd.Timestamp), fsm.state.Keys}
 		if fsm.state.shouldApply(newState) {
-			return fsm.applyAdd(cmd.Event, newState)
+			return fsm.applyAdd(&cmd, newState)
 		}
 		return &fsmAddResponse{error: fmt.Errorf("state already applied!: %+v -> %+v", fsm.state, newState)}
 
@@ -152,12 +324,42 @@ func (fsm *BalloonFSM) Apply(l *raft.Log) interface{} {
 			return &fsmAddBulkResponse{error: err}
 		}
 		// INFO: after applying a bulk there will be a jump in term version due to balloon version mapping.
-		newState := &fsmState{l.Index, l.Term, fsm.balloon.Version() + uint64(len(cmd.Events)-1)}
+		newState := &fsmState{l.Index, l.Term, fsm.balloon.Version() + uint64(len(cmd.Events)-1), fsm.hasher, fsm.balloon.Format(), fsm.nextTimestamp(cmd.Timestamp), fsm.state.Keys}
+		if fsm.state.shouldApply(newState) {
+			return fsm.applyAddBulk(&cmd, newState)
+		}
+		return &fsmAddBulkResponse{error: fmt.Errorf("state already applied!: %+v -> %+v", fsm.state, newState)}
+
+	case commands.AddDigestCommandType:
+		var cmd commands.AddDigestCommand
+		if err := commands.Decode(buf[1:], &cmd); err != nil {
+			return &fsmAddResponse{error: err}
+		}
+		newState := &fsmState{l.Index, l.Term, fsm.balloon.Version(), fsm.hasher, fsm.balloon.Format(), fsm.nextTimestamp(cmd.Timestamp), fsm.state.Keys}
+		if fsm.state.shouldApply(newState) {
+			return fsm.applyAddDigest(cmd.Digest, newState)
+		}
+		return &fsmAddResponse{error: fmt.Errorf("state already applied!: %+v -> %+v", fsm.state, newState)}
+
+	case commands.AddDigestsBulkCommandType:
+		var cmd commands.AddDigestsBulkCommand
+		if err := commands.Decode(buf[1:], &cmd); err != nil {
+			return &fsmAddBulkResponse{error: err}
+		}
+		// INFO: after applying a bulk there will be a jump in term version due to balloon version mapping.
+		newState := &fsmState{l.Index, l.Term, fsm.balloon.Version() + uint64(len(cmd.Digests)-1), fsm.hasher, fsm.balloon.Format(), fsm.nextTimestamp(cmd.Timestamp), fsm.state.Keys}
 		if fsm.state.shouldApply(newState) {
-			return fsm.applyAddBulk(cmd.Events, newState)
+			return fsm.applyAddDigestBulk(cmd.Digests, newState)
 		}
 		return &fsmAddBulkResponse{error: fmt.Errorf("state already applied!: %+v -> %+v", fsm.state, newState)}
 
+	case commands.ActivateKeyCommandType:
+		var cmd commands.ActivateKeyCommand
+		if err := commands.Decode(buf[1:], &cmd); err != nil {
+			return &fsmGenericResponse{error: err}
+		}
+		return fsm.applyActivateKey(&cmd)
+
 	case commands.MetadataSetCommandType:
 		var cmd commands.MetadataSetCommand
 		if err := commands.Decode(buf[1:], &cmd); err != nil {
@@ -200,6 +402,16 @@ func (fsm *BalloonFSM) Snapshot() (raft.FSMSnapshot, error) {
 	fsm.restoreMu.Lock()
 	defer fsm.restoreMu.Unlock()
 
+	snap, err := fsm.snapshot()
+	if err != nil {
+		return nil, err
+	}
+	return snap, nil
+}
+
+// snapshot checkpoints the store and copies the node metadata. The caller
+// must hold the restore mutex.
+func (fsm *BalloonFSM) snapshot() (*fsmSnapshot, error) {
 	id, err := fsm.store.Snapshot()
 	if err != nil {
 		return nil, err
@@ -207,7 +419,9 @@ func (fsm *BalloonFSM) Snapshot() (raft.FSMSnapshot, error) {
 	log.Debugf("Generating snapshot until version: %d (balloon version %d)", id, fsm.balloon.Version())
 
 	// Copy the node metadata.
+	fsm.metaMu.RLock()
 	meta, err := json.Marshal(fsm.meta)
+	fsm.metaMu.RUnlock()
 	if err != nil {
 		log.Debugf("failed to encode meta for snapshot: %s", err.Error())
 		return nil, err
@@ -221,31 +435,46 @@ func (fsm *BalloonFSM) Restore(rc io.ReadCloser) error {
 
 	log.Debug("Restoring Balloon...")
 
-	var err error
+	br := bufio.NewReader(rc)
+	meta, err := readSnapshotHeader(br)
+	if err != nil {
+		return err
+	}
+
 	// Set the state from the snapshot, no lock required according to
 	// Hashicorp docs.
-	if err = fsm.store.Load(rc); err != nil {
+	if err = fsm.store.Load(br); err != nil {
 		return err
 	}
 
-	// TODO: Restore metadata??
+	// A snapshot built with a different hasher or tree format cannot
+	// be served by this node.
+	state, err := loadState(fsm.store)
+	if err != nil {
+		return err
+	}
+	if err = checkState(state, fsm.hasher); err != nil {
+		return err
+	}
+	if state.Format != fsm.balloon.Format() {
+		return fmt.Errorf("format mismatch: the snapshot uses format %d but this node uses %d", state.Format, fsm.balloon.Format())
+	}
+	fsm.state = state
 
-	// log.Debug("Restoring Metadata...")
-	// var sz uint64
+	fsm.keysMu.Lock()
+	fsm.keys = state.Keys
+	fsm.keysMu.Unlock()
 
-	// // Get size of meta, read those bytes, and set to meta.
-	// if err := binary.Read(rc, binary.LittleEndian, &sz); err != nil {
-	// 	return err
-	// }
-	// meta := make([]byte, sz)
-	// if _, err := io.ReadFull(rc, meta); err != nil {
-	// 	return err
-	// }
-	// err = func() error {
-	// 	fsm.metaMu.Lock()
-	// 	defer fsm.metaMu.Unlock()
-	// 	return json.Unmarshal(meta, &fsm.meta)
-	// }()
+	if meta != nil {
+		log.Debug("Restoring Metadata...")
+		restored := make(map[string]map[string]string)
+		if err := json.Unmarshal(meta, &restored); err != nil {
+			return err
+		}
+		fsm.metaMu.Lock()
+		fsm.meta = restored
+		fsm.metaMu.Unlock()
+	}
 
 	return fsm.balloon.RefreshVersion()
 }
@@ -255,13 +484,169 @@ func (fsm *BalloonFSM) Close() error {
 	return nil
 }
 
-func (fsm *BalloonFSM) applyAdd(event []byte, state *fsmState) *fsmAddResponse {
+func (fsm *BalloonFSM) applyAdd(cmd *commands.AddEventCommand, state *fsmState) *fsmAddResponse {
+
+	if err := fsm.pruneIdempotencyKeys(state.Timestamp); err != nil {
+		return &fsmAddResponse{error: err}
+	}
+
+	if cmd.IdempotencyKey != "" {
+		snapshot, err := fsm.queryIdempotencyKey(cmd.IdempotencyKey, state.Timestamp)
+		if err != nil {
+			return &fsmAddResponse{error: err}
+		}
+		if snapshot != nil {
+			if !bytes.Equal(snapshot.EventDigest, fsm.hasherF().Do(cmd.Event)) {
+				return &fsmAddResponse{error: ErrIdempotencyKeyReused}
+			}
+			return fsm.applyNoop(snapshot, nil, state)
+		}
+	}
+
+	var snapshot *balloon.Snapshot
+	var mutations []*storage.Mutation
+	var err error
+	if cmd.IfAbsent {
+		var proof *balloon.MembershipProof
+		snapshot, proof, mutations, err = fsm.balloon.AddIfAbsent(cmd.Event)
+		if err != nil {
+			return &fsmAddResponse{error: err}
+		}
+		if proof != nil {
+			if err := fsm.setTimestamp(snapshot); err != nil {
+				return &fsmAddResponse{error: err}
+			}
+			return fsm.applyNoop(snapshot, proof, state)
+		}
+	} else {
+		snapshot, mutations, err = fsm.balloon.Add(cmd.Event)
+		if err != nil {
+			return &fsmAddResponse{error: err}
+		}
+	}
+	snapshot.Timestamp = state.Timestamp
+	mutations = append(mutations, timestampMutation(snapshot))
+	if cmd.StorePayload {
+		mutations = append(mutations, payloadMutation(snapshot, cmd.Event))
+	}
+
+	if cmd.IdempotencyKey != "" {
+		snapshotBuff, err := encodeMsgPack(snapshot)
+		if err != nil {
+			return &fsmAddResponse{error: err}
+		}
+		mutations = append(mutations,
+			storage.NewMutation(storage.IdempotencyKeysTable, []byte(cmd.IdempotencyKey), snapshotBuff.Bytes()),
+			idempotencyExpiryMutation(snapshot, cmd.IdempotencyKey),
+		)
+	}
+
+	stateBuff, err := encodeMsgPack(state)
+	if err != nil {
+		return &fsmAddResponse{error: err}
+	}
+
+	mutations = append(mutations, storage.NewMutation(storage.FSMStateTable, storage.FSMStateTableKey, stateBuff.Bytes()))
+	err = fsm.store.Mutate(mutations)
+	if err != nil {
+		return &fsmAddResponse{error: err}
+	}
+	fsm.state = state
+
+	return &fsmAddResponse{snapshot: snapshot}
+}
+
+// applyNoop records that the command has been applied without adding any
+// event, so the balloon version and the timestamp of the state stay the
+// same.
+func (fsm *BalloonFSM) applyNoop(snapshot *balloon.Snapshot, proof *balloon.MembershipProof, state *fsmState) *fsmAddResponse {
+
+	state.BalloonVersion = fsm.state.BalloonVersion
+	state.Timestamp = fsm.state.Timestamp
 
-	snapshot, mutations, err := fsm.balloon.Add(event)
+	stateBuff, err := encodeMsgPack(state)
 	if err != nil {
 		return &fsmAddResponse{error: err}
 	}
 
+	err = fsm.store.Mutate([]*storage.Mutation{
+		storage.NewMutation(storage.FSMStateTable, storage.FSMStateTableKey, stateBuff.Bytes()),
+	})
+	if err != nil {
+		return &fsmAddResponse{error: err}
+	}
+	fsm.state = state
+
+	return &fsmAddResponse{snapshot: snapshot, proof: proof, existing: true}
+}
+
+func (fsm *BalloonFSM) applyAddBulk(cmd *commands.AddEventsBulkCommand, state *fsmState) *fsmAddBulkResponse {
+
+	var snapshotBulk []*balloon.Snapshot
+	var proofs []*balloon.MembershipProof
+	var mutations []*storage.Mutation
+	var err error
+	if cmd.IfAbsent {
+		snapshotBulk, proofs, mutations, err = fsm.balloon.AddBulkIfAbsent(cmd.Events)
+	} else {
+		snapshotBulk, mutations, err = fsm.balloon.AddBulk(cmd.Events)
+	}
+	if err != nil {
+		return &fsmAddBulkResponse{error: err}
+	}
+
+	added := false
+	stamped := make(map[*balloon.Snapshot]bool) // repeated events share their snapshot
+	for i, snapshot := range snapshotBulk {
+		if stamped[snapshot] {
+			continue
+		}
+		stamped[snapshot] = true
+		if proofs != nil && proofs[i] != nil {
+			if err := fsm.setTimestamp(snapshot); err != nil {
+				return &fsmAddBulkResponse{error: err}
+			}
+			continue
+		}
+		snapshot.Timestamp = state.Timestamp
+		mutations = append(mutations, timestampMutation(snapshot))
+		if cmd.StorePayloads && (cmd.MaxPayloadSize <= 0 || len(cmd.Events[i]) <= cmd.MaxPayloadSize) {
+			mutations = append(mutations, payloadMutation(snapshot, cmd.Events[i]))
+		}
+		added = true
+	}
+
+	if added {
+		state.BalloonVersion = fsm.balloon.Version() - 1
+	} else {
+		state.BalloonVersion = fsm.state.BalloonVersion
+		state.Timestamp = fsm.state.Timestamp
+	}
+
+	stateBuff, err := encodeMsgPack(state)
+	if err != nil {
+		return &fsmAddBulkResponse{error: err}
+	}
+
+	mutations = append(mutations, storage.NewMutation(storage.FSMStateTable, storage.FSMStateTableKey, stateBuff.Bytes()))
+	err = fsm.store.Mutate(mutations)
+	if err != nil {
+		return &fsmAddBulkResponse{error: err}
+	}
+	fsm.state = state
+
+	return &fsmAddBulkResponse{snapshotBulk: snapshotBulk, proofs: proofs}
+}
+
+func (fsm *BalloonFSM) applyAddDigest(digest []byte, state *fsmState) *fsmAddResponse {
+
+	snapshot, mutations, err := fsm.balloon.AddDigest(digest)
+	if err != nil {
+		return &fsmAddResponse{error: err}
+	}
+	snapshot.Timestamp = state.Timestamp
+	mutations = append(mutations, timestampMutation(snapshot))
+
 	stateBuff, err := encodeMsgPack(state)
 	if err != nil {
 		return &fsmAddResponse{error: err}
@@ -277,12 +662,21 @@ func (fsm *BalloonFSM) applyAdd(event []byte, state *fsmState) *fsmAddResponse {
 	return &fsmAddResponse{snapshot: snapshot}
 }
 
-func (fsm *BalloonFSM) applyAddBulk(events [][]byte, state *fsmState) *fsmAddBulkResponse {
+func (fsm *BalloonFSM) applyAddDigestBulk(digests [][]byte, state *fsmState) *fsmAddBulkResponse {
 
-	snapshotBulk, mutations, err := fsm.balloon.AddBulk(events)
+	eventBulkDigest := make([]hashing.Digest, 0, len(digests))
+	for _, digest := range digests {
+		eventBulkDigest = append(eventBulkDigest, digest)
+	}
+
+	snapshotBulk, mutations, err := fsm.balloon.AddDigestBulk(eventBulkDigest)
 	if err != nil {
 		return &fsmAddBulkResponse{error: err}
 	}
+	for _, snapshot := range snapshotBulk {
+		snapshot.Timestamp = state.Timestamp
+		mutations = append(mutations, timestampMutation(snapshot))
+	}
 
 	stateBuff, err := encodeMsgPack(state)
 	if err != nil {
@@ -299,6 +693,189 @@ func (fsm *BalloonFSM) applyAddBulk(events [][]byte, state *fsmState) *fsmAddBul
 	return &fsmAddBulkResponse{snapshotBulk: snapshotBulk}
 }
 
+// setTimestamp fills in the timestamp of the snapshot of an existing event.
+func (fsm *BalloonFSM) setTimestamp(snapshot *balloon.Snapshot) error {
+	timestamp, err := fsm.QueryTimestamp(snapshot.Version)
+	if err != nil {
+		return err
+	}
+	snapshot.Timestamp = timestamp
+	return nil
+}
+
+// queryIdempotencyKey returns the snapshot of the event added with the
+// given idempotency key, or nil if the key has not been used or it has
+// expired at the given log time.
+func (fsm *BalloonFSM) queryIdempotencyKey(key string, now int64) (*balloon.Snapshot, error) {
+	snapshot, err := fsm.loadIdempotencyKey([]byte(key))
+	if err != nil || snapshot == nil {
+		return nil, err
+	}
+	if idempotencyKeyExpired(snapshot, now) {
+		return nil, nil
+	}
+	return snapshot, nil
+}
+
+// loadIdempotencyKey returns the snapshot stored for the given idempotency
+// key, expired or not, or nil if the key has not been used.
+func (fsm *BalloonFSM) loadIdempotencyKey(key []byte) (*balloon.Snapshot, error) {
+	kv, err := fsm.store.Get(storage.IdempotencyKeysTable, key)
+	if err == storage.ErrKeyNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var snapshot balloon.Snapshot
+	if err := decodeMsgPack(kv.Value, &snapshot); err != nil {
+		return nil, err
+	}
+	return &snapshot, nil
+}
+
+// pruneIdempotencyKeys deletes the idempotency keys expired at the given
+// log time, at most once every idempotencyKeysPruneInterval. Expired keys
+// are never honoured and log times only grow, so every node can prune
+// them at a different time without changing the results of the commands.
+//
+// Only the range of the expiry index that has expired is read. A key that
+// was used again after it expired has a newer entry in the index, so it
+// is only deleted along with its last entry.
+func (fsm *BalloonFSM) pruneIdempotencyKeys(now int64) error {
+	if now-fsm.lastKeysPrune < int64(idempotencyKeysPruneInterval) {
+		return nil
+	}
+	cutoff := now - int64(IdempotencyKeyTTL)
+	if cutoff < 0 {
+		fsm.lastKeysPrune = now
+		return nil
+	}
+	// index keys start with the log time, so every entry logged at the
+	// cutoff or before sorts before the next log time alone
+	expired, err := fsm.store.GetRange(storage.IdempotencyExpiryTable, util.Uint64AsBytes(0), util.Uint64AsBytes(uint64(cutoff)+1))
+	if err != nil {
+		return err
+	}
+	var deletions []*storage.Mutation
+	for _, kv := range expired {
+		deletions = append(deletions, storage.NewDeletion(storage.IdempotencyExpiryTable, kv.Key))
+		snapshot, err := fsm.loadIdempotencyKey(kv.Value)
+		if err != nil {
+			return err
+		}
+		if snapshot != nil && idempotencyKeyExpired(snapshot, now) {
+			deletions = append(deletions, storage.NewDeletion(storage.IdempotencyKeysTable, kv.Value))
+		}
+	}
+	if len(deletions) > 0 {
+		if err := fsm.store.Mutate(deletions); err != nil {
+			return err
+		}
+		log.Debugf("Pruned %d expired idempotency keys", len(expired))
+	}
+	fsm.lastKeysPrune = now
+	return nil
+}
+
+// idempotencyExpiryMutation indexes the idempotency key by the log time of
+// the add that used it.
+func idempotencyExpiryMutation(snapshot *balloon.Snapshot, key string) *storage.Mutation {
+	indexKey := append(util.Uint64AsBytes(uint64(snapshot.Timestamp)), key...)
+	return storage.NewMutation(storage.IdempotencyExpiryTable, indexKey, []byte(key))
+}
+
+func idempotencyKeyExpired(snapshot *balloon.Snapshot, now int64) bool {
+	return now-snapshot.Timestamp >= int64(IdempotencyKeyTTL)
+}
+
+// applyActivateKey closes the window of the active key and appends the
+// new one. Keys cannot be activated twice, which also makes the command
+// safe to replay.
+func (fsm *BalloonFSM) applyActivateKey(cmd *commands.ActivateKeyCommand) *fsmGenericResponse {
+
+	keyID := sign.KeyID(cmd.PublicKey)
+	keys := fsm.Keys()
+	for _, k := range keys {
+		if k.KeyID == keyID {
+			return &fsmGenericResponse{error: ErrKeyAlreadyActivated}
+		}
+	}
+
+	if len(keys) == 0 && len(cmd.PreviousPublicKey) > 0 && !bytes.Equal(cmd.PreviousPublicKey, cmd.PublicKey) {
+		keys = append(keys, protocol.KeyInfo{
+			KeyID:     sign.KeyID(cmd.PreviousPublicKey),
+			Algorithm: cmd.PreviousAlgorithm,
+			PublicKey: cmd.PreviousPublicKey,
+		})
+	}
+	if len(keys) > 0 {
+		keys[len(keys)-1].ValidUntil = cmd.Timestamp
+	}
+	keys = append(keys, protocol.KeyInfo{
+		KeyID:     keyID,
+		Algorithm: cmd.Algorithm,
+		PublicKey: cmd.PublicKey,
+		ValidFrom: cmd.Timestamp,
+	})
+
+	state := *fsm.state
+	state.Keys = keys
+	stateBuff, err := encodeMsgPack(&state)
+	if err != nil {
+		return &fsmGenericResponse{error: err}
+	}
+	err = fsm.store.Mutate([]*storage.Mutation{
+		storage.NewMutation(storage.FSMStateTable, storage.FSMStateTableKey, stateBuff.Bytes()),
+	})
+	if err != nil {
+		return &fsmGenericResponse{error: err}
+	}
+	fsm.state = &state
+
+	fsm.keysMu.Lock()
+	fsm.keys = keys
+	fsm.keysMu.Unlock()
+
+	return &fsmGenericResponse{}
+}
+
+// Keys returns a copy of the signing keys of the log, ordered by
+// activation.
+func (fsm *BalloonFSM) Keys() []protocol.KeyInfo {
+	fsm.keysMu.RLock()
+	defer fsm.keysMu.RUnlock()
+	keys := make([]protocol.KeyInfo, len(fsm.keys))
+	copy(keys, fsm.keys)
+	return keys
+}
+
+// nextTimestamp returns the timestamp assigned by the leader unless it is
+// behind the last one applied, so timestamps never go backwards when the
+// leadership moves to a node with a delayed clock.
+func (fsm *BalloonFSM) nextTimestamp(timestamp int64) int64 {
+	if timestamp < fsm.state.Timestamp {
+		return fsm.state.Timestamp
+	}
+	return timestamp
+}
+
+func timestampMutation(snapshot *balloon.Snapshot) *storage.Mutation {
+	return storage.NewMutation(
+		storage.TimestampsTable,
+		util.Uint64AsBytes(snapshot.Version),
+		util.Uint64AsBytes(uint64(snapshot.Timestamp)),
+	)
+}
+
+func payloadMutation(snapshot *balloon.Snapshot, event []byte) *storage.Mutation {
+	return storage.NewMutation(
+		storage.PayloadsTable,
+		util.Uint64AsBytes(snapshot.Version),
+		storage.EncodePayload(event),
+	)
+}
+
 // Decode reverses the encode operation on a byte slice input
 func decodeMsgPack(buf []byte, out interface{}) error {
 	r := bytes.NewBuffer(buf)
@@ -331,6 +908,58 @@ func (fsm *BalloonFSM) Metadata(id, key string) string {
 	return ""
 }
 
+// commandVersions returns the number of events that the command of a log
+// entry adds to the balloon.
+func commandVersions(buf []byte) uint64 {
+	if len(buf) == 0 {
+		return 0
+	}
+	switch commands.CommandType(buf[0]) {
+	case commands.AddEventCommandType, commands.AddDigestCommandType:
+		return 1
+	case commands.AddEventsBulkCommandType:
+		var cmd commands.AddEventsBulkCommand
+		if err := commands.Decode(buf[1:], &cmd); err != nil {
+			return 0
+		}
+		return uint64(len(cmd.Events))
+	case commands.AddDigestsBulkCommandType:
+		var cmd commands.AddDigestsBulkCommand
+		if err := commands.Decode(buf[1:], &cmd); err != nil {
+			return 0
+		}
+		return uint64(len(cmd.Digests))
+	}
+	return 0
+}
+
+// NodeMetadata returns a copy of the metadata of the given node ID.
+func (fsm *BalloonFSM) NodeMetadata(id string) map[string]string {
+	fsm.metaMu.RLock()
+	defer fsm.metaMu.RUnlock()
+
+	md := make(map[string]string, len(fsm.meta[id]))
+	for k, v := range fsm.meta[id] {
+		md[k] = v
+	}
+	return md
+}
+
+// NodesMetadata returns a copy of the metadata of every node.
+func (fsm *BalloonFSM) NodesMetadata() map[string]map[string]string {
+	fsm.metaMu.RLock()
+	defer fsm.metaMu.RUnlock()
+
+	meta := make(map[string]map[string]string, len(fsm.meta))
+	for id, md := range fsm.meta {
+		meta[id] = make(map[string]string, len(md))
+		for k, v := range md {
+			meta[id][k] = v
+		}
+	}
+	return meta
+}
+
 // setMetadata adds the metadata md to any existing metadata for
 // the given node ID.
 func (fsm *BalloonFSM) setMetadata(id string, md map[string]string) *commands.MetadataSetCommand {
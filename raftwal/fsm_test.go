@@ -1,7 +1,12 @@
 package raftwal
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
 	"io"
+	"io/ioutil"
+	"math"
 	"testing"
 
 	"github.com/hashicorp/raft"
@@ -9,11 +14,123 @@ import (
 
 	"github.com/bbva/qed/hashing"
 	"github.com/bbva/qed/log"
+	"github.com/bbva/qed/protocol"
 	"github.com/bbva/qed/raftwal/commands"
+	"github.com/bbva/qed/sign"
+	"github.com/bbva/qed/storage"
 	"github.com/bbva/qed/testutils/rand"
 	storage_utils "github.com/bbva/qed/testutils/storage"
+	"github.com/bbva/qed/util"
 )
 
+func TestNewBalloonFSMHasher(t *testing.T) {
+
+	log.SetLogger("TestNewBalloonFSMHasher", log.SILENT)
+
+	store, closeF := storage_utils.OpenBPlusTreeStore()
+	defer closeF()
+
+	_, err := NewBalloonFSM(store, "md5")
+	require.Error(t, err, "Unknown hashers should be rejected")
+
+	fsm, err := NewBalloonFSM(store, hashing.Sha3_256)
+	require.NoError(t, err)
+	require.Equal(t, hashing.Sha3_256, fsm.Hasher())
+
+	command := newRaftCommand(commands.AddEventCommandType, []byte("All's right with the world"))
+	r := fsm.Apply(newRaftLog(1, 1, command)).(*fsmAddResponse)
+	require.NoError(t, r.error)
+
+	_, err = NewBalloonFSM(store, hashing.Sha256)
+	require.Error(t, err, "The hasher recorded at bootstrap should not change")
+
+	_, err = NewBalloonFSM(store, hashing.Sha3_256)
+	require.NoError(t, err)
+}
+
+func TestCheckState(t *testing.T) {
+
+	tests := []struct {
+		state          *fsmState
+		hasher         string
+		expectedHasher string
+		expectedFormat hashing.FormatVersion
+		expectedError  bool
+	}{
+		{&fsmState{}, hashing.Blake2b256, hashing.Blake2b256, hashing.CurrentFormat, false},                                                             // clean instance
+		{&fsmState{Index: 5, Term: 1}, hashing.Sha256, hashing.Sha256, hashing.FormatV0, false},                                                         // legacy state
+		{&fsmState{Index: 5, Term: 1}, hashing.Sha3_256, hashing.Sha256, hashing.FormatV0, true},                                                        // legacy state
+		{&fsmState{Index: 5, Term: 1, Hasher: hashing.Sha256}, hashing.Sha256, hashing.Sha256, hashing.FormatV0, false},                                 // legacy format
+		{&fsmState{Index: 5, Term: 1, Hasher: hashing.Sha3_256, Format: hashing.FormatV1}, hashing.Sha3_256, hashing.Sha3_256, hashing.FormatV1, false}, // same hasher
+		{&fsmState{Index: 5, Term: 1, Hasher: hashing.Sha3_256, Format: hashing.FormatV1}, hashing.Sha256, hashing.Sha3_256, hashing.FormatV1, true},    // mismatch
+		{&fsmState{BalloonVersion: 9, Hasher: hashing.Sha256, Format: hashing.FormatV0}, hashing.Sha256, hashing.Sha256, hashing.FormatV0, false},       // restored
+	}
+
+	for i, test := range tests {
+		err := checkState(test.state, test.hasher)
+		require.Equalf(t, test.expectedError, err != nil, "Unexpected error in test %d: %v", i, err)
+		require.Equalf(t, test.expectedHasher, test.state.Hasher, "Unexpected hasher in test %d", i)
+		require.Equalf(t, test.expectedFormat, test.state.Format, "Unexpected format in test %d", i)
+	}
+}
+
+func TestLegacyStateFormat(t *testing.T) {
+
+	log.SetLogger("TestLegacyStateFormat", log.SILENT)
+
+	store, closeF := storage_utils.OpenBPlusTreeStore()
+	defer closeF()
+
+	// states persisted before the format was recorded keep the legacy format
+	legacy := struct {
+		Index, Term, BalloonVersion uint64
+	}{10, 1, 9}
+	buff, err := encodeMsgPack(legacy)
+	require.NoError(t, err)
+	require.NoError(t, store.Mutate([]*storage.Mutation{
+		storage.NewMutation(storage.FSMStateTable, storage.FSMStateTableKey, buff.Bytes()),
+	}))
+
+	fsm, err := NewBalloonFSM(store, hashing.Sha256)
+	require.NoError(t, err)
+	require.Equal(t, hashing.FormatV0, fsm.Format())
+
+	// new logs get the current format
+	store2, close2F := storage_utils.OpenBPlusTreeStore()
+	defer close2F()
+
+	fsm2, err := NewBalloonFSM(store2, hashing.Sha256)
+	require.NoError(t, err)
+	require.Equal(t, hashing.CurrentFormat, fsm2.Format())
+}
+
+func TestSetFormat(t *testing.T) {
+
+	log.SetLogger("TestSetFormat", log.SILENT)
+
+	store, closeF := storage_utils.OpenBPlusTreeStore()
+	defer closeF()
+
+	fsm, err := NewBalloonFSM(store, hashing.Sha256)
+	require.NoError(t, err)
+	require.Error(t, fsm.SetFormat(hashing.CurrentFormat+1), "Unknown formats must be rejected")
+
+	// a clean log can join a cluster created with the legacy format
+	require.NoError(t, fsm.SetFormat(hashing.FormatV0))
+	require.Equal(t, hashing.FormatV0, fsm.Format())
+
+	command := newRaftCommand(commands.AddEventCommandType, []byte("All's right with the world"))
+	r := fsm.Apply(newRaftLog(1, 1, command)).(*fsmAddResponse)
+	require.NoError(t, r.error)
+
+	// the format is recorded and cannot change once there are events
+	fsm2, err := NewBalloonFSM(store, hashing.Sha256)
+	require.NoError(t, err)
+	require.Equal(t, hashing.FormatV0, fsm2.Format())
+	require.NoError(t, fsm2.SetFormat(hashing.FormatV0))
+	require.Error(t, fsm2.SetFormat(hashing.FormatV1), "The format of a log with events must not change")
+}
+
 func TestApplyAdd(t *testing.T) {
 
 	log.SetLogger("TestApplyAdd", log.SILENT)
@@ -21,7 +138,7 @@ func TestApplyAdd(t *testing.T) {
 	store, closeF := storage_utils.OpenBPlusTreeStore()
 	defer closeF()
 
-	fsm, err := NewBalloonFSM(store, hashing.NewSha256Hasher)
+	fsm, err := NewBalloonFSM(store, hashing.Sha256)
 	require.NoError(t, err)
 
 	event := []byte("All's right with the world")
@@ -50,7 +167,7 @@ func TestApplyAddBulk(t *testing.T) {
 	store, closeF := storage_utils.OpenBPlusTreeStore()
 	defer closeF()
 
-	fsm, err := NewBalloonFSM(store, hashing.NewSha256Hasher)
+	fsm, err := NewBalloonFSM(store, hashing.Sha256)
 	require.NoError(t, err)
 
 	events := [][]byte{
@@ -81,6 +198,363 @@ func TestApplyAddBulk(t *testing.T) {
 	}
 }
 
+func TestApplyCommandVersions(t *testing.T) {
+
+	tests := []struct {
+		command  []byte
+		expected uint64
+	}{
+		{nil, 0},
+		{newRaftCommand(commands.AddEventCommandType, []byte("event")), 1},
+		{newRaftCommand(commands.AddDigestCommandType, []byte("digest")), 1},
+		{newRaftCommand(commands.AddEventsBulkCommandType, [][]byte{[]byte("a"), []byte("b"), []byte("c")}), 3},
+		{newRaftCommand(commands.AddDigestsBulkCommandType, [][]byte{[]byte("a"), []byte("b")}), 2},
+		{[]byte{byte(commands.MetadataSetCommandType)}, 0},
+	}
+
+	for i, test := range tests {
+		require.Equal(t, test.expected, commandVersions(test.command), "Wrong version count for command %d", i)
+	}
+}
+
+func TestApplyAddDigest(t *testing.T) {
+
+	log.SetLogger("TestApplyAddDigest", log.SILENT)
+
+	store, closeF := storage_utils.OpenBPlusTreeStore()
+	defer closeF()
+
+	fsm, err := NewBalloonFSM(store, hashing.Sha256)
+	require.NoError(t, err)
+
+	event := []byte("All's right with the world")
+	digest := hashing.NewSha256Hasher().Do(event)
+
+	tests := []struct {
+		log           *raft.Log
+		expectedError bool
+	}{
+		{newRaftLog(1, 1, newRaftCommand(commands.AddDigestCommandType, []byte(digest))), false},                   // happy path
+		{newRaftLog(2, 1, newRaftCommand(commands.AddDigestCommandType, []byte(digest[:8]))), true},                // Error: invalid digest
+		{newRaftLog(2, 1, newRaftCommand(commands.AddDigestsBulkCommandType, [][]byte{digest, digest[:8]})), true}, // Error: invalid digest
+		{newRaftLog(2, 1, newRaftCommand(commands.AddDigestsBulkCommandType, [][]byte{digest, digest})), false},    // happy path
+		{newRaftLog(1, 1, newRaftCommand(commands.AddDigestCommandType, []byte(digest))), true},                    // Error: Command out of order
+	}
+
+	for i, test := range tests {
+		var failed bool
+		switch r := fsm.Apply(test.log).(type) {
+		case *fsmAddResponse:
+			failed = r.error != nil
+		case *fsmAddBulkResponse:
+			failed = r.error != nil
+		}
+		require.Equalf(t, test.expectedError, failed, "Unexpected result in test %d", i)
+	}
+	require.Equal(t, uint64(3), fsm.balloon.Version(), "Only valid digests should be added")
+
+	proof, err := fsm.QueryMembership(event, 0)
+	require.NoError(t, err)
+	require.True(t, proof.Exists, "The event of the digest should be a member")
+}
+
+func TestApplyTimestamps(t *testing.T) {
+
+	log.SetLogger("TestApplyTimestamps", log.SILENT)
+
+	store, closeF := storage_utils.OpenBPlusTreeStore()
+	defer closeF()
+
+	fsm, err := NewBalloonFSM(store, hashing.Sha256)
+	require.NoError(t, err)
+
+	// an event logged before timestamps were recorded
+	_, mutations, err := fsm.balloon.Add(rand.Bytes(32))
+	require.NoError(t, err)
+	require.NoError(t, store.Mutate(mutations))
+	timestamp, err := fsm.QueryTimestamp(0)
+	require.NoError(t, err)
+	require.Zero(t, timestamp, "Events logged before timestamps were recorded should have none")
+
+	add := func(index uint64, timestamp int64) *fsmAddResponse {
+		command, _ := commands.Encode(commands.AddEventCommandType, &commands.AddEventCommand{Event: rand.Bytes(32), Timestamp: timestamp})
+		return fsm.Apply(newRaftLog(index, 1, command)).(*fsmAddResponse)
+	}
+
+	tests := []struct {
+		timestamp         int64
+		expectedTimestamp int64
+	}{
+		{100, 100},
+		{200, 200},
+		{150, 200}, // a leader with a delayed clock cannot go backwards
+		{300, 300},
+	}
+
+	for i, test := range tests {
+		r := add(uint64(i+1), test.timestamp)
+		require.NoError(t, r.error)
+		require.Equalf(t, test.expectedTimestamp, r.snapshot.Timestamp, "Unexpected snapshot timestamp in test %d", i)
+
+		timestamp, err := fsm.QueryTimestamp(r.snapshot.Version)
+		require.NoError(t, err)
+		require.Equalf(t, test.expectedTimestamp, timestamp, "Unexpected stored timestamp in test %d", i)
+	}
+
+	bulk, _ := commands.Encode(commands.AddEventsBulkCommandType, &commands.AddEventsBulkCommand{Events: [][]byte{rand.Bytes(32), rand.Bytes(32)}, Timestamp: 400})
+	r := fsm.Apply(newRaftLog(uint64(len(tests)+1), 1, bulk)).(*fsmAddBulkResponse)
+	require.NoError(t, r.error)
+	for _, snapshot := range r.snapshotBulk {
+		require.Equal(t, int64(400), snapshot.Timestamp, "Every event in a bulk should share the timestamp")
+	}
+
+	// the last timestamp survives a restart
+	fsm2, err := NewBalloonFSM(store, hashing.Sha256)
+	require.NoError(t, err)
+	require.Equal(t, int64(400), fsm2.state.Timestamp)
+
+	_, err = fsm.QueryTimestamp(100)
+	require.Equal(t, storage.ErrKeyNotFound, err, "Versions not in the log have no timestamp")
+}
+
+func TestApplyAddIfAbsent(t *testing.T) {
+
+	log.SetLogger("TestApplyAddIfAbsent", log.SILENT)
+
+	store, closeF := storage_utils.OpenBPlusTreeStore()
+	defer closeF()
+
+	fsm, err := NewBalloonFSM(store, hashing.Sha256)
+	require.NoError(t, err)
+
+	add := func(index uint64, cmd *commands.AddEventCommand) *fsmAddResponse {
+		command, _ := commands.Encode(commands.AddEventCommandType, cmd)
+		return fsm.Apply(newRaftLog(index, 1, command)).(*fsmAddResponse)
+	}
+
+	event := rand.Bytes(32)
+	first := add(1, &commands.AddEventCommand{Event: event, Timestamp: 100, IfAbsent: true})
+	require.NoError(t, first.error)
+	require.Nil(t, first.proof, "A new event should not come with a proof")
+
+	again := add(2, &commands.AddEventCommand{Event: event, Timestamp: 200, IfAbsent: true})
+	require.NoError(t, again.error)
+	require.True(t, again.existing, "The event should not be added twice")
+	require.Equal(t, first.snapshot, again.snapshot, "The snapshot of the first add should be returned")
+	require.True(t, again.proof.Verify(event, again.snapshot), "The proof should verify against the snapshot")
+	require.Equal(t, uint64(1), fsm.balloon.Version(), "The balloon should not grow")
+
+	// following adds are applied as usual
+	next := add(3, &commands.AddEventCommand{Event: rand.Bytes(32), Timestamp: 300})
+	require.NoError(t, next.error)
+	require.Equal(t, uint64(1), next.snapshot.Version)
+
+	bulk, _ := commands.Encode(commands.AddEventsBulkCommandType, &commands.AddEventsBulkCommand{Events: [][]byte{event, event, rand.Bytes(32)}, Timestamp: 400, IfAbsent: true})
+	r := fsm.Apply(newRaftLog(4, 1, bulk)).(*fsmAddBulkResponse)
+	require.NoError(t, r.error)
+	require.NotNil(t, r.proofs[0], "The existing event should come with a proof")
+	require.NotNil(t, r.proofs[1], "The existing event should come with a proof")
+	require.Nil(t, r.proofs[2], "The new event should not come with a proof")
+	require.Equal(t, uint64(2), r.snapshotBulk[2].Version)
+	require.Equal(t, uint64(2), fsm.state.BalloonVersion)
+}
+
+func TestApplyAddIdempotent(t *testing.T) {
+
+	log.SetLogger("TestApplyAddIdempotent", log.SILENT)
+
+	store, closeF := storage_utils.OpenBPlusTreeStore()
+	defer closeF()
+
+	fsm, err := NewBalloonFSM(store, hashing.Sha256)
+	require.NoError(t, err)
+
+	add := func(index uint64, cmd *commands.AddEventCommand) *fsmAddResponse {
+		command, _ := commands.Encode(commands.AddEventCommandType, cmd)
+		return fsm.Apply(newRaftLog(index, 1, command)).(*fsmAddResponse)
+	}
+
+	event := rand.Bytes(32)
+	first := add(1, &commands.AddEventCommand{Event: event, Timestamp: 100, IdempotencyKey: "key"})
+	require.NoError(t, first.error)
+
+	retry := add(2, &commands.AddEventCommand{Event: event, Timestamp: 200, IdempotencyKey: "key"})
+	require.NoError(t, retry.error)
+	require.True(t, retry.existing, "A retry should not add the event again")
+	require.Equal(t, first.snapshot, retry.snapshot, "A retry should return the snapshot of the first add")
+	require.Equal(t, uint64(1), fsm.balloon.Version(), "The balloon should not grow")
+
+	reused := add(3, &commands.AddEventCommand{Event: rand.Bytes(32), Timestamp: 300, IdempotencyKey: "key"})
+	require.Equal(t, ErrIdempotencyKeyReused, reused.error)
+
+	next := add(4, &commands.AddEventCommand{Event: event, Timestamp: 400})
+	require.NoError(t, next.error)
+	require.Equal(t, uint64(1), next.snapshot.Version)
+}
+
+func TestApplyAddIdempotentExpiry(t *testing.T) {
+
+	log.SetLogger("TestApplyAddIdempotentExpiry", log.SILENT)
+
+	store, closeF := storage_utils.OpenBPlusTreeStore()
+	defer closeF()
+
+	fsm, err := NewBalloonFSM(store, hashing.Sha256)
+	require.NoError(t, err)
+
+	add := func(index uint64, cmd *commands.AddEventCommand) *fsmAddResponse {
+		command, _ := commands.Encode(commands.AddEventCommandType, cmd)
+		return fsm.Apply(newRaftLog(index, 1, command)).(*fsmAddResponse)
+	}
+	ttl := int64(IdempotencyKeyTTL)
+
+	first := add(1, &commands.AddEventCommand{Event: rand.Bytes(32), Timestamp: ttl, IdempotencyKey: "key"})
+	require.NoError(t, first.error)
+	other := add(2, &commands.AddEventCommand{Event: rand.Bytes(32), Timestamp: ttl + 1, IdempotencyKey: "other"})
+	require.NoError(t, other.error)
+
+	// an expired key is forgotten, so it can be used with another event
+	reused := add(3, &commands.AddEventCommand{Event: rand.Bytes(32), Timestamp: 2 * ttl, IdempotencyKey: "key"})
+	require.NoError(t, reused.error)
+	require.False(t, reused.existing, "The event sent with an expired key should be added")
+	require.Equal(t, uint64(2), reused.snapshot.Version)
+
+	retry := add(4, &commands.AddEventCommand{Event: rand.Bytes(32), Timestamp: 2*ttl + 1, IdempotencyKey: "other"})
+	require.NoError(t, retry.error, "The key should have expired")
+	require.Equal(t, uint64(3), retry.snapshot.Version)
+
+	expiryIndex := func() storage.KVRange {
+		index, err := store.GetRange(storage.IdempotencyExpiryTable, util.Uint64AsBytes(0), util.Uint64AsBytes(math.MaxUint64))
+		require.NoError(t, err)
+		return index
+	}
+	require.Len(t, expiryIndex(), 3, "The pruning should only delete the expired entries of the index")
+
+	// the first use of a key used again expires without deleting the key
+	add(5, &commands.AddEventCommand{Event: rand.Bytes(32), Timestamp: 2*ttl + int64(idempotencyKeysPruneInterval)})
+	_, err = store.Get(storage.IdempotencyKeysTable, []byte("other"))
+	require.NoError(t, err, "A key used again should not be pruned")
+	require.Len(t, expiryIndex(), 2)
+
+	// expired keys are pruned when a later add is applied
+	add(6, &commands.AddEventCommand{Event: rand.Bytes(32), Timestamp: 4 * ttl})
+	_, err = store.Get(storage.IdempotencyKeysTable, []byte("key"))
+	require.Equal(t, storage.ErrKeyNotFound, err, "The expired key should be pruned")
+	_, err = store.Get(storage.IdempotencyKeysTable, []byte("other"))
+	require.Equal(t, storage.ErrKeyNotFound, err, "The expired key should be pruned")
+	require.Empty(t, expiryIndex(), "The expired entries of the index should be pruned")
+}
+
+func TestApplyPayloads(t *testing.T) {
+
+	log.SetLogger("TestApplyPayloads", log.SILENT)
+
+	store, closeF := storage_utils.OpenBPlusTreeStore()
+	defer closeF()
+
+	fsm, err := NewBalloonFSM(store, hashing.Sha256)
+	require.NoError(t, err)
+
+	add := func(index uint64, cmd *commands.AddEventCommand) *fsmAddResponse {
+		command, _ := commands.Encode(commands.AddEventCommandType, cmd)
+		return fsm.Apply(newRaftLog(index, 1, command)).(*fsmAddResponse)
+	}
+
+	event := rand.Bytes(32)
+	r := add(1, &commands.AddEventCommand{Event: event, Timestamp: 100, StorePayload: true})
+	require.NoError(t, r.error)
+	payload, err := fsm.QueryPayload(r.snapshot.Version)
+	require.NoError(t, err)
+	require.Equal(t, event, payload)
+
+	r = add(2, &commands.AddEventCommand{Event: rand.Bytes(32), Timestamp: 200})
+	require.NoError(t, r.error)
+	_, err = fsm.QueryPayload(r.snapshot.Version)
+	require.Equal(t, storage.ErrKeyNotFound, err, "Only the payloads requested should be stored")
+
+	// existing events keep the payload of their first add
+	r = add(3, &commands.AddEventCommand{Event: event, Timestamp: 300, IfAbsent: true, StorePayload: true})
+	require.NoError(t, r.error)
+	require.True(t, r.existing)
+
+	events := [][]byte{event, rand.Bytes(32), rand.Bytes(32)}
+	bulk, _ := commands.Encode(commands.AddEventsBulkCommandType, &commands.AddEventsBulkCommand{Events: events, Timestamp: 400, IfAbsent: true, StorePayloads: true})
+	rb := fsm.Apply(newRaftLog(4, 1, bulk)).(*fsmAddBulkResponse)
+	require.NoError(t, rb.error)
+	for i, snapshot := range rb.snapshotBulk {
+		payload, err := fsm.QueryPayload(snapshot.Version)
+		require.NoErrorf(t, err, "The payload of event %d should be stored", i)
+		require.Equalf(t, events[i], payload, "Unexpected payload of event %d", i)
+	}
+	require.Equal(t, uint64(4), fsm.balloon.Version())
+
+	// events too large to store are logged only by their digest
+	events = [][]byte{rand.Bytes(32), rand.Bytes(64)}
+	bulk, _ = commands.Encode(commands.AddEventsBulkCommandType, &commands.AddEventsBulkCommand{Events: events, Timestamp: 500, StorePayloads: true, MaxPayloadSize: 32})
+	rb = fsm.Apply(newRaftLog(5, 1, bulk)).(*fsmAddBulkResponse)
+	require.NoError(t, rb.error)
+	payload, err = fsm.QueryPayload(rb.snapshotBulk[0].Version)
+	require.NoError(t, err)
+	require.Equal(t, events[0], payload)
+	_, err = fsm.QueryPayload(rb.snapshotBulk[1].Version)
+	require.Equal(t, storage.ErrKeyNotFound, err, "Payloads larger than the maximum size should not be stored")
+	proof, err := fsm.QueryMembership(events[1], rb.snapshotBulk[1].Version)
+	require.NoError(t, err)
+	require.True(t, proof.Exists, "Events too large to store should be logged")
+}
+
+func TestApplyActivateKey(t *testing.T) {
+
+	log.SetLogger("TestApplyActivateKey", log.SILENT)
+
+	store, closeF := storage_utils.OpenBPlusTreeStore()
+	defer closeF()
+
+	fsm, err := NewBalloonFSM(store, hashing.Sha256)
+	require.NoError(t, err)
+
+	first, second := sign.NewEd25519Signer(), sign.NewECDSASigner()
+	activate := func(index uint64, cmd *commands.ActivateKeyCommand) error {
+		data, err := commands.Encode(commands.ActivateKeyCommandType, cmd)
+		require.NoError(t, err)
+		return fsm.Apply(newRaftLog(index, 1, data)).(*fsmGenericResponse).error
+	}
+
+	// the first rotation records the key that signed until then
+	require.NoError(t, activate(1, &commands.ActivateKeyCommand{
+		PublicKey:         second.PublicKey(),
+		Algorithm:         second.Algorithm(),
+		PreviousPublicKey: first.PublicKey(),
+		PreviousAlgorithm: first.Algorithm(),
+		Timestamp:         100,
+	}))
+	require.Equal(t, []protocol.KeyInfo{
+		{KeyID: sign.KeyID(first.PublicKey()), Algorithm: sign.Ed25519, PublicKey: first.PublicKey(), ValidUntil: 100},
+		{KeyID: sign.KeyID(second.PublicKey()), Algorithm: sign.ECDSAP256, PublicKey: second.PublicKey(), ValidFrom: 100},
+	}, fsm.Keys(), "Wrong keys after the first rotation")
+
+	// adding events keeps the keys of the state
+	resp := fsm.Apply(newRaftLog(2, 1, newRaftCommand(commands.AddEventCommandType, []byte("All's right with the world"))))
+	require.NoError(t, resp.(*fsmAddResponse).error)
+
+	// keys cannot be activated twice
+	err = activate(3, &commands.ActivateKeyCommand{PublicKey: first.PublicKey(), Timestamp: 200})
+	require.Equal(t, ErrKeyAlreadyActivated, err, "Keys cannot be activated again")
+
+	third := sign.NewEd25519Signer()
+	require.NoError(t, activate(4, &commands.ActivateKeyCommand{PublicKey: third.PublicKey(), Timestamp: 300}))
+
+	keys := fsm.Keys()
+	require.Len(t, keys, 3, "Wrong number of keys")
+	require.Equal(t, int64(300), keys[1].ValidUntil, "The previous key should be closed")
+	require.Equal(t, sign.KeyID(third.PublicKey()), keys[2].KeyID, "The last key should be the active one")
+
+	// the keys survive a restart
+	fsm2, err := NewBalloonFSM(store, hashing.Sha256)
+	require.NoError(t, err)
+	require.Equal(t, keys, fsm2.Keys(), "The keys should be recorded in the state")
+}
+
 func TestSnapshot(t *testing.T) {
 
 	log.SetLogger("TestSnapshot", log.SILENT)
@@ -88,7 +562,7 @@ func TestSnapshot(t *testing.T) {
 	store, closeF := storage_utils.OpenRocksDBStore(t, "/var/tmp/balloon.test.db")
 	defer closeF()
 
-	fsm, err := NewBalloonFSM(store, hashing.NewSha256Hasher)
+	fsm, err := NewBalloonFSM(store, hashing.Sha256)
 	require.NoError(t, err)
 
 	command := newRaftCommand(commands.AddEventCommandType, []byte("All's right with the world"))
@@ -116,7 +590,7 @@ func TestRestore(t *testing.T) {
 	store, closeF := storage_utils.OpenRocksDBStore(t, "/var/tmp/balloon.test.db")
 	defer closeF()
 
-	fsm, err := NewBalloonFSM(store, hashing.NewSha256Hasher)
+	fsm, err := NewBalloonFSM(store, hashing.Sha256)
 	require.NoError(t, err)
 
 	require.NoError(t, fsm.Restore(&fakeRC{}))
@@ -129,12 +603,18 @@ func TestAddAndRestoreSnapshot(t *testing.T) {
 	store, closeF := storage_utils.OpenRocksDBStore(t, "/var/tmp/balloon.test.db")
 	defer closeF()
 
-	fsm, err := NewBalloonFSM(store, hashing.NewSha256Hasher)
+	fsm, err := NewBalloonFSM(store, hashing.Sha256)
 	require.NoError(t, err)
 
 	command := newRaftCommand(commands.AddEventCommandType, []byte("All's right with the world"))
 	fsm.Apply(newRaftLog(0, 0, command))
 
+	metadata, _ := commands.Encode(commands.MetadataSetCommandType, &commands.MetadataSetCommand{
+		Id:   "1",
+		Data: map[string]string{"HTTPAddr": "127.0.0.1:8800"},
+	})
+	fsm.Apply(newRaftLog(1, 0, metadata))
+
 	fsmsnap, err := fsm.Snapshot()
 	require.NoError(t, err)
 
@@ -162,7 +642,7 @@ func TestAddAndRestoreSnapshot(t *testing.T) {
 	defer close2F()
 
 	// New FSMStore
-	fsm2, err := NewBalloonFSM(store2, hashing.NewSha256Hasher)
+	fsm2, err := NewBalloonFSM(store2, hashing.Sha256)
 	require.NoError(t, err)
 
 	err = fsm2.Restore(r)
@@ -171,6 +651,51 @@ func TestAddAndRestoreSnapshot(t *testing.T) {
 	// Error: Command already applied
 	e := fsm2.Apply(newRaftLog(0, 0, command)).(*fsmAddResponse)
 	require.Error(t, e.error)
+
+	require.Equal(t, "127.0.0.1:8800", fsm2.Metadata("1", "HTTPAddr"), "The node metadata should be restored")
+}
+
+func TestSnapshotHeader(t *testing.T) {
+
+	meta := []byte(`{"1":{"HTTPAddr":"127.0.0.1:8800"}}`)
+
+	var buf bytes.Buffer
+	require.NoError(t, writeSnapshotHeader(&buf, meta))
+	buf.WriteString("backup")
+
+	r := bufio.NewReader(&buf)
+	restored, err := readSnapshotHeader(r)
+	require.NoError(t, err)
+	require.Equal(t, meta, restored)
+
+	rest, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, "backup", string(rest), "The header should be consumed before the backup")
+}
+
+func TestLegacySnapshotHeader(t *testing.T) {
+
+	legacy := make([]byte, 16)
+	binary.LittleEndian.PutUint64(legacy, 8)
+
+	r := bufio.NewReader(bytes.NewReader(legacy))
+	meta, err := readSnapshotHeader(r)
+	require.NoError(t, err)
+	require.Nil(t, meta, "Legacy snapshots carry no metadata")
+
+	rest, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, legacy, rest, "Legacy snapshots should be loaded from the first byte")
+
+	empty, err := readSnapshotHeader(bufio.NewReader(&fakeRC{}))
+	require.NoError(t, err)
+	require.Nil(t, empty)
+
+	var buf bytes.Buffer
+	buf.Write(snapshotMagic)
+	_ = binary.Write(&buf, binary.LittleEndian, snapshotVersion+1)
+	_, err = readSnapshotHeader(bufio.NewReader(&buf))
+	require.Error(t, err, "Unknown snapshot versions should be rejected")
 }
 
 func BenchmarkApplyAdd(b *testing.B) {
@@ -180,7 +705,7 @@ func BenchmarkApplyAdd(b *testing.B) {
 	store, closeF := storage_utils.OpenRocksDBStore(b, "/var/tmp/fsm_bench.db")
 	defer closeF()
 
-	fsm, err := NewBalloonFSM(store, hashing.NewSha256Hasher)
+	fsm, err := NewBalloonFSM(store, hashing.Sha256)
 	defer fsm.Close()
 	require.NoError(b, err)
 
@@ -205,6 +730,10 @@ func newRaftCommand(commandType commands.CommandType, content interface{}) (data
 		data, _ = commands.Encode(commands.AddEventCommandType, &commands.AddEventCommand{Event: content.([]byte)})
 	case commands.AddEventsBulkCommandType:
 		data, _ = commands.Encode(commands.AddEventsBulkCommandType, &commands.AddEventsBulkCommand{Events: content.([][]byte)})
+	case commands.AddDigestCommandType:
+		data, _ = commands.Encode(commands.AddDigestCommandType, &commands.AddDigestCommand{Digest: content.([]byte)})
+	case commands.AddDigestsBulkCommandType:
+		data, _ = commands.Encode(commands.AddDigestsBulkCommandType, &commands.AddDigestsBulkCommand{Digests: content.([][]byte)})
 	default:
 		data = nil
 	}
@@ -17,20 +17,25 @@
 package raftwal
 
 import (
+	"crypto/tls"
 	"errors"
 	"fmt"
+	"io"
 	"net"
+	"strconv"
 	"sync"
 	"time"
 
 	"github.com/bbva/qed/balloon"
+	"github.com/bbva/qed/balloon/history"
 	"github.com/bbva/qed/hashing"
 	"github.com/bbva/qed/log"
 	"github.com/bbva/qed/metrics"
 	"github.com/bbva/qed/protocol"
 	"github.com/bbva/qed/raftwal/commands"
-	"github.com/bbva/qed/raftwal/raftrocks"
+	"github.com/bbva/qed/sign"
 	"github.com/bbva/qed/storage"
+	hclog "github.com/hashicorp/go-hclog"
 	"github.com/hashicorp/raft"
 )
 
@@ -48,17 +53,105 @@ var (
 	// ErrNotLeader is returned when a node attempts to execute a leader-only
 	// operation.
 	ErrNotLeader = errors.New("not leader")
+
+	// ErrIdempotencyKeyReused is returned when an idempotency key is sent
+	// again along with a different event.
+	ErrIdempotencyKeyReused = errors.New("idempotency key already used with another event")
+
+	// ErrInvalidDigestLength is returned when a digest sent to be added
+	// does not have the length of the digests of the log hasher.
+	ErrInvalidDigestLength = errors.New("invalid digest length")
+
+	// ErrKeyAlreadyActivated is returned when a signing key that is, or
+	// has been, active is activated again.
+	ErrKeyAlreadyActivated = errors.New("key already activated")
+
+	// ErrStaleRead is returned when a node lags behind the leader more
+	// than a query allows.
+	ErrStaleRead = errors.New("node too stale for the requested consistency")
 )
 
 // RaftBalloon is the interface Raft-backed balloons must implement.
 type RaftBalloonApi interface {
 	Add(event []byte) (*balloon.Snapshot, error)
 	AddBulk(bulk [][]byte) ([]*balloon.Snapshot, error)
+	// AddIfAbsent adds the event unless it is already in the balloon, in
+	// which case it returns its snapshot along with a membership proof
+	AddIfAbsent(event []byte) (*balloon.Snapshot, *balloon.MembershipProof, error)
+	// AddBulkIfAbsent adds the events of the bulk that are not already in
+	// the balloon, returning a membership proof for the rest
+	AddBulkIfAbsent(bulk [][]byte) ([]*balloon.Snapshot, []*balloon.MembershipProof, error)
+	// AddIdempotent adds the event unless another add was made with the
+	// same key, in which case it returns the snapshot of that add
+	AddIdempotent(event []byte, key string) (*balloon.Snapshot, error)
+	// AddDigest adds an event already hashed by the producer
+	AddDigest(eventDigest hashing.Digest) (*balloon.Snapshot, error)
+	// AddDigestBulk adds a bulk of events already hashed by the producer
+	AddDigestBulk(eventBulkDigest []hashing.Digest) ([]*balloon.Snapshot, error)
 	QueryDigestMembership(keyDigest hashing.Digest, version uint64) (*balloon.MembershipProof, error)
 	QueryMembership(event []byte, version uint64) (*balloon.MembershipProof, error)
 	QueryConsistency(start, end uint64) (*balloon.IncrementalProof, error)
+	QueryHistoryDigest(version uint64) (hashing.Digest, error)
+	// QueryHyperDigest returns the hyper digest of the balloon as it was
+	// at the given version
+	QueryHyperDigest(version uint64) (hashing.Digest, error)
+	QueryLeafHashes(start, end uint64) ([]hashing.Digest, error)
+	// QueryEventDigests returns the digests of the events logged between
+	// the start and end versions, both included, or nil for the events
+	// logged before the index was kept
+	QueryEventDigests(start, end uint64) ([]hashing.Digest, error)
+	// QueryHistoryMembership returns a proof that the event logged at the
+	// given index is in the history tree as it was at the given version
+	QueryHistoryMembership(index, version uint64) (*history.MembershipProof, error)
+	// QueryTimestamp returns the time, in nanoseconds since the epoch, at
+	// which the event with the given version was logged, or zero if it
+	// was logged before timestamps were recorded
+	QueryTimestamp(version uint64) (int64, error)
+	// QueryPayload returns the event with the given version if its
+	// payload has been stored, or storage.ErrKeyNotFound otherwise
+	QueryPayload(version uint64) ([]byte, error)
+	// PayloadTooLarge returns whether the event is too large for its
+	// payload to be stored, so it is logged only by its digest
+	PayloadTooLarge(event []byte) bool
+	// Version returns the number of events added to the balloon
+	Version() uint64
+	// Hasher returns the name of the hashing algorithm of the log
+	Hasher() string
+	// Format returns the format version of the balloon trees
+	Format() hashing.FormatVersion
+	// Keys returns the signing keys of the log ordered by activation
+	Keys() []protocol.KeyInfo
+	// ActiveKeyID returns the ID of the key that signs the snapshots, or
+	// an empty string if the log has never been rotated
+	ActiveKeyID() string
+	// ActivateKey makes the given key the one that signs the snapshots.
+	// The previous key, if any, is recorded if the log has no keys.
+	ActivateKey(key, previous sign.Signer) error
 	// Join joins the node, identified by nodeID and reachable at addr, to the cluster
 	Join(nodeID, addr string, metadata map[string]string) error
+	// Remove removes the node, identified by id, from the cluster
+	Remove(id string) error
+	// Status returns the raft and FSM state of this node
+	Status() (*protocol.NodeStatus, error)
+	// ClusterNodes returns the members of the cluster along with their metadata
+	ClusterNodes() ([]protocol.ClusterNode, error)
+	// SetMetadata adds the given metadata to the one of the node
+	SetMetadata(nodeInvolved string, md map[string]string) error
+	// Snapshot makes this node take a raft snapshot, so that its log can
+	// be compacted
+	Snapshot() error
+	// TransferLeadership hands the leadership over to the node with the
+	// given ID, or to the most up to date follower if the ID is empty
+	TransferLeadership(id string) error
+	// Backup writes a consistent backup of the log to w, along with its
+	// last snapshot signed by the given signer
+	Backup(w io.Writer, signer sign.Signer) error
+	// BackupVersions writes a backup of the versions of the log between
+	// from and until, both included, signed by the given signer
+	BackupVersions(w io.Writer, from, until uint64, signer sign.Signer) error
+	// CheckConsistency returns an error if the node cannot answer queries
+	// with the given read consistency
+	CheckConsistency(c protocol.ReadConsistency) error
 	Info() map[string]interface{}
 }
 
@@ -71,17 +164,17 @@ type RaftBalloon struct {
 	raft struct {
 		api          *raft.Raft             // The consensus mechanism
 		transport    *raft.NetworkTransport // Raft network transport
+		tlsConfig    *tls.Config            // TLS configuration of the transport, if any
 		config       *raft.Config           //Config provides any necessary configuration for the Raft server.
 		nodes        *raft.Configuration    //Configuration tracks which servers are in the cluster, and whether they have votes.
 		applyTimeout time.Duration
 	}
 
 	store struct {
-		db         storage.ManagedStore    // Persistent database
-		log        raft.LogStore           // Persistent log store
-		rocksStore *raftrocks.RocksDBStore // Underlying rocksdb-backed persistent log store
-		//stable    *raftrocks.RocksDBStore // Persistent k-v store
-		snapshots *raft.FileSnapshotStore // Persistent snapstop store
+		db        storage.ManagedStore // Persistent database
+		log       raft.LogStore        // Persistent log store
+		raftStore RaftStore            // Underlying persistent log and stable store
+		snapshots raft.SnapshotStore   // Persistent snapstop store
 	}
 
 	sync.Mutex
@@ -92,29 +185,30 @@ type RaftBalloon struct {
 	fsm         *BalloonFSM             // balloon's finite state machine
 	snapshotsCh chan *protocol.Snapshot // channel to publish snapshots
 
+	maxPayloadSize int // payloads are stored, up to this size, if positive
+
 	metrics *raftBalloonMetrics
 }
 
-// NewRaftBalloon returns a new RaftBalloon.
-func NewRaftBalloon(path, addr, id string, store storage.ManagedStore, snapshotsCh chan *protocol.Snapshot) (*RaftBalloon, error) {
+// RaftStore is the log and stable store of raft.
+type RaftStore interface {
+	raft.LogStore
+	raft.StableStore
+	Close() error
+	metrics.Registerer
+}
 
-	// Create the log store and stable store
-	rocksStore, err := raftrocks.New(raftrocks.Options{Path: path + "/wal", NoSync: true, EnableStatistics: true})
-	if err != nil {
-		return nil, fmt.Errorf("cannot create a new rocksdb log store: %s", err)
-	}
-	logStore, err := raft.NewLogCache(raftLogCacheSize, rocksStore)
+// NewRaftBalloonWithStore returns a new RaftBalloon whose raft log is kept
+// in the given store. An empty path keeps the raft snapshots in memory.
+func NewRaftBalloonWithStore(path, addr, id, hasher string, store storage.ManagedStore, raftStore RaftStore, snapshotsCh chan *protocol.Snapshot) (*RaftBalloon, error) {
+
+	logStore, err := raft.NewLogCache(raftLogCacheSize, raftStore)
 	if err != nil {
 		return nil, fmt.Errorf("cannot create a new cached store: %s", err)
 	}
 
-	// stableStore, err := raftrocks.New(raftrocks.Options{Path: path + "/config", NoSync: true})
-	// if err != nil {
-	// 	return nil, fmt.Errorf("cannot create a new rocksdb stable store: %s", err)
-	// }
-
 	// Instantiate balloon FSM
-	fsm, err := NewBalloonFSM(store, hashing.NewSha256Hasher)
+	fsm, err := NewBalloonFSM(store, hasher)
 	if err != nil {
 		return nil, fmt.Errorf("new balloon fsm: %s", err)
 	}
@@ -130,12 +224,35 @@ func NewRaftBalloon(path, addr, id string, store storage.ManagedStore, snapshots
 
 	rb.store.db = store
 	rb.store.log = logStore
-	rb.store.rocksStore = rocksStore
+	rb.store.raftStore = raftStore
 	rb.metrics = newRaftBalloonMetrics(rb)
 
 	return rb, nil
 }
 
+// EnableTLS makes the raft transport use TLS with the given configuration,
+// which is used both to accept and to dial the connections of the peers.
+// It must be called before Open.
+func (b *RaftBalloon) EnableTLS(config *tls.Config) {
+	b.raft.tlsConfig = config
+}
+
+// StorePayloads makes the events added through this node keep their
+// payload, which can then be read by version. Events larger than maxSize
+// bytes are still logged, but only by their digest. The leader decides for
+// the whole cluster, so every node should be configured the same way. It
+// must be called before Open.
+func (b *RaftBalloon) StorePayloads(maxSize int) {
+	b.maxPayloadSize = maxSize
+}
+
+// SetFormat makes a clean log use the given tree format, which must be the
+// one of the cluster the node is going to join. It must be called before
+// Open.
+func (b *RaftBalloon) SetFormat(format hashing.FormatVersion) error {
+	return b.fsm.SetFormat(format)
+}
+
 // Open opens the Balloon. If no joinAddr is provided, then there are no existing peers,
 // then this node becomes the first node, and therefore, leader of the cluster.
 func (b *RaftBalloon) Open(bootstrap bool, metadata map[string]string) error {
@@ -151,7 +268,7 @@ func (b *RaftBalloon) Open(bootstrap bool, metadata map[string]string) error {
 	// Setup Raft configuration
 	b.raft.config = raft.DefaultConfig()
 	b.raft.config.LocalID = raft.ServerID(b.id)
-	b.raft.config.Logger = log.GetLogger()
+	b.raft.config.Logger = raftLogger()
 	b.raft.applyTimeout = 10 * time.Second
 
 	// Setup Raft communication
@@ -160,20 +277,32 @@ func (b *RaftBalloon) Open(bootstrap bool, metadata map[string]string) error {
 		return err
 	}
 
-	b.raft.transport, err = raft.NewTCPTransportWithLogger(b.addr, raddr, 3, 10*time.Second, log.GetLogger())
-	if err != nil {
-		return err
+	if b.raft.tlsConfig != nil {
+		stream, err := newTLSStreamLayer(b.addr, raddr, b.raft.tlsConfig)
+		if err != nil {
+			return err
+		}
+		b.raft.transport = raft.NewNetworkTransportWithLogger(stream, 3, 10*time.Second, raftLogger())
+	} else {
+		b.raft.transport, err = raft.NewTCPTransportWithLogger(b.addr, raddr, 3, 10*time.Second, raftLogger())
+		if err != nil {
+			return err
+		}
 	}
 
 	// Create the snapshot store. This allows the Raft to truncate the log. The library creates
 	// a folder to store the snapshots in.
-	b.store.snapshots, err = raft.NewFileSnapshotStoreWithLogger(b.path, retainSnapshotCount, log.GetLogger())
-	if err != nil {
-		return fmt.Errorf("file snapshot store: %s", err)
+	if b.path == "" {
+		b.store.snapshots = raft.NewInmemSnapshotStore()
+	} else {
+		b.store.snapshots, err = raft.NewFileSnapshotStoreWithLogger(b.path, retainSnapshotCount, raftLogger())
+		if err != nil {
+			return fmt.Errorf("file snapshot store: %s", err)
+		}
 	}
 
 	// Instantiate the Raft system
-	b.raft.api, err = raft.NewRaft(b.raft.config, b.fsm, b.store.log, b.store.rocksStore, b.store.snapshots, b.raft.transport)
+	b.raft.api, err = raft.NewRaft(b.raft.config, b.fsm, b.store.log, b.store.raftStore, b.store.snapshots, b.raft.transport)
 	if err != nil {
 		return fmt.Errorf("new raft: %s", err)
 	}
@@ -231,11 +360,11 @@ func (b *RaftBalloon) Close(wait bool) error {
 	}
 
 	// close raft store
-	if err := b.store.rocksStore.Close(); err != nil {
+	if err := b.store.raftStore.Close(); err != nil {
 		return err
 	}
 
-	b.store.rocksStore = nil
+	b.store.raftStore = nil
 	b.store.log = nil
 	b.metrics = nil
 
@@ -287,6 +416,16 @@ func (b *RaftBalloon) LeaderAddr() string {
 	return string(b.raft.api.Leader())
 }
 
+// Hasher returns the name of the hashing algorithm of the log.
+func (b *RaftBalloon) Hasher() string {
+	return b.fsm.Hasher()
+}
+
+// Format returns the format version of the balloon trees.
+func (b *RaftBalloon) Format() hashing.FormatVersion {
+	return b.fsm.Format()
+}
+
 // ID returns the Raft ID of the store.
 func (b *RaftBalloon) ID() string {
 	return b.id
@@ -320,6 +459,184 @@ func (b *RaftBalloon) Nodes() ([]raft.Server, error) {
 	return f.Configuration().Servers, nil
 }
 
+// Status returns the raft and FSM state of this node.
+func (b *RaftBalloon) Status() (*protocol.NodeStatus, error) {
+	leaderID, err := b.LeaderID()
+	if err != nil {
+		return nil, err
+	}
+
+	stats := b.raft.api.Stats()
+	statsUint := func(key string) uint64 {
+		v, _ := strconv.ParseUint(stats[key], 10, 64)
+		return v
+	}
+
+	return &protocol.NodeStatus{
+		ID:                b.ID(),
+		Addr:              b.Addr(),
+		LeaderID:          leaderID,
+		State:             b.raft.api.State().String(),
+		Term:              statsUint("term"),
+		LastLogIndex:      statsUint("last_log_index"),
+		CommitIndex:       statsUint("commit_index"),
+		AppliedIndex:      statsUint("applied_index"),
+		LastSnapshotIndex: statsUint("last_snapshot_index"),
+		FSM: protocol.FSMStatus{
+			Version:     b.fsm.Version(),
+			Hasher:      b.fsm.Hasher(),
+			Format:      b.fsm.Format(),
+			ActiveKeyID: b.ActiveKeyID(),
+		},
+	}, nil
+}
+
+// ClusterNodes returns the members of the cluster along with the metadata
+// they registered when joining.
+func (b *RaftBalloon) ClusterNodes() ([]protocol.ClusterNode, error) {
+	servers, err := b.Nodes()
+	if err != nil {
+		return nil, err
+	}
+
+	leaderAddr := b.LeaderAddr()
+	nodes := make([]protocol.ClusterNode, 0, len(servers))
+	for _, srv := range servers {
+		nodes = append(nodes, protocol.ClusterNode{
+			ID:       string(srv.ID),
+			Addr:     string(srv.Address),
+			Voter:    srv.Suffrage == raft.Voter,
+			Leader:   string(srv.Address) == leaderAddr,
+			Metadata: b.fsm.NodeMetadata(string(srv.ID)),
+		})
+	}
+	return nodes, nil
+}
+
+// Backup writes a consistent backup of the log to w. It can be restored
+// in an offline node with RestoreBackup.
+func (b *RaftBalloon) Backup(w io.Writer, signer sign.Signer) error {
+	return b.fsm.Backup(w, signer)
+}
+
+// BackupVersions writes a point-in-time backup of the versions of the log
+// between from and until, both included. Backups starting after version
+// zero are incremental.
+func (b *RaftBalloon) BackupVersions(w io.Writer, from, until uint64, signer sign.Signer) error {
+	return b.fsm.BackupVersions(w, from, until, signer)
+}
+
+// Snapshot makes this node take a raft snapshot of its FSM, so that the
+// log entries already applied can be compacted.
+func (b *RaftBalloon) Snapshot() error {
+	return b.raft.api.Snapshot().Error()
+}
+
+// TransferLeadership hands the leadership over to the node with the given
+// ID, or to the most up to date follower if the ID is empty. It must be
+// called on the leader, and returns once the node has stepped down.
+func (b *RaftBalloon) TransferLeadership(id string) error {
+	if b.raft.api.State() != raft.Leader {
+		return ErrNotLeader
+	}
+
+	var f raft.Future
+	if id == "" {
+		f = b.raft.api.LeadershipTransfer()
+	} else {
+		configFuture := b.raft.api.GetConfiguration()
+		if err := configFuture.Error(); err != nil {
+			return err
+		}
+		var address raft.ServerAddress
+		for _, srv := range configFuture.Configuration().Servers {
+			if srv.ID == raft.ServerID(id) {
+				address = srv.Address
+			}
+		}
+		if address == "" {
+			return fmt.Errorf("node %s is not a member of the cluster", id)
+		}
+		f = b.raft.api.LeadershipTransferToServer(raft.ServerID(id), address)
+	}
+
+	if err := f.Error(); err != nil {
+		if err == raft.ErrNotLeader {
+			return ErrNotLeader
+		}
+		return err
+	}
+	log.Infof("leadership transferred by node %s", b.id)
+	return nil
+}
+
+// CheckConsistency returns an error if the node cannot answer queries with
+// the given read consistency. Linearizable queries make the leader confirm
+// its leadership and apply every committed entry, while bounded queries
+// check the time since the node last heard from the leader and, optionally,
+// the number of events it has received but not applied yet. The latter
+// alone does not bound a node cut off from the leader, so bounded queries
+// always carry a maximum time.
+func (b *RaftBalloon) CheckConsistency(c protocol.ReadConsistency) error {
+	switch c.Level {
+	case protocol.Stale, "":
+		return nil
+
+	case protocol.Linearizable:
+		if err := b.raft.api.VerifyLeader().Error(); err != nil {
+			if err == raft.ErrNotLeader || err == raft.ErrLeadershipLost {
+				return ErrNotLeader
+			}
+			return err
+		}
+		err := b.raft.api.Barrier(b.raft.applyTimeout).Error()
+		if err == raft.ErrNotLeader || err == raft.ErrLeadershipLost {
+			return ErrNotLeader
+		}
+		return err
+
+	case protocol.Bounded:
+		if c.MaxLag == 0 {
+			return fmt.Errorf("bounded read consistency needs a maximum lag")
+		}
+		if !b.IsLeader() {
+			last := b.raft.api.LastContact()
+			if last.IsZero() || time.Since(last) > c.MaxLag {
+				return ErrStaleRead
+			}
+		}
+		if c.MaxLagVersions > 0 {
+			pending, err := b.pendingVersions(c.MaxLagVersions)
+			if err != nil {
+				return err
+			}
+			if pending > c.MaxLagVersions {
+				return ErrStaleRead
+			}
+		}
+		return nil
+	}
+
+	return fmt.Errorf("unknown read consistency %q", c.Level)
+}
+
+// pendingVersions counts the events of the entries that the node stores in
+// its log but has not applied yet. It stops counting past the given limit.
+func (b *RaftBalloon) pendingVersions(limit uint64) (uint64, error) {
+	var pending uint64
+	last := b.raft.api.LastIndex()
+	for i := b.raft.api.AppliedIndex() + 1; i <= last && pending <= limit; i++ {
+		var entry raft.Log
+		if err := b.store.log.GetLog(i, &entry); err != nil {
+			return 0, err
+		}
+		if entry.Type == raft.LogCommand {
+			pending += commandVersions(entry.Data)
+		}
+	}
+	return pending, nil
+}
+
 // Remove removes a node from the store, specified by ID.
 func (b *RaftBalloon) Remove(id string) error {
 	log.Infof("received request to remove node %s", id)
@@ -370,7 +687,7 @@ func (b *RaftBalloon) raftApply(t commands.CommandType, cmd interface{}) (interf
 */
 
 func (b *RaftBalloon) Add(event []byte) (*balloon.Snapshot, error) {
-	cmd := &commands.AddEventCommand{Event: event}
+	cmd := &commands.AddEventCommand{Event: event, Timestamp: time.Now().UnixNano(), StorePayload: b.storesPayload(event)}
 	resp, err := b.raftApply(commands.AddEventCommandType, cmd)
 	if err != nil {
 		return nil, err
@@ -387,7 +704,7 @@ func (b *RaftBalloon) Add(event []byte) (*balloon.Snapshot, error) {
 }
 
 func (b *RaftBalloon) AddBulk(bulk [][]byte) ([]*balloon.Snapshot, error) {
-	cmd := &commands.AddEventsBulkCommand{Events: bulk}
+	cmd := &commands.AddEventsBulkCommand{Events: bulk, Timestamp: time.Now().UnixNano(), StorePayloads: b.maxPayloadSize > 0, MaxPayloadSize: b.maxPayloadSize}
 	resp, err := b.raftApply(commands.AddEventsBulkCommandType, cmd)
 	if err != nil {
 		return nil, err
@@ -406,6 +723,146 @@ func (b *RaftBalloon) AddBulk(bulk [][]byte) ([]*balloon.Snapshot, error) {
 	return snapshotBulk, nil
 }
 
+func (b *RaftBalloon) AddIfAbsent(event []byte) (*balloon.Snapshot, *balloon.MembershipProof, error) {
+	cmd := &commands.AddEventCommand{Event: event, Timestamp: time.Now().UnixNano(), IfAbsent: true, StorePayload: b.storesPayload(event)}
+	resp, err := b.applyAdd(cmd)
+	if err != nil {
+		return nil, nil, err
+	}
+	return resp.snapshot, resp.proof, nil
+}
+
+func (b *RaftBalloon) AddBulkIfAbsent(bulk [][]byte) ([]*balloon.Snapshot, []*balloon.MembershipProof, error) {
+	cmd := &commands.AddEventsBulkCommand{Events: bulk, Timestamp: time.Now().UnixNano(), IfAbsent: true, StorePayloads: b.maxPayloadSize > 0, MaxPayloadSize: b.maxPayloadSize}
+	resp, err := b.raftApply(commands.AddEventsBulkCommandType, cmd)
+	if err != nil {
+		return nil, nil, err
+	}
+	bulkResp := resp.(*fsmAddBulkResponse)
+	if bulkResp.error != nil {
+		return nil, nil, bulkResp.error
+	}
+
+	//Send only the new snapshots to the snapshot channel, once per event
+	published := make(map[*balloon.Snapshot]bool)
+	for i, s := range bulkResp.snapshotBulk {
+		if bulkResp.proofs[i] != nil || published[s] {
+			continue
+		}
+		published[s] = true
+		b.metrics.Adds.Inc()
+		p := protocol.Snapshot(*s)
+		b.snapshotsCh <- &p
+	}
+
+	return bulkResp.snapshotBulk, bulkResp.proofs, nil
+}
+
+func (b *RaftBalloon) AddIdempotent(event []byte, key string) (*balloon.Snapshot, error) {
+	cmd := &commands.AddEventCommand{Event: event, Timestamp: time.Now().UnixNano(), IdempotencyKey: key, StorePayload: b.storesPayload(event)}
+	resp, err := b.applyAdd(cmd)
+	if err != nil {
+		return nil, err
+	}
+	return resp.snapshot, nil
+}
+
+// AddDigest adds an event already hashed by the producer. Digests are
+// checked before reaching the log, so every node can apply them.
+func (b *RaftBalloon) AddDigest(eventDigest hashing.Digest) (*balloon.Snapshot, error) {
+	if err := b.checkDigest(eventDigest); err != nil {
+		return nil, err
+	}
+	cmd := &commands.AddDigestCommand{Digest: eventDigest, Timestamp: time.Now().UnixNano()}
+	resp, err := b.raftApply(commands.AddDigestCommandType, cmd)
+	if err != nil {
+		return nil, err
+	}
+	addResp := resp.(*fsmAddResponse)
+	if addResp.error != nil {
+		return nil, addResp.error
+	}
+	b.metrics.Adds.Inc()
+
+	p := protocol.Snapshot(*addResp.snapshot)
+
+	//Send snapshot to the snapshot channel
+	b.snapshotsCh <- &p // TODO move this to an upper layer (shard manager?)
+
+	return addResp.snapshot, nil
+}
+
+// AddDigestBulk adds a bulk of events already hashed by the producer.
+func (b *RaftBalloon) AddDigestBulk(eventBulkDigest []hashing.Digest) ([]*balloon.Snapshot, error) {
+	digests := make([][]byte, 0, len(eventBulkDigest))
+	for _, eventDigest := range eventBulkDigest {
+		if err := b.checkDigest(eventDigest); err != nil {
+			return nil, err
+		}
+		digests = append(digests, eventDigest)
+	}
+	cmd := &commands.AddDigestsBulkCommand{Digests: digests, Timestamp: time.Now().UnixNano()}
+	resp, err := b.raftApply(commands.AddDigestsBulkCommandType, cmd)
+	if err != nil {
+		return nil, err
+	}
+	bulkResp := resp.(*fsmAddBulkResponse)
+	if bulkResp.error != nil {
+		return nil, bulkResp.error
+	}
+	b.metrics.Adds.Add(float64(len(eventBulkDigest)))
+
+	//Send snapshot to the snapshot channel
+	// TODO move this to an upper layer (shard manager?)
+	for _, s := range bulkResp.snapshotBulk {
+		p := protocol.Snapshot(*s)
+		b.snapshotsCh <- &p
+	}
+
+	return bulkResp.snapshotBulk, nil
+}
+
+func (b *RaftBalloon) checkDigest(eventDigest hashing.Digest) error {
+	if len(eventDigest) != int(b.fsm.hasherF().Len()/8) {
+		return ErrInvalidDigestLength
+	}
+	return nil
+}
+
+// PayloadTooLarge returns whether the event is too large for its payload
+// to be stored when it is added through this node, so it is logged only by
+// its digest.
+func (b *RaftBalloon) PayloadTooLarge(event []byte) bool {
+	return b.maxPayloadSize > 0 && len(event) > b.maxPayloadSize
+}
+
+// storesPayload returns whether the payload of the event is stored when it
+// is added through this node.
+func (b *RaftBalloon) storesPayload(event []byte) bool {
+	return b.maxPayloadSize > 0 && !b.PayloadTooLarge(event)
+}
+
+// applyAdd applies the add command and sends the snapshot to the snapshot
+// channel only if the event has been added.
+func (b *RaftBalloon) applyAdd(cmd *commands.AddEventCommand) (*fsmAddResponse, error) {
+	resp, err := b.raftApply(commands.AddEventCommandType, cmd)
+	if err != nil {
+		return nil, err
+	}
+	addResp := resp.(*fsmAddResponse)
+	if addResp.error != nil {
+		return nil, addResp.error
+	}
+
+	if !addResp.existing {
+		b.metrics.Adds.Inc()
+		p := protocol.Snapshot(*addResp.snapshot)
+		b.snapshotsCh <- &p // TODO move this to an upper layer (shard manager?)
+	}
+
+	return addResp, nil
+}
+
 func (b *RaftBalloon) QueryDigestMembership(keyDigest hashing.Digest, version uint64) (*balloon.MembershipProof, error) {
 	b.metrics.DigestMembershipQueries.Inc()
 	return b.fsm.QueryDigestMembership(keyDigest, version)
@@ -421,6 +878,39 @@ func (b *RaftBalloon) QueryConsistency(start, end uint64) (*balloon.IncrementalP
 	return b.fsm.QueryConsistency(start, end)
 }
 
+func (b *RaftBalloon) QueryHistoryDigest(version uint64) (hashing.Digest, error) {
+	return b.fsm.QueryHistoryDigest(version)
+}
+
+func (b *RaftBalloon) QueryHyperDigest(version uint64) (hashing.Digest, error) {
+	return b.fsm.QueryHyperDigest(version)
+}
+
+func (b *RaftBalloon) QueryLeafHashes(start, end uint64) ([]hashing.Digest, error) {
+	return b.fsm.QueryLeafHashes(start, end)
+}
+
+func (b *RaftBalloon) QueryEventDigests(start, end uint64) ([]hashing.Digest, error) {
+	return b.fsm.QueryEventDigests(start, end)
+}
+
+func (b *RaftBalloon) QueryHistoryMembership(index, version uint64) (*history.MembershipProof, error) {
+	return b.fsm.QueryHistoryMembership(index, version)
+}
+
+func (b *RaftBalloon) QueryTimestamp(version uint64) (int64, error) {
+	return b.fsm.QueryTimestamp(version)
+}
+
+func (b *RaftBalloon) QueryPayload(version uint64) ([]byte, error) {
+	return b.fsm.QueryPayload(version)
+}
+
+// Version returns the number of events added to the balloon.
+func (b *RaftBalloon) Version() uint64 {
+	return b.fsm.Version()
+}
+
 // Join joins a node, identified by id and located at addr, to this store.
 // The node must be ready to respond to Raft communications at that address.
 // This must be called from the Leader or it will fail.
@@ -428,6 +918,13 @@ func (b *RaftBalloon) Join(nodeID, addr string, metadata map[string]string) erro
 
 	log.Infof("received join request for remote node %s at %s", nodeID, addr)
 
+	if hasher, ok := metadata["hasher"]; ok && hasher != b.fsm.Hasher() {
+		return fmt.Errorf("node %s uses hasher %s but the cluster uses %s", nodeID, hasher, b.fsm.Hasher())
+	}
+	if format, ok := metadata["format"]; ok && format != strconv.Itoa(int(b.fsm.Format())) {
+		return fmt.Errorf("node %s uses tree format %s but the cluster uses %d: start it with that tree format", nodeID, format, b.fsm.Format())
+	}
+
 	configFuture := b.raft.api.GetConfiguration()
 	if err := configFuture.Error(); err != nil {
 		log.Errorf("failed to get raft servers configuration: %v", err)
@@ -469,16 +966,56 @@ func (b *RaftBalloon) Join(nodeID, addr string, metadata map[string]string) erro
 	return nil
 }
 
+// Keys returns the signing keys of the log ordered by activation.
+func (b *RaftBalloon) Keys() []protocol.KeyInfo {
+	return b.fsm.Keys()
+}
+
+// ActiveKeyID returns the ID of the key that signs the snapshots, or an
+// empty string if the log has never been rotated.
+func (b *RaftBalloon) ActiveKeyID() string {
+	keys := b.fsm.Keys()
+	if len(keys) == 0 {
+		return ""
+	}
+	return keys[len(keys)-1].KeyID
+}
+
+// ActivateKey replicates the activation of a signing key, so that every
+// node signs with it from then on.
+func (b *RaftBalloon) ActivateKey(key, previous sign.Signer) error {
+	cmd := &commands.ActivateKeyCommand{
+		PublicKey: key.PublicKey(),
+		Algorithm: key.Algorithm(),
+		Timestamp: time.Now().UnixNano(),
+	}
+	if previous != nil {
+		cmd.PreviousPublicKey = previous.PublicKey()
+		cmd.PreviousAlgorithm = previous.Algorithm()
+	}
+	resp, err := b.raftApply(commands.ActivateKeyCommandType, cmd)
+	if err != nil {
+		return err
+	}
+	return resp.(*fsmGenericResponse).error
+}
+
 // SetMetadata adds the metadata md to any existing metadata for
 // this node.
 func (b *RaftBalloon) SetMetadata(nodeInvolved string, md map[string]string) error {
 	cmd := b.fsm.setMetadata(nodeInvolved, md)
+	if cmd == nil {
+		return nil
+	}
 	_, err := b.WaitForLeader(5 * time.Second)
 	if err != nil {
 		return err
 	}
 
 	resp, err := b.raftApply(commands.MetadataSetCommandType, cmd)
+	if err == raft.ErrNotLeader {
+		return ErrNotLeader
+	}
 	if err != nil {
 		return err
 	}
@@ -491,13 +1028,23 @@ func (b *RaftBalloon) Info() map[string]interface{} {
 	m := make(map[string]interface{})
 	m["nodeID"] = b.ID()
 	m["leaderID"], _ = b.LeaderID()
-	m["meta"] = b.fsm.meta
+	m["meta"] = b.fsm.NodesMetadata()
 	return m
 }
 
 func (b *RaftBalloon) RegisterMetrics(registry metrics.Registry) {
 	if registry != nil {
-		b.store.rocksStore.RegisterMetrics(registry)
+		b.store.raftStore.RegisterMetrics(registry)
 	}
 	registry.MustRegister(b.metrics.collectors()...)
 }
+
+// raftLogger returns a logger for the raft library that writes through
+// the qed logger, so its level filters the raft messages.
+func raftLogger() hclog.Logger {
+	return hclog.New(&hclog.LoggerOptions{
+		Name:   "raft",
+		Level:  hclog.Debug,
+		Output: log.GetLogger().Writer(),
+	})
+}
@@ -0,0 +1,48 @@
+//go:build cgo
+// +build cgo
+
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package raftwal
+
+import (
+	"fmt"
+
+	"github.com/bbva/qed/protocol"
+	"github.com/bbva/qed/raftwal/raftrocks"
+	"github.com/bbva/qed/storage"
+)
+
+// NewRaftBalloon returns a new RaftBalloon whose raft log is kept in a
+// RocksDB store under path, which needs a build with cgo. The hasher
+// names the hashing algorithm of the log and must match the one recorded
+// at bootstrap.
+func NewRaftBalloon(path, addr, id, hasher string, store storage.ManagedStore, snapshotsCh chan *protocol.Snapshot) (*RaftBalloon, error) {
+
+	// Create the log store and stable store
+	rocksStore, err := raftrocks.New(raftrocks.Options{Path: path + "/wal", NoSync: true, EnableStatistics: true})
+	if err != nil {
+		return nil, fmt.Errorf("cannot create a new rocksdb log store: %s", err)
+	}
+
+	rb, err := NewRaftBalloonWithStore(path, addr, id, hasher, store, rocksStore, snapshotsCh)
+	if err != nil {
+		rocksStore.Close()
+		return nil, err
+	}
+	return rb, nil
+}
@@ -27,6 +27,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/bbva/qed/hashing"
 	"github.com/bbva/qed/protocol"
 
 	"github.com/bbva/qed/log"
@@ -57,7 +58,7 @@ func newNode(t *testing.T, id int) (*RaftBalloon, func()) {
 	raftPath := fmt.Sprintf("/var/tmp/raft-test/node%d/raft", id)
 	err = os.MkdirAll(raftPath, os.FileMode(0755))
 	require.NoError(t, err)
-	r, err := NewRaftBalloon(raftPath, raftAddr(id), fmt.Sprintf("%d", id), db, make(chan *protocol.Snapshot, 25000))
+	r, err := NewRaftBalloon(raftPath, raftAddr(id), fmt.Sprintf("%d", id), hashing.Sha256, db, make(chan *protocol.Snapshot, 25000))
 	require.NoError(t, err)
 
 	return r, func() {
@@ -138,6 +139,28 @@ func Test_Raft_MultiNode_Join(t *testing.T) {
 
 }
 
+func Test_Raft_MultiNode_JoinHasherMismatch(t *testing.T) {
+
+	log.SetLogger("Test_Raft_MultiNode_JoinHasherMismatch", log.SILENT)
+
+	r0, clean0 := newNode(t, 3)
+	defer func() {
+		err := r0.Close(true)
+		require.NoError(t, err)
+		clean0()
+	}()
+
+	err := r0.Open(true, map[string]string{"foo": "bar"})
+	require.NoError(t, err)
+
+	_, err = r0.WaitForLeader(10 * time.Second)
+	require.NoError(t, err)
+
+	err = r0.Join("1", raftAddr(4), map[string]string{"hasher": hashing.Blake2b256})
+	require.Error(t, err, "Nodes with a different hasher should not be able to join")
+
+}
+
 func Test_Raft_MultiNode_JoinRemove(t *testing.T) {
 
 	r0, clean0 := newNode(t, 5)
@@ -367,6 +390,155 @@ func Test_Raft_MultiNode_WithMetadata(t *testing.T) {
 	require.Equal(t, r0.Info()["meta"], r1.Info()["meta"], "Both nodes must have the same metadata.")
 }
 
+func Test_Raft_MultiNode_ClusterStatus(t *testing.T) {
+
+	log.SetLogger("Test_Raft_MultiNodeClusterStatus", log.SILENT)
+
+	r0, clean0 := newNode(t, 0)
+	defer func() {
+		err := r0.Close(true)
+		require.NoError(t, err)
+		clean0()
+	}()
+
+	err := r0.Open(true, map[string]string{"nodeID": "0"})
+	require.NoError(t, err)
+
+	_, err = r0.WaitForLeader(10 * time.Second)
+	require.NoError(t, err)
+
+	r1, clean1 := newNode(t, 1)
+	defer func() {
+		err := r1.Close(true)
+		require.NoError(t, err)
+		clean1()
+	}()
+
+	err = r1.Open(false, map[string]string{})
+	require.NoError(t, err)
+
+	err = r0.Join("1", string(r1.raft.transport.LocalAddr()), map[string]string{"nodeID": "1"})
+	require.NoError(t, err)
+
+	_, err = r0.Add([]byte("event"))
+	require.NoError(t, err)
+
+	time.Sleep(1 * time.Second)
+
+	status, err := r0.Status()
+	require.NoError(t, err)
+	require.Equal(t, "0", status.ID, "Wrong node ID")
+	require.Equal(t, "0", status.LeaderID, "Node 0 should be the leader")
+	require.Equal(t, "Leader", status.State, "Node 0 should be the leader")
+	require.Equal(t, uint64(1), status.FSM.Version, "Wrong balloon version")
+	require.True(t, status.AppliedIndex > 0, "Node 0 should have applied entries")
+
+	status, err = r1.Status()
+	require.NoError(t, err)
+	require.Equal(t, "Follower", status.State, "Node 1 should be a follower")
+	require.Equal(t, "0", status.LeaderID, "Node 1 should know the leader")
+
+	nodes, err := r1.ClusterNodes()
+	require.NoError(t, err)
+	require.Len(t, nodes, 2, "The cluster should have 2 nodes")
+	for _, n := range nodes {
+		require.Equal(t, n.ID == "0", n.Leader, "Wrong leader flag of node %s", n.ID)
+		require.Equal(t, map[string]string{"nodeID": n.ID}, n.Metadata, "Wrong metadata of node %s", n.ID)
+	}
+
+	require.Equal(t, ErrNotLeader, r1.SetMetadata("1", map[string]string{"zone": "a"}), "Only the leader can set metadata")
+	require.NoError(t, r0.SetMetadata("1", map[string]string{"zone": "a"}))
+	require.NoError(t, r0.Snapshot(), "The leader should take a snapshot")
+}
+
+func Test_Raft_MultiNode_TransferLeadership(t *testing.T) {
+
+	log.SetLogger("Test_Raft_MultiNodeTransferLeadership", log.SILENT)
+
+	r0, clean0 := newNode(t, 0)
+	defer func() {
+		err := r0.Close(true)
+		require.NoError(t, err)
+		clean0()
+	}()
+
+	err := r0.Open(true, map[string]string{"nodeID": "0"})
+	require.NoError(t, err)
+
+	_, err = r0.WaitForLeader(10 * time.Second)
+	require.NoError(t, err)
+
+	r1, clean1 := newNode(t, 1)
+	defer func() {
+		err := r1.Close(true)
+		require.NoError(t, err)
+		clean1()
+	}()
+
+	err = r1.Open(false, map[string]string{})
+	require.NoError(t, err)
+
+	err = r0.Join("1", string(r1.raft.transport.LocalAddr()), map[string]string{"nodeID": "1"})
+	require.NoError(t, err)
+
+	_, err = r0.Add([]byte("event"))
+	require.NoError(t, err)
+
+	require.Equal(t, ErrNotLeader, r1.TransferLeadership("0"), "Only the leader can transfer the leadership")
+	require.Error(t, r0.TransferLeadership("2"), "The leadership cannot be transferred to an unknown node")
+
+	require.NoError(t, r0.TransferLeadership("1"))
+	_, err = r1.WaitForLeader(10 * time.Second)
+	require.NoError(t, err)
+	require.True(t, r1.IsLeader(), "Node 1 should be the leader")
+	require.False(t, r0.IsLeader(), "Node 0 should have stepped down")
+}
+
+func Test_Raft_MultiNode_ReadConsistency(t *testing.T) {
+
+	log.SetLogger("Test_Raft_MultiNodeReadConsistency", log.SILENT)
+
+	r0, clean0 := newNode(t, 0)
+	defer func() {
+		err := r0.Close(true)
+		require.NoError(t, err)
+		clean0()
+	}()
+
+	err := r0.Open(true, map[string]string{"nodeID": "0"})
+	require.NoError(t, err)
+
+	_, err = r0.WaitForLeader(10 * time.Second)
+	require.NoError(t, err)
+
+	r1, clean1 := newNode(t, 1)
+	defer func() {
+		err := r1.Close(true)
+		require.NoError(t, err)
+		clean1()
+	}()
+
+	err = r1.Open(false, map[string]string{})
+	require.NoError(t, err)
+
+	err = r0.Join("1", string(r1.raft.transport.LocalAddr()), map[string]string{"nodeID": "1"})
+	require.NoError(t, err)
+
+	_, err = r0.Add([]byte("event"))
+	require.NoError(t, err)
+
+	time.Sleep(1 * time.Second)
+
+	linearizable := protocol.ReadConsistency{Level: protocol.Linearizable}
+	bounded := protocol.ReadConsistency{Level: protocol.Bounded, MaxLag: 5 * time.Second, MaxLagVersions: 10}
+
+	require.NoError(t, r0.CheckConsistency(linearizable), "The leader should answer linearizable queries")
+	require.Equal(t, ErrNotLeader, r1.CheckConsistency(linearizable), "Followers should not answer linearizable queries")
+	require.NoError(t, r0.CheckConsistency(bounded), "The leader should answer bounded queries")
+	require.NoError(t, r1.CheckConsistency(bounded), "An up to date follower should answer bounded queries")
+	require.NoError(t, r1.CheckConsistency(protocol.ReadConsistency{Level: protocol.Stale}))
+}
+
 func Test_Raft_MultiNode_Remove_WithMetadata(t *testing.T) {
 
 	log.SetLogger("Test_Raft_MultiNodeMetadataRemove", log.SILENT)
@@ -464,7 +636,7 @@ func newNodeBench(b *testing.B, id int) (*RaftBalloon, func()) {
 	snapshotsCh := make(chan *protocol.Snapshot, 10000)
 	snapshotsDrainer(snapshotsCh)
 
-	node, err := NewRaftBalloon(raftPath, raftAddr(id), fmt.Sprintf("%d", id), store, snapshotsCh)
+	node, err := NewRaftBalloon(raftPath, raftAddr(id), fmt.Sprintf("%d", id), hashing.Sha256, store, snapshotsCh)
 	require.NoError(b, err)
 
 	srvCloseF := metrics_utils.StartMetricsServer(node, store)
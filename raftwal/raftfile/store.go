@@ -0,0 +1,275 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package raftfile implements a raft log and stable store in pure Go. It
+// keeps everything in memory and, optionally, appends every change to a
+// file that is replayed when the store is opened again.
+package raftfile
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/bbva/qed/log"
+	"github.com/bbva/qed/metrics"
+	"github.com/hashicorp/go-msgpack/codec"
+	"github.com/hashicorp/raft"
+)
+
+type op uint8
+
+const (
+	storeLogsOp op = iota
+	deleteRangeOp
+	setOp
+	setUint64Op
+)
+
+// record is a change of the store, as it is appended to the file.
+type record struct {
+	Op       op
+	Logs     []*raft.Log `codec:",omitempty"`
+	Min, Max uint64      `codec:",omitempty"`
+	Key      []byte      `codec:",omitempty"`
+	Value    []byte      `codec:",omitempty"`
+	Uint64   uint64      `codec:",omitempty"`
+}
+
+// Store is a raft log and stable store.
+type Store struct {
+	*raft.InmemStore
+
+	// file logs the changes, if the store is persistent
+	file *os.File
+
+	// serializes the changes, so that the file and the
+	// memory see them in the same order
+	mu sync.Mutex
+}
+
+// New returns a store persisted in the file at the given path, created if
+// it does not exist, or kept only in memory if the path is empty. The file
+// is compacted every time it is opened, and a change cut by a crash at the
+// end of the file is discarded.
+//
+// Logs are not synced to disk until the store is closed, like the log
+// store of RocksDB opened with NoSync. The changes of the stable store,
+// which holds the current term and vote, are synced at once, as raft
+// must not forget them after a crash.
+func New(path string) (*Store, error) {
+	s := &Store{InmemStore: raft.NewInmemStore()}
+	if path == "" {
+		return s, nil
+	}
+
+	// the stable store cannot be listed, so its last values are kept
+	// while replaying
+	values := make(map[string][]byte)
+	uint64s := make(map[string]uint64)
+
+	file, err := os.Open(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		err = readRecords(bufio.NewReader(file), func(r *record) error {
+			switch r.Op {
+			case setOp:
+				values[string(r.Key)] = r.Value
+			case setUint64Op:
+				uint64s[string(r.Key)] = r.Uint64
+			}
+			return s.apply(r)
+		})
+		file.Close()
+		if err == io.ErrUnexpectedEOF {
+			log.Infof("Discarding the last change of %s, which is incomplete", path)
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	compacted := make([]*record, 0)
+	first, _ := s.FirstIndex()
+	last, _ := s.LastIndex()
+	if last > 0 {
+		logs := make([]*raft.Log, 0, last-first+1)
+		for i := first; i <= last; i++ {
+			l := new(raft.Log)
+			if err := s.GetLog(i, l); err != nil {
+				return nil, err
+			}
+			logs = append(logs, l)
+		}
+		compacted = append(compacted, &record{Op: storeLogsOp, Logs: logs})
+	}
+	for k, v := range values {
+		compacted = append(compacted, &record{Op: setOp, Key: []byte(k), Value: v})
+	}
+	for k, v := range uint64s {
+		compacted = append(compacted, &record{Op: setUint64Op, Key: []byte(k), Uint64: v})
+	}
+
+	// the compacted file replaces the old one at once
+	tmp := path + ".tmp"
+	file, err = os.Create(tmp)
+	if err != nil {
+		return nil, err
+	}
+	w := bufio.NewWriter(file)
+	for _, r := range compacted {
+		if err := writeRecord(w, r); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	s.file = file
+	return s, nil
+}
+
+// StoreLog implements the LogStore interface.
+func (s *Store) StoreLog(log *raft.Log) error {
+	return s.StoreLogs([]*raft.Log{log})
+}
+
+// StoreLogs implements the LogStore interface.
+func (s *Store) StoreLogs(logs []*raft.Log) error {
+	return s.change(&record{Op: storeLogsOp, Logs: logs}, false)
+}
+
+// DeleteRange implements the LogStore interface.
+func (s *Store) DeleteRange(min, max uint64) error {
+	return s.change(&record{Op: deleteRangeOp, Min: min, Max: max}, false)
+}
+
+// Set implements the StableStore interface.
+func (s *Store) Set(key []byte, val []byte) error {
+	return s.change(&record{Op: setOp, Key: key, Value: val}, true)
+}
+
+// SetUint64 implements the StableStore interface.
+func (s *Store) SetUint64(key []byte, val uint64) error {
+	return s.change(&record{Op: setUint64Op, Key: key, Uint64: val}, true)
+}
+
+// Close syncs and closes the file of the store, if any.
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Sync()
+	if cerr := s.file.Close(); err == nil {
+		err = cerr
+	}
+	s.file = nil
+	return err
+}
+
+func (s *Store) RegisterMetrics(registry metrics.Registry) {
+}
+
+// change appends the record to the file, syncing it if asked, and then
+// applies it to the memory.
+func (s *Store) change(r *record, sync bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file != nil {
+		if err := writeRecord(s.file, r); err != nil {
+			return err
+		}
+		if sync {
+			if err := s.file.Sync(); err != nil {
+				return err
+			}
+		}
+	}
+	return s.apply(r)
+}
+
+func (s *Store) apply(r *record) error {
+	switch r.Op {
+	case storeLogsOp:
+		return s.InmemStore.StoreLogs(r.Logs)
+	case deleteRangeOp:
+		return s.InmemStore.DeleteRange(r.Min, r.Max)
+	case setOp:
+		return s.InmemStore.Set(r.Key, r.Value)
+	case setUint64Op:
+		return s.InmemStore.SetUint64(r.Key, r.Uint64)
+	}
+	return nil
+}
+
+// writeRecord writes the size of the record as a little endian uint64
+// followed by the record encoded with msgpack, in a single write.
+func writeRecord(w io.Writer, r *record) error {
+	var buf bytes.Buffer
+	buf.Write(make([]byte, 8))
+	enc := codec.NewEncoder(&buf, &codec.MsgpackHandle{})
+	if err := enc.Encode(r); err != nil {
+		return err
+	}
+	frame := buf.Bytes()
+	binary.LittleEndian.PutUint64(frame, uint64(len(frame)-8))
+	_, err := w.Write(frame)
+	return err
+}
+
+func readRecords(r io.Reader, f func(*record) error) error {
+	for {
+		var size uint64
+		err := binary.Read(r, binary.LittleEndian, &size)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		buf := make([]byte, size)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		var rec record
+		if err := codec.NewDecoderBytes(buf, &codec.MsgpackHandle{}).Decode(&rec); err != nil {
+			return err
+		}
+		if err := f(&rec); err != nil {
+			return err
+		}
+	}
+}
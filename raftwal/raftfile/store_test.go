@@ -0,0 +1,90 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package raftfile
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPersistence(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "raftfile")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "raft.db")
+
+	store, err := New(path)
+	require.NoError(t, err)
+	for i := uint64(1); i <= 10; i++ {
+		require.NoError(t, store.StoreLog(&raft.Log{Index: i, Term: 1, Data: []byte{byte(i)}}))
+	}
+	require.NoError(t, store.DeleteRange(1, 3))
+	require.NoError(t, store.Set([]byte("key"), []byte("value")))
+	require.NoError(t, store.SetUint64([]byte("term"), 7))
+	require.NoError(t, store.Close())
+
+	// a change cut by a crash is discarded
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	_, err = file.Write([]byte{0x10, 0, 0, 0, 0, 0, 0, 0, 0x1})
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	// the store is opened twice to replay a compacted file
+	for i := 0; i < 2; i++ {
+		store, err = New(path)
+		require.NoError(t, err)
+
+		first, err := store.FirstIndex()
+		require.NoError(t, err)
+		require.Equal(t, uint64(4), first)
+		last, err := store.LastIndex()
+		require.NoError(t, err)
+		require.Equal(t, uint64(10), last)
+
+		var l raft.Log
+		require.NoError(t, store.GetLog(5, &l))
+		require.Equal(t, []byte{0x5}, l.Data)
+		require.Equal(t, raft.ErrLogNotFound, store.GetLog(2, &l), "Deleted logs should not be replayed")
+
+		value, err := store.Get([]byte("key"))
+		require.NoError(t, err)
+		require.Equal(t, []byte("value"), value)
+		term, err := store.GetUint64([]byte("term"))
+		require.NoError(t, err)
+		require.Equal(t, uint64(7), term)
+
+		require.NoError(t, store.Close())
+	}
+}
+
+func TestInmem(t *testing.T) {
+
+	store, err := New("")
+	require.NoError(t, err)
+	require.NoError(t, store.StoreLog(&raft.Log{Index: 1, Term: 1}))
+	last, err := store.LastIndex()
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), last)
+	require.NoError(t, store.Close())
+}
@@ -17,11 +17,29 @@
 package raftwal
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+
 	"github.com/bbva/qed/log"
 	"github.com/bbva/qed/storage"
 	"github.com/hashicorp/raft"
 )
 
+// Snapshots start with a header that carries the node metadata, followed by
+// the store backup:
+//
+// magic (8 bytes) | version (uint32) | metadata size (uint64) | metadata | backup
+//
+// Integers are little endian. Legacy snapshots hold only the store backup,
+// whose first 8 bytes are the size of a single entry and can never match
+// the magic.
+var snapshotMagic = []byte("QEDSNAP\x00")
+
+const snapshotVersion uint32 = 1
+
 type fsmSnapshot struct {
 	id    uint64
 	store storage.ManagedStore
@@ -32,7 +50,7 @@ type fsmSnapshot struct {
 func (f *fsmSnapshot) Persist(sink raft.SnapshotSink) error {
 	log.Debug("Persisting snapshot...")
 	err := func() error {
-		if err := f.store.Backup(sink, f.id); err != nil {
+		if err := f.writeTo(sink); err != nil {
 			return err
 		}
 		return sink.Close()
@@ -43,7 +61,64 @@ func (f *fsmSnapshot) Persist(sink raft.SnapshotSink) error {
 	return err
 }
 
+// writeTo writes the snapshot header followed by the store backup.
+func (f *fsmSnapshot) writeTo(w io.Writer) error {
+	if err := writeSnapshotHeader(w, f.meta); err != nil {
+		return err
+	}
+	return f.store.Backup(w, f.id)
+}
+
 // Release is invoked when we are finished with the snapshot.
 func (f *fsmSnapshot) Release() {
 	log.Debug("Snapshot created.")
 }
+
+func writeSnapshotHeader(w io.Writer, meta []byte) error {
+	if _, err := w.Write(snapshotMagic); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, snapshotVersion); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, uint64(len(meta))); err != nil {
+		return err
+	}
+	_, err := w.Write(meta)
+	return err
+}
+
+// readSnapshotHeader consumes the header of a snapshot and returns the
+// encoded node metadata, leaving the reader at the start of the store
+// backup. Legacy snapshots have no header and return nil metadata.
+func readSnapshotHeader(r *bufio.Reader) ([]byte, error) {
+	magic, err := r.Peek(len(snapshotMagic))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if !bytes.Equal(magic, snapshotMagic) {
+		log.Debug("Restoring legacy snapshot without metadata")
+		return nil, nil
+	}
+	if _, err := r.Discard(len(snapshotMagic)); err != nil {
+		return nil, err
+	}
+
+	var version uint32
+	if err := binary.Read(r, binary.LittleEndian, &version); err != nil {
+		return nil, err
+	}
+	if version != snapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %d", version)
+	}
+
+	var size uint64
+	if err := binary.Read(r, binary.LittleEndian, &size); err != nil {
+		return nil, err
+	}
+	meta := make([]byte, size)
+	if _, err := io.ReadFull(r, meta); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
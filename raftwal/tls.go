@@ -0,0 +1,83 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package raftwal
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// tlsStreamLayer is a raft stream layer that wraps every connection
+// between nodes in TLS. The configuration should require and verify the
+// certificates of the peers, so that only nodes with a certificate signed
+// by the cluster CA can exchange raft messages.
+type tlsStreamLayer struct {
+	advertise net.Addr
+	listener  net.Listener
+	config    *tls.Config
+}
+
+func newTLSStreamLayer(bindAddr string, advertise net.Addr, config *tls.Config) (*tlsStreamLayer, error) {
+
+	listener, err := tls.Listen("tcp", bindAddr, config)
+	if err != nil {
+		return nil, err
+	}
+
+	stream := &tlsStreamLayer{
+		advertise: advertise,
+		listener:  listener,
+		config:    config,
+	}
+
+	// raft advertises the address to its peers
+	addr, ok := stream.Addr().(*net.TCPAddr)
+	if !ok || addr.IP.IsUnspecified() {
+		listener.Close()
+		return nil, errors.New("the raft address is not advertisable")
+	}
+
+	return stream, nil
+}
+
+// Dial implements the raft.StreamLayer interface.
+func (t *tlsStreamLayer) Dial(address raft.ServerAddress, timeout time.Duration) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: timeout}
+	return tls.DialWithDialer(dialer, "tcp", string(address), t.config)
+}
+
+// Accept implements the net.Listener interface.
+func (t *tlsStreamLayer) Accept() (net.Conn, error) {
+	return t.listener.Accept()
+}
+
+// Close implements the net.Listener interface.
+func (t *tlsStreamLayer) Close() error {
+	return t.listener.Close()
+}
+
+// Addr implements the net.Listener interface.
+func (t *tlsStreamLayer) Addr() net.Addr {
+	if t.advertise != nil {
+		return t.advertise
+	}
+	return t.listener.Addr()
+}
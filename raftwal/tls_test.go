@@ -0,0 +1,92 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package raftwal
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/bbva/qed/testutils/keys"
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTLSStreamLayer(t *testing.T) {
+
+	path, err := ioutil.TempDir("", "qed-raft-tls")
+	require.NoError(t, err)
+	defer os.RemoveAll(path)
+	_, err = keys.GenerateTlsCert(path)
+	require.NoError(t, err)
+
+	cert, err := tls.LoadX509KeyPair(path+"/cert.pem", path+"/key.pem")
+	require.NoError(t, err)
+	pem, err := ioutil.ReadFile(path + "/cert.pem")
+	require.NoError(t, err)
+	pool := x509.NewCertPool()
+	require.True(t, pool.AppendCertsFromPEM(pem))
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+		RootCAs:      pool,
+	}
+
+	stream, err := newTLSStreamLayer("127.0.0.1:0", nil, config)
+	require.NoError(t, err)
+	defer stream.Close()
+
+	go func() {
+		for {
+			conn, err := stream.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				buf := make([]byte, 4)
+				if _, err := conn.Read(buf); err == nil {
+					conn.Write(buf)
+				}
+			}()
+		}
+	}()
+
+	// peers with a certificate signed by the CA can talk
+	conn, err := stream.Dial(raft.ServerAddress(stream.Addr().String()), time.Second)
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("ping"))
+	require.NoError(t, err)
+	buf := make([]byte, 4)
+	_, err = conn.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, "ping", string(buf), "The peer should answer")
+
+	// peers without a certificate cannot
+	anonymous, err := tls.Dial("tcp", stream.Addr().String(), &tls.Config{RootCAs: pool})
+	if err == nil {
+		defer anonymous.Close()
+		anonymous.Write([]byte("ping"))
+		_, err = anonymous.Read(buf)
+	}
+	require.Error(t, err, "Peers without certificate should be rejected")
+}
@@ -0,0 +1,171 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package server
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/bbva/qed/protocol"
+	"github.com/bbva/qed/raftwal"
+	"github.com/bbva/qed/sign"
+)
+
+// Restore rebuilds the database of an offline node from a backup taken
+// through the /backup management endpoint, and returns the signed snapshot
+// the restored log has been checked against. The signature must verify
+// with one of the signing keys of the configuration or with one of the
+// public keys in trustedKeysPaths, never with a key recorded in the backup
+// itself.
+//
+// The raft directory must be empty, and the restored node starts a new
+// cluster: other nodes join it once it is running. Full backups need an
+// empty database, while incremental ones are restored on top of the
+// database rebuilt from the previous backups. The backup is loaded into a
+// copy of the database, which only replaces it once the restored log has
+// been checked, so a failed restore leaves the database untouched.
+func Restore(conf *Config, r io.Reader, trustedKeysPaths []string) (*protocol.SignedSnapshot, error) {
+
+	if conf.Storage == "memory" {
+		return nil, fmt.Errorf("cannot restore a backup into memory storage")
+	}
+	if err := checkEmptyDir(conf.RaftPath); err != nil {
+		return nil, err
+	}
+
+	trusted, err := signingKeys(conf)
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range trustedKeysPaths {
+		verifier, err := sign.NewVerifierFromFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load trusted key %s: %v", path, err)
+		}
+		trusted = append(trusted, verifier)
+	}
+
+	restoreConf := *conf
+	restoreConf.DBPath = filepath.Clean(conf.DBPath) + ".restore"
+	if err := os.RemoveAll(restoreConf.DBPath); err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(restoreConf.DBPath)
+	if err := copyDir(conf.DBPath, restoreConf.DBPath); err != nil {
+		return nil, fmt.Errorf("unable to copy the database: %v", err)
+	}
+
+	store, err := openStore(&restoreConf)
+	if err != nil {
+		return nil, err
+	}
+	signed, err := raftwal.RestoreBackup(r, store, conf.Hasher, trusted...)
+	if cerr := store.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := replaceDir(conf.DBPath, restoreConf.DBPath); err != nil {
+		return nil, fmt.Errorf("unable to replace the database with the restored one: %v", err)
+	}
+	return signed, nil
+}
+
+// copyDir copies the files of the src directory into dst, which is
+// created. Nothing is copied if src does not exist.
+func copyDir(src, dst string) error {
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return err
+	}
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) && path == src {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if info.IsDir() {
+			return os.MkdirAll(target, info.Mode())
+		}
+		return copyFile(path, target, info.Mode())
+	})
+}
+
+func copyFile(src, dst string, mode os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if err == nil {
+		err = out.Sync()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// replaceDir moves the src directory to dst, removing the previous dst.
+// The previous directory is renamed first, so dst is never left half
+// removed.
+func replaceDir(dst, src string) error {
+	old := filepath.Clean(dst) + ".old"
+	if err := os.RemoveAll(old); err != nil {
+		return err
+	}
+	if err := os.Rename(dst, old); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Rename(src, dst); err != nil {
+		os.Rename(old, dst)
+		return err
+	}
+	return os.RemoveAll(old)
+}
+
+// checkEmptyDir returns an error unless the directory is empty or does not
+// exist.
+func checkEmptyDir(dir string) error {
+	files, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if len(files) > 0 {
+		return fmt.Errorf("the directory %s is not empty", dir)
+	}
+	return nil
+}
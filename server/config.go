@@ -20,14 +20,21 @@ import (
 	"net"
 	"os"
 	"path/filepath"
+
+	"github.com/bbva/qed/hashing"
 )
 
 type Config struct {
 	//Log level
 	Log string
 
-	// Unique identifier to allow connections
-	APIKey string
+	// API key with the admin role. It is also sent by the nodes that
+	// join a cluster. It is never published in the server info.
+	APIKey string `json:"-"`
+
+	// Path to the JSON file with the hashed API keys and their roles. It
+	// is reloaded when it changes.
+	APIKeysPath string
 
 	// Unique name for this node. It identifies itself both in raft and
 	// gossip clusters. If not set, fallback to hostname.
@@ -56,15 +63,61 @@ type Config struct {
 	// Path to Raft storage directory.
 	RaftPath string
 
+	// Storage backend of the database and the raft log: "rocksdb", which
+	// needs a build with cgo, "memory", which loses everything when the
+	// server stops, or "file", which keeps append-only files under DBPath
+	// and RaftPath.
+	Storage string
+
+	// Store the events added along with their digests, so they can be
+	// read back by version. Events larger than MaxPayloadSize bytes are
+	// logged only by their digest.
+	StorePayloads bool
+
+	// Size, in bytes, of the largest event whose payload is stored.
+	MaxPayloadSize int
+
+	// Hashing algorithm of the log (sha256, sha512-256, sha3-256 or
+	// blake2b-256). It is fixed at bootstrap.
+	Hasher string
+
+	// Tree format (0 or 1) of a new log. Nodes that join a cluster created
+	// with an older format must use that one. If empty, new logs use the
+	// current format and existing logs keep the one they were created with.
+	TreeFormat string
+
 	// Gossip management server bind address/port.
 	GossipAddr string
 
 	// List of nodes, through which a gossip cluster can be joined (protocol://host:port).
 	GossipJoinAddr []string
 
-	// Path to the private key file used to sign snapshots.
+	// Followers forward the events they receive to the leader, instead of
+	// failing because they are not the leader.
+	EnableWriteForwarding bool
+
+	// Base64 encoded keys that encrypt the gossip traffic. The first one is
+	// the primary key. If empty, the gossip traffic is not encrypted. They
+	// are never published in the server info.
+	GossipEncryptionKeys []string `json:"-"`
+
+	// Backend that signs the snapshots: "file" signs with the private key
+	// file, and "external" delegates to a signing process listening on
+	// SignerSocketPath.
+	SignerBackend string
+
+	// Path to the private key file used to sign snapshots. The signature
+	// algorithm follows the type of the key: Ed25519, ECDSA P-256 or RSA,
+	// which signs with RSA-PSS.
 	PrivateKeyPath string
 
+	// Unix socket of the external signing process.
+	SignerSocketPath string
+
+	// Paths to other private key files that can sign snapshots once they
+	// are activated through a key rotation.
+	SigningKeysPaths []string
+
 	// Enable TLS service
 	EnableTLS bool
 
@@ -79,6 +132,20 @@ type Config struct {
 
 	// TLS server cerificate key
 	SSLCertificateKey string
+
+	// CA bundle that signs the certificates of the clients and of the
+	// nodes of the cluster. Nodes present their server certificate as
+	// client certificate too.
+	SSLCACertificate string
+
+	// Require client certificates signed by the CA in the public API.
+	EnableClientAuth bool
+
+	// Serve the management API with mutual TLS.
+	EnableMgmtTLS bool
+
+	// Use mutual TLS in the raft transport.
+	EnableRaftTLS bool
 }
 
 func DefaultConfig() *Config {
@@ -86,23 +153,36 @@ func DefaultConfig() *Config {
 	currentDir := getCurrentDir()
 
 	return &Config{
-		Log:               "info",
-		APIKey:            "",
-		NodeID:            hostname,
-		HTTPAddr:          "127.0.0.1:8800",
-		RaftAddr:          "127.0.0.1:8500",
-		MgmtAddr:          "127.0.0.1:8700",
-		MetricsAddr:       "127.0.0.1:8600",
-		RaftJoinAddr:      []string{},
-		GossipAddr:        "127.0.0.1:8400",
-		GossipJoinAddr:    []string{},
-		DBPath:            currentDir + "/db",
-		RaftPath:          currentDir + "/wal",
-		EnableTLS:         false,
-		EnableProfiling:   false,
-		ProfilingAddr:     "127.0.0.1:6060",
-		SSLCertificate:    "",
-		SSLCertificateKey: "",
+		Log:                   "info",
+		APIKey:                "",
+		APIKeysPath:           "",
+		NodeID:                hostname,
+		HTTPAddr:              "127.0.0.1:8800",
+		RaftAddr:              "127.0.0.1:8500",
+		MgmtAddr:              "127.0.0.1:8700",
+		MetricsAddr:           "127.0.0.1:8600",
+		RaftJoinAddr:          []string{},
+		GossipAddr:            "127.0.0.1:8400",
+		GossipJoinAddr:        []string{},
+		GossipEncryptionKeys:  []string{},
+		EnableWriteForwarding: false,
+		SignerBackend:         "file",
+		SigningKeysPaths:      []string{},
+		DBPath:                currentDir + "/db",
+		RaftPath:              currentDir + "/wal",
+		Storage:               "rocksdb",
+		StorePayloads:         false,
+		MaxPayloadSize:        64 * 1024,
+		Hasher:                hashing.DefaultHasher,
+		EnableTLS:             false,
+		EnableProfiling:       false,
+		ProfilingAddr:         "127.0.0.1:6060",
+		SSLCertificate:        "",
+		SSLCertificateKey:     "",
+		SSLCACertificate:      "",
+		EnableClientAuth:      false,
+		EnableMgmtTLS:         false,
+		EnableRaftTLS:         false,
 	}
 }
 
@@ -0,0 +1,39 @@
+//go:build cgo
+// +build cgo
+
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package server
+
+import (
+	"github.com/bbva/qed/protocol"
+	"github.com/bbva/qed/raftwal"
+	"github.com/bbva/qed/storage"
+	"github.com/bbva/qed/storage/rocks"
+)
+
+// openRocksDBStore opens the RocksDB database of the rocksdb storage
+// backend.
+func openRocksDBStore(path string) (storage.ManagedStore, error) {
+	return rocks.NewRocksDBStore(path)
+}
+
+// newRocksDBRaftBalloon returns a RaftBalloon whose raft log is kept in
+// RocksDB too.
+func newRocksDBRaftBalloon(conf *Config, store storage.ManagedStore, snapshotsCh chan *protocol.Snapshot) (*raftwal.RaftBalloon, error) {
+	return raftwal.NewRaftBalloon(conf.RaftPath, conf.RaftAddr, conf.NodeID, conf.Hasher, store, snapshotsCh)
+}
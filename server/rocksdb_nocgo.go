@@ -0,0 +1,40 @@
+//go:build !cgo
+// +build !cgo
+
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package server
+
+import (
+	"errors"
+
+	"github.com/bbva/qed/protocol"
+	"github.com/bbva/qed/raftwal"
+	"github.com/bbva/qed/storage"
+)
+
+// errNoRocksDB is returned by the rocksdb storage backend of the builds
+// without cgo, which can only use the file and memory backends.
+var errNoRocksDB = errors.New("the rocksdb storage backend needs a build with cgo: use the file or memory storage")
+
+func openRocksDBStore(path string) (storage.ManagedStore, error) {
+	return nil, errNoRocksDB
+}
+
+func newRocksDBRaftBalloon(conf *Config, store storage.ManagedStore, snapshotsCh chan *protocol.Snapshot) (*raftwal.RaftBalloon, error) {
+	return nil, errNoRocksDB
+}
@@ -17,7 +17,6 @@
 package server
 
 import (
-	"fmt"
 	"time"
 
 	"github.com/bbva/qed/gossip"
@@ -52,10 +51,11 @@ type Sender struct {
 	NumSenders int
 	TTL        int
 	signer     sign.Signer
+	hasher     string
 	quitCh     chan bool
 }
 
-func NewSender(a *gossip.Agent, s sign.Signer, size, ttl, n int) *Sender {
+func NewSender(a *gossip.Agent, s sign.Signer, hasher string, size, ttl, n int) *Sender {
 	return &Sender{
 		agent:      a,
 		Interval:   100 * time.Millisecond,
@@ -63,6 +63,7 @@ func NewSender(a *gossip.Agent, s sign.Signer, size, ttl, n int) *Sender {
 		NumSenders: n,
 		TTL:        ttl,
 		signer:     s,
+		hasher:     hasher,
 		quitCh:     make(chan bool),
 	}
 }
@@ -151,10 +152,22 @@ func (s Sender) Stop() {
 }
 
 func (s *Sender) doSign(snapshot *protocol.Snapshot) (*protocol.SignedSnapshot, error) {
-	signature, err := s.signer.Sign([]byte(fmt.Sprintf("%v", snapshot)))
+	key := sign.ActiveKey(s.signer)
+	signed := &protocol.SignedSnapshot{
+		Snapshot:  snapshot,
+		Hasher:    s.hasher,
+		KeyID:     sign.KeyID(key.PublicKey()),
+		Algorithm: key.Algorithm(),
+	}
+	payload, err := signed.SigningPayload()
+	if err != nil {
+		log.Infof("Publisher: error encoding snapshot: %v", err)
+		return nil, err
+	}
+	signed.Signature, err = key.Sign(payload)
 	if err != nil {
 		log.Info("Publisher: error signing snapshot")
 		return nil, err
 	}
-	return &protocol.SignedSnapshot{Snapshot: snapshot, Signature: signature}, nil
+	return signed, nil
 }
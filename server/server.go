@@ -22,24 +22,31 @@ import (
 	"bytes"
 	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
-	"io"
 	"io/ioutil"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strconv"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 
 	"github.com/bbva/qed/api/apihttp"
+	"github.com/bbva/qed/api/auth"
 	"github.com/bbva/qed/api/mgmthttp"
 	"github.com/bbva/qed/gossip"
+	"github.com/bbva/qed/hashing"
 	"github.com/bbva/qed/log"
 	"github.com/bbva/qed/metrics"
 	"github.com/bbva/qed/protocol"
 	"github.com/bbva/qed/raftwal"
+	"github.com/bbva/qed/raftwal/raftfile"
 	"github.com/bbva/qed/sign"
-	"github.com/bbva/qed/storage/rocks"
+	"github.com/bbva/qed/storage"
+	"github.com/bbva/qed/storage/bplus"
 )
 
 // Server encapsulates the data and login to start/stop a QED server
@@ -53,34 +60,14 @@ type Server struct {
 	metrics            *serverMetrics
 	metricsServer      *metrics.Server
 	prometheusRegistry *prometheus.Registry
-	signer             sign.Signer
+	keyring            *sign.Keyring
+	apiKeys            *auth.KeyStore
+	nodeTLS            *tls.Config
 	sender             *Sender
 	agent              *gossip.Agent
 	snapshotsCh        chan *protocol.Snapshot
 }
 
-func serverInfo(conf *Config) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		// Make sure we can only be called with an HTTP POST request.
-		if r.Method != "GET" {
-			w.Header().Set("Allow", "GET")
-			w.WriteHeader(http.StatusMethodNotAllowed)
-			return
-		}
-
-		out, err := json.Marshal(conf)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-
-		w.WriteHeader(http.StatusOK)
-		w.Write(out)
-		return
-
-	}
-}
-
 // NewServer creates a new Server based on the parameters it receives.
 func NewServer(conf *Config) (*Server, error) {
 
@@ -94,27 +81,41 @@ func NewServer(conf *Config) (*Server, error) {
 		bootstrap: bootstrap,
 	}
 
-	log.Infof("ensuring directory at %s exists", conf.DBPath)
-	if err := os.MkdirAll(conf.DBPath, 0755); err != nil {
+	if err := validateTLS(conf); err != nil {
 		return nil, err
 	}
+	if conf.EnableMgmtTLS || conf.EnableRaftTLS {
+		var err error
+		server.nodeTLS, err = mutualTLSConfig(conf)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	log.Infof("ensuring directory at %s exists", conf.RaftPath)
-	if err := os.MkdirAll(conf.RaftPath, 0755); err != nil {
+	store, err := openStore(conf)
+	if err != nil {
 		return nil, err
 	}
 
-	// Open RocksDB store
-	store, err := rocks.NewRocksDBStore(conf.DBPath)
+	// Create the keyring. It signs with the key activated in the log
+	// or, until the first rotation, with the default key.
+	signers, err := signingKeys(conf)
 	if err != nil {
 		return nil, err
 	}
+	server.keyring = sign.NewKeyring(server.activeKeyID, signers[0], signers[1:]...)
 
-	// Create signer
-	server.signer, err = sign.NewEd25519SignerFromFile(conf.PrivateKeyPath)
+	// Create the API key store
+	server.apiKeys, err = auth.NewKeyStore(conf.APIKeysPath)
 	if err != nil {
 		return nil, err
 	}
+	if conf.APIKey != "" {
+		server.apiKeys.AddKey("config", conf.APIKey, auth.Admin)
+	}
+	if conf.APIKeysPath == "" && conf.APIKey == "" {
+		log.Info("No API keys configured: every API request will be rejected")
+	}
 
 	// Create metrics server
 	server.metricsServer = metrics.NewServer(conf.MetricsAddr)
@@ -135,8 +136,13 @@ func NewServer(conf *Config) (*Server, error) {
 	config.BindAddr = conf.GossipAddr
 	config.Role = "server"
 	config.NodeName = conf.NodeID
+	config.EncryptionKeys = conf.GossipEncryptionKeys
 
-	server.agent, err = gossip.NewAgentFromConfig(config)
+	// the server signs the batches it gossips with its default key, so
+	// that the agents can check that they come from a trusted server. It
+	// is not the keyring: activating another snapshot key must not change
+	// the identity that the agents trust.
+	server.agent, err = gossip.NewAgentFromConfig(config, gossip.SetIdentity(signers[0]))
 	if err != nil {
 		return nil, err
 	}
@@ -145,27 +151,74 @@ func NewServer(conf *Config) (*Server, error) {
 	server.snapshotsCh = make(chan *protocol.Snapshot, 1<<16)
 
 	// Create sender
-	server.sender = NewSender(server.agent, server.signer, 500, 2, 3)
+	server.sender = NewSender(server.agent, server.keyring, conf.Hasher, 500, 2, 3)
 
 	// Create RaftBalloon
-	server.raftBalloon, err = raftwal.NewRaftBalloon(conf.RaftPath, conf.RaftAddr, conf.NodeID, store, server.snapshotsCh)
+	server.raftBalloon, err = newRaftBalloon(conf, store, server.snapshotsCh)
 	if err != nil {
 		return nil, err
 	}
+	if conf.EnableRaftTLS {
+		server.raftBalloon.EnableTLS(server.nodeTLS)
+	}
+	if conf.TreeFormat != "" {
+		format, err := strconv.ParseUint(conf.TreeFormat, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid tree format %s", conf.TreeFormat)
+		}
+		if err := server.raftBalloon.SetFormat(hashing.FormatVersion(format)); err != nil {
+			return nil, err
+		}
+	}
+	if conf.StorePayloads {
+		if conf.MaxPayloadSize <= 0 {
+			return nil, fmt.Errorf("invalid maximum payload size %d", conf.MaxPayloadSize)
+		}
+		server.raftBalloon.StorePayloads(conf.MaxPayloadSize)
+	}
 
 	// Create http endpoints
-	httpMux := apihttp.NewApiHttp(server.raftBalloon)
-	httpMux.HandleFunc("/info", serverInfo(conf))
+	var forwarder *apihttp.Forwarder
+	if conf.EnableWriteForwarding {
+		tlsConfig, err := forwardTLSConfig(conf)
+		if err != nil {
+			return nil, err
+		}
+		forwarder = apihttp.NewForwarder(server.raftBalloon, tlsConfig, forwardTimeout)
+	}
+	// the info is served without authentication, so the
+	// configuration itself is never published
+	info := protocol.ServerInfo{
+		NodeID:         conf.NodeID,
+		HTTPAddr:       conf.HTTPAddr,
+		Hasher:         conf.Hasher,
+		Format:         server.raftBalloon.Format(),
+		EnableTLS:      conf.EnableTLS,
+		StorePayloads:  conf.StorePayloads,
+		MaxPayloadSize: conf.MaxPayloadSize,
+	}
+	httpMux := apihttp.NewApiHttp(server.raftBalloon, server.apiKeys, server.keyring, info, forwarder)
 
 	if conf.EnableTLS {
-		server.httpServer = newTLSServer(conf.HTTPAddr, httpMux)
+		var clientCAs *x509.CertPool
+		if conf.EnableClientAuth {
+			clientCAs, err = loadCertPool(conf.SSLCACertificate)
+			if err != nil {
+				return nil, err
+			}
+		}
+		server.httpServer = newTLSServer(conf.HTTPAddr, httpMux, clientCAs)
 	} else {
 		server.httpServer = newHTTPServer(conf.HTTPAddr, httpMux)
 	}
 
 	// Create management endpoints
-	mgmtMux := mgmthttp.NewMgmtHttp(server.raftBalloon)
-	server.mgmtServer = newHTTPServer(conf.MgmtAddr, mgmtMux)
+	mgmtMux := mgmthttp.NewMgmtHttp(server.raftBalloon, server.apiKeys, server.keyring, server.agent)
+	if conf.EnableMgmtTLS {
+		server.mgmtServer = newTLSServer(conf.MgmtAddr, mgmtMux, server.nodeTLS.ClientCAs)
+	} else {
+		server.mgmtServer = newHTTPServer(conf.MgmtAddr, mgmtMux)
+	}
 
 	// register qed metrics
 	server.metrics = newServerMetrics()
@@ -173,11 +226,127 @@ func NewServer(conf *Config) (*Server, error) {
 	store.RegisterMetrics(server.metricsServer)
 	server.raftBalloon.RegisterMetrics(server.metricsServer)
 	server.sender.RegisterMetrics(server.metricsServer)
+	server.apiKeys.RegisterMetrics(server.metricsServer)
 
 	return server, nil
 }
 
-func join(joinAddr, raftAddr, nodeID string, metadata map[string]string) error {
+// signerTimeout bounds every request to an external signing process.
+const signerTimeout = 5 * time.Second
+
+// apiKeysReloadInterval is how often the API keys file is checked for
+// changes.
+const apiKeysReloadInterval = 10 * time.Second
+
+// forwardTimeout bounds every write forwarded to the leader.
+const forwardTimeout = 30 * time.Second
+
+// newSigner returns the default signing key of the configured backend.
+func newSigner(conf *Config) (sign.Signer, error) {
+	switch conf.SignerBackend {
+	case "", "file":
+		return sign.NewSignerFromFile(conf.PrivateKeyPath)
+	case "external":
+		return sign.NewExternalSigner(conf.SignerSocketPath, signerTimeout)
+	default:
+		return nil, fmt.Errorf("unknown signer backend %q", conf.SignerBackend)
+	}
+}
+
+// openStore opens the database of the configured storage backend.
+func openStore(conf *Config) (storage.ManagedStore, error) {
+	switch conf.Storage {
+	case "", "rocksdb":
+		if err := ensureDir(conf.DBPath); err != nil {
+			return nil, err
+		}
+		return openRocksDBStore(conf.DBPath)
+	case "file":
+		if err := ensureDir(conf.DBPath); err != nil {
+			return nil, err
+		}
+		return bplus.NewBPlusTreeFileStore(filepath.Join(conf.DBPath, "qed.db"))
+	case "memory":
+		return bplus.NewBPlusTreeStore(), nil
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", conf.Storage)
+	}
+}
+
+// newRaftBalloon returns a RaftBalloon whose raft log is kept by the
+// configured storage backend.
+func newRaftBalloon(conf *Config, store storage.ManagedStore, snapshotsCh chan *protocol.Snapshot) (*raftwal.RaftBalloon, error) {
+	var path, logPath string
+	switch conf.Storage {
+	case "", "rocksdb":
+		if err := ensureDir(conf.RaftPath); err != nil {
+			return nil, err
+		}
+		return newRocksDBRaftBalloon(conf, store, snapshotsCh)
+	case "file":
+		if err := ensureDir(conf.RaftPath); err != nil {
+			return nil, err
+		}
+		path, logPath = conf.RaftPath, filepath.Join(conf.RaftPath, "raft.log")
+	}
+
+	raftStore, err := raftfile.New(logPath)
+	if err != nil {
+		return nil, err
+	}
+	rb, err := raftwal.NewRaftBalloonWithStore(path, conf.RaftAddr, conf.NodeID, conf.Hasher, store, raftStore, snapshotsCh)
+	if err != nil {
+		raftStore.Close()
+		return nil, err
+	}
+	return rb, nil
+}
+
+// ensureDir creates the directory if it does not exist.
+func ensureDir(dir string) error {
+	log.Infof("ensuring directory at %s exists", dir)
+	return os.MkdirAll(dir, 0755)
+}
+
+// signingKeys returns the signers of the configuration, the default one
+// first. They can also verify the snapshots they have signed.
+func signingKeys(conf *Config) ([]sign.Signer, error) {
+	signer, err := newSigner(conf)
+	if err != nil {
+		return nil, err
+	}
+	signers := []sign.Signer{signer}
+	for _, path := range conf.SigningKeysPaths {
+		s, err := sign.NewSignerFromFile(path)
+		if err != nil {
+			return nil, err
+		}
+		signers = append(signers, s)
+	}
+	return signers, nil
+}
+
+// mgmtTLS returns the TLS configuration to talk to the management API of
+// other nodes, or nil if it does not use TLS.
+func (s *Server) mgmtTLS() *tls.Config {
+	if !s.conf.EnableMgmtTLS {
+		return nil
+	}
+	return s.nodeTLS
+}
+
+// activeKeyID returns the ID of the signing key activated in the log.
+func (s *Server) activeKeyID() string {
+	if s.raftBalloon == nil {
+		return ""
+	}
+	return s.raftBalloon.ActiveKeyID()
+}
+
+// join asks the node at joinAddr to add this node to the cluster. A TLS
+// configuration makes the request over HTTPS with the certificate of the
+// node.
+func join(joinAddr, raftAddr, nodeID, apiKey string, tlsConfig *tls.Config, metadata map[string]string) error {
 	body := make(map[string]interface{})
 	body["addr"] = raftAddr
 	body["id"] = nodeID
@@ -187,12 +356,28 @@ func join(joinAddr, raftAddr, nodeID string, metadata map[string]string) error {
 		return err
 	}
 
-	resp, err := http.Post(fmt.Sprintf("http://%s/join", joinAddr), "application-type/json", bytes.NewReader(b))
+	scheme, client := "http", http.DefaultClient
+	if tlsConfig != nil {
+		scheme = "https"
+		client = &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+	}
+
+	req, err := http.NewRequest("POST", fmt.Sprintf("%s://%s/join", scheme, joinAddr), bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application-type/json")
+	req.Header.Set("Api-Key", apiKey)
+
+	resp, err := client.Do(req)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
-	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	msg, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("join request rejected [status=%d]: %s", resp.StatusCode, bytes.TrimSpace(msg))
+	}
 
 	return nil
 }
@@ -204,12 +389,17 @@ func (s *Server) Start() error {
 
 	metadata := map[string]string{}
 	metadata["HTTPAddr"] = s.conf.HTTPAddr
+	metadata["MgmtAddr"] = s.conf.MgmtAddr
+	metadata["hasher"] = s.conf.Hasher
+	metadata["format"] = strconv.Itoa(int(s.raftBalloon.Format()))
 
 	err := s.raftBalloon.Open(s.bootstrap, metadata)
 	if err != nil {
 		return err
 	}
 
+	s.apiKeys.Start(apiKeysReloadInterval)
+
 	log.Debugf("	* Starting metrics HTTP server in addr: %s", s.conf.MetricsAddr)
 	s.metricsServer.Start()
 
@@ -235,7 +425,13 @@ func (s *Server) Start() error {
 
 	go func() {
 		log.Debug("	* Starting QED MGMT HTTP server in addr: ", s.conf.MgmtAddr)
-		if err := s.mgmtServer.ListenAndServe(); err != http.ErrServerClosed {
+		var err error
+		if s.conf.EnableMgmtTLS {
+			err = s.mgmtServer.ListenAndServeTLS(s.conf.SSLCertificate, s.conf.SSLCertificateKey)
+		} else {
+			err = s.mgmtServer.ListenAndServe()
+		}
+		if err != http.ErrServerClosed {
 			log.Errorf("Can't start QED MGMT HTTP Server: %s", err)
 		}
 	}()
@@ -245,7 +441,7 @@ func (s *Server) Start() error {
 	if !s.bootstrap {
 		for _, addr := range s.conf.RaftJoinAddr {
 			log.Debug("	* Joining existent cluster QED MGMT HTTP server in addr: ", s.conf.MgmtAddr)
-			if err := join(addr, s.conf.RaftAddr, s.conf.NodeID, metadata); err != nil {
+			if err := join(addr, s.conf.RaftAddr, s.conf.NodeID, s.conf.APIKey, s.mgmtTLS(), metadata); err != nil {
 				log.Fatalf("failed to join node at %s: %s", addr, err.Error())
 			}
 		}
@@ -263,6 +459,8 @@ func (s *Server) Stop() error {
 	s.metrics.Instances.Dec()
 	log.Infof("\nShutting down QED server %s", s.conf.NodeID)
 
+	s.apiKeys.Stop()
+
 	log.Debugf("Metrics enabled: stopping server...")
 	s.metricsServer.Shutdown()
 
@@ -306,7 +504,9 @@ func (s *Server) RegisterMetrics(registry metrics.Registry) {
 	}
 }
 
-func newTLSServer(addr string, mux *http.ServeMux) *http.Server {
+// newTLSServer returns an HTTPS server. If a pool of client CAs is given,
+// it requires client certificates signed by them.
+func newTLSServer(addr string, mux *http.ServeMux, clientCAs *x509.CertPool) *http.Server {
 
 	cfg := &tls.Config{
 		MinVersion: tls.VersionTLS12,
@@ -323,6 +523,10 @@ func newTLSServer(addr string, mux *http.ServeMux) *http.Server {
 			tls.TLS_RSA_WITH_AES_256_CBC_SHA,
 		},
 	}
+	if clientCAs != nil {
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+		cfg.ClientCAs = clientCAs
+	}
 
 	return &http.Server{
 		Addr:         addr,
@@ -0,0 +1,91 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+)
+
+// loadCertPool reads the PEM encoded certificates of a CA bundle.
+func loadCertPool(path string) (*x509.CertPool, error) {
+	pem, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", path)
+	}
+	return pool, nil
+}
+
+// validateTLS checks that the certificates needed by the enabled TLS
+// listeners are configured.
+func validateTLS(conf *Config) error {
+	mutual := conf.EnableClientAuth || conf.EnableMgmtTLS || conf.EnableRaftTLS
+	if conf.EnableClientAuth && !conf.EnableTLS {
+		return errors.New("client authentication needs TLS in the public API")
+	}
+	if mutual && conf.SSLCACertificate == "" {
+		return errors.New("mutual TLS needs a CA certificate")
+	}
+	if (mutual || conf.EnableTLS) && (conf.SSLCertificate == "" || conf.SSLCertificateKey == "") {
+		return errors.New("TLS needs a certificate and its key")
+	}
+	return nil
+}
+
+// mutualTLSConfig returns the TLS configuration of the connections between
+// nodes, which is used both to accept and to dial them. Nodes present their
+// certificate and require one signed by the CA from their peers.
+func mutualTLSConfig(conf *Config) (*tls.Config, error) {
+
+	cert, err := tls.LoadX509KeyPair(conf.SSLCertificate, conf.SSLCertificateKey)
+	if err != nil {
+		return nil, err
+	}
+	pool, err := loadCertPool(conf.SSLCACertificate)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+		RootCAs:      pool,
+	}, nil
+}
+
+// forwardTLSConfig returns the TLS configuration that followers use to
+// forward writes to the public API of the leader, or nil if the API does
+// not use TLS. With a CA certificate, nodes also present their own
+// certificate, as the leader may require client authentication.
+func forwardTLSConfig(conf *Config) (*tls.Config, error) {
+	if !conf.EnableTLS {
+		return nil, nil
+	}
+	if conf.SSLCACertificate == "" {
+		return &tls.Config{MinVersion: tls.VersionTLS12}, nil
+	}
+	return mutualTLSConfig(conf)
+}
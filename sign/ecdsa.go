@@ -0,0 +1,120 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package sign
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"math/big"
+)
+
+// ECDSASigner signs the SHA-256 digest of the messages with an ECDSA key
+// over the NIST P-256 curve. Signatures are ASN.1 DER encoded, and the
+// public key is the DER encoding of its PKIX structure.
+type ECDSASigner struct {
+	privateKey *ecdsa.PrivateKey
+	publicKey  *ecdsa.PublicKey
+	encoded    []byte
+}
+
+type ecdsaSignature struct {
+	R, S *big.Int
+}
+
+func NewECDSASigner() Signer {
+
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+
+	signer, err := newECDSASigner(privateKey)
+	if err != nil {
+		panic(err)
+	}
+	return signer
+
+}
+
+func newECDSASigner(privateKey *ecdsa.PrivateKey) (*ECDSASigner, error) {
+	signer, err := newECDSAVerifier(&privateKey.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	signer.privateKey = privateKey
+	return signer, nil
+}
+
+func newECDSAVerifier(publicKey *ecdsa.PublicKey) (*ECDSASigner, error) {
+	if publicKey.Curve != elliptic.P256() {
+		return nil, errors.New("the ecdsa key is not a P-256 key")
+	}
+	encoded, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	return &ECDSASigner{publicKey: publicKey, encoded: encoded}, nil
+}
+
+// NewECDSAVerifier returns a signer that can only verify, built from the
+// PKIX encoding of an ECDSA P-256 public key.
+func NewECDSAVerifier(publicKey []byte) (Signer, error) {
+	pk, err := x509.ParsePKIXPublicKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	ecdsaKey, ok := pk.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, errors.New("the public key is not an ecdsa key")
+	}
+	return newECDSAVerifier(ecdsaKey)
+}
+
+func (s *ECDSASigner) Sign(message []byte) ([]byte, error) {
+	if s.privateKey == nil {
+		return nil, ErrNoPrivateKey
+	}
+	digest := sha256.Sum256(message)
+	r, ss, err := ecdsa.Sign(rand.Reader, s.privateKey, digest[:])
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(ecdsaSignature{r, ss})
+}
+
+func (s *ECDSASigner) Verify(message, sig []byte) (bool, error) {
+	var signature ecdsaSignature
+	rest, err := asn1.Unmarshal(sig, &signature)
+	if err != nil || len(rest) > 0 || signature.R == nil || signature.S == nil {
+		return false, nil
+	}
+	digest := sha256.Sum256(message)
+	return ecdsa.Verify(s.publicKey, digest[:], signature.R, signature.S), nil
+}
+
+func (s *ECDSASigner) PublicKey() []byte {
+	return s.encoded
+}
+
+func (s *ECDSASigner) Algorithm() string {
+	return ECDSAP256
+}
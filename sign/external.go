@@ -0,0 +1,176 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package sign
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"time"
+)
+
+// Methods of the requests to a signing process.
+const (
+	ExternalPublicKey = "PublicKey"
+	ExternalSign      = "Sign"
+)
+
+// ExternalRequest is the request that an ExternalSigner sends to the
+// signing process. Every connection carries a single JSON encoded request
+// followed by its response.
+type ExternalRequest struct {
+	Method  string
+	Message []byte `json:",omitempty"`
+}
+
+// ExternalResponse is the response of the signing process. PublicKey
+// requests are answered with the algorithm and the public key, in the
+// encoding that the signers of that algorithm return, and Sign requests
+// with the signature. Failed requests only carry the error.
+type ExternalResponse struct {
+	Algorithm string `json:",omitempty"`
+	PublicKey []byte `json:",omitempty"`
+	Signature []byte `json:",omitempty"`
+	Error     string `json:",omitempty"`
+}
+
+// ExternalSigner delegates the signatures to a signing process listening
+// on a Unix socket, so that the private key never sits in the QED
+// process. Verifications are done locally.
+type ExternalSigner struct {
+	socketPath string
+	timeout    time.Duration
+	verifier   Signer
+}
+
+// NewExternalSigner asks the signing process listening on the given
+// socket for its public key and checks that it signs with it.
+func NewExternalSigner(socketPath string, timeout time.Duration) (Signer, error) {
+
+	signer := &ExternalSigner{
+		socketPath: socketPath,
+		timeout:    timeout,
+	}
+
+	resp, err := signer.call(&ExternalRequest{Method: ExternalPublicKey})
+	if err != nil {
+		return nil, err
+	}
+	signer.verifier, err = NewVerifier(resp.Algorithm, resp.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := signer.Sign([]byte("test message")); err != nil {
+		return nil, err
+	}
+
+	return signer, nil
+}
+
+func (s *ExternalSigner) Sign(message []byte) ([]byte, error) {
+	resp, err := s.call(&ExternalRequest{Method: ExternalSign, Message: message})
+	if err != nil {
+		return nil, err
+	}
+	// the signing process could have changed its key, and the snapshots
+	// would be published with a wrong key ID
+	ok, err := s.verifier.Verify(message, resp.Signature)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errors.New("external signer: the signature does not verify with its public key")
+	}
+	return resp.Signature, nil
+}
+
+func (s *ExternalSigner) Verify(message, sig []byte) (bool, error) {
+	return s.verifier.Verify(message, sig)
+}
+
+func (s *ExternalSigner) PublicKey() []byte {
+	return s.verifier.PublicKey()
+}
+
+func (s *ExternalSigner) Algorithm() string {
+	return s.verifier.Algorithm()
+}
+
+func (s *ExternalSigner) call(req *ExternalRequest) (*ExternalResponse, error) {
+
+	conn, err := net.DialTimeout("unix", s.socketPath, s.timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if err := conn.SetDeadline(time.Now().Add(s.timeout)); err != nil {
+		return nil, err
+	}
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return nil, err
+	}
+
+	var resp ExternalResponse
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return nil, err
+	}
+	if resp.Error != "" {
+		return nil, errors.New("external signer: " + resp.Error)
+	}
+	return &resp, nil
+}
+
+// ServeExternal answers the requests of external signers with the given
+// signer until the listener is closed. It is a reference implementation
+// of the signing process.
+func ServeExternal(l net.Listener, signer Signer) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go serveExternalConn(conn, signer)
+	}
+}
+
+func serveExternalConn(conn net.Conn, signer Signer) {
+	defer conn.Close()
+
+	var req ExternalRequest
+	if err := json.NewDecoder(conn).Decode(&req); err != nil {
+		return
+	}
+
+	var resp ExternalResponse
+	switch req.Method {
+	case ExternalPublicKey:
+		resp.Algorithm = signer.Algorithm()
+		resp.PublicKey = signer.PublicKey()
+	case ExternalSign:
+		sig, err := signer.Sign(req.Message)
+		if err != nil {
+			resp.Error = err.Error()
+		}
+		resp.Signature = sig
+	default:
+		resp.Error = "unknown method " + req.Method
+	}
+
+	_ = json.NewEncoder(conn).Encode(&resp)
+}
@@ -0,0 +1,79 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package sign
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+func startSigningProcess(t *testing.T, signer Signer) (string, func()) {
+	dir, err := ioutil.TempDir("", "qed-signer")
+	assert.NoError(t, err)
+
+	socketPath := filepath.Join(dir, "signer.sock")
+	l, err := net.Listen("unix", socketPath)
+	assert.NoError(t, err)
+	go ServeExternal(l, signer)
+
+	return socketPath, func() {
+		l.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestExternalSigner(t *testing.T) {
+
+	for _, key := range []Signer{NewEd25519Signer(), NewECDSASigner()} {
+		socketPath, stop := startSigningProcess(t, key)
+		defer stop()
+
+		signer, err := NewExternalSigner(socketPath, time.Second)
+		assert.NoError(t, err)
+		assert.Equal(t, key.Algorithm(), signer.Algorithm(), "Wrong algorithm")
+		assert.Equal(t, key.PublicKey(), signer.PublicKey(), "Wrong public key")
+
+		testSign(t, signer)
+
+		message := []byte("send reinforcements, we're going to advance")
+		sig, err := signer.Sign(message)
+		assert.NoError(t, err)
+		result, _ := key.Verify(message, sig)
+		assert.True(t, result, "The signing process should sign with its key")
+	}
+}
+
+func TestExternalSignerErrors(t *testing.T) {
+
+	_, err := NewExternalSigner(filepath.Join(os.TempDir(), "qed-no-signer.sock"), time.Second)
+	assert.Error(t, err, "There is no signing process")
+
+	// the signing process has no private key
+	verifier, err := NewEd25519Verifier(NewEd25519Signer().PublicKey())
+	assert.NoError(t, err)
+	socketPath, stop := startSigningProcess(t, verifier)
+	defer stop()
+
+	_, err = NewExternalSigner(socketPath, time.Second)
+	assert.Error(t, err, "The signing process cannot sign")
+}
@@ -0,0 +1,123 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package sign
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrUnknownKey is returned when a keyring is asked for a key it does
+// not hold.
+var ErrUnknownKey = errors.New("unknown key")
+
+// Keyring holds several signing keys indexed by their key ID. It signs
+// with the key that the active function names, so that every server of
+// a cluster switches keys at the same point of the replicated log. While
+// the function names no key, or a key that the keyring does not hold,
+// the keyring signs with its default key.
+//
+// A keyring is itself a Signer, so it can be used wherever a single key
+// was used before.
+type Keyring struct {
+	mu         sync.RWMutex
+	keys       map[string]Signer
+	defaultKey Signer
+	active     func() string
+}
+
+// NewKeyring returns a keyring holding the given signers. The first one
+// is the default key. The active function may be nil.
+func NewKeyring(active func() string, defaultKey Signer, others ...Signer) *Keyring {
+	k := &Keyring{
+		keys:       make(map[string]Signer),
+		defaultKey: defaultKey,
+		active:     active,
+	}
+	for _, s := range append([]Signer{defaultKey}, others...) {
+		k.keys[KeyID(s.PublicKey())] = s
+	}
+	return k
+}
+
+// Add adds a key to the keyring and returns its ID.
+func (k *Keyring) Add(s Signer) string {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	id := KeyID(s.PublicKey())
+	k.keys[id] = s
+	return id
+}
+
+// Get returns the key with the given ID.
+func (k *Keyring) Get(id string) (Signer, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	s, ok := k.keys[id]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	return s, nil
+}
+
+// Default returns the default key of the keyring.
+func (k *Keyring) Default() Signer {
+	return k.defaultKey
+}
+
+// Active returns the key that signs the messages.
+func (k *Keyring) Active() Signer {
+	if k.active == nil {
+		return k.defaultKey
+	}
+	id := k.active()
+	if id == "" {
+		return k.defaultKey
+	}
+	s, err := k.Get(id)
+	if err != nil {
+		return k.defaultKey
+	}
+	return s
+}
+
+func (k *Keyring) Sign(message []byte) ([]byte, error) {
+	return k.Active().Sign(message)
+}
+
+func (k *Keyring) Verify(message, sig []byte) (bool, error) {
+	return k.Active().Verify(message, sig)
+}
+
+func (k *Keyring) PublicKey() []byte {
+	return k.Active().PublicKey()
+}
+
+func (k *Keyring) Algorithm() string {
+	return k.Active().Algorithm()
+}
+
+// ActiveKey returns the key that signs for the given signer: the active
+// key of a keyring or the signer itself. Callers that sign several
+// messages together and report the key ID use it to stick to a single
+// key during a rotation.
+func ActiveKey(s Signer) Signer {
+	if k, ok := s.(*Keyring); ok {
+		return k.Active()
+	}
+	return s
+}
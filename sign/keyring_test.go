@@ -0,0 +1,75 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package sign
+
+import (
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+func TestKeyringActive(t *testing.T) {
+
+	oldKey, newKey := NewEd25519Signer(), NewEd25519Signer()
+	oldID, newID := KeyID(oldKey.PublicKey()), KeyID(newKey.PublicKey())
+
+	var active string
+	keyring := NewKeyring(func() string { return active }, oldKey, newKey)
+
+	testCases := []struct {
+		active      string
+		expectedKey Signer
+	}{
+		{"", oldKey},
+		{newID, newKey},
+		{oldID, oldKey},
+		{"unknown", oldKey},
+	}
+
+	message := []byte("send reinforcements, we're going to advance")
+
+	for i, c := range testCases {
+		active = c.active
+		assert.Equalf(t, c.expectedKey, keyring.Active(), "Wrong active key in test case %d", i)
+		assert.Equalf(t, c.expectedKey, ActiveKey(keyring), "Wrong active key in test case %d", i)
+		assert.Equalf(t, c.expectedKey.PublicKey(), keyring.PublicKey(), "Wrong public key in test case %d", i)
+
+		sig, err := keyring.Sign(message)
+		assert.NoError(t, err)
+		ok, _ := c.expectedKey.Verify(message, sig)
+		assert.Truef(t, ok, "The active key should sign in test case %d", i)
+	}
+}
+
+func TestKeyringGet(t *testing.T) {
+
+	defaultKey := NewEd25519Signer()
+	keyring := NewKeyring(nil, defaultKey)
+	assert.Equal(t, defaultKey, keyring.Default())
+	assert.Equal(t, defaultKey, keyring.Active(), "Keyrings without active function should sign with the default key")
+
+	other := NewEd25519Signer()
+	_, err := keyring.Get(KeyID(other.PublicKey()))
+	assert.Equal(t, ErrUnknownKey, err)
+
+	id := keyring.Add(other)
+	s, err := keyring.Get(id)
+	assert.NoError(t, err)
+	assert.Equal(t, other, s)
+
+	assert.Equal(t, other, ActiveKey(other), "Single keys should be their own active key")
+}
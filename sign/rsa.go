@@ -0,0 +1,113 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package sign
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"errors"
+)
+
+// RSAMinBits is the minimum size of the RSA keys that can sign snapshots.
+const RSAMinBits = 2048
+
+// RSAPSSSigner signs the SHA-256 digest of the messages with RSASSA-PSS
+// (RFC 8017), using a salt as long as the digest. The public key is the
+// DER encoding of its PKIX structure.
+type RSAPSSSigner struct {
+	privateKey *rsa.PrivateKey
+	publicKey  *rsa.PublicKey
+	encoded    []byte
+}
+
+var pssOptions = &rsa.PSSOptions{
+	SaltLength: rsa.PSSSaltLengthEqualsHash,
+	Hash:       crypto.SHA256,
+}
+
+func NewRSAPSSSigner(bits int) Signer {
+
+	privateKey, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		panic(err)
+	}
+
+	signer, err := newRSAPSSSigner(privateKey)
+	if err != nil {
+		panic(err)
+	}
+	return signer
+
+}
+
+func newRSAPSSSigner(privateKey *rsa.PrivateKey) (*RSAPSSSigner, error) {
+	signer, err := newRSAPSSVerifier(&privateKey.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	signer.privateKey = privateKey
+	return signer, nil
+}
+
+func newRSAPSSVerifier(publicKey *rsa.PublicKey) (*RSAPSSSigner, error) {
+	if publicKey.N.BitLen() < RSAMinBits {
+		return nil, errors.New("the rsa key is too short")
+	}
+	encoded, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	return &RSAPSSSigner{publicKey: publicKey, encoded: encoded}, nil
+}
+
+// NewRSAPSSVerifier returns a signer that can only verify, built from the
+// PKIX encoding of an RSA public key.
+func NewRSAPSSVerifier(publicKey []byte) (Signer, error) {
+	pk, err := x509.ParsePKIXPublicKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := pk.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("the public key is not an rsa key")
+	}
+	return newRSAPSSVerifier(rsaKey)
+}
+
+func (s *RSAPSSSigner) Sign(message []byte) ([]byte, error) {
+	if s.privateKey == nil {
+		return nil, ErrNoPrivateKey
+	}
+	digest := sha256.Sum256(message)
+	return rsa.SignPSS(rand.Reader, s.privateKey, crypto.SHA256, digest[:], pssOptions)
+}
+
+func (s *RSAPSSSigner) Verify(message, sig []byte) (bool, error) {
+	digest := sha256.Sum256(message)
+	return rsa.VerifyPSS(s.publicKey, crypto.SHA256, digest[:], sig, pssOptions) == nil, nil
+}
+
+func (s *RSAPSSSigner) PublicKey() []byte {
+	return s.encoded
+}
+
+func (s *RSAPSSSigner) Algorithm() string {
+	return RSAPSS
+}
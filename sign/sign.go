@@ -17,17 +17,143 @@
 package sign
 
 import (
+	"crypto/ecdsa"
 	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
 	"errors"
+	"fmt"
 	"io/ioutil"
 
 	"golang.org/x/crypto/ed25519"
 	"golang.org/x/crypto/ssh"
 )
 
+// ErrNoPrivateKey is returned when a signer built from a public key is
+// asked to sign.
+var ErrNoPrivateKey = errors.New("the signer has no private key")
+
+// Identifiers of the signature algorithms. They are carried with every
+// signature, so verifiers know how to check it.
+const (
+	Ed25519   = "ed25519"
+	ECDSAP256 = "ecdsa-p256-sha256"
+	RSAPSS    = "rsa-pss-sha256"
+)
+
 type Signer interface {
 	Sign(message []byte) ([]byte, error)
 	Verify(message, sig []byte) (bool, error)
+	PublicKey() []byte
+	Algorithm() string
+}
+
+// KeyID returns a short identifier of a public key: the hex encoding of the
+// first 8 bytes of its SHA-256 digest.
+func KeyID(publicKey []byte) string {
+	digest := sha256.Sum256(publicKey)
+	return hex.EncodeToString(digest[:8])
+}
+
+// NewSignerFromFile returns a signer for the private key in the given
+// file. The algorithm follows the type of the key: Ed25519 keys must be
+// in the OpenSSH format, ECDSA P-256 keys in the SEC 1, PKCS#8 or OpenSSH
+// formats and RSA keys, which sign with RSA-PSS, in the PKCS#1, PKCS#8 or
+// OpenSSH formats.
+func NewSignerFromFile(privateKeyPath string) (Signer, error) {
+
+	privateKeyBytes, err := ioutil.ReadFile(privateKeyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	pk, err := ssh.ParseRawPrivateKey(privateKeyBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	var signer Signer
+	switch key := pk.(type) {
+	case *ed25519.PrivateKey:
+		signer = &Ed25519Signer{*key, key.Public().(ed25519.PublicKey)}
+	case *ecdsa.PrivateKey:
+		signer, err = newECDSASigner(key)
+	case *rsa.PrivateKey:
+		signer, err = newRSAPSSSigner(key)
+	default:
+		return nil, fmt.Errorf("unsupported private key %T", pk)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	message := []byte("test message")
+	sig, _ := signer.Sign(message)
+	result, _ := signer.Verify(message, sig)
+	if result != true {
+		return nil, errors.New("key is unusable")
+	}
+
+	return signer, nil
+}
+
+// NewVerifier returns a signer that can only verify, built from a public
+// key in the encoding that the signers of the given algorithm return.
+func NewVerifier(algorithm string, publicKey []byte) (Signer, error) {
+	switch algorithm {
+	case Ed25519:
+		return NewEd25519Verifier(publicKey)
+	case ECDSAP256:
+		return NewECDSAVerifier(publicKey)
+	case RSAPSS:
+		return NewRSAPSSVerifier(publicKey)
+	default:
+		return nil, fmt.Errorf("unsupported signature algorithm %q", algorithm)
+	}
+}
+
+// NewVerifierFromFile returns a signer that can only verify, built from
+// the public key in the given file. The file holds either a key in the
+// OpenSSH authorized_keys format, like the .pub files of ssh-keygen, or a
+// PEM encoded PKIX public key, like the ones of openssl.
+func NewVerifierFromFile(publicKeyPath string) (Signer, error) {
+
+	publicKeyBytes, err := ioutil.ReadFile(publicKeyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var pk interface{}
+	if block, _ := pem.Decode(publicKeyBytes); block != nil && block.Type == "PUBLIC KEY" {
+		pk, err = x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		sshKey, _, _, _, err := ssh.ParseAuthorizedKey(publicKeyBytes)
+		if err != nil {
+			return nil, err
+		}
+		cpk, ok := sshKey.(ssh.CryptoPublicKey)
+		if !ok {
+			return nil, errors.New("unsupported public key")
+		}
+		pk = cpk.CryptoPublicKey()
+	}
+
+	switch key := pk.(type) {
+	case ed25519.PublicKey:
+		return NewEd25519Verifier(key)
+	case *ecdsa.PublicKey:
+		return newECDSAVerifier(key)
+	case *rsa.PublicKey:
+		return newRSAPSSVerifier(key)
+	default:
+		return nil, fmt.Errorf("unsupported public key %T", pk)
+	}
 }
 
 type Ed25519Signer struct {
@@ -78,10 +204,57 @@ func NewEd25519SignerFromFile(privateKeyPath string) (Signer, error) {
 
 }
 
+// NewEd25519VerifierFromFile returns a signer that can only verify, built
+// from a public key in the OpenSSH authorized_keys format, like the .pub
+// file that ssh-keygen writes next to the private key.
+func NewEd25519VerifierFromFile(publicKeyPath string) (Signer, error) {
+
+	publicKeyBytes, err := ioutil.ReadFile(publicKeyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	pk, _, _, _, err := ssh.ParseAuthorizedKey(publicKeyBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	cpk, ok := pk.(ssh.CryptoPublicKey)
+	if !ok {
+		return nil, errors.New("unsupported public key")
+	}
+	publicKey, ok := cpk.CryptoPublicKey().(ed25519.PublicKey)
+	if !ok {
+		return nil, errors.New("the public key is not an ed25519 key")
+	}
+
+	return &Ed25519Signer{publicKey: publicKey}, nil
+}
+
+// NewEd25519Verifier returns a signer that can only verify, built from a
+// raw ed25519 public key.
+func NewEd25519Verifier(publicKey []byte) (Signer, error) {
+	if len(publicKey) != ed25519.PublicKeySize {
+		return nil, errors.New("the public key is not an ed25519 key")
+	}
+	return &Ed25519Signer{publicKey: ed25519.PublicKey(publicKey)}, nil
+}
+
 func (s *Ed25519Signer) Sign(message []byte) ([]byte, error) {
+	if s.privateKey == nil {
+		return nil, ErrNoPrivateKey
+	}
 	return ed25519.Sign(s.privateKey, message), nil
 }
 
 func (s *Ed25519Signer) Verify(message, sig []byte) (bool, error) {
 	return ed25519.Verify(s.publicKey, message, sig), nil
 }
+
+func (s *Ed25519Signer) PublicKey() []byte {
+	return s.publicKey
+}
+
+func (s *Ed25519Signer) Algorithm() string {
+	return Ed25519
+}
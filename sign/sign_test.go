@@ -17,10 +17,19 @@
 package sign
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
 	"fmt"
+	"io/ioutil"
+	"os"
 	"testing"
 
 	assert "github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
 )
 
 func testSign(t *testing.T, signer Signer) {
@@ -33,7 +42,124 @@ func testSign(t *testing.T, signer Signer) {
 	assert.True(t, result, "Must be verified")
 
 }
-func TestEdSign(t *testing.T) { testSign(t, NewEd25519Signer()) }
+func TestEdSign(t *testing.T)     { testSign(t, NewEd25519Signer()) }
+func TestECDSASign(t *testing.T)  { testSign(t, NewECDSASigner()) }
+func TestRSAPSSSign(t *testing.T) { testSign(t, NewRSAPSSSigner(RSAMinBits)) }
+
+func TestNewVerifier(t *testing.T) {
+
+	message := []byte("send reinforcements, we're going to advance")
+
+	for _, signer := range []Signer{NewEd25519Signer(), NewECDSASigner(), NewRSAPSSSigner(RSAMinBits)} {
+		verifier, err := NewVerifier(signer.Algorithm(), signer.PublicKey())
+		assert.NoError(t, err)
+		assert.Equal(t, signer.Algorithm(), verifier.Algorithm(), "Wrong algorithm")
+		assert.Equal(t, signer.PublicKey(), verifier.PublicKey(), "Wrong public key")
+
+		sig, _ := signer.Sign(message)
+		result, err := verifier.Verify(message, sig)
+		assert.NoError(t, err)
+		assert.Truef(t, result, "%s signatures must be verified", signer.Algorithm())
+
+		result, _ = verifier.Verify([]byte("send three and fourpence, we're going to a dance"), sig)
+		assert.Falsef(t, result, "%s signatures must not verify other messages", signer.Algorithm())
+
+		_, err = verifier.Sign(message)
+		assert.Equal(t, ErrNoPrivateKey, err, "A verifier must not sign")
+	}
+
+	_, err := NewVerifier("dsa", []byte{0x00})
+	assert.Error(t, err, "Unknown algorithms must fail")
+}
+
+func TestSignerFromFile(t *testing.T) {
+
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	ecdsaDER, err := x509.MarshalECPrivateKey(ecdsaKey)
+	assert.NoError(t, err)
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, RSAMinBits)
+	assert.NoError(t, err)
+
+	p384Key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	assert.NoError(t, err)
+	p384DER, err := x509.MarshalECPrivateKey(p384Key)
+	assert.NoError(t, err)
+
+	testCases := []struct {
+		block             *pem.Block
+		publicKey         interface{}
+		expectedAlgorithm string
+	}{
+		{&pem.Block{Type: "EC PRIVATE KEY", Bytes: ecdsaDER}, &ecdsaKey.PublicKey, ECDSAP256},
+		{&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}, &rsaKey.PublicKey, RSAPSS},
+		{&pem.Block{Type: "EC PRIVATE KEY", Bytes: p384DER}, &p384Key.PublicKey, ""},
+	}
+
+	for i, c := range testCases {
+		privateKeyPath := writeTempFile(t, "signing_key", pem.EncodeToMemory(c.block))
+		defer os.Remove(privateKeyPath)
+
+		signer, err := NewSignerFromFile(privateKeyPath)
+		if c.expectedAlgorithm == "" {
+			assert.Errorf(t, err, "The key should not be supported in test case %d", i)
+			continue
+		}
+		assert.NoError(t, err)
+		assert.Equalf(t, c.expectedAlgorithm, signer.Algorithm(), "Wrong algorithm in test case %d", i)
+
+		der, err := x509.MarshalPKIXPublicKey(c.publicKey)
+		assert.NoError(t, err)
+		publicKeyPath := writeTempFile(t, "signing_key.pem", pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+		defer os.Remove(publicKeyPath)
+
+		verifier, err := NewVerifierFromFile(publicKeyPath)
+		assert.NoError(t, err)
+		assert.Equalf(t, KeyID(signer.PublicKey()), KeyID(verifier.PublicKey()), "Both keys should have the same ID in test case %d", i)
+
+		message := []byte("send reinforcements, we're going to advance")
+		sig, _ := signer.Sign(message)
+		result, _ := verifier.Verify(message, sig)
+		assert.Truef(t, result, "Must be verified in test case %d", i)
+	}
+}
+
+func writeTempFile(t *testing.T, pattern string, data []byte) string {
+	file, err := ioutil.TempFile("", pattern)
+	assert.NoError(t, err)
+	defer file.Close()
+	_, err = file.Write(data)
+	assert.NoError(t, err)
+	return file.Name()
+}
+
+func TestEdVerifierFromFile(t *testing.T) {
+
+	signer := NewEd25519Signer()
+	publicKey, err := ssh.NewPublicKey(signer.(*Ed25519Signer).publicKey)
+	assert.NoError(t, err)
+
+	file, err := ioutil.TempFile("", "id_ed25519.pub")
+	assert.NoError(t, err)
+	defer os.Remove(file.Name())
+	_, err = file.Write(ssh.MarshalAuthorizedKey(publicKey))
+	assert.NoError(t, err)
+	file.Close()
+
+	verifier, err := NewEd25519VerifierFromFile(file.Name())
+	assert.NoError(t, err)
+	assert.Equal(t, KeyID(signer.PublicKey()), KeyID(verifier.PublicKey()), "Both keys should have the same ID")
+
+	message := []byte("send reinforcements, we're going to advance")
+	sig, _ := signer.Sign(message)
+	result, err := verifier.Verify(message, sig)
+	assert.NoError(t, err)
+	assert.True(t, result, "Must be verified")
+
+	_, err = verifier.Sign(message)
+	assert.Equal(t, ErrNoPrivateKey, err, "A verifier must not sign")
+}
 
 func syncBenchmark(b *testing.B, signer Signer, iterations int) {
 
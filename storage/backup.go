@@ -0,0 +1,105 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package storage
+
+import (
+	"encoding/binary"
+	"io"
+
+	"github.com/bbva/qed/storage/pb"
+)
+
+// EntryFunc receives the entries of a backup one at a time.
+type EntryFunc func(table Table, key, value []byte) error
+
+// NewBackupWriter returns an EntryFunc that writes the entries to w in the
+// format read by ManagedStore.Load: the size of every entry as a little
+// endian uint64 followed by the entry encoded as a protobuf.
+func NewBackupWriter(w io.Writer) EntryFunc {
+	return func(table Table, key, value []byte) error {
+		entry := &pb.KVPair{
+			Table: pb.Table(table),
+			Key:   key,
+			Value: value,
+		}
+		if err := binary.Write(w, binary.LittleEndian, uint64(entry.Size())); err != nil {
+			return err
+		}
+		buf, err := entry.Marshal()
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(buf)
+		return err
+	}
+}
+
+// ForEach calls f with every entry of the table in key order, stopping at
+// the first error.
+func ForEach(store Store, table Table, f func(kv *KVPair) error) error {
+	reader := store.GetAll(table)
+	defer reader.Close()
+	for {
+		entries := make([]*KVPair, 100)
+		n, err := reader.Read(entries)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return nil
+		}
+		for _, entry := range entries[:n] {
+			if err := f(entry); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// ReadBackup calls f with every entry written by a backup writer, until the
+// reader is exhausted. A backup cut in the middle of an entry returns
+// io.ErrUnexpectedEOF.
+func ReadBackup(r io.Reader, f EntryFunc) error {
+	var buf []byte
+	for {
+		var size uint64
+		err := binary.Read(r, binary.LittleEndian, &size)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if uint64(cap(buf)) < size {
+			buf = make([]byte, size)
+		}
+		if _, err := io.ReadFull(r, buf[:size]); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		entry := &pb.KVPair{}
+		if err := entry.Unmarshal(buf[:size]); err != nil {
+			return err
+		}
+		if err := f(Table(entry.Table), entry.Key, entry.Value); err != nil {
+			return err
+		}
+	}
+}
@@ -17,20 +17,117 @@
 package bplus
 
 import (
+	"bufio"
 	"bytes"
-	"github.com/bbva/qed/metrics"
+	"errors"
 	"io"
+	"os"
+	"sync"
 
+	"github.com/bbva/qed/log"
+	"github.com/bbva/qed/metrics"
 	"github.com/bbva/qed/storage"
 	"github.com/google/btree"
 )
 
+// tables lists every table, so that backups can recover the table of an
+// entry from the prefix of its key.
+var tables = []storage.Table{
+	storage.DefaultTable,
+	storage.HyperTable,
+	storage.HistoryTable,
+	storage.FSMStateTable,
+	storage.HyperVersionsTable,
+	storage.TimestampsTable,
+	storage.IdempotencyKeysTable,
+	storage.PayloadsTable,
+	storage.IndexTable,
+	storage.IdempotencyExpiryTable,
+}
+
+// deletionsTable marks the deletions in the file of a persistent store,
+// as the format of the backups only holds entries. The key of a deletion
+// is the prefixed key of the removed entry.
+const deletionsTable = storage.Table(0xff)
+
+// ErrSnapshotNotFound is returned when backing up a snapshot that was
+// never taken or has already been backed up.
+var ErrSnapshotNotFound = errors.New("snapshot not found")
+
+// BPlusTreeStore keeps every table in a single in-memory B+tree, whose keys
+// are prefixed with the table. Optionally, every mutation is appended to a
+// file, which is replayed when the store is opened again.
 type BPlusTreeStore struct {
 	db *btree.BTree
+
+	// file logs the mutations, if the store is persistent
+	file *os.File
+
+	// snapshots are lazy copies of the tree, taken by Snapshot
+	// and released by Backup
+	snapshots      map[uint64]*btree.BTree
+	lastSnapshotID uint64
+
+	sync.RWMutex
 }
 
 func NewBPlusTreeStore() *BPlusTreeStore {
-	return &BPlusTreeStore{btree.New(2)}
+	return &BPlusTreeStore{
+		db:        btree.New(2),
+		snapshots: make(map[uint64]*btree.BTree),
+	}
+}
+
+// NewBPlusTreeFileStore returns a store persisted in the file at the given
+// path, created if it does not exist. The file holds the mutations in the
+// format of the backups, and it is compacted every time it is opened. A
+// mutation cut by a crash at the end of the file is discarded.
+//
+// Mutations are not synced to disk until the store is closed, so a crash
+// can lose the last ones, as raft replays them anyway.
+func NewBPlusTreeFileStore(path string) (*BPlusTreeStore, error) {
+	s := NewBPlusTreeStore()
+
+	file, err := os.Open(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		err = storage.ReadBackup(bufio.NewReader(file), s.replay)
+		file.Close()
+		if err == io.ErrUnexpectedEOF {
+			log.Infof("Discarding the last mutation of %s, which is incomplete", path)
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	// the compacted file replaces the old one at once
+	tmp := path + ".tmp"
+	file, err = os.Create(tmp)
+	if err != nil {
+		return nil, err
+	}
+	w := bufio.NewWriter(file)
+	if err := s.writeTo(s.db, w); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if err := w.Flush(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	s.file = file
+	return s, nil
 }
 
 type KVItem struct {
@@ -42,14 +139,57 @@ func (p KVItem) Less(b btree.Item) bool {
 }
 
 func (s *BPlusTreeStore) Mutate(mutations []*storage.Mutation) error {
+	s.Lock()
+	defer s.Unlock()
+	if s.file != nil {
+		var buf bytes.Buffer
+		write := storage.NewBackupWriter(&buf)
+		for _, m := range mutations {
+			var err error
+			if m.Delete {
+				err = write(deletionsTable, prefixed(m.Table, m.Key), nil)
+			} else {
+				err = write(m.Table, m.Key, m.Value)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		if _, err := s.file.Write(buf.Bytes()); err != nil {
+			return err
+		}
+	}
 	for _, m := range mutations {
-		key := append([]byte{m.Table.Prefix()}, m.Key...)
-		s.db.ReplaceOrInsert(KVItem{key, m.Value})
+		if m.Delete {
+			s.db.Delete(KVItem{prefixed(m.Table, m.Key), nil})
+			continue
+		}
+		s.put(m.Table, m.Key, m.Value)
 	}
 	return nil
 }
 
-func (s BPlusTreeStore) GetRange(table storage.Table, start, end []byte) (storage.KVRange, error) {
+func (s *BPlusTreeStore) put(table storage.Table, key, value []byte) error {
+	s.db.ReplaceOrInsert(KVItem{prefixed(table, key), value})
+	return nil
+}
+
+// replay applies an entry of the file of a persistent store.
+func (s *BPlusTreeStore) replay(table storage.Table, key, value []byte) error {
+	if table == deletionsTable {
+		s.db.Delete(KVItem{key, nil})
+		return nil
+	}
+	return s.put(table, key, value)
+}
+
+func prefixed(table storage.Table, key []byte) []byte {
+	return append([]byte{table.Prefix()}, key...)
+}
+
+func (s *BPlusTreeStore) GetRange(table storage.Table, start, end []byte) (storage.KVRange, error) {
+	s.RLock()
+	defer s.RUnlock()
 	result := make(storage.KVRange, 0)
 	startKey := append([]byte{table.Prefix()}, start...)
 	endKey := append([]byte{table.Prefix()}, end...)
@@ -64,7 +204,9 @@ func (s BPlusTreeStore) GetRange(table storage.Table, start, end []byte) (storag
 	return result, nil
 }
 
-func (s BPlusTreeStore) Get(table storage.Table, key []byte) (*storage.KVPair, error) {
+func (s *BPlusTreeStore) Get(table storage.Table, key []byte) (*storage.KVPair, error) {
+	s.RLock()
+	defer s.RUnlock()
 	result := new(storage.KVPair)
 	result.Key = key
 	k := append([]byte{table.Prefix()}, key...)
@@ -77,10 +219,15 @@ func (s BPlusTreeStore) Get(table storage.Table, key []byte) (*storage.KVPair, e
 	}
 }
 
-func (s BPlusTreeStore) GetLast(table storage.Table) (*storage.KVPair, error) {
+func (s *BPlusTreeStore) GetLast(table storage.Table) (*storage.KVPair, error) {
+	s.RLock()
+	defer s.RUnlock()
 	result := new(storage.KVPair)
-	s.db.DescendGreaterThan(KVItem{[]byte{table.Prefix()}, nil}, func(i btree.Item) bool {
+	s.db.DescendLessOrEqual(KVItem{[]byte{table.Prefix() + 1}, nil}, func(i btree.Item) bool {
 		item := i.(KVItem)
+		if item.Key[0] != table.Prefix() {
+			return item.Key[0] > table.Prefix()
+		}
 		result.Key = item.Key[1:]
 		result.Value = item.Value
 		return false
@@ -91,8 +238,29 @@ func (s BPlusTreeStore) GetLast(table storage.Table) (*storage.KVPair, error) {
 	return result, nil
 }
 
-func (s BPlusTreeStore) GetAll(table storage.Table) storage.KVPairReader {
-	return NewBPlusKVPairReader(table, s.db)
+func (s *BPlusTreeStore) GetFloor(table storage.Table, key []byte) (*storage.KVPair, error) {
+	s.RLock()
+	defer s.RUnlock()
+	result := new(storage.KVPair)
+	k := append([]byte{table.Prefix()}, key...)
+	s.db.DescendLessOrEqual(KVItem{k, nil}, func(i btree.Item) bool {
+		item := i.(KVItem)
+		if item.Key[0] == table.Prefix() {
+			result.Key = item.Key[1:]
+			result.Value = item.Value
+		}
+		return false
+	})
+	if result.Key == nil {
+		return nil, storage.ErrKeyNotFound
+	}
+	return result, nil
+}
+
+// GetAll returns a reader of the table as it is now, which is not affected
+// by later mutations.
+func (s *BPlusTreeStore) GetAll(table storage.Table) storage.KVPairReader {
+	return NewBPlusKVPairReader(table, s.clone())
 }
 
 type BPlusKVPairReader struct {
@@ -116,6 +284,9 @@ func (r *BPlusKVPairReader) Read(buffer []*storage.KVPair) (n int, err error) {
 			return false
 		}
 		key := i.(KVItem).Key
+		if key[0] != r.prefix {
+			return false
+		}
 		if bytes.Compare(key, r.lastKey) != 0 {
 			buffer[n] = &storage.KVPair{key[1:], i.(KVItem).Value}
 			n++
@@ -130,23 +301,95 @@ func (r *BPlusKVPairReader) Close() {
 	r.db = nil
 }
 
-func (s BPlusTreeStore) Close() error {
+func (s *BPlusTreeStore) Close() error {
+	s.Lock()
+	defer s.Unlock()
 	s.db.Clear(false)
-	return nil
+	s.snapshots = make(map[uint64]*btree.BTree)
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Sync()
+	if cerr := s.file.Close(); err == nil {
+		err = cerr
+	}
+	s.file = nil
+	return err
+}
+
+// Snapshot takes a lazy copy of the tree, which is dumped and released by
+// Backup with the returned ID.
+func (s *BPlusTreeStore) Snapshot() (uint64, error) {
+	snapshot := s.clone()
+	s.Lock()
+	defer s.Unlock()
+	s.lastSnapshotID++
+	s.snapshots[s.lastSnapshotID] = snapshot
+	return s.lastSnapshotID, nil
+}
+
+// Backup dumps every entry of the snapshot with the given ID, taken by
+// Snapshot, in the format read by Load. The snapshot is released
+// afterwards.
+func (s *BPlusTreeStore) Backup(w io.Writer, id uint64) error {
+	s.Lock()
+	snapshot, ok := s.snapshots[id]
+	delete(s.snapshots, id)
+	s.Unlock()
+	if !ok {
+		return ErrSnapshotNotFound
+	}
+	return s.writeTo(snapshot, w)
+}
+
+// Load reads the entries of a backup and writes them to the store.
+func (s *BPlusTreeStore) Load(r io.Reader) error {
+	br := bufio.NewReaderSize(r, 16<<10)
+	mutations := make([]*storage.Mutation, 0, 1000)
+	err := storage.ReadBackup(br, func(table storage.Table, key, value []byte) error {
+		mutations = append(mutations, storage.NewMutation(table, key, value))
+		if len(mutations) == cap(mutations) {
+			if err := s.Mutate(mutations); err != nil {
+				return err
+			}
+			mutations = mutations[:0]
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return s.Mutate(mutations)
 }
 
-func (s BPlusTreeStore) Backup(w io.Writer, id uint64) error {
-	panic("Not implemented")
+func (s *BPlusTreeStore) RegisterMetrics(registry metrics.Registry) {
 }
 
-func (s BPlusTreeStore) Load(r io.Reader) error {
-	panic("Not implemented")
+// clone returns a lazy copy of the tree, which can be read while the
+// store is mutated.
+func (s *BPlusTreeStore) clone() *btree.BTree {
+	// cloning marks the nodes as shared, so it cannot run along mutations
+	s.Lock()
+	defer s.Unlock()
+	return s.db.Clone()
 }
 
-func (s BPlusTreeStore) Snapshot() (uint64, error) {
-	panic("Not implemented")
+func (s *BPlusTreeStore) writeTo(db *btree.BTree, w io.Writer) error {
+	write := storage.NewBackupWriter(w)
+	var err error
+	db.Ascend(func(i btree.Item) bool {
+		item := i.(KVItem)
+		err = write(tableOf(item.Key[0]), item.Key[1:], item.Value)
+		return err == nil
+	})
+	return err
 }
 
-func (s BPlusTreeStore) RegisterMetrics(registry metrics.Registry) {
-	panic("Not implemented")
+func tableOf(prefix byte) storage.Table {
+	for _, t := range tables {
+		if t.Prefix() == prefix {
+			return t
+		}
+	}
+	return storage.DefaultTable
 }
@@ -17,6 +17,10 @@
 package bplus
 
 import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/bbva/qed/storage"
@@ -53,6 +57,26 @@ func TestMutate(t *testing.T) {
 	}
 }
 
+func TestDelete(t *testing.T) {
+	store, closeF := openBPlusTreeStore()
+	defer closeF()
+
+	require.NoError(t, store.Mutate([]*storage.Mutation{
+		storage.NewMutation(storage.IdempotencyKeysTable, []byte("Key1"), []byte("Value1")),
+		storage.NewMutation(storage.IdempotencyKeysTable, []byte("Key2"), []byte("Value2")),
+	}))
+	require.NoError(t, store.Mutate([]*storage.Mutation{
+		storage.NewDeletion(storage.IdempotencyKeysTable, []byte("Key1")),
+		storage.NewDeletion(storage.IdempotencyKeysTable, []byte("Unknown")),
+	}))
+
+	_, err := store.Get(storage.IdempotencyKeysTable, []byte("Key1"))
+	require.Equal(t, storage.ErrKeyNotFound, err, "The deleted key should not be found")
+	kv, err := store.Get(storage.IdempotencyKeysTable, []byte("Key2"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("Value2"), kv.Value)
+}
+
 func TestGetExistentKey(t *testing.T) {
 
 	store, closeF := openBPlusTreeStore()
@@ -72,7 +96,7 @@ func TestGetExistentKey(t *testing.T) {
 	for _, test := range testCases {
 		if test.expectedError == nil {
 			err := store.Mutate([]*storage.Mutation{
-				{test.table, test.key, test.value},
+				{Table: test.table, Key: test.key, Value: test.value},
 			})
 			require.NoError(t, err)
 		}
@@ -107,7 +131,7 @@ func TestGetRange(t *testing.T) {
 	table := storage.HistoryTable
 	for i := 10; i < 50; i++ {
 		store.Mutate([]*storage.Mutation{
-			{table, []byte{byte(i)}, []byte("Value")},
+			{Table: table, Key: []byte{byte(i)}, Value: []byte("Value")},
 		})
 	}
 
@@ -140,7 +164,7 @@ func TestGetAll(t *testing.T) {
 	for i := uint16(0); i < numElems; i++ {
 		key := util.Uint16AsBytes(i)
 		store.Mutate([]*storage.Mutation{
-			{table, key, key},
+			{Table: table, Key: key, Value: key},
 		})
 	}
 
@@ -170,13 +194,13 @@ func TestGetLast(t *testing.T) {
 
 	// insert
 	numElems := uint64(20)
-	tables := []storage.Table{storage.HistoryTable, storage.HyperTable}
+	tables := []storage.Table{storage.HistoryTable, storage.HyperTable, storage.FSMStateTable}
 	for _, table := range tables {
 		for i := uint64(0); i < numElems; i++ {
 			key := util.Uint64AsBytes(i)
-			store.Mutate([]*storage.Mutation{
-				{table, key, key},
-			})
+			require.NoError(t, store.Mutate([]*storage.Mutation{
+				storage.NewMutation(table, key, key),
+			}))
 		}
 	}
 
@@ -187,6 +211,144 @@ func TestGetLast(t *testing.T) {
 	require.Equalf(t, util.Uint64AsBytes(numElems-1), kv.Value, "The value should match the last inserted element")
 }
 
+func TestGetFloor(t *testing.T) {
+	store, closeF := openBPlusTreeStore()
+	defer closeF()
+
+	// insert even keys in two tables
+	tables := []storage.Table{storage.HyperTable, storage.HyperVersionsTable}
+	for _, table := range tables {
+		for i := uint64(2); i < 20; i += 2 {
+			key := util.Uint64AsBytes(i)
+			require.NoError(t, store.Mutate([]*storage.Mutation{
+				storage.NewMutation(table, key, key),
+			}))
+		}
+	}
+
+	testCases := []struct {
+		key           uint64
+		expectedKey   uint64
+		expectedError error
+	}{
+		{0, 0, storage.ErrKeyNotFound},
+		{1, 0, storage.ErrKeyNotFound},
+		{2, 2, nil},
+		{3, 2, nil},
+		{11, 10, nil},
+		{18, 18, nil},
+		{100, 18, nil},
+	}
+
+	for i, c := range testCases {
+		kv, err := store.GetFloor(storage.HyperVersionsTable, util.Uint64AsBytes(c.key))
+		require.Equalf(t, c.expectedError, err, "Unexpected error in test case %d", i)
+		if c.expectedError == nil {
+			require.Equalf(t, util.Uint64AsBytes(c.expectedKey), kv.Key, "The key should match the floor element in test case %d", i)
+			require.Equalf(t, util.Uint64AsBytes(c.expectedKey), kv.Value, "The value should match the floor element in test case %d", i)
+		}
+	}
+}
+
+func TestBackupAndLoad(t *testing.T) {
+
+	store, closeF := openBPlusTreeStore()
+	defer closeF()
+
+	tables := []storage.Table{storage.HyperTable, storage.HistoryTable, storage.FSMStateTable, storage.TimestampsTable}
+	for i := uint16(0); i < 100; i++ {
+		key := util.Uint16AsBytes(i)
+		require.NoError(t, store.Mutate([]*storage.Mutation{
+			storage.NewMutation(tables[i%4], key, key),
+		}))
+	}
+
+	id, err := store.Snapshot()
+	require.NoError(t, err)
+
+	// mutations after the snapshot are left out of the backup
+	require.NoError(t, store.Mutate([]*storage.Mutation{
+		storage.NewMutation(storage.HistoryTable, []byte{0xff, 0xff}, []byte{0x1}),
+	}))
+
+	var backup bytes.Buffer
+	require.NoError(t, store.Backup(&backup, id))
+	require.Equal(t, ErrSnapshotNotFound, store.Backup(&bytes.Buffer{}, id), "Snapshots should be released after the backup")
+
+	restored, closeRestored := openBPlusTreeStore()
+	defer closeRestored()
+	require.NoError(t, restored.Load(&backup))
+
+	for i := uint16(0); i < 100; i++ {
+		key := util.Uint16AsBytes(i)
+		kv, err := restored.Get(tables[i%4], key)
+		require.NoErrorf(t, err, "Key %d should be restored in its table", i)
+		require.Equal(t, key, kv.Value)
+	}
+	_, err = restored.Get(storage.HistoryTable, []byte{0xff, 0xff})
+	require.Equal(t, storage.ErrKeyNotFound, err, "Mutations after the snapshot should not be restored")
+}
+
+func TestFileStore(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "bplus")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "store.db")
+
+	store, err := NewBPlusTreeFileStore(path)
+	require.NoError(t, err)
+	for i := uint16(0); i < 100; i++ {
+		key := util.Uint16AsBytes(i)
+		require.NoError(t, store.Mutate([]*storage.Mutation{
+			storage.NewMutation(storage.HistoryTable, key, key),
+			storage.NewMutation(storage.HyperTable, key, []byte{0x1}),
+		}))
+		// overwritten values are compacted
+		require.NoError(t, store.Mutate([]*storage.Mutation{
+			storage.NewMutation(storage.HyperTable, key, key),
+		}))
+		// and deleted ones are not restored
+		require.NoError(t, store.Mutate([]*storage.Mutation{
+			storage.NewMutation(storage.IdempotencyKeysTable, key, key),
+		}))
+		require.NoError(t, store.Mutate([]*storage.Mutation{
+			storage.NewDeletion(storage.IdempotencyKeysTable, key),
+		}))
+	}
+	require.NoError(t, store.Close())
+
+	// a mutation cut by a crash is discarded
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	_, err = file.Write([]byte{0x10, 0, 0, 0, 0, 0, 0, 0, 0x1})
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	store, err = NewBPlusTreeFileStore(path)
+	require.NoError(t, err)
+	defer store.Close()
+
+	for i := uint16(0); i < 100; i++ {
+		key := util.Uint16AsBytes(i)
+		for _, table := range []storage.Table{storage.HistoryTable, storage.HyperTable} {
+			kv, err := store.Get(table, key)
+			require.NoErrorf(t, err, "Key %d should be persisted in table %s", i, table)
+			require.Equal(t, key, kv.Value)
+		}
+		_, err := store.Get(storage.IdempotencyKeysTable, key)
+		require.Equalf(t, storage.ErrKeyNotFound, err, "Key %d should be deleted", i)
+	}
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	var compacted bytes.Buffer
+	id, err := store.Snapshot()
+	require.NoError(t, err)
+	require.NoError(t, store.Backup(&compacted, id))
+	require.Equal(t, int64(compacted.Len()), info.Size(), "The file should be compacted when opened")
+}
+
 func BenchmarkMutate(b *testing.B) {
 	store, closeF := openBPlusTreeStore()
 	defer closeF()
@@ -194,7 +356,7 @@ func BenchmarkMutate(b *testing.B) {
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		store.Mutate([]*storage.Mutation{
-			{storage.HistoryTable, rand.Bytes(128), []byte("Value")},
+			{Table: storage.HistoryTable, Key: rand.Bytes(128), Value: []byte("Value")},
 		})
 	}
 }
@@ -211,11 +373,11 @@ func BenchmarkGet(b *testing.B) {
 		if i == 10 {
 			key = rand.Bytes(128)
 			store.Mutate([]*storage.Mutation{
-				{storage.HistoryTable, key, []byte("Value")},
+				{Table: storage.HistoryTable, Key: key, Value: []byte("Value")},
 			})
 		} else {
 			store.Mutate([]*storage.Mutation{
-				{storage.HistoryTable, rand.Bytes(128), []byte("Value")},
+				{Table: storage.HistoryTable, Key: rand.Bytes(128), Value: []byte("Value")},
 			})
 		}
 	}
@@ -236,7 +398,7 @@ func BenchmarkGetRangeInLargeTree(b *testing.B) {
 	// populate storage
 	for i := 0; i < N; i++ {
 		store.Mutate([]*storage.Mutation{
-			{storage.HistoryTable, []byte{byte(i)}, []byte("Value")},
+			{Table: storage.HistoryTable, Key: []byte{byte(i)}, Value: []byte("Value")},
 		})
 	}
 
@@ -0,0 +1,72 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package storage
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"io/ioutil"
+)
+
+// Encodings of the values of the PayloadsTable, stored in their first
+// byte.
+const (
+	rawPayload   byte = 0x0
+	flatePayload byte = 0x1
+)
+
+// ErrInvalidPayload is returned when decoding a value that has not been
+// encoded by EncodePayload.
+var ErrInvalidPayload = errors.New("invalid payload encoding")
+
+// EncodePayload returns the value stored in the PayloadsTable for the
+// payload of an event. It is compressed with DEFLATE unless that does not
+// make it smaller.
+func EncodePayload(payload []byte) []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(flatePayload)
+	w, err := flate.NewWriter(&buf, flate.DefaultCompression)
+	if err == nil {
+		_, err = w.Write(payload)
+	}
+	if err == nil {
+		err = w.Close()
+	}
+	if err != nil || buf.Len() >= len(payload)+1 {
+		return append([]byte{rawPayload}, payload...)
+	}
+	return buf.Bytes()
+}
+
+// DecodePayload returns the payload of an event from its value in the
+// PayloadsTable.
+func DecodePayload(value []byte) ([]byte, error) {
+	if len(value) == 0 {
+		return nil, ErrInvalidPayload
+	}
+	switch value[0] {
+	case rawPayload:
+		return value[1:], nil
+	case flatePayload:
+		r := flate.NewReader(bytes.NewReader(value[1:]))
+		defer r.Close()
+		return ioutil.ReadAll(r)
+	default:
+		return nil, ErrInvalidPayload
+	}
+}
@@ -0,0 +1,54 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package storage
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncodePayload(t *testing.T) {
+
+	testCases := []struct {
+		payload    []byte
+		compressed bool
+	}{
+		{[]byte{}, false},
+		{[]byte("a short event"), false},
+		{bytes.Repeat([]byte(`{"level":"info","msg":"user logged in"}`), 100), true},
+	}
+
+	for i, c := range testCases {
+		value := EncodePayload(c.payload)
+		if c.compressed {
+			require.Truef(t, len(value) < len(c.payload), "The payload should be compressed in test case %d", i)
+		} else {
+			require.Equalf(t, len(c.payload)+1, len(value), "The payload should be stored raw in test case %d", i)
+		}
+
+		payload, err := DecodePayload(value)
+		require.NoErrorf(t, err, "Decoding should not fail in test case %d", i)
+		require.Equalf(t, c.payload, payload, "The decoded payload should match in test case %d", i)
+	}
+
+	_, err := DecodePayload(nil)
+	require.Equal(t, ErrInvalidPayload, err)
+	_, err = DecodePayload([]byte{0xff, 0x1})
+	require.Equal(t, ErrInvalidPayload, err)
+}
@@ -50,6 +50,12 @@ func newRocksDBMetrics(store *RocksDBStore) *rocksDBMetrics {
 	tables = append(tables, newPerTableMetrics(storage.HyperTable, store))
 	tables = append(tables, newPerTableMetrics(storage.HistoryTable, store))
 	tables = append(tables, newPerTableMetrics(storage.FSMStateTable, store))
+	tables = append(tables, newPerTableMetrics(storage.HyperVersionsTable, store))
+	tables = append(tables, newPerTableMetrics(storage.TimestampsTable, store))
+	tables = append(tables, newPerTableMetrics(storage.IdempotencyKeysTable, store))
+	tables = append(tables, newPerTableMetrics(storage.PayloadsTable, store))
+	tables = append(tables, newPerTableMetrics(storage.IndexTable, store))
+	tables = append(tables, newPerTableMetrics(storage.IdempotencyExpiryTable, store))
 	return &rocksDBMetrics{
 		blockCacheMetrics:  newBlockCacheMetrics(store.stats, store.blockCache),
 		bloomFilterMetrics: newBloomFilterMetrics(store.stats),
@@ -18,7 +18,6 @@ package rocks
 import (
 	"bufio"
 	"bytes"
-	"encoding/binary"
 	"fmt"
 	"io"
 	"os"
@@ -26,7 +25,6 @@ import (
 	"github.com/bbva/qed/metrics"
 	"github.com/bbva/qed/rocksdb"
 	"github.com/bbva/qed/storage"
-	"github.com/bbva/qed/storage/pb"
 )
 
 type RocksDBStore struct {
@@ -78,6 +76,12 @@ func NewRocksDBStoreOpts(opts *Options) (*RocksDBStore, error) {
 		storage.HyperTable.String(),
 		storage.HistoryTable.String(),
 		storage.FSMStateTable.String(),
+		storage.HyperVersionsTable.String(),
+		storage.TimestampsTable.String(),
+		storage.IdempotencyKeysTable.String(),
+		storage.PayloadsTable.String(),
+		storage.IndexTable.String(),
+		storage.IdempotencyExpiryTable.String(),
 	}
 
 	// env
@@ -106,6 +110,12 @@ func NewRocksDBStoreOpts(opts *Options) (*RocksDBStore, error) {
 		getHyperTableOpts(blockCache),
 		getHistoryTableOpts(blockCache),
 		getFsmStateTableOpts(),
+		getHyperVersionsTableOpts(blockCache),
+		getTimestampsTableOpts(blockCache),
+		getIdempotencyKeysTableOpts(blockCache),
+		getPayloadsTableOpts(blockCache),
+		getTimestampsTableOpts(blockCache),
+		getTimestampsTableOpts(blockCache),
 	}
 
 	db, cfHandles, err := rocksdb.OpenDBColumnFamilies(opts.Path, globalOpts, cfNames, cfOpts)
@@ -308,10 +318,140 @@ func getFsmStateTableOpts() *rocksdb.Options {
 	return opts
 }
 
+// The hyper versions table is insert-only, like the history table,
+// but its values are whole batches (~1KB) so we keep the block
+// size and compression of the hyper table. Reads are always
+// reverse seeks to the last version of a batch.
+func getHyperVersionsTableOpts(blockCache *rocksdb.Cache) *rocksdb.Options {
+
+	bbto := rocksdb.NewDefaultBlockBasedTableOptions()
+	// In order to have a fine-grained control over the memory usage
+	// we cache SST's index and filters in the block cache.
+	bbto.SetCacheIndexAndFilterBlocks(true)
+	bbto.SetBlockCache(blockCache)
+	// increase block size to 16KB
+	bbto.SetBlockSize(16 * 1024)
+
+	opts := rocksdb.NewDefaultOptions()
+	opts.SetBlockBasedTableFactory(bbto)
+	opts.SetCompression(rocksdb.SnappyCompression)
+
+	opts.SetWriteBufferSize(64 * 1024 * 1024) // 64MB
+	opts.SetMaxWriteBufferNumber(3)
+	opts.SetMinWriteBufferNumberToMerge(1)
+	opts.SetLevel0FileNumCompactionTrigger(8)
+	opts.SetLevel0SlowdownWritesTrigger(17)
+	opts.SetLevel0StopWritesTrigger(24)
+	opts.SetTargetFileSizeBase(64 * 1024 * 1024) // 64MB
+	opts.SetTargetFileSizeMultiplier(8)
+	opts.SetMaxBytesForLevelBase(512 * 1024 * 1024) // 512MB
+	opts.SetMaxBytesForLevelMultiplier(8)
+	opts.SetNumLevels(5)
+
+	// io parallelism
+	opts.SetMaxBackgroundCompactions(4)
+	opts.SetMaxBackgroundFlushes(1)
+	return opts
+}
+
+// The timestamps table is insert-only and its keys are sequential
+// versions, so the workload is similar to the history table but with
+// much less data: one 8 byte value per event. The index table, with one
+// digest per event, shares the same options.
+func getTimestampsTableOpts(blockCache *rocksdb.Cache) *rocksdb.Options {
+
+	bbto := rocksdb.NewDefaultBlockBasedTableOptions()
+	// In order to have a fine-grained control over the memory usage
+	// we cache SST's index and filters in the block cache.
+	bbto.SetCacheIndexAndFilterBlocks(true)
+	bbto.SetBlockCache(blockCache)
+
+	opts := rocksdb.NewDefaultOptions()
+	opts.SetBlockBasedTableFactory(bbto)
+	opts.SetCompression(rocksdb.SnappyCompression)
+
+	opts.SetWriteBufferSize(16 * 1024 * 1024) // 16MB
+	opts.SetMaxWriteBufferNumber(3)
+	opts.SetMinWriteBufferNumberToMerge(1)
+	opts.SetLevel0FileNumCompactionTrigger(8)
+	opts.SetTargetFileSizeBase(16 * 1024 * 1024)    // 16MB
+	opts.SetMaxBytesForLevelBase(128 * 1024 * 1024) // 128MB
+	opts.SetNumLevels(5)
+
+	// io parallelism
+	opts.SetMaxBackgroundCompactions(1)
+	opts.SetMaxBackgroundFlushes(1)
+	return opts
+}
+
+// The idempotency keys table receives random client keys and is only
+// read with point lookups, most of them for keys that are not present,
+// so we use bloom filters to avoid reading from disk.
+func getIdempotencyKeysTableOpts(blockCache *rocksdb.Cache) *rocksdb.Options {
+
+	bbto := rocksdb.NewDefaultBlockBasedTableOptions()
+	bbto.SetFilterPolicy(rocksdb.NewFullBloomFilterPolicy(10))
+	// In order to have a fine-grained control over the memory usage
+	// we cache SST's index and filters in the block cache.
+	bbto.SetCacheIndexAndFilterBlocks(true)
+	bbto.SetBlockCache(blockCache)
+
+	opts := rocksdb.NewDefaultOptions()
+	opts.SetBlockBasedTableFactory(bbto)
+	opts.SetCompression(rocksdb.SnappyCompression)
+
+	opts.SetWriteBufferSize(16 * 1024 * 1024) // 16MB
+	opts.SetMaxWriteBufferNumber(3)
+	opts.SetMinWriteBufferNumberToMerge(1)
+	opts.SetLevel0FileNumCompactionTrigger(8)
+	opts.SetTargetFileSizeBase(16 * 1024 * 1024)    // 16MB
+	opts.SetMaxBytesForLevelBase(128 * 1024 * 1024) // 128MB
+	opts.SetNumLevels(5)
+
+	// io parallelism
+	opts.SetMaxBackgroundCompactions(1)
+	opts.SetMaxBackgroundFlushes(1)
+	return opts
+}
+
+// The payloads table is insert-only and its keys are sequential versions,
+// like the timestamps table, but its values are the events themselves.
+// They are already compressed by storage.EncodePayload, so the table is
+// not compressed again.
+func getPayloadsTableOpts(blockCache *rocksdb.Cache) *rocksdb.Options {
+
+	bbto := rocksdb.NewDefaultBlockBasedTableOptions()
+	// In order to have a fine-grained control over the memory usage
+	// we cache SST's index and filters in the block cache.
+	bbto.SetCacheIndexAndFilterBlocks(true)
+	bbto.SetBlockCache(blockCache)
+
+	opts := rocksdb.NewDefaultOptions()
+	opts.SetBlockBasedTableFactory(bbto)
+	opts.SetCompression(rocksdb.NoCompression)
+
+	opts.SetWriteBufferSize(64 * 1024 * 1024) // 64MB
+	opts.SetMaxWriteBufferNumber(3)
+	opts.SetMinWriteBufferNumberToMerge(1)
+	opts.SetLevel0FileNumCompactionTrigger(8)
+	opts.SetTargetFileSizeBase(64 * 1024 * 1024)    // 64MB
+	opts.SetMaxBytesForLevelBase(512 * 1024 * 1024) // 512MB
+	opts.SetNumLevels(5)
+
+	// io parallelism
+	opts.SetMaxBackgroundCompactions(2)
+	opts.SetMaxBackgroundFlushes(1)
+	return opts
+}
+
 func (s *RocksDBStore) Mutate(mutations []*storage.Mutation) error {
 	batch := rocksdb.NewWriteBatch()
 	defer batch.Destroy()
 	for _, m := range mutations {
+		if m.Delete {
+			batch.DeleteCF(s.cfHandles[m.Table], m.Key)
+			continue
+		}
 		batch.PutCF(s.cfHandles[m.Table], m.Key, m.Value)
 	}
 	err := s.db.Write(s.wo, batch)
@@ -375,6 +515,27 @@ func (s *RocksDBStore) GetLast(table storage.Table) (*storage.KVPair, error) {
 	return nil, storage.ErrKeyNotFound
 }
 
+func (s *RocksDBStore) GetFloor(table storage.Table, key []byte) (*storage.KVPair, error) {
+	it := s.db.NewIteratorCF(s.ro, s.cfHandles[table])
+	defer it.Close()
+	it.SeekForPrev(key)
+	if it.Valid() {
+		result := new(storage.KVPair)
+		keySlice := it.Key()
+		k := make([]byte, keySlice.Size())
+		copy(k, keySlice.Data())
+		keySlice.Free()
+		result.Key = k
+		valueSlice := it.Value()
+		value := make([]byte, valueSlice.Size())
+		copy(value, valueSlice.Data())
+		valueSlice.Free()
+		result.Value = value
+		return result, nil
+	}
+	return nil, storage.ErrKeyNotFound
+}
+
 type RocksDBKVPairReader struct {
 	it *rocksdb.Iterator
 }
@@ -468,8 +629,9 @@ func (s *RocksDBStore) Snapshot() (uint64, error) {
 	return id, nil
 }
 
-// Backup dumps a protobuf-encoded list of all entries in the database into the
-// given writer, that are newer than the specified version.
+// Backup dumps a protobuf-encoded list of all entries of the checkpoint with
+// the given ID, taken by Snapshot, into the given writer. The checkpoint is
+// removed afterwards.
 func (s *RocksDBStore) Backup(w io.Writer, id uint64) error {
 
 	checkDir := s.checkpoints[id]
@@ -485,11 +647,18 @@ func (s *RocksDBStore) Backup(w io.Writer, id uint64) error {
 	ro := rocksdb.NewDefaultReadOptions()
 	ro.SetFillCache(false)
 
+	write := storage.NewBackupWriter(w)
 	tables := []storage.Table{
 		storage.DefaultTable,
 		storage.HyperTable,
 		storage.HistoryTable,
 		storage.FSMStateTable,
+		storage.HyperVersionsTable,
+		storage.TimestampsTable,
+		storage.IdempotencyKeysTable,
+		storage.PayloadsTable,
+		storage.IndexTable,
+		storage.IdempotencyExpiryTable,
 	}
 	for _, table := range tables {
 
@@ -506,14 +675,8 @@ func (s *RocksDBStore) Backup(w io.Writer, id uint64) error {
 			keySlice.Free()
 			valueSlice.Free()
 
-			entry := &pb.KVPair{
-				Table: pb.Table(table),
-				Key:   key,
-				Value: value,
-			}
-
 			// write entries to disk
-			if err := writeTo(entry, w); err != nil {
+			if err := write(table, key, value); err != nil {
 				return err
 			}
 		}
@@ -539,39 +702,22 @@ func (s *RocksDBStore) Backup(w io.Writer, id uint64) error {
 func (s *RocksDBStore) Load(r io.Reader) error {
 
 	br := bufio.NewReaderSize(r, 16<<10)
-	unmarshalBuf := make([]byte, 1<<10)
 	batch := rocksdb.NewWriteBatch()
 	wo := rocksdb.NewDefaultWriteOptions()
 	wo.SetDisableWAL(true)
 
-	for {
-		var data uint64
-		err := binary.Read(br, binary.LittleEndian, &data)
-		if err == io.EOF {
-			break
-		} else if err != nil {
-			return err
-		}
-
-		if cap(unmarshalBuf) < int(data) {
-			unmarshalBuf = make([]byte, data)
-		}
-
-		kv := &pb.KVPair{}
-		if _, err = io.ReadFull(br, unmarshalBuf[:data]); err != nil {
-			return err
-		}
-		if err = kv.Unmarshal(unmarshalBuf[:data]); err != nil {
-			return err
-		}
-		table := storage.Table(kv.GetTable())
-		batch.PutCF(s.cfHandles[table], kv.Key, kv.Value)
-
+	err := storage.ReadBackup(br, func(table storage.Table, key, value []byte) error {
+		batch.PutCF(s.cfHandles[table], key, value)
 		if batch.Count() == 1000 {
-			s.db.Write(wo, batch)
+			if err := s.db.Write(wo, batch); err != nil {
+				return err
+			}
 			batch.Clear()
-			continue
 		}
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
 	if batch.Count() > 0 {
@@ -586,15 +732,3 @@ func (s *RocksDBStore) RegisterMetrics(registry metrics.Registry) {
 		registry.MustRegister(s.metrics.collectors()...)
 	}
 }
-
-func writeTo(entry *pb.KVPair, w io.Writer) error {
-	if err := binary.Write(w, binary.LittleEndian, uint64(entry.Size())); err != nil {
-		return err
-	}
-	buf, err := entry.Marshal()
-	if err != nil {
-		return err
-	}
-	_, err = w.Write(buf)
-	return err
-}
@@ -51,6 +51,27 @@ func TestMutate(t *testing.T) {
 		require.Equalf(t, test.expectedError, err, "Error getting key in test: %s", test.testname)
 	}
 }
+
+func TestDelete(t *testing.T) {
+	store, closeF := openRocksDBStore(t)
+	defer closeF()
+
+	require.NoError(t, store.Mutate([]*storage.Mutation{
+		storage.NewMutation(storage.IdempotencyKeysTable, []byte("Key1"), []byte("Value1")),
+		storage.NewMutation(storage.IdempotencyKeysTable, []byte("Key2"), []byte("Value2")),
+	}))
+	require.NoError(t, store.Mutate([]*storage.Mutation{
+		storage.NewDeletion(storage.IdempotencyKeysTable, []byte("Key1")),
+		storage.NewDeletion(storage.IdempotencyKeysTable, []byte("Unknown")),
+	}))
+
+	_, err := store.Get(storage.IdempotencyKeysTable, []byte("Key1"))
+	require.Equal(t, storage.ErrKeyNotFound, err, "The deleted key should not be found")
+	kv, err := store.Get(storage.IdempotencyKeysTable, []byte("Key2"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("Value2"), kv.Value)
+}
+
 func TestGetExistentKey(t *testing.T) {
 
 	store, closeF := openRocksDBStore(t)
@@ -111,7 +132,7 @@ func TestGetRange(t *testing.T) {
 	table := storage.HistoryTable
 	for i := 10; i < 50; i++ {
 		store.Mutate([]*storage.Mutation{
-			{table, []byte{byte(i)}, []byte("Value")},
+			{Table: table, Key: []byte{byte(i)}, Value: []byte("Value")},
 		})
 	}
 
@@ -144,7 +165,7 @@ func TestGetAll(t *testing.T) {
 	for i := uint16(0); i < numElems; i++ {
 		key := util.Uint16AsBytes(i)
 		store.Mutate([]*storage.Mutation{
-			{table, key, key},
+			{Table: table, Key: key, Value: key},
 		})
 	}
 
@@ -179,7 +200,7 @@ func TestGetLast(t *testing.T) {
 		for i := uint64(0); i < numElems; i++ {
 			key := util.Uint64AsBytes(i)
 			store.Mutate([]*storage.Mutation{
-				{table, key, key},
+				{Table: table, Key: key, Value: key},
 			})
 		}
 	}
@@ -191,6 +212,43 @@ func TestGetLast(t *testing.T) {
 	require.Equalf(t, util.Uint64AsBytes(numElems-1), kv.Value, "The value should match the last inserted element")
 }
 
+func TestGetFloor(t *testing.T) {
+	store, closeF := openRocksDBStore(t)
+	defer closeF()
+
+	// insert even keys in two tables
+	tables := []storage.Table{storage.HyperTable, storage.HyperVersionsTable}
+	for _, table := range tables {
+		for i := uint64(2); i < 20; i += 2 {
+			key := util.Uint64AsBytes(i)
+			store.Mutate([]*storage.Mutation{
+				{Table: table, Key: key, Value: key},
+			})
+		}
+	}
+
+	testCases := []struct {
+		key           uint64
+		expectedKey   uint64
+		expectedError error
+	}{
+		{1, 0, storage.ErrKeyNotFound},
+		{2, 2, nil},
+		{3, 2, nil},
+		{11, 10, nil},
+		{100, 18, nil},
+	}
+
+	for i, c := range testCases {
+		kv, err := store.GetFloor(storage.HyperVersionsTable, util.Uint64AsBytes(c.key))
+		require.Equalf(t, c.expectedError, err, "Unexpected error in test case %d", i)
+		if c.expectedError == nil {
+			require.Equalf(t, util.Uint64AsBytes(c.expectedKey), kv.Key, "The key should match the floor element in test case %d", i)
+			require.Equalf(t, util.Uint64AsBytes(c.expectedKey), kv.Value, "The value should match the floor element in test case %d", i)
+		}
+	}
+}
+
 func TestBackupLoad(t *testing.T) {
 
 	store, closeF := openRocksDBStore(t)
@@ -39,6 +39,31 @@ const (
 	// FSMStateTable contains the current state of the FSM (index, term, version...).
 	// key -> state
 	FSMStateTable
+	// HyperVersionsTable contains a copy of every batch of the hyper tree
+	// each time it is modified, so the tree can be queried as of any
+	// previous version.
+	// Position+Version -> Batch
+	HyperVersionsTable
+	// TimestampsTable contains the time at which every event was logged,
+	// in nanoseconds since the epoch.
+	// Version -> Timestamp
+	TimestampsTable
+	// IdempotencyKeysTable contains the snapshot returned to every add
+	// request made with an idempotency key, so retries get it back.
+	// Key -> Snapshot
+	IdempotencyKeysTable
+	// PayloadsTable contains the raw events of the logs that store them,
+	// encoded by EncodePayload. They are not part of the trees.
+	// Version -> Payload
+	PayloadsTable
+	// IndexTable contains the digest of the event logged at every version.
+	// Version -> Digest
+	IndexTable
+	// IdempotencyExpiryTable indexes the idempotency keys by the log time
+	// of the add that used them, so the expired ones are pruned with a
+	// range instead of a scan of every key.
+	// Timestamp+Key -> Key
+	IdempotencyExpiryTable
 )
 
 // FSMStateTableKey single key to persist fsm state.
@@ -56,6 +81,18 @@ func (t Table) String() string {
 		s = "history"
 	case FSMStateTable:
 		s = "fsm"
+	case HyperVersionsTable:
+		s = "hyper_versions"
+	case TimestampsTable:
+		s = "timestamps"
+	case IdempotencyKeysTable:
+		s = "idempotency_keys"
+	case PayloadsTable:
+		s = "payloads"
+	case IndexTable:
+		s = "index"
+	case IdempotencyExpiryTable:
+		s = "idempotency_expiry"
 	}
 	return s
 }
@@ -71,6 +108,18 @@ func (t Table) Prefix() byte {
 		prefix = byte(0x1)
 	case FSMStateTable:
 		prefix = byte(0x2)
+	case HyperVersionsTable:
+		prefix = byte(0x4)
+	case TimestampsTable:
+		prefix = byte(0x5)
+	case IdempotencyKeysTable:
+		prefix = byte(0x6)
+	case PayloadsTable:
+		prefix = byte(0x7)
+	case IndexTable:
+		prefix = byte(0x8)
+	case IdempotencyExpiryTable:
+		prefix = byte(0x9)
 	default:
 		prefix = byte(0x3)
 	}
@@ -87,12 +136,17 @@ type Store interface {
 	Get(table Table, key []byte) (*KVPair, error)
 	GetAll(table Table) KVPairReader
 	GetLast(table Table) (*KVPair, error)
+	// GetFloor returns the pair with the greatest key less than
+	// or equal to the given one.
+	GetFloor(table Table, key []byte) (*KVPair, error)
 	Close() error
 }
 
 type ManagedStore interface {
 	Store
-	Backup(w io.Writer, until uint64) error
+	// Backup dumps every entry of the checkpoint with the given ID,
+	// taken by Snapshot, in the format read by Load.
+	Backup(w io.Writer, id uint64) error
 	Snapshot() (uint64, error)
 	Load(r io.Reader) error
 	metrics.Registerer
@@ -101,6 +155,8 @@ type ManagedStore interface {
 type Mutation struct {
 	Table      Table
 	Key, Value []byte
+	// Delete removes the key from the table instead of setting its value.
+	Delete bool
 }
 
 func NewMutation(table Table, key, value []byte) *Mutation {
@@ -111,6 +167,15 @@ func NewMutation(table Table, key, value []byte) *Mutation {
 	}
 }
 
+// NewDeletion returns a mutation that removes the key from the table.
+func NewDeletion(table Table, key []byte) *Mutation {
+	return &Mutation{
+		Table:  table,
+		Key:    key,
+		Delete: true,
+	}
+}
+
 type KVPair struct {
 	Key, Value []byte
 }
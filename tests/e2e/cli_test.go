@@ -72,8 +72,8 @@ func Test_Client_To_Single_Server(t *testing.T) {
 				"--endpoints=https://127.0.0.1:8800",
 				"membership",
 				"--event-digest=8694718de4363adf07ec3b4aff4c76589f60fe89a7715bee7c8b250e06493922",
-				"--hyper-digest=2b0096433f25f59a4310af8a8999abfd9beb6adacbbbb086251f24609c4f5bbf",
-				"--history-digest=0f5129eaf5dbfb1405ff072a04d716aaf4e4ba4247a3322c41582e970dbb7b00",
+				"--hyper-digest=b11200d283be806323c5a1e8dae6f1f866f1aabc3b8a3a3cb9a3543431be461f",
+				"--history-digest=5250eec374b7f9689a38827d0879091dc5625b37955aad61292ab78fd71e981d",
 				"--verify",
 				"--version=0",
 				"--attempt-to-revive-endpoints",
@@ -103,8 +103,8 @@ func Test_Client_To_Single_Server(t *testing.T) {
 				"--api-key=APIKey",
 				"--endpoints=https://127.0.0.1:8800",
 				"membership",
-				"--hyper-digest=2b0096433f25f59a4310af8a8999abfd9beb6adacbbbb086251f24609c4f5bbf",
-				"--history-digest=0f5129eaf5dbfb1405ff072a04d716aaf4e4ba4247a3322c41582e970dbb7b00",
+				"--hyper-digest=b11200d283be806323c5a1e8dae6f1f866f1aabc3b8a3a3cb9a3543431be461f",
+				"--history-digest=5250eec374b7f9689a38827d0879091dc5625b37955aad61292ab78fd71e981d",
 				"--version=0",
 				"--event='test event'",
 				"--log=info",
@@ -173,8 +173,8 @@ func Test_Client_To_Cluster_With_Leader_Change(t *testing.T) {
 				"--endpoints=https://127.0.0.1:8800,https://127.0.0.1:8801,https://127.0.0.1:8802",
 				"membership",
 				"--event-digest=8694718de4363adf07ec3b4aff4c76589f60fe89a7715bee7c8b250e06493922",
-				"--hyper-digest=2b0096433f25f59a4310af8a8999abfd9beb6adacbbbb086251f24609c4f5bbf",
-				"--history-digest=0f5129eaf5dbfb1405ff072a04d716aaf4e4ba4247a3322c41582e970dbb7b00",
+				"--hyper-digest=b11200d283be806323c5a1e8dae6f1f866f1aabc3b8a3a3cb9a3543431be461f",
+				"--history-digest=5250eec374b7f9689a38827d0879091dc5625b37955aad61292ab78fd71e981d",
 				"--verify",
 				"--version=0",
 				"--attempt-to-revive-endpoints",
@@ -204,8 +204,8 @@ func Test_Client_To_Cluster_With_Leader_Change(t *testing.T) {
 				"--api-key=APIKey",
 				"--endpoints=https://127.0.0.1:8800,https://127.0.0.1:8801,https://127.0.0.1:8802",
 				"membership",
-				"--hyper-digest=2b0096433f25f59a4310af8a8999abfd9beb6adacbbbb086251f24609c4f5bbf",
-				"--history-digest=0f5129eaf5dbfb1405ff072a04d716aaf4e4ba4247a3322c41582e970dbb7b00",
+				"--hyper-digest=b11200d283be806323c5a1e8dae6f1f866f1aabc3b8a3a3cb9a3543431be461f",
+				"--history-digest=5250eec374b7f9689a38827d0879091dc5625b37955aad61292ab78fd71e981d",
 				"--version=0",
 				"--event='test event'",
 				"--log=info",
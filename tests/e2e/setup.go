@@ -95,6 +95,9 @@ func setupStore(t *testing.T) (scope.TestF, scope.TestF) {
 //	- signPath: oath to where the signer key is stored
 //	- tlsPath: path to where the tls cer and key are stored
 //	- tls: if true, tls is activated
+// The storage backend is the one named by QED_E2E_STORAGE (rocksdb, memory
+// or file), so the tests can run without RocksDB. By default, it is RocksDB
+// in the builds with cgo and memory in the rest.
 func configQedServer(id int, pathDB, signPath, tlsPath string, tls bool) *server.Config {
 	hostname, _ := os.Hostname()
 	conf := server.DefaultConfig()
@@ -111,6 +114,10 @@ func configQedServer(id int, pathDB, signPath, tlsPath string, tls bool) *server
 	}
 	conf.DBPath = pathDB + "data"
 	conf.RaftPath = pathDB + "raft"
+	conf.Storage = defaultStorage
+	if storage := os.Getenv("QED_E2E_STORAGE"); storage != "" {
+		conf.Storage = storage
+	}
 	conf.PrivateKeyPath = signPath
 	if tls {
 		conf.SSLCertificate = tlsPath + "/cert.pem"
@@ -0,0 +1,25 @@
+//go:build !cgo
+// +build !cgo
+
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package e2e
+
+// defaultStorage is the storage backend of the servers started by the
+// tests unless QED_E2E_STORAGE names another one. Builds without cgo
+// have no RocksDB, so they keep the data in memory.
+const defaultStorage = "memory"
@@ -54,6 +54,9 @@ gfOBLfAY8KLnYBjypDJPAAAAHWdkaWF6bG9AbWFjc2xhY2suc2xhY2t3YXJlLmVz
 	return path + "/id_ed25519", nil
 }
 
+// GenerateTlsCert writes a self-signed certificate for 127.0.0.1, and its
+// key, to cert.pem and key.pem. The certificate is also a CA and can be
+// used as client certificate, so it fits the mutual TLS between nodes.
 func GenerateTlsCert(path string) (string, error) {
 	priv, err := rsa.GenerateKey(rand.Reader, 2048)
 	if err != nil {
@@ -77,7 +80,7 @@ func GenerateTlsCert(path string) (string, error) {
 		NotBefore:             notBefore,
 		NotAfter:              notAfter,
 		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
-		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
 		BasicConstraintsValid: true,
 	}
 
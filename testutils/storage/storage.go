@@ -21,8 +21,6 @@ import (
 	"os"
 
 	"github.com/bbva/qed/storage/bplus"
-	"github.com/bbva/qed/storage/rocks"
-	"github.com/stretchr/testify/require"
 )
 
 func OpenBPlusTreeStore() (*bplus.BPlusTreeStore, func()) {
@@ -32,18 +30,6 @@ func OpenBPlusTreeStore() (*bplus.BPlusTreeStore, func()) {
 	}
 }
 
-func OpenRocksDBStore(t require.TestingT, path string) (*rocks.RocksDBStore, func()) {
-	store, err := rocks.NewRocksDBStore(path)
-	if err != nil {
-		t.Errorf("Error opening rocksdb store: %v", err)
-		t.FailNow()
-	}
-	return store, func() {
-		store.Close()
-		deleteFile(path)
-	}
-}
-
 func deleteFile(path string) {
 	err := os.RemoveAll(path)
 	if err != nil {